@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"backup-to-oss/internal/config"
+	"backup-to-oss/internal/controller"
+	"backup-to-oss/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkReadData   bool   // 是否下载并校验所有数据块
+	checkIdentity   string // 解密使用的 age 私钥文件
+	checkPassphrase string // 解密口令
+)
+
+// checkCmd represents the check command
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "检查备份目标中的去重仓库",
+	Long: `检查所有备份目标（--dest 指定的所有目标）中的去重仓库（--repository 模式的备份）。
+
+默认检查每个快照索引是否可以读取、引用的数据块是否存在，并输出没有被引用的数据块（执行 prune 时回收）。
+指定 --read-data 时下载所有被引用的数据块，解密、解压后校验数据块 ID（内容的 SHA-256，加密时为 HMAC-SHA256），
+数据块加密时需要 --identity 或 --passphrase（与恢复时相同）。
+
+发现问题时以退出码 1 退出。
+
+示例:
+  backup-to-oss check
+  或
+  backup-to-oss check --read-data --identity /root/.config/age/key.txt`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runCheck(); err != nil {
+			logger.Error("仓库检查失败", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+
+	checkCmd.Flags().BoolVar(&checkReadData, "read-data", false, "下载并校验所有被引用的数据块的内容")
+	checkCmd.Flags().StringVar(&checkIdentity, "identity", "", "age 私钥文件路径，--read-data 检查加密的数据块时需要（可通过 DECRYPT_IDENTITY_FILE 环境变量设置）")
+	checkCmd.Flags().StringVar(&checkPassphrase, "passphrase", "", "解密口令，--read-data 检查使用口令加密的数据块时需要（可通过 ENCRYPT_PASSPHRASE 环境变量设置）")
+}
+
+func runCheck() error {
+	// 加载配置（从 .env 文件或环境变量）
+	cfg, err := config.LoadConfig(envFile)
+	if err != nil {
+		return fmt.Errorf("加载配置失败: %v", err)
+	}
+
+	// 合并命令行参数（命令行参数优先级更高）
+	cfg.MergeWithFlags("", "", "", ossEndpoint, ossAccessKey, ossSecretKey, ossBucket, ossObjectPrefix)
+	cfg.MergeWithDestFlag(destinations)
+	cfg.MergeWithS3Flags(s3Endpoint, s3Region, s3AccessKey, s3SecretKey, s3PathStyle)
+	cfg.MergeWithSFTPFlags(sftpKeyFile, sftpKeyPass, sftpKnownHosts)
+	cfg.MergeWithDecryptFlags(checkIdentity, checkPassphrase)
+
+	// 验证备份目标配置
+	if err := cfg.ValidateStorage(); err != nil {
+		return err
+	}
+
+	req := controller.CheckRequest{
+		ReadData:   checkReadData,
+		Encryption: cfg.Encryption,
+		Storage:    newStorageConfig(cfg),
+	}
+	return controller.Check(context.Background(), req)
+}
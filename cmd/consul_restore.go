@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"backup-to-oss/internal/controller"
+	"backup-to-oss/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	consulCACert        string // CA 证书文件路径
+	consulCert          string // 客户端证书文件路径
+	consulKey           string // 客户端私钥文件路径
+	consulTLSServerName string // 校验服务端证书时使用的主机名
+	consulTLSSkipVerify bool   // 是否跳过服务端证书校验
+	consulRestoreYes    bool   // 是否跳过确认
+)
+
+// consulRestoreCmd represents the consul restore command
+var consulRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "从备份目标恢复 Consul snapshot 到集群",
+	Long: `从备份目标（--dest 指定的第一个目标）下载 Consul snapshot 备份，校验 SHA-256 后解密、解压，
+检查 snapshot 后通过 Consul API（PUT /v1/snapshot）恢复到集群。对标 consul snapshot restore 命令的功能。
+
+恢复会覆盖集群的全部数据，恢复前会显示 snapshot 和集群当前的索引并提示确认，
+非交互式运行（如脚本中）时需要指定 --yes。
+
+可以通过 --key 指定备份文件的对象键，也可以通过 --host、--date 查找最新的 snapshot。
+TLS 配置也可以通过 Consul 的环境变量设置（CONSUL_CACERT、CONSUL_CLIENT_CERT、CONSUL_CLIENT_KEY、CONSUL_TLS_SERVER_NAME）。
+
+示例:
+  backup-to-oss consul restore --address http://localhost:8500 --token your-token
+  或
+  backup-to-oss consul restore --host 1.2.3.4 --date 20250101 --address https://consul.example.com:8501 \
+    --cacert /etc/consul/ca.pem --client-cert /etc/consul/client.pem --client-key /etc/consul/client-key.pem --yes`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runConsulRestore(); err != nil {
+			logger.Error("恢复失败", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	consulCmd.AddCommand(consulRestoreCmd)
+
+	consulRestoreCmd.Flags().StringVar(&restoreKey, "key", "", "snapshot 备份文件的对象键（相对于备份目标根路径），指定后忽略 --host/--date")
+	consulRestoreCmd.Flags().StringVar(&restoreHost, "host", "", "备份主机的公网 IP（默认为本机公网 IP）")
+	consulRestoreCmd.Flags().StringVar(&restoreDate, "date", "", "备份日期，如 20250101（默认在所有日期中查找最新的 snapshot）")
+	consulRestoreCmd.Flags().StringVar(&restoreIdentity, "identity", "", "age 私钥文件路径，备份文件加密时需要（可通过 DECRYPT_IDENTITY_FILE 环境变量设置）")
+	consulRestoreCmd.Flags().StringVar(&restorePassphrase, "passphrase", "", "解密口令，备份文件使用口令加密时需要（可通过 ENCRYPT_PASSPHRASE 环境变量设置）")
+
+	consulRestoreCmd.Flags().StringVar(&consulAddress, "address", "", "Consul 服务器地址（可通过 CONSUL_ADDRESS 环境变量设置，默认为 http://127.0.0.1:8500）")
+	consulRestoreCmd.Flags().StringVar(&consulToken, "token", "", "Consul ACL Token，需要 operator:write 权限（可通过 CONSUL_TOKEN 环境变量设置）")
+	consulRestoreCmd.Flags().StringVar(&consulCACert, "cacert", "", "CA 证书文件路径（可选，用于 TLS）")
+	consulRestoreCmd.Flags().StringVar(&consulCert, "client-cert", "", "客户端证书文件路径（可选，用于 TLS）")
+	consulRestoreCmd.Flags().StringVar(&consulKey, "client-key", "", "客户端私钥文件路径（可选，用于 TLS）")
+	consulRestoreCmd.Flags().StringVar(&consulTLSServerName, "tls-server-name", "", "校验服务端证书时使用的主机名（可选）")
+	consulRestoreCmd.Flags().BoolVar(&consulTLSSkipVerify, "tls-skip-verify", false, "跳过服务端证书校验（不安全，仅用于测试）")
+	consulRestoreCmd.Flags().BoolVarP(&consulRestoreYes, "yes", "y", false, "跳过确认，直接恢复")
+}
+
+func runConsulRestore() error {
+	req, err := newRestoreRequest()
+	if err != nil {
+		return err
+	}
+
+	// 从环境变量获取 Consul 配置（如果命令行参数未设置）
+	consulAddr := consulAddress
+	if consulAddr == "" {
+		if envAddr := os.Getenv("CONSUL_ADDRESS"); envAddr != "" {
+			consulAddr = envAddr
+		} else {
+			consulAddr = "http://127.0.0.1:8500" // 默认地址
+		}
+	}
+
+	consulTok := consulToken
+	if consulTok == "" {
+		consulTok = os.Getenv("CONSUL_TOKEN")
+	}
+
+	return controller.RestoreConsul(context.Background(), controller.ConsulRestoreRequest{
+		RestoreRequest: req,
+		ConsulAddress:  consulAddr,
+		ConsulToken:    consulTok,
+		CACert:         consulCACert,
+		Cert:           consulCert,
+		Key:            consulKey,
+		TLSServerName:  consulTLSServerName,
+		TLSSkipVerify:  consulTLSSkipVerify,
+		Yes:            consulRestoreYes,
+	})
+}
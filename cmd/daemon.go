@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"backup-to-oss/internal/api"
+	"backup-to-oss/internal/config"
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/scheduler"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+)
+
+var (
+	shutdownTimeout time.Duration // 退出时等待正在执行的任务完成的最长时间
+	apiListen       string        // HTTP 控制接口监听地址
+	apiToken        string        // HTTP 控制接口认证 token
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "常驻运行，按备份任务配置文件中的 schedule 定时执行备份任务",
+	Long: `常驻运行，按备份任务配置文件（默认为当前目录下的 backup.yaml）中每个任务的 schedule 定时执行备份任务，
+替代在每台主机上为每个子命令配置 crontab。没有设置 schedule 的任务不会定时执行，只能通过 HTTP 控制接口触发。
+
+- schedule 支持标准的 5 段 cron 表达式（分 时 日 月 周）、@daily/@hourly 等描述符、@every 1h，
+  以及 CRON_TZ=Asia/Shanghai 前缀指定时区（默认使用本地时区）
+- jitter 为最大随机延迟，如 5m，避免多台主机同时执行
+- 同一个任务上一次执行尚未完成时跳过本次执行
+- 收到 SIGHUP 时重新加载配置文件，配置文件有错误时继续使用原来的配置
+- 收到 SIGTERM/SIGINT 时停止调度，等待正在执行的任务完成（最长 --shutdown-timeout）后退出
+
+设置 --api-listen 时启动 HTTP 控制接口（需要 --api-token 认证）：
+  GET  /healthz                   健康检查（不需要认证）
+  GET  /metrics                   Prometheus 指标（不需要认证）
+  GET  /api/v1/jobs               任务列表和调度状态
+  POST /api/v1/jobs/{name}/run    立即执行任务，?wait=true 时等待执行结束
+  GET  /api/v1/runs               最近的执行记录，支持 ?job= 和 ?limit=
+  GET  /api/v1/runs/{id}          执行记录的状态和进度
+
+示例:
+  backup-to-oss daemon
+  或
+  backup-to-oss daemon --config /etc/backup-to-oss/backup.yaml --log-dir /var/log/backup-to-oss
+  或
+  backup-to-oss daemon --api-listen 127.0.0.1:8080 --api-token your-token`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDaemon(cmd); err != nil {
+			logger.Error("daemon 运行失败", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().StringVarP(&jobsFile, "config", "f", "", "备份任务配置文件路径（可通过 BACKUP_CONFIG 环境变量设置，默认为当前目录下的 backup.yaml）")
+	daemonCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 5*time.Minute, "退出时等待正在执行的任务完成的最长时间，超时后取消任务")
+	daemonCmd.Flags().StringVar(&apiListen, "api-listen", "", "HTTP 控制接口监听地址，如 127.0.0.1:8080（可通过 API_LISTEN 环境变量设置，为空时不启动）")
+	daemonCmd.Flags().StringVar(&apiToken, "api-token", "", "HTTP 控制接口认证 token（可通过 API_TOKEN 环境变量设置）")
+}
+
+func runDaemon(cmd *cobra.Command) error {
+	config.LoadEnvFile(envFile)
+	listen := valueOrEnv(apiListen, "API_LISTEN")
+	token := valueOrEnv(apiToken, "API_TOKEN")
+	if listen != "" && token == "" {
+		return fmt.Errorf("启动 HTTP 控制接口需要设置 --api-token 或 API_TOKEN 环境变量")
+	}
+
+	jobs, err := loadScheduledJobs(cmd, listen != "")
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	s := scheduler.New()
+	s.Start(jobs)
+
+	var server *api.Server
+	if listen != "" {
+		server = api.NewServer(listen, token, s)
+		if err := server.Start(); err != nil {
+			s.Stop(context.Background())
+			return fmt.Errorf("启动 HTTP 控制接口失败: %v", err)
+		}
+	}
+	logger.Info("daemon 已启动", "jobs", len(jobs), "pid", os.Getpid())
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			logger.Info("收到 SIGHUP，重新加载配置文件")
+			jobs, err := loadScheduledJobs(cmd, server != nil)
+			if err != nil {
+				logger.Error("重新加载配置文件失败，继续使用原来的配置", "error", err)
+				continue
+			}
+			s.Start(jobs)
+			logger.Info("配置文件已重新加载", "jobs", len(jobs))
+			continue
+		}
+
+		logger.Info("收到退出信号，等待正在执行的任务完成", "signal", sig.String(), "timeout", shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		err := s.Stop(ctx)
+		if server != nil {
+			// 任务已结束，?wait=true 的请求可以返回执行结果
+			if err := server.Shutdown(ctx); err != nil {
+				logger.Warn("关闭 HTTP 控制接口失败", "error", err)
+			}
+		}
+		cancel()
+		if err != nil {
+			return fmt.Errorf("等待任务完成超时，已取消正在执行的任务")
+		}
+		logger.Info("daemon 已退出")
+		return nil
+	}
+	return nil
+}
+
+// loadScheduledJobs 加载备份任务配置文件中的任务
+// 没有设置 schedule 的任务只能通过 HTTP 控制接口触发，withAPI 为 false 时至少需要一个设置了 schedule 的任务
+func loadScheduledJobs(cmd *cobra.Command, withAPI bool) ([]scheduler.Job, error) {
+	file, err := loadJobsFile()
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []scheduler.Job
+	scheduled := 0
+	for _, job := range file.Jobs {
+		if job.Jitter < 0 {
+			return nil, fmt.Errorf("任务 %s 的 jitter 不能为负数", job.Name)
+		}
+		var schedule cron.Schedule
+		if job.Schedule == "" {
+			logger.Info("任务没有设置 schedule，不会定时执行", "job", job.Name)
+		} else {
+			if schedule, err = scheduler.Parse(job.Schedule); err != nil {
+				return nil, fmt.Errorf("任务 %s 的 schedule 无效: %v", job.Name, err)
+			}
+			scheduled++
+		}
+		jobs = append(jobs, scheduler.Job{
+			Name:     job.Name,
+			Spec:     job.Schedule,
+			Schedule: schedule,
+			Jitter:   job.Jitter,
+			Run: func(ctx context.Context) error {
+				return runJob(ctx, cmd, job)
+			},
+		})
+	}
+	if scheduled == 0 && !withAPI {
+		return nil, fmt.Errorf("配置文件 %s 中没有设置 schedule 的任务", file.Path)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"backup-to-oss/internal/config"
+	"backup-to-oss/internal/controller"
+	"backup-to-oss/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	decryptInput      string // 加密的备份文件路径
+	decryptOutput     string // 解密后的文件路径
+	decryptIdentity   string // age 私钥文件路径
+	decryptPassphrase string // 解密口令
+)
+
+// decryptCmd represents the decrypt command
+var decryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "解密下载的 .age 备份文件",
+	Long: `使用 age 私钥文件或口令解密通过 --encrypt-recipient/--encrypt-passphrase 加密的备份文件。
+解密后的文件为压缩的归档文件，可以直接使用 tar/zstd/gzip 解压。
+
+配置可以通过以下方式提供：
+1. .env 文件（可通过 --env-file 指定路径）
+2. 环境变量（DECRYPT_IDENTITY_FILE、ENCRYPT_PASSPHRASE）
+3. 命令行参数（优先级最高）
+
+示例:
+  backup-to-oss decrypt --input 20250101-020000_data.tar.zst.age --identity /root/.config/age/key.txt
+  或
+  backup-to-oss decrypt --input etcd-snapshot-20250101-020000.db.zst.age --output snapshot.db.zst --passphrase 'my secret'`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDecrypt(); err != nil {
+			logger.Error("解密失败", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(decryptCmd)
+
+	decryptCmd.Flags().StringVarP(&decryptInput, "input", "i", "", "要解密的 .age 文件路径")
+	decryptCmd.Flags().StringVarP(&decryptOutput, "output", "o", "", "解密后的文件路径（默认去掉 .age 后缀）")
+	decryptCmd.Flags().StringVar(&decryptIdentity, "identity", "", "age 私钥文件路径（可通过 DECRYPT_IDENTITY_FILE 环境变量设置）")
+	decryptCmd.Flags().StringVar(&decryptPassphrase, "passphrase", "", "解密口令（可通过 ENCRYPT_PASSPHRASE 环境变量设置）")
+}
+
+func runDecrypt() error {
+	// 加载配置（从 .env 文件或环境变量）
+	cfg, err := config.LoadConfig(envFile)
+	if err != nil {
+		return fmt.Errorf("加载配置失败: %v", err)
+	}
+
+	// 合并命令行参数（命令行参数优先级更高）
+	cfg.MergeWithDecryptFlags(decryptIdentity, decryptPassphrase)
+
+	req := controller.DecryptRequest{
+		InputFile:  decryptInput,
+		OutputFile: decryptOutput,
+		Encryption: cfg.Encryption,
+	}
+	return controller.Decrypt(req)
+}
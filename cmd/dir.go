@@ -4,9 +4,11 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"backup-to-oss/internal/catalog"
 	"backup-to-oss/internal/config"
 	"backup-to-oss/internal/controller"
 	"backup-to-oss/internal/logger"
@@ -17,6 +19,8 @@ import (
 var (
 	dirPath         string
 	excludePatterns string
+	incrementalDir  bool   // 是否增量备份
+	fullInterval    string // 增量备份时完整备份的间隔
 )
 
 // dirCmd represents the dir command
@@ -37,11 +41,13 @@ OSS 相关配置（--endpoint, --access-key, --secret-key, --bucket, --prefix）
   或
   backup-to-oss dir --path /path/to/dir --endpoint oss-cn-hangzhou.aliyuncs.com --bucket my-bucket
   或
-  backup-to-oss --env-file /path/to/.env dir --path /path/to/dir`,
+  backup-to-oss --env-file /path/to/.env dir --path /path/to/dir
+  或
+  backup-to-oss dir --path /data --incremental --full-interval 168h`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := runDirBackup(); err != nil {
 			logger.Error("备份失败", "error", err)
-			os.Exit(1)
+			os.Exit(backupExitCode(err))
 		}
 	},
 }
@@ -51,6 +57,10 @@ func init() {
 
 	dirCmd.Flags().StringVarP(&dirPath, "path", "p", "", "要备份的目录路径，支持多个目录用逗号分隔（可通过 DIRS_TO_BACKUP 环境变量设置）")
 	dirCmd.Flags().StringVarP(&excludePatterns, "exclude", "x", "", "排除模式，支持多个模式用逗号分隔（可通过 EXCLUDE_PATTERNS 环境变量设置），支持 glob 模式，如: *.log,node_modules,.git")
+	dirCmd.Flags().BoolVar(&incrementalDir, "incremental", false, "增量备份：只打包上一次备份之后新增或修改的文件（可通过 INCREMENTAL 环境变量设置）")
+	dirCmd.Flags().StringVar(&fullInterval, "full-interval", "", "增量备份时完整备份的间隔，如 168h（可通过 FULL_INTERVAL 环境变量设置，默认为 168h）")
+	addBackupFlags(dirCmd)
+	addFailFastFlag(dirCmd)
 }
 
 func runDirBackup() error {
@@ -80,6 +90,36 @@ func runDirBackup() error {
 
 	// 合并命令行参数（命令行参数优先级更高）
 	cfg.MergeWithFlags(dirPath, excludePatterns, compressMethodValue, ossEndpoint, ossAccessKey, ossSecretKey, ossBucket, ossObjectPrefix)
+	cfg.MergeWithDestFlag(destinations)
+	cfg.MergeWithRepositoryFlag(repository)
+	cfg.MergeWithS3Flags(s3Endpoint, s3Region, s3AccessKey, s3SecretKey, s3PathStyle)
+	cfg.MergeWithSFTPFlags(sftpKeyFile, sftpKeyPass, sftpKnownHosts)
+	cfg.MergeWithOSSOptionFlags(ossSSE, ossKMSKeyID, ossStorageClass)
+	cfg.MergeWithUploadFlags(partSizeMB, uploadParallel, checkpointDir, streamUpload)
+	cfg.MergeWithFailFastFlag(failFast)
+	if err := cfg.MergeWithIncrementalFlags(incrementalDir, fullInterval); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithTagFlags(jobName, objectTags); err != nil {
+		return err
+	}
+	cfg.MergeWithEncryptFlags(encryptTo, encryptToFile, encryptPass)
+	if err := cfg.MergeWithRetryFlags("dir", retryAttempts, retryInitial, retryMaxDelay); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithPruneFlags(keepLast, keepDaily, keepWeekly, keepMonthly, keepYearly, pruneAfter); err != nil {
+		return err
+	}
+	cfg.MergeWithMetricsFlags(metricsTextfile)
+	if err := cfg.MergeWithReportFlags(outputFormat, reportFile); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithNotifyFlags(notifyOn, notifyTemplate, notifyWebhook, notifyDingTalk, notifyFeishu, notifySlack, notifyEmail); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithHookFlags(hookPre, hookPostSuccess, hookPostFailure, hookAlways, hookTimeout); err != nil {
+		return err
+	}
 
 	// 验证配置
 	if err := cfg.Validate(); err != nil {
@@ -92,12 +132,17 @@ func runDirBackup() error {
 		ExcludePatterns: cfg.ExcludePatterns,
 		CompressMethod:  cfg.CompressMethod,
 		KeepBackupFiles: keepBackupFilesFlag,
-		OSSEndpoint:     cfg.OSSEndpoint,
-		OSSAccessKey:    cfg.OSSAccessKey,
-		OSSSecretKey:    cfg.OSSSecretKey,
-		OSSBucket:       cfg.OSSBucket,
-		OSSObjectPrefix: cfg.OSSObjectPrefix,
+		Stream:          cfg.Stream,
+		FailFast:        cfg.FailFast,
+		Incremental:     cfg.Incremental,
+		FullInterval:    cfg.FullInterval,
+		IndexCacheDir:   cfg.IndexCacheDir,
+		Retry:           cfg.Retry,
+		Retention:       cfg.PrunePolicy(),
+		Storage:         newStorageConfig(cfg),
 	}
 
-	return controller.DirBackup(req)
+	return observeBackup(context.Background(), cfg, catalog.TypeDir, func(ctx context.Context) error {
+		return controller.DirBackup(ctx, req)
+	})
 }
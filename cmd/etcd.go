@@ -4,11 +4,13 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
+	"backup-to-oss/internal/catalog"
 	"backup-to-oss/internal/config"
 	"backup-to-oss/internal/controller"
 	"backup-to-oss/internal/logger"
@@ -72,6 +74,7 @@ func init() {
 	etcdCmd.Flags().StringVar(&etcdPassword, "password", "", "etcd 密码（可通过 ETCD_PASSWORD 环境变量设置，可选）")
 	etcdCmd.Flags().StringVar(&etcdDialTimeout, "dial-timeout", "", "连接超时时间（可通过 ETCD_DIAL_TIMEOUT 环境变量设置，如 20s，默认 5s）")
 	etcdCmd.Flags().StringVar(&etcdCommandTimeout, "command-timeout", "", "命令超时时间（可通过 ETCD_COMMAND_TIMEOUT 环境变量设置，如 60s，默认无超时）")
+	addBackupFlags(etcdCmd)
 }
 
 func runEtcdBackup() error {
@@ -178,19 +181,36 @@ func runEtcdBackup() error {
 
 	// 合并命令行参数（命令行参数优先级更高）
 	cfg.MergeWithFlags("", "", compressMethodValue, ossEndpoint, ossAccessKey, ossSecretKey, ossBucket, ossObjectPrefix)
-
-	// 验证 OSS 配置
-	if cfg.OSSEndpoint == "" {
-		return fmt.Errorf("OSS端点未设置（通过 --endpoint 参数或 OSS_ENDPOINT 环境变量）")
+	cfg.MergeWithDestFlag(destinations)
+	cfg.MergeWithRepositoryFlag(repository)
+	cfg.MergeWithS3Flags(s3Endpoint, s3Region, s3AccessKey, s3SecretKey, s3PathStyle)
+	cfg.MergeWithSFTPFlags(sftpKeyFile, sftpKeyPass, sftpKnownHosts)
+	cfg.MergeWithOSSOptionFlags(ossSSE, ossKMSKeyID, ossStorageClass)
+	cfg.MergeWithUploadFlags(partSizeMB, uploadParallel, checkpointDir, streamUpload)
+	if err := cfg.MergeWithTagFlags(jobName, objectTags); err != nil {
+		return err
+	}
+	cfg.MergeWithEncryptFlags(encryptTo, encryptToFile, encryptPass)
+	if err := cfg.MergeWithRetryFlags("etcd", retryAttempts, retryInitial, retryMaxDelay); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithPruneFlags(keepLast, keepDaily, keepWeekly, keepMonthly, keepYearly, pruneAfter); err != nil {
+		return err
 	}
-	if cfg.OSSAccessKey == "" {
-		return fmt.Errorf("OSS AccessKey未设置（通过 --access-key 参数或 OSS_ACCESS_KEY 环境变量）")
+	cfg.MergeWithMetricsFlags(metricsTextfile)
+	if err := cfg.MergeWithReportFlags(outputFormat, reportFile); err != nil {
+		return err
 	}
-	if cfg.OSSSecretKey == "" {
-		return fmt.Errorf("OSS SecretKey未设置（通过 --secret-key 参数或 OSS_SECRET_KEY 环境变量）")
+	if err := cfg.MergeWithNotifyFlags(notifyOn, notifyTemplate, notifyWebhook, notifyDingTalk, notifyFeishu, notifySlack, notifyEmail); err != nil {
+		return err
 	}
-	if cfg.OSSBucket == "" {
-		return fmt.Errorf("OSS存储桶未设置（通过 --bucket 参数或 OSS_BUCKET 环境变量）")
+	if err := cfg.MergeWithHookFlags(hookPre, hookPostSuccess, hookPostFailure, hookAlways, hookTimeout); err != nil {
+		return err
+	}
+
+	// 验证备份目标配置
+	if err := cfg.ValidateStorage(); err != nil {
+		return err
 	}
 
 	// 构建请求
@@ -205,12 +225,13 @@ func runEtcdBackup() error {
 		CommandTimeout:  commandTimeoutDuration,
 		CompressMethod:  cfg.CompressMethod,
 		KeepBackupFiles: keepBackupFilesFlag,
-		OSSEndpoint:     cfg.OSSEndpoint,
-		OSSAccessKey:    cfg.OSSAccessKey,
-		OSSSecretKey:    cfg.OSSSecretKey,
-		OSSBucket:       cfg.OSSBucket,
-		OSSObjectPrefix: cfg.OSSObjectPrefix,
+		Stream:          cfg.Stream,
+		Retry:           cfg.Retry,
+		Retention:       cfg.PrunePolicy(),
+		Storage:         newStorageConfig(cfg),
 	}
 
-	return controller.EtcdBackup(req)
+	return observeBackup(context.Background(), cfg, catalog.TypeEtcd, func(ctx context.Context) error {
+		return controller.EtcdBackup(ctx, req)
+	})
 }
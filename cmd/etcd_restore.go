@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"backup-to-oss/internal/controller"
+	"backup-to-oss/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	etcdRestoreName          string // etcd 成员名称
+	etcdRestoreDataDir       string // 恢复的数据目录
+	etcdRestoreCluster       string // 初始集群配置
+	etcdRestoreClusterToken  string // 初始集群 token
+	etcdRestorePeerURLs      string // 成员的 peer 地址
+	etcdRestoreSkipHashCheck bool   // 是否跳过 snapshot 的 sha256 校验
+)
+
+// etcdRestoreCmd represents the etcd restore command
+var etcdRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "从备份目标恢复 etcd snapshot 到新的数据目录",
+	Long: `从备份目标（--dest 指定的第一个目标）下载 etcd snapshot 备份，校验 SHA-256 后解密、解压，
+执行 snapshot status 检查后恢复到新的数据目录。对标 etcdutl snapshot restore 命令的功能。
+
+可以通过 --key 指定备份文件的对象键，也可以通过 --host、--date 查找最新的 snapshot。
+集群中的每个成员都需要使用同一个 snapshot 分别恢复，--initial-cluster 和 --initial-cluster-token 必须一致，
+恢复完成后使用相同的 --name、--initial-cluster、--initial-cluster-token 和 --data-dir 启动 etcd。
+
+示例:
+  backup-to-oss etcd restore --data-dir /var/lib/etcd-restore
+  或
+  backup-to-oss etcd restore --host 1.2.3.4 --date 20250101 --name infra0 \
+    --initial-cluster infra0=https://10.0.0.1:2380,infra1=https://10.0.0.2:2380,infra2=https://10.0.0.3:2380 \
+    --initial-cluster-token etcd-cluster-1 --initial-advertise-peer-urls https://10.0.0.1:2380 \
+    --data-dir /var/lib/etcd-restore`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runEtcdRestore(); err != nil {
+			logger.Error("恢复失败", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	etcdCmd.AddCommand(etcdRestoreCmd)
+
+	etcdRestoreCmd.Flags().StringVar(&restoreKey, "key", "", "snapshot 备份文件的对象键（相对于备份目标根路径），指定后忽略 --host/--date")
+	etcdRestoreCmd.Flags().StringVar(&restoreHost, "host", "", "备份主机的公网 IP（默认为本机公网 IP）")
+	etcdRestoreCmd.Flags().StringVar(&restoreDate, "date", "", "备份日期，如 20250101（默认在所有日期中查找最新的 snapshot）")
+	etcdRestoreCmd.Flags().StringVar(&restoreIdentity, "identity", "", "age 私钥文件路径，备份文件加密时需要（可通过 DECRYPT_IDENTITY_FILE 环境变量设置）")
+	etcdRestoreCmd.Flags().StringVar(&restorePassphrase, "passphrase", "", "解密口令，备份文件使用口令加密时需要（可通过 ENCRYPT_PASSPHRASE 环境变量设置）")
+
+	etcdRestoreCmd.Flags().StringVar(&etcdRestoreName, "name", "default", "etcd 成员名称")
+	etcdRestoreCmd.Flags().StringVar(&etcdRestoreDataDir, "data-dir", "", "恢复的数据目录，必须不存在或为空目录（默认为 {name}.etcd）")
+	etcdRestoreCmd.Flags().StringVar(&etcdRestoreCluster, "initial-cluster", "default=http://localhost:2380", "初始集群配置，如 infra0=http://10.0.0.1:2380,infra1=http://10.0.0.2:2380")
+	etcdRestoreCmd.Flags().StringVar(&etcdRestoreClusterToken, "initial-cluster-token", "etcd-cluster", "初始集群 token")
+	etcdRestoreCmd.Flags().StringVar(&etcdRestorePeerURLs, "initial-advertise-peer-urls", "http://localhost:2380", "成员的 peer 地址，多个地址用逗号分隔")
+	etcdRestoreCmd.Flags().BoolVar(&etcdRestoreSkipHashCheck, "skip-hash-check", false, "跳过 snapshot 的 sha256 校验（从数据目录复制的 db 文件没有校验和时需要）")
+}
+
+func runEtcdRestore() error {
+	req, err := newRestoreRequest()
+	if err != nil {
+		return err
+	}
+
+	return controller.RestoreEtcd(context.Background(), controller.EtcdRestoreRequest{
+		RestoreRequest:           req,
+		MemberName:               etcdRestoreName,
+		DataDir:                  etcdRestoreDataDir,
+		InitialCluster:           etcdRestoreCluster,
+		InitialClusterToken:      etcdRestoreClusterToken,
+		InitialAdvertisePeerURLs: etcdRestorePeerURLs,
+		SkipHashCheck:            etcdRestoreSkipHashCheck,
+	})
+}
@@ -4,9 +4,11 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"backup-to-oss/internal/catalog"
 	"backup-to-oss/internal/config"
 	"backup-to-oss/internal/controller"
 	"backup-to-oss/internal/logger"
@@ -43,7 +45,7 @@ OSS 相关配置（--endpoint, --access-key, --secret-key, --bucket, --prefix）
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := runFileBackup(); err != nil {
 			logger.Error("备份失败", "error", err)
-			os.Exit(1)
+			os.Exit(backupExitCode(err))
 		}
 	},
 }
@@ -52,6 +54,8 @@ func init() {
 	rootCmd.AddCommand(fileCmd)
 
 	fileCmd.Flags().StringVarP(&filePaths, "path", "p", "", "要备份的文件路径，支持多个文件用逗号分隔（可通过 FILES_TO_BACKUP 环境变量设置）")
+	addBackupFlags(fileCmd)
+	addFailFastFlag(fileCmd)
 }
 
 func runFileBackup() error {
@@ -81,6 +85,33 @@ func runFileBackup() error {
 
 	// 合并命令行参数（命令行参数优先级更高）
 	cfg.MergeWithFileFlags(filePaths, compressMethodValue, ossEndpoint, ossAccessKey, ossSecretKey, ossBucket, ossObjectPrefix)
+	cfg.MergeWithDestFlag(destinations)
+	cfg.MergeWithRepositoryFlag(repository)
+	cfg.MergeWithS3Flags(s3Endpoint, s3Region, s3AccessKey, s3SecretKey, s3PathStyle)
+	cfg.MergeWithSFTPFlags(sftpKeyFile, sftpKeyPass, sftpKnownHosts)
+	cfg.MergeWithOSSOptionFlags(ossSSE, ossKMSKeyID, ossStorageClass)
+	cfg.MergeWithUploadFlags(partSizeMB, uploadParallel, checkpointDir, streamUpload)
+	cfg.MergeWithFailFastFlag(failFast)
+	if err := cfg.MergeWithTagFlags(jobName, objectTags); err != nil {
+		return err
+	}
+	cfg.MergeWithEncryptFlags(encryptTo, encryptToFile, encryptPass)
+	if err := cfg.MergeWithRetryFlags("file", retryAttempts, retryInitial, retryMaxDelay); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithPruneFlags(keepLast, keepDaily, keepWeekly, keepMonthly, keepYearly, pruneAfter); err != nil {
+		return err
+	}
+	cfg.MergeWithMetricsFlags(metricsTextfile)
+	if err := cfg.MergeWithReportFlags(outputFormat, reportFile); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithNotifyFlags(notifyOn, notifyTemplate, notifyWebhook, notifyDingTalk, notifyFeishu, notifySlack, notifyEmail); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithHookFlags(hookPre, hookPostSuccess, hookPostFailure, hookAlways, hookTimeout); err != nil {
+		return err
+	}
 
 	// 验证配置
 	if err := cfg.ValidateFileConfig(); err != nil {
@@ -92,12 +123,14 @@ func runFileBackup() error {
 		FilePaths:       cfg.FilePaths,
 		CompressMethod:  cfg.CompressMethod,
 		KeepBackupFiles: keepBackupFilesFlag,
-		OSSEndpoint:     cfg.OSSEndpoint,
-		OSSAccessKey:    cfg.OSSAccessKey,
-		OSSSecretKey:    cfg.OSSSecretKey,
-		OSSBucket:       cfg.OSSBucket,
-		OSSObjectPrefix: cfg.OSSObjectPrefix,
+		Stream:          cfg.Stream,
+		FailFast:        cfg.FailFast,
+		Retry:           cfg.Retry,
+		Retention:       cfg.PrunePolicy(),
+		Storage:         newStorageConfig(cfg),
 	}
 
-	return controller.FileBackup(req)
+	return observeBackup(context.Background(), cfg, catalog.TypeFile, func(ctx context.Context) error {
+		return controller.FileBackup(ctx, req)
+	})
 }
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/config"
+	"backup-to-oss/internal/controller"
+	"backup-to-oss/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	listHost   string // 只列出该主机的备份
+	listSince  string // 只列出该时间之后的备份
+	listType   string // 只列出该类型的备份
+	listOutput string // 输出格式
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "列出备份目标中的备份文件",
+	Aliases: []string{"ls"},
+	Long: `遍历备份目标（--dest 指定的第一个目标）中 {ip}/{date}/ 结构的备份文件，
+显示主机、日期、备份类型、大小、压缩方式和备份时间，按备份时间排序。
+
+--since 支持日期（20250101、2025-01-01）、时间（2025-01-01 15:04:05、RFC3339）
+以及相对时间（如 24h、7d，表示最近 24 小时、最近 7 天）。
+
+示例:
+  backup-to-oss list
+  或
+  backup-to-oss list --host 1.2.3.4 --type etcd --since 7d
+  或
+  backup-to-oss list --since 2025-01-01 --output json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runList(); err != nil {
+			logger.Error("列出备份失败", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().StringVar(&listHost, "host", "", "只列出该主机（公网 IP）的备份，默认列出所有主机")
+	listCmd.Flags().StringVar(&listSince, "since", "", "只列出该时间之后的备份，如 2025-01-01 或 7d")
+	listCmd.Flags().StringVar(&listType, "type", "", "只列出该类型的备份 (dir/file/etcd/consul/unknown)")
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", "table", "输出格式 (table/json/csv)")
+}
+
+func runList() error {
+	// 加载配置（从 .env 文件或环境变量）
+	cfg, err := config.LoadConfig(envFile)
+	if err != nil {
+		return fmt.Errorf("加载配置失败: %v", err)
+	}
+
+	// 合并命令行参数（命令行参数优先级更高）
+	cfg.MergeWithFlags("", "", "", ossEndpoint, ossAccessKey, ossSecretKey, ossBucket, ossObjectPrefix)
+	cfg.MergeWithDestFlag(destinations)
+	cfg.MergeWithRepositoryFlag(repository)
+	cfg.MergeWithS3Flags(s3Endpoint, s3Region, s3AccessKey, s3SecretKey, s3PathStyle)
+	cfg.MergeWithSFTPFlags(sftpKeyFile, sftpKeyPass, sftpKnownHosts)
+
+	// 验证备份目标配置
+	if err := cfg.ValidateStorage(); err != nil {
+		return err
+	}
+
+	var write func(w io.Writer, backups []catalog.Backup) error
+	switch listOutput {
+	case "table":
+		write = writeBackupTable
+	case "json":
+		write = writeBackupJSON
+	case "csv":
+		write = writeBackupCSV
+	default:
+		return fmt.Errorf("不支持的输出格式: %s，支持的格式: table, json, csv", listOutput)
+	}
+
+	var since time.Time
+	if listSince != "" {
+		if since, err = parseSince(listSince, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	req := controller.ListRequest{
+		Host:    listHost,
+		Since:   since,
+		Type:    listType,
+		Storage: newStorageConfig(cfg),
+	}
+	backups, err := controller.ListBackups(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	return write(os.Stdout, backups)
+}
+
+// parseSince 解析 --since 参数：日期、时间或相对时间（如 24h、7d）
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"20060102", "2006-01-02", "2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无效的 --since 格式: %s（支持 20250101、2025-01-01、2025-01-01 15:04:05、24h、7d）", s)
+}
+
+// writeBackupTable 以表格格式输出备份列表
+func writeBackupTable(w io.Writer, backups []catalog.Backup) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tDATE\tTYPE\tSIZE\tCOMPRESSION\tENCRYPTED\tTIME\tKEY")
+	for _, b := range backups {
+		host := b.Host
+		if host == "" {
+			host = "-"
+		}
+		backupType := b.Type
+		if b.Incremental {
+			backupType += " (incr)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\n",
+			host, b.Date, backupType, formatSize(b.Size), b.Compression, b.Encrypted, b.Time.Format(time.DateTime), b.Key)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n共 %d 个备份文件\n", len(backups))
+	return nil
+}
+
+// writeBackupJSON 以 JSON 格式输出备份列表
+func writeBackupJSON(w io.Writer, backups []catalog.Backup) error {
+	if backups == nil {
+		backups = []catalog.Backup{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(backups)
+}
+
+// writeBackupCSV 以 CSV 格式输出备份列表（大小为字节数，时间为 RFC3339 格式）
+func writeBackupCSV(w io.Writer, backups []catalog.Backup) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"host", "date", "type", "size", "compression", "encrypted", "time", "key", "incremental"})
+	for _, b := range backups {
+		cw.Write([]string{
+			b.Host,
+			b.Date,
+			b.Type,
+			strconv.FormatInt(b.Size, 10),
+			b.Compression,
+			strconv.FormatBool(b.Encrypted),
+			b.Time.Format(time.RFC3339),
+			b.Key,
+			strconv.FormatBool(b.Incremental),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatSize 将字节数格式化为便于阅读的大小
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
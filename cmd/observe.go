@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"backup-to-oss/internal/config"
+	"backup-to-oss/internal/controller"
+	"backup-to-oss/internal/hook"
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/metrics"
+	"backup-to-oss/internal/notify"
+	"backup-to-oss/internal/report"
+
+	"github.com/rboyer/safeio"
+)
+
+var (
+	textfileMu     sync.Mutex          // 串行写入指标文件（daemon 中的任务可能同时结束）
+	textfileLoaded = map[string]bool{} // 已恢复过指标的指标文件
+	stdoutMu       sync.Mutex          // 串行输出运行报告
+)
+
+// observeBackup 执行备份，记录指标和运行报告并发送备份结果通知，任务名称为空时使用备份类型作为任务名称
+// 备份前执行 pre hook（失败时不执行备份，按备份失败处理），备份结束后按备份结果执行 post hook
+// 设置了指标文件时，第一次使用前先从文件恢复之前的指标（累加失败次数、保留其他任务的指标），备份结束后将所有指标写入文件
+// 运行报告按配置输出到标准输出（--report-format json）和报告文件，写入失败只记录日志
+func observeBackup(ctx context.Context, cfg *config.Config, backupType string, backup func(ctx context.Context) error) error {
+	job := cfg.JobName
+	if job == "" {
+		job = backupType
+	}
+	notifier, err := notify.New(cfg.Notify)
+	if err != nil {
+		return err
+	}
+	if cfg.MetricsTextfile != "" {
+		loadMetricsTextfile(cfg.MetricsTextfile)
+	}
+
+	hostname, _ := os.Hostname()
+	run := &metrics.Run{}
+	start := time.Now()
+	rep := report.New(job, backupType, hostname, start)
+	if err = cfg.Hooks.RunPre(ctx, hook.Env{Job: job, Type: backupType}); err == nil {
+		err = backup(report.WithReport(metrics.WithRun(ctx, run), rep))
+	}
+	duration := time.Since(start)
+	summary := run.Summary()
+	status, errMsg := notify.StatusSuccess, ""
+	if err != nil {
+		status, errMsg = notify.StatusFailure, err.Error()
+		if controller.IsPartial(err) {
+			status = notify.StatusPartial
+		}
+	}
+	cfg.Hooks.RunPost(ctx, hook.Env{
+		Job:          job,
+		Type:         backupType,
+		Host:         summary.Host,
+		Status:       status,
+		Error:        errMsg,
+		ObjectKeys:   summary.Objects,
+		ArchivePaths: rep.ArchivePaths(),
+	}, err != nil)
+
+	metrics.Observe(job, backupType, duration, run, err)
+	rep.Finish(start.Add(duration), err)
+	writeReport(cfg, rep)
+
+	if cfg.MetricsTextfile != "" {
+		textfileMu.Lock()
+		if werr := metrics.WriteTextfile(cfg.MetricsTextfile); werr != nil {
+			logger.Warn("写入指标文件失败", "path", cfg.MetricsTextfile, "error", werr)
+		}
+		textfileMu.Unlock()
+	}
+
+	event := notify.Event{
+		Job:       job,
+		Type:      backupType,
+		Status:    status,
+		Host:      summary.Host,
+		Hostname:  hostname,
+		Objects:   summary.Objects,
+		Size:      summary.ArchiveSize,
+		StartedAt: start,
+		Duration:  duration,
+		Error:     errMsg,
+	}
+	notifier.Notify(ctx, event)
+	return err
+}
+
+// writeReport 按配置将运行报告输出到标准输出（单行 JSON，多个任务时每行一个报告）和报告文件
+func writeReport(cfg *config.Config, rep *report.Report) {
+	if cfg.Output == "json" {
+		data, err := json.Marshal(rep)
+		if err != nil {
+			logger.Warn("生成运行报告失败", "error", err)
+			return
+		}
+		stdoutMu.Lock()
+		fmt.Fprintln(os.Stdout, string(data))
+		stdoutMu.Unlock()
+	}
+	if cfg.ReportFile != "" {
+		data, err := json.MarshalIndent(rep, "", "  ")
+		if err != nil {
+			logger.Warn("生成运行报告失败", "error", err)
+			return
+		}
+		if _, err := safeio.WriteToFile(bytes.NewReader(data), cfg.ReportFile, 0644); err != nil {
+			logger.Warn("写入运行报告文件失败", "path", cfg.ReportFile, "error", err)
+			return
+		}
+		logger.Info("已写入运行报告", "path", cfg.ReportFile)
+	}
+}
+
+// loadMetricsTextfile 从指标文件恢复之前的指标（每个文件只恢复一次）
+func loadMetricsTextfile(path string) {
+	textfileMu.Lock()
+	defer textfileMu.Unlock()
+	if textfileLoaded[path] {
+		return
+	}
+	textfileLoaded[path] = true
+	if err := metrics.LoadTextfile(path); err != nil {
+		// 文件损坏时忽略，备份结束后覆盖写入
+		logger.Warn("恢复指标文件失败，将覆盖该文件", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"backup-to-oss/internal/config"
+	"backup-to-oss/internal/controller"
+	"backup-to-oss/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneHost   string // 只清理该主机的备份
+	pruneType   string // 只清理该类型的备份
+	pruneDryRun bool   // 只输出清理计划，不删除
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "按保留规则清理备份目标中的旧备份",
+	Long: `按保留规则（GFS）清理所有备份目标（--dest 指定的所有目标）中的旧备份。
+
+备份文件按主机、备份类型和来源（如目录路径）分组，每组独立计算需要保留的备份：
+  --keep-last     保留最近的 N 个备份
+  --keep-daily    保留最近 N 天每天最新的一个备份
+  --keep-weekly   保留最近 N 周每周最新的一个备份
+  --keep-monthly  保留最近 N 个月每月最新的一个备份
+  --keep-yearly   保留最近 N 年每年最新的一个备份
+备份满足任意一条规则即保留，其余的备份及其 .sha256 校验文件会被删除。
+建议先使用 --dry-run 查看清理计划。
+
+也可以在备份命令中指定 --prune，备份成功后自动清理该主机同类型的旧备份。
+
+示例:
+  backup-to-oss prune --keep-daily 7 --keep-weekly 4 --keep-monthly 6 --dry-run
+  或
+  backup-to-oss prune --host 1.2.3.4 --type etcd --keep-last 10`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runPrune(); err != nil {
+			logger.Error("清理旧备份失败", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().StringVar(&pruneHost, "host", "", "只清理该主机（公网 IP）的备份，默认清理所有主机")
+	pruneCmd.Flags().StringVar(&pruneType, "type", "", "只清理该类型的备份 (dir/file/etcd/consul)，默认清理所有类型")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "只输出清理计划，不删除任何文件")
+	addRetentionFlags(pruneCmd)
+}
+
+func runPrune() error {
+	// 加载配置（从 .env 文件或环境变量）
+	cfg, err := config.LoadConfig(envFile)
+	if err != nil {
+		return fmt.Errorf("加载配置失败: %v", err)
+	}
+
+	// 合并命令行参数（命令行参数优先级更高）
+	cfg.MergeWithFlags("", "", "", ossEndpoint, ossAccessKey, ossSecretKey, ossBucket, ossObjectPrefix)
+	cfg.MergeWithDestFlag(destinations)
+	cfg.MergeWithRepositoryFlag(repository)
+	cfg.MergeWithS3Flags(s3Endpoint, s3Region, s3AccessKey, s3SecretKey, s3PathStyle)
+	cfg.MergeWithSFTPFlags(sftpKeyFile, sftpKeyPass, sftpKnownHosts)
+	if err := cfg.MergeWithPruneFlags(keepLast, keepDaily, keepWeekly, keepMonthly, keepYearly, false); err != nil {
+		return err
+	}
+
+	// 验证备份目标配置
+	if err := cfg.ValidateStorage(); err != nil {
+		return err
+	}
+
+	req := controller.PruneRequest{
+		Host:    pruneHost,
+		Type:    pruneType,
+		Policy:  cfg.Retention,
+		DryRun:  pruneDryRun,
+		Storage: newStorageConfig(cfg),
+	}
+	return controller.Prune(context.Background(), req)
+}
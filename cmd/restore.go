@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"backup-to-oss/internal/config"
+	"backup-to-oss/internal/controller"
+	"backup-to-oss/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	restoreKey        string // 备份文件的对象键
+	restoreHost       string // 备份主机的公网 IP
+	restoreDate       string // 备份日期
+	restoreName       string // 备份文件名关键字
+	restoreTarget     string // 解压目录
+	restoreOutput     string // 单个文件备份的输出路径
+	restoreStrip      int    // 去掉归档路径中前 N 级目录
+	restoreIncludes   string // 只恢复匹配的路径
+	restoreOverwrite  bool   // 是否覆盖已存在的文件
+	restoreIdentity   string // age 私钥文件路径
+	restorePassphrase string // 解密口令
+)
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "从备份目标下载并恢复备份",
+	Long: `从备份目标（--dest 指定的第一个目标）下载备份文件，校验 SHA-256 后解密、解压到目标目录。
+
+可以通过 --key 指定备份文件的对象键，也可以通过 --host、--date、--name 查找最新的备份：
+对象路径为 {prefix}/{ip}/{date}/{name}，--host 默认为本机公网 IP，未指定 --date 时在所有日期中查找。
+
+示例:
+  backup-to-oss restore dir --name etc_nginx --target /tmp/restore
+  或
+  backup-to-oss restore dir --host 1.2.3.4 --date 20250101 --name data --target /data --strip 1 --include 'www/*'
+  或
+  backup-to-oss restore file --key 1.2.3.4/20250101/20250101-020000_app.zst --output /etc/app.conf`,
+}
+
+// restoreDirCmd represents the restore dir command
+var restoreDirCmd = &cobra.Command{
+	Use:   "dir",
+	Short: "恢复目录备份",
+	Long: `下载目录备份（.tar.zst/.tgz/.tar）并解压到 --target 指定的目录。
+解压时拒绝绝对路径、包含 .. 的路径以及指向目标目录之外的链接。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runRestore(controller.RestoreDir); err != nil {
+			logger.Error("恢复失败", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// restoreFileCmd represents the restore file command
+var restoreFileCmd = &cobra.Command{
+	Use:   "file",
+	Short: "恢复文件备份",
+	Long: `下载文件备份并解压：单个文件的备份输出到 --output 指定的路径（默认为 --target 目录下去掉时间前缀的文件名），
+多个文件的备份（*_files.tar.zst）解压到 --target 指定的目录。
+备份文件名中不包含原始文件的扩展名，恢复单个文件时建议通过 --output 指定完整的文件路径。`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runRestore(controller.RestoreFile); err != nil {
+			logger.Error("恢复失败", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+	restoreCmd.AddCommand(restoreDirCmd)
+	restoreCmd.AddCommand(restoreFileCmd)
+
+	restoreCmd.PersistentFlags().StringVar(&restoreKey, "key", "", "备份文件的对象键（相对于备份目标根路径，如 1.2.3.4/20250101/20250101-020000_etc.tar.zst），指定后忽略 --host/--date/--name")
+	restoreCmd.PersistentFlags().StringVar(&restoreHost, "host", "", "备份主机的公网 IP（默认为本机公网 IP）")
+	restoreCmd.PersistentFlags().StringVar(&restoreDate, "date", "", "备份日期，如 20250101（默认在所有日期中查找最新的备份）")
+	restoreCmd.PersistentFlags().StringVar(&restoreName, "name", "", "备份文件名包含的关键字，如目录路径 etc_nginx")
+	restoreCmd.PersistentFlags().StringVarP(&restoreTarget, "target", "t", ".", "解压目录")
+	restoreCmd.PersistentFlags().IntVar(&restoreStrip, "strip", 0, "去掉归档路径中前 N 级目录（与 tar --strip-components 相同）")
+	restoreCmd.PersistentFlags().StringVar(&restoreIncludes, "include", "", "只恢复匹配的路径，支持 glob 模式，多个模式用逗号分隔（匹配去掉前缀后的路径或其上级目录）")
+	restoreCmd.PersistentFlags().BoolVar(&restoreOverwrite, "overwrite", false, "覆盖已存在的文件")
+	restoreCmd.PersistentFlags().StringVar(&restoreIdentity, "identity", "", "age 私钥文件路径，备份文件加密时需要（可通过 DECRYPT_IDENTITY_FILE 环境变量设置）")
+	restoreCmd.PersistentFlags().StringVar(&restorePassphrase, "passphrase", "", "解密口令，备份文件使用口令加密时需要（可通过 ENCRYPT_PASSPHRASE 环境变量设置）")
+
+	restoreFileCmd.Flags().StringVarP(&restoreOutput, "output", "o", "", "单个文件备份的输出路径（默认为 --target 目录下去掉时间前缀的文件名）")
+}
+
+// runRestore 加载配置并执行恢复
+func runRestore(restore func(ctx context.Context, req controller.RestoreRequest) error) error {
+	req, err := newRestoreRequest()
+	if err != nil {
+		return err
+	}
+	return restore(context.Background(), req)
+}
+
+// newRestoreRequest 加载配置并根据命令行参数构建恢复请求
+func newRestoreRequest() (controller.RestoreRequest, error) {
+	// 加载配置（从 .env 文件或环境变量）
+	cfg, err := config.LoadConfig(envFile)
+	if err != nil {
+		return controller.RestoreRequest{}, fmt.Errorf("加载配置失败: %v", err)
+	}
+
+	// 合并命令行参数（命令行参数优先级更高）
+	cfg.MergeWithFlags("", "", "", ossEndpoint, ossAccessKey, ossSecretKey, ossBucket, ossObjectPrefix)
+	cfg.MergeWithDestFlag(destinations)
+	cfg.MergeWithRepositoryFlag(repository)
+	cfg.MergeWithS3Flags(s3Endpoint, s3Region, s3AccessKey, s3SecretKey, s3PathStyle)
+	cfg.MergeWithSFTPFlags(sftpKeyFile, sftpKeyPass, sftpKnownHosts)
+	cfg.MergeWithDecryptFlags(restoreIdentity, restorePassphrase)
+
+	// 验证备份目标配置
+	if err := cfg.ValidateStorage(); err != nil {
+		return controller.RestoreRequest{}, err
+	}
+	if restoreStrip < 0 {
+		return controller.RestoreRequest{}, fmt.Errorf("--strip 不能为负数: %d", restoreStrip)
+	}
+
+	var includes []string
+	for _, pattern := range strings.Split(restoreIncludes, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			includes = append(includes, pattern)
+		}
+	}
+
+	return controller.RestoreRequest{
+		Key:        restoreKey,
+		Host:       restoreHost,
+		Date:       restoreDate,
+		Name:       restoreName,
+		TargetDir:  restoreTarget,
+		Output:     restoreOutput,
+		Strip:      restoreStrip,
+		Includes:   includes,
+		Overwrite:  restoreOverwrite,
+		Encryption: cfg.Encryption,
+		Storage:    newStorageConfig(cfg),
+	}, nil
+}
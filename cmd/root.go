@@ -6,22 +6,71 @@ package cmd
 import (
 	"os"
 
+	"backup-to-oss/internal/config"
+	"backup-to-oss/internal/controller"
 	"backup-to-oss/internal/logger"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	logLevel        string // 日志级别
-	logDir          string // 日志目录
-	envFile         string // .env 文件路径
-	compressMethod  string // 压缩方式
-	keepBackupFiles bool   // 是否保留备份文件
-	ossEndpoint     string // OSS端点地址
-	ossAccessKey    string // OSS AccessKey
-	ossSecretKey    string // OSS SecretKey
-	ossBucket       string // OSS存储桶名称
-	ossObjectPrefix string // OSS对象前缀
+	logLevel        string   // 日志级别
+	logDir          string   // 日志目录
+	envFile         string   // .env 文件路径
+	compressMethod  string   // 压缩方式
+	keepBackupFiles bool     // 是否保留备份文件
+	ossEndpoint     string   // OSS端点地址
+	ossAccessKey    string   // OSS AccessKey
+	ossSecretKey    string   // OSS SecretKey
+	ossBucket       string   // OSS存储桶名称
+	ossObjectPrefix string   // OSS对象前缀
+	ossSSE          string   // OSS 服务端加密方式
+	ossKMSKeyID     string   // OSS KMS 密钥 ID
+	ossStorageClass string   // OSS 存储类型
+	jobName         string   // 备份任务名称
+	objectTags      string   // 对象标签
+	destinations    string   // 备份目标地址列表
+	repository      bool     // 是否使用去重仓库
+	s3Endpoint      string   // S3 自定义端点
+	s3Region        string   // S3 区域
+	s3AccessKey     string   // S3 AccessKey
+	s3SecretKey     string   // S3 SecretKey
+	s3PathStyle     bool     // S3 是否使用 path-style 地址
+	sftpKeyFile     string   // SFTP 私钥文件路径
+	sftpKeyPass     string   // SFTP 私钥密码
+	sftpKnownHosts  string   // SFTP known_hosts 文件路径
+	partSizeMB      int      // 分片大小（MB）
+	uploadParallel  int      // 分片上传并发数
+	checkpointDir   string   // 断点续传 checkpoint 目录
+	streamUpload    bool     // 是否流式上传
+	failFast        bool     // 一个目录或文件备份失败时立即停止
+	retryAttempts   int      // 最大尝试次数
+	retryInitial    string   // 第一次重试前的等待时间
+	retryMaxDelay   string   // 最长重试等待时间
+	encryptTo       string   // age 加密公钥列表
+	encryptToFile   string   // age 加密公钥文件路径
+	encryptPass     string   // 加密口令
+	keepLast        int      // 保留最近的 N 个备份
+	keepDaily       int      // 保留最近 N 天每天最新的一个备份
+	keepWeekly      int      // 保留最近 N 周每周最新的一个备份
+	keepMonthly     int      // 保留最近 N 个月每月最新的一个备份
+	keepYearly      int      // 保留最近 N 年每年最新的一个备份
+	pruneAfter      bool     // 备份成功后是否清理旧备份
+	metricsTextfile string   // node_exporter textfile collector 指标文件路径
+	outputFormat    string   // 运行报告输出格式
+	reportFile      string   // 运行报告文件路径
+	notifyOn        string   // 发送通知的时机
+	notifyTemplate  string   // 通知消息模板文件路径
+	notifyWebhook   string   // 通用 JSON webhook 地址
+	notifyDingTalk  string   // 钉钉机器人 webhook 地址
+	notifyFeishu    string   // 飞书机器人 webhook 地址
+	notifySlack     string   // Slack incoming webhook 地址
+	notifyEmail     string   // 邮件通知收件人列表
+	hookPre         []string // 备份前执行的命令
+	hookPostSuccess []string // 备份成功后执行的命令
+	hookPostFailure []string // 备份失败后执行的命令
+	hookAlways      []string // 无论备份是否成功都执行的命令
+	hookTimeout     string   // hook 命令的超时时间
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -51,6 +100,20 @@ to quickly create a Cobra application.`,
 	},
 }
 
+// 备份命令的退出码
+const (
+	exitFailure = 1 // 备份失败
+	exitPartial = 2 // 部分目录、文件或任务备份失败
+)
+
+// backupExitCode 返回备份失败时的退出码，部分失败时为 exitPartial
+func backupExitCode(err error) int {
+	if controller.IsPartial(err) {
+		return exitPartial
+	}
+	return exitFailure
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -87,4 +150,104 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&ossSecretKey, "secret-key", "s", "", "OSS SecretKey（可通过 OSS_SECRET_KEY 环境变量设置）")
 	rootCmd.PersistentFlags().StringVarP(&ossBucket, "bucket", "b", "", "OSS存储桶名称（可通过 OSS_BUCKET 环境变量设置）")
 	rootCmd.PersistentFlags().StringVar(&ossObjectPrefix, "prefix", "", "OSS对象前缀（可通过 OSS_OBJECT_PREFIX 环境变量设置，默认为时间戳）")
+	rootCmd.PersistentFlags().StringVar(&ossSSE, "oss-sse", "", "OSS 服务端加密方式 (AES256/KMS/SM4)（可通过 OSS_SSE 环境变量设置，为空时使用存储桶默认配置）")
+	rootCmd.PersistentFlags().StringVar(&ossKMSKeyID, "oss-kms-key-id", "", "OSS KMS 密钥 ID，仅 --oss-sse KMS 时有效（可通过 OSS_KMS_KEY_ID 环境变量设置，为空时使用 OSS 托管的默认密钥）")
+	rootCmd.PersistentFlags().StringVar(&ossStorageClass, "oss-storage-class", "", "OSS 存储类型 (Standard/IA/Archive/ColdArchive)（可通过 OSS_STORAGE_CLASS 环境变量设置，为空时使用存储桶默认存储类型）")
+	// 添加对象标签选项
+	rootCmd.PersistentFlags().StringVar(&jobName, "job-name", "", "备份任务名称，写入对象标签 job（可通过 JOB_NAME 环境变量设置）")
+	rootCmd.PersistentFlags().StringVar(&objectTags, "tags", "", "自定义对象标签，格式为 key=value，多个标签用逗号分隔（可通过 OBJECT_TAGS 环境变量设置）")
+	// 添加备份目标选项
+	rootCmd.PersistentFlags().StringVar(&destinations, "dest", "", "备份目标地址，支持多个目标用逗号分隔，如 oss://bucket/prefix（可通过 BACKUP_DEST 环境变量设置，未设置时使用 oss://{bucket}/{prefix}）")
+	rootCmd.PersistentFlags().BoolVar(&repository, "repository", false, "使用去重仓库存储备份：数据按内容分块后压缩（和加密）保存到备份目标的 repo/ 下，相同的数据块只保存一次（可通过 REPOSITORY 环境变量设置）")
+	// 添加 S3 兼容存储配置选项（用于 s3:// 目标）
+	rootCmd.PersistentFlags().StringVar(&s3Endpoint, "s3-endpoint", "", "S3 自定义端点，如 http://minio.local:9000（可通过 S3_ENDPOINT 环境变量设置，为空时使用 AWS 官方端点）")
+	rootCmd.PersistentFlags().StringVar(&s3Region, "s3-region", "", "S3 区域（可通过 S3_REGION 环境变量设置，默认为 us-east-1）")
+	rootCmd.PersistentFlags().StringVar(&s3AccessKey, "s3-access-key", "", "S3 AccessKey（可通过 S3_ACCESS_KEY 环境变量设置，为空时使用 AWS 默认凭证链）")
+	rootCmd.PersistentFlags().StringVar(&s3SecretKey, "s3-secret-key", "", "S3 SecretKey（可通过 S3_SECRET_KEY 环境变量设置）")
+	rootCmd.PersistentFlags().BoolVar(&s3PathStyle, "s3-path-style", false, "S3 使用 path-style 地址，MinIO、Ceph RGW 通常需要开启（可通过 S3_PATH_STYLE 环境变量设置）")
+	// 添加 SFTP 配置选项（用于 sftp:// 目标）
+	rootCmd.PersistentFlags().StringVar(&sftpKeyFile, "sftp-key", "", "SFTP 私钥文件路径（可通过 SFTP_KEY_FILE 环境变量设置）")
+	rootCmd.PersistentFlags().StringVar(&sftpKeyPass, "sftp-key-passphrase", "", "SFTP 私钥密码（可通过 SFTP_KEY_PASSPHRASE 环境变量设置，可选）")
+	rootCmd.PersistentFlags().StringVar(&sftpKnownHosts, "sftp-known-hosts", "", "SFTP known_hosts 文件路径，用于校验服务器公钥（可通过 SFTP_KNOWN_HOSTS 环境变量设置，默认为 ~/.ssh/known_hosts）")
+	// 添加分片上传选项
+	rootCmd.PersistentFlags().IntVar(&partSizeMB, "part-size-mb", 0, "分片上传的分片大小（MB），超过该大小的文件使用分片上传（可通过 PART_SIZE_MB 环境变量设置，默认为 100）")
+	rootCmd.PersistentFlags().IntVar(&uploadParallel, "parallel", 0, "分片上传并发数（可通过 UPLOAD_PARALLEL 环境变量设置，默认为 3）")
+	rootCmd.PersistentFlags().StringVar(&checkpointDir, "checkpoint-dir", "", "断点续传 checkpoint 目录，上传中断时保留备份文件，下次运行时续传未完成的分片（可通过 CHECKPOINT_DIR 环境变量设置，为空时不启用）")
+	// 添加流式上传选项
+	rootCmd.PersistentFlags().BoolVar(&streamUpload, "stream", false, "流式上传，打包压缩的数据直接上传，不生成本地临时文件（可通过 STREAM_UPLOAD 环境变量设置）")
+	// 添加重试选项
+	rootCmd.PersistentFlags().IntVar(&retryAttempts, "retry-max-attempts", 0, "上传和获取 snapshot 的最大尝试次数，1 表示不重试（可通过 RETRY_MAX_ATTEMPTS 环境变量设置，默认为 3）")
+	rootCmd.PersistentFlags().StringVar(&retryInitial, "retry-initial-delay", "", "第一次重试前的等待时间，之后按指数退避，如 2s（可通过 RETRY_INITIAL_DELAY 环境变量设置，默认为 2s）")
+	rootCmd.PersistentFlags().StringVar(&retryMaxDelay, "retry-max-delay", "", "最长重试等待时间，如 1m（可通过 RETRY_MAX_DELAY 环境变量设置，默认为 1m）")
+	// 添加加密选项
+	rootCmd.PersistentFlags().StringVar(&encryptTo, "encrypt-recipient", "", "使用 age 公钥加密备份文件，多个公钥用逗号分隔（可通过 ENCRYPT_RECIPIENTS 环境变量设置）")
+	rootCmd.PersistentFlags().StringVar(&encryptToFile, "encrypt-recipients-file", "", "age 公钥文件路径，每行一个公钥（可通过 ENCRYPT_RECIPIENTS_FILE 环境变量设置）")
+	rootCmd.PersistentFlags().StringVar(&encryptPass, "encrypt-passphrase", "", "使用口令加密备份文件，不能与公钥同时使用（可通过 ENCRYPT_PASSPHRASE 环境变量设置）")
+}
+
+// addRetentionFlags 添加备份保留规则选项（备份命令和 prune 命令）
+func addRetentionFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&keepLast, "keep-last", 0, "保留最近的 N 个备份（可通过 PRUNE_KEEP_LAST 环境变量设置）")
+	cmd.Flags().IntVar(&keepDaily, "keep-daily", 0, "保留最近 N 天每天最新的一个备份（可通过 PRUNE_KEEP_DAILY 环境变量设置）")
+	cmd.Flags().IntVar(&keepWeekly, "keep-weekly", 0, "保留最近 N 周每周最新的一个备份（可通过 PRUNE_KEEP_WEEKLY 环境变量设置）")
+	cmd.Flags().IntVar(&keepMonthly, "keep-monthly", 0, "保留最近 N 个月每月最新的一个备份（可通过 PRUNE_KEEP_MONTHLY 环境变量设置）")
+	cmd.Flags().IntVar(&keepYearly, "keep-yearly", 0, "保留最近 N 年每年最新的一个备份（可通过 PRUNE_KEEP_YEARLY 环境变量设置）")
+}
+
+// addBackupFlags 添加备份命令（dir/file/etcd/consul/run）的保留规则、清理、通知、hook、运行报告和指标选项
+func addBackupFlags(cmd *cobra.Command) {
+	addRetentionFlags(cmd)
+	cmd.Flags().BoolVar(&pruneAfter, "prune", false, "备份成功后按保留规则清理该主机同类型、本次备份的来源的旧备份（可通过 PRUNE_AFTER_BACKUP 环境变量设置）")
+	// 添加通知选项
+	cmd.Flags().StringVar(&notifyOn, "notify-on", "", "发送备份结果通知的时机 (failure/always/never)（可通过 NOTIFY_ON 环境变量设置，默认为 failure）")
+	cmd.Flags().StringVar(&notifyTemplate, "notify-template", "", "通知消息模板文件路径，Go text/template 格式（可通过 NOTIFY_TEMPLATE 环境变量设置，为空时使用默认模板）")
+	cmd.Flags().StringVar(&notifyWebhook, "notify-webhook", "", "通用 JSON webhook 地址（可通过 NOTIFY_WEBHOOK_URL 环境变量设置）")
+	cmd.Flags().StringVar(&notifyDingTalk, "notify-dingtalk", "", "钉钉机器人 webhook 地址（可通过 NOTIFY_DINGTALK_WEBHOOK 环境变量设置，加签密钥通过 NOTIFY_DINGTALK_SECRET 设置）")
+	cmd.Flags().StringVar(&notifyFeishu, "notify-feishu", "", "飞书机器人 webhook 地址（可通过 NOTIFY_FEISHU_WEBHOOK 环境变量设置，签名校验密钥通过 NOTIFY_FEISHU_SECRET 设置）")
+	cmd.Flags().StringVar(&notifySlack, "notify-slack", "", "Slack incoming webhook 地址（可通过 NOTIFY_SLACK_WEBHOOK 环境变量设置）")
+	cmd.Flags().StringVar(&notifyEmail, "notify-email", "", "邮件通知收件人，多个收件人用逗号分隔（可通过 NOTIFY_EMAIL_TO 环境变量设置，SMTP 服务器通过 NOTIFY_SMTP_* 环境变量设置）")
+	// 添加 hook 选项
+	cmd.Flags().StringArrayVar(&hookPre, "pre-hook", nil, "备份前通过 sh -c 执行的命令，失败时中止备份，可以指定多次（可通过 HOOK_PRE 环境变量设置）")
+	cmd.Flags().StringArrayVar(&hookPostSuccess, "post-success-hook", nil, "备份成功后执行的命令，可以指定多次（可通过 HOOK_POST_SUCCESS 环境变量设置）")
+	cmd.Flags().StringArrayVar(&hookPostFailure, "post-failure-hook", nil, "备份失败（包括部分失败）后执行的命令，可以指定多次（可通过 HOOK_POST_FAILURE 环境变量设置）")
+	cmd.Flags().StringArrayVar(&hookAlways, "always-hook", nil, "无论备份是否成功都执行的命令，可以指定多次（可通过 HOOK_ALWAYS 环境变量设置）")
+	cmd.Flags().StringVar(&hookTimeout, "hook-timeout", "", "每个 hook 命令的超时时间，如 30s（可通过 HOOK_TIMEOUT 环境变量设置，默认为 10m）")
+	// 添加运行报告选项
+	cmd.Flags().StringVar(&outputFormat, "report-format", "text", "运行报告输出格式 (text/json)，json 时备份结束后将运行报告输出到标准输出")
+	cmd.Flags().StringVar(&reportFile, "report-file", "", "备份结束后将 JSON 格式的运行报告写入该文件（可通过 REPORT_FILE 环境变量设置）")
+	// 添加指标选项
+	cmd.Flags().StringVar(&metricsTextfile, "metrics-textfile", "", "备份完成后将指标写入该文件，供 node_exporter textfile collector 采集，如 /var/lib/node_exporter/textfile/backup.prom（可通过 METRICS_TEXTFILE 环境变量设置）")
+}
+
+// addFailFastFlag 添加 --fail-fast 选项（备份多个目录或文件的命令：dir/file/run）
+func addFailFastFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "一个目录备份失败或有文件不存在时立即停止，默认继续备份其他目录或文件（可通过 FAIL_FAST 环境变量设置）")
+}
+
+// newStorageConfig 根据配置构建存储目标配置
+func newStorageConfig(cfg *config.Config) controller.StorageConfig {
+	return controller.StorageConfig{
+		Destinations:       cfg.StorageDestinations(),
+		Repository:         cfg.Repository,
+		OSSEndpoint:        cfg.OSSEndpoint,
+		OSSAccessKey:       cfg.OSSAccessKey,
+		OSSSecretKey:       cfg.OSSSecretKey,
+		OSSSSE:             cfg.OSSSSE,
+		OSSKMSKeyID:        cfg.OSSKMSKeyID,
+		OSSStorageClass:    cfg.OSSStorageClass,
+		S3Endpoint:         cfg.S3Endpoint,
+		S3Region:           cfg.S3Region,
+		S3AccessKey:        cfg.S3AccessKey,
+		S3SecretKey:        cfg.S3SecretKey,
+		S3PathStyle:        cfg.S3PathStyle,
+		SFTPKeyFile:        cfg.SFTPKeyFile,
+		SFTPKeyPassphrase:  cfg.SFTPKeyPassphrase,
+		SFTPKnownHostsFile: cfg.SFTPKnownHostsFile,
+		PartSize:           int64(cfg.PartSizeMB) * 1024 * 1024,
+		Parallel:           cfg.UploadParallel,
+		CheckpointDir:      cfg.CheckpointDir,
+		KeyCacheDir:        cfg.KeyCacheDir,
+		Encryption:         cfg.Encryption,
+		Tags:               cfg.ObjectTags(),
+	}
 }
@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/config"
+	"backup-to-oss/internal/controller"
+	"backup-to-oss/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	jobsFile string // 备份任务配置文件路径
+	runAll   bool   // 是否执行所有任务
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run [job...]",
+	Short: "执行备份任务配置文件（backup.yaml）中的任务",
+	Long: `执行备份任务配置文件（默认为当前目录下的 backup.yaml）中定义的备份任务。
+
+配置文件中可以定义多个任务，每个任务有自己的备份类型（dir/file/etcd/consul）、备份来源、
+备份目标、压缩方式等配置，任务中未设置的配置使用 defaults 中的配置。
+
+配置优先级：命令行参数 > 环境变量 > 任务配置 > defaults 配置。
+备份来源（paths、exclude、etcd、consul）只从配置文件读取，密钥等敏感配置建议通过环境变量设置。
+
+示例:
+  backup-to-oss run nginx
+  或
+  backup-to-oss run nginx etcd-main --config /etc/backup-to-oss/backup.yaml
+  或
+  backup-to-oss run --all`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runJobs(cmd, args); err != nil {
+			logger.Error("执行备份任务失败", "error", err)
+			os.Exit(backupExitCode(err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	runCmd.Flags().StringVarP(&jobsFile, "config", "f", "", "备份任务配置文件路径（可通过 BACKUP_CONFIG 环境变量设置，默认为当前目录下的 backup.yaml）")
+	runCmd.Flags().BoolVar(&runAll, "all", false, "按配置文件中的顺序执行所有任务")
+	addBackupFlags(runCmd)
+	addFailFastFlag(runCmd)
+}
+
+func runJobs(cmd *cobra.Command, names []string) error {
+	file, err := loadJobsFile()
+	if err != nil {
+		return err
+	}
+
+	var jobs []config.Job
+	switch {
+	case runAll && len(names) > 0:
+		return fmt.Errorf("--all 不能与任务名称同时使用")
+	case runAll:
+		jobs = file.Jobs
+	case len(names) == 0:
+		return fmt.Errorf("需要指定任务名称或 --all，可用的任务: %s", strings.Join(file.Names(), ", "))
+	default:
+		for _, name := range names {
+			job, ok := file.Job(name)
+			if !ok {
+				return fmt.Errorf("任务 %s 不存在，可用的任务: %s", name, strings.Join(file.Names(), ", "))
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	// 依次执行任务，一个任务失败不影响其他任务
+	var failed []string
+	partial := 0
+	for i, job := range jobs {
+		logger.Info("开始执行备份任务", "job", job.Name, "type", job.Type, "index", i+1, "total", len(jobs))
+		if err := runJob(context.Background(), cmd, job); err != nil {
+			logger.Error("备份任务失败", "job", job.Name, "error", err)
+			failed = append(failed, job.Name)
+			if controller.IsPartial(err) {
+				partial++
+			}
+			continue
+		}
+		logger.Info("备份任务完成", "job", job.Name)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	err = fmt.Errorf("%d 个任务失败: %s", len(failed), strings.Join(failed, ", "))
+	// 有任务成功或部分成功时为部分失败
+	if succeeded := len(jobs) - len(failed); succeeded > 0 || partial > 0 {
+		return &controller.PartialError{Succeeded: succeeded, Failed: len(failed), Total: len(jobs), Err: err}
+	}
+	return err
+}
+
+// loadJobsFile 加载备份任务配置文件（--config > BACKUP_CONFIG 环境变量 > backup.yaml）
+func loadJobsFile() (*config.JobsFile, error) {
+	path := jobsFile
+	if path == "" {
+		path = os.Getenv("BACKUP_CONFIG")
+	}
+	if path == "" {
+		path = config.DefaultJobsFile
+	}
+	return config.LoadJobsFile(path)
+}
+
+// runJob 执行一个备份任务
+func runJob(ctx context.Context, cmd *cobra.Command, job config.Job) error {
+	cfg, err := config.LoadJobConfig(envFile, job)
+	if err != nil {
+		return fmt.Errorf("加载配置失败: %v", err)
+	}
+
+	// 合并命令行参数（命令行参数优先级更高，--compress 只在显式指定时覆盖任务配置）
+	if cmd.Flags().Changed("compress") {
+		cfg.CompressMethod = compressMethod
+	}
+	cfg.MergeWithFlags("", "", "", ossEndpoint, ossAccessKey, ossSecretKey, ossBucket, ossObjectPrefix)
+	cfg.MergeWithDestFlag(destinations)
+	cfg.MergeWithRepositoryFlag(repository)
+	cfg.MergeWithS3Flags(s3Endpoint, s3Region, s3AccessKey, s3SecretKey, s3PathStyle)
+	cfg.MergeWithSFTPFlags(sftpKeyFile, sftpKeyPass, sftpKnownHosts)
+	cfg.MergeWithOSSOptionFlags(ossSSE, ossKMSKeyID, ossStorageClass)
+	cfg.MergeWithUploadFlags(partSizeMB, uploadParallel, checkpointDir, streamUpload)
+	cfg.MergeWithFailFastFlag(failFast)
+	if err := cfg.MergeWithTagFlags("", objectTags); err != nil {
+		return err
+	}
+	cfg.MergeWithEncryptFlags(encryptTo, encryptToFile, encryptPass)
+	if err := cfg.MergeWithRetryFlags(job.Type, retryAttempts, retryInitial, retryMaxDelay); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithPruneFlags(keepLast, keepDaily, keepWeekly, keepMonthly, keepYearly, pruneAfter); err != nil {
+		return err
+	}
+	cfg.MergeWithMetricsFlags(metricsTextfile)
+	if err := cfg.MergeWithReportFlags(outputFormat, reportFile); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithNotifyFlags(notifyOn, notifyTemplate, notifyWebhook, notifyDingTalk, notifyFeishu, notifySlack, notifyEmail); err != nil {
+		return err
+	}
+	if err := cfg.MergeWithHookFlags(hookPre, hookPostSuccess, hookPostFailure, hookAlways, hookTimeout); err != nil {
+		return err
+	}
+
+	// 验证备份目标配置
+	if err := cfg.ValidateStorage(); err != nil {
+		return err
+	}
+
+	// 获取保留备份文件选项（命令行参数、环境变量或任务配置）
+	keep := keepBackupFiles || job.KeepBackupFiles
+	if envKeep := os.Getenv("KEEP_BACKUP_FILES"); envKeep == "true" || envKeep == "1" {
+		keep = true
+	}
+
+	return observeBackup(ctx, cfg, job.Type, func(ctx context.Context) error {
+		return backupJob(ctx, job, cfg, keep)
+	})
+}
+
+// backupJob 按任务的备份类型执行备份
+func backupJob(ctx context.Context, job config.Job, cfg *config.Config, keep bool) error {
+	var err error
+	switch job.Type {
+	case catalog.TypeDir:
+		return controller.DirBackup(ctx, controller.DirBackupRequest{
+			DirPaths:        cfg.DirPaths,
+			ExcludePatterns: cfg.ExcludePatterns,
+			CompressMethod:  cfg.CompressMethod,
+			KeepBackupFiles: keep,
+			Stream:          cfg.Stream,
+			FailFast:        cfg.FailFast,
+			Incremental:     cfg.Incremental,
+			FullInterval:    cfg.FullInterval,
+			IndexCacheDir:   cfg.IndexCacheDir,
+			Retry:           cfg.Retry,
+			Retention:       cfg.PrunePolicy(),
+			Storage:         newStorageConfig(cfg),
+		})
+	case catalog.TypeFile:
+		return controller.FileBackup(ctx, controller.FileBackupRequest{
+			FilePaths:       cfg.FilePaths,
+			CompressMethod:  cfg.CompressMethod,
+			KeepBackupFiles: keep,
+			Stream:          cfg.Stream,
+			FailFast:        cfg.FailFast,
+			Retry:           cfg.Retry,
+			Retention:       cfg.PrunePolicy(),
+			Storage:         newStorageConfig(cfg),
+		})
+	case catalog.TypeEtcd:
+		// 任务中未设置的 etcd 配置从环境变量获取
+		endpoints := job.Etcd.Endpoints
+		if len(endpoints) == 0 {
+			endpoints = strings.Split(envOrDefault("ETCD_ENDPOINTS", "http://127.0.0.1:2379"), ",")
+		}
+		dialTimeout := job.Etcd.DialTimeout
+		if dialTimeout == 0 {
+			if dialTimeout, err = time.ParseDuration(envOrDefault("ETCD_DIAL_TIMEOUT", "5s")); err != nil {
+				return fmt.Errorf("无效的 ETCD_DIAL_TIMEOUT 格式: %v", err)
+			}
+		}
+		commandTimeout := job.Etcd.CommandTimeout
+		if commandTimeout == 0 && os.Getenv("ETCD_COMMAND_TIMEOUT") != "" {
+			if commandTimeout, err = time.ParseDuration(os.Getenv("ETCD_COMMAND_TIMEOUT")); err != nil {
+				return fmt.Errorf("无效的 ETCD_COMMAND_TIMEOUT 格式: %v", err)
+			}
+		}
+		return controller.EtcdBackup(ctx, controller.EtcdBackupRequest{
+			Endpoints:       endpoints,
+			CACert:          valueOrEnv(job.Etcd.CACert, "ETCD_CACERT"),
+			Cert:            valueOrEnv(job.Etcd.Cert, "ETCD_CERT"),
+			Key:             valueOrEnv(job.Etcd.Key, "ETCD_KEY"),
+			User:            valueOrEnv(job.Etcd.User, "ETCD_USER"),
+			Password:        valueOrEnv(job.Etcd.Password, "ETCD_PASSWORD"),
+			DialTimeout:     dialTimeout,
+			CommandTimeout:  commandTimeout,
+			CompressMethod:  cfg.CompressMethod,
+			KeepBackupFiles: keep,
+			Stream:          cfg.Stream,
+			Retry:           cfg.Retry,
+			Retention:       cfg.PrunePolicy(),
+			Storage:         newStorageConfig(cfg),
+		})
+	case catalog.TypeConsul:
+		stale := job.Consul.Stale
+		if envStale := os.Getenv("CONSUL_STALE"); envStale == "true" || envStale == "1" {
+			stale = true
+		}
+		address := valueOrEnv(job.Consul.Address, "CONSUL_ADDRESS")
+		if address == "" {
+			address = "http://127.0.0.1:8500" // 默认地址
+		}
+		return controller.ConsulBackup(ctx, controller.ConsulBackupRequest{
+			ConsulAddress:   address,
+			ConsulToken:     valueOrEnv(job.Consul.Token, "CONSUL_TOKEN"),
+			Stale:           stale,
+			CompressMethod:  cfg.CompressMethod,
+			KeepBackupFiles: keep,
+			Stream:          cfg.Stream,
+			Retry:           cfg.Retry,
+			Retention:       cfg.PrunePolicy(),
+			Storage:         newStorageConfig(cfg),
+		})
+	}
+	return fmt.Errorf("不支持的备份类型: %s", job.Type)
+}
+
+// valueOrEnv 返回任务配置中的值，为空时返回环境变量 key 的值
+func valueOrEnv(value, key string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+// envOrDefault 获取环境变量，如果不存在则返回默认值
+func envOrDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/metrics"
+	"backup-to-oss/internal/scheduler"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Server daemon 模式的 HTTP 控制接口
+//
+//	GET  /healthz                   健康检查（不需要认证）
+//	GET  /metrics                   Prometheus 指标（不需要认证）
+//	GET  /api/v1/jobs               任务列表和调度状态
+//	POST /api/v1/jobs/{name}/run    立即执行任务，?wait=true 时等待执行结束
+//	GET  /api/v1/runs               最近的执行记录，支持 ?job= 和 ?limit=
+//	GET  /api/v1/runs/{id}          执行记录的状态和进度
+type Server struct {
+	scheduler *scheduler.Scheduler
+	token     string
+	server    *http.Server
+}
+
+// NewServer 创建 HTTP 控制接口，除 /healthz 和 /metrics 外的接口需要 Authorization: Bearer <token> 认证
+func NewServer(addr, token string, s *scheduler.Scheduler) *Server {
+	srv := &Server{scheduler: s, token: token}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", srv.healthz)
+	mux.Handle("GET /metrics", metricsHandler())
+	mux.Handle("GET /api/v1/jobs", srv.auth(srv.listJobs))
+	mux.Handle("POST /api/v1/jobs/{name}/run", srv.auth(srv.runJob))
+	mux.Handle("GET /api/v1/runs", srv.auth(srv.listRuns))
+	mux.Handle("GET /api/v1/runs/{id}", srv.auth(srv.getRun))
+
+	srv.server = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return srv
+}
+
+// Start 在后台监听并处理请求，监听失败时返回错误
+func (s *Server) Start() error {
+	ln, err := (&net.ListenConfig{}).Listen(context.Background(), "tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	logger.Info("HTTP 控制接口已启动", "addr", ln.Addr().String())
+	go func() {
+		if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP 控制接口异常退出", "error", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown 停止接收新的请求并等待正在处理的请求完成
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// metricsHandler 返回备份指标和 daemon 进程的运行时指标
+func metricsHandler() http.Handler {
+	runtime := prometheus.NewRegistry()
+	runtime.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(prometheus.Gatherers{metrics.Registry, runtime}, promhttp.HandlerOpts{})
+}
+
+// auth 校验 Bearer Token
+func (s *Server) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="backup-to-oss"`)
+			writeError(w, http.StatusUnauthorized, "未认证或 token 无效")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.scheduler.Jobs())
+}
+
+func (s *Server) runJob(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	info, err := s.scheduler.Trigger(name)
+	switch {
+	case errors.Is(err, scheduler.ErrJobNotFound):
+		writeError(w, http.StatusNotFound, "任务不存在: "+name)
+		return
+	case errors.Is(err, scheduler.ErrJobRunning):
+		// 返回正在执行的记录，调用方可以等待该记录结束
+		writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error(), "run": info})
+		return
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	logger.Info("通过 API 触发任务", "job", name, "run_id", info.ID, "remote", r.RemoteAddr)
+
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+		// 客户端断开连接时停止等待，任务继续执行
+		info, err = s.scheduler.Wait(r.Context(), info.ID)
+		if err != nil {
+			return
+		}
+		status := http.StatusOK
+		if info.Status == scheduler.StatusFailed {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, info)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, info)
+}
+
+func (s *Server) listRuns(w http.ResponseWriter, r *http.Request) {
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "无效的 limit: "+value)
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, s.scheduler.Runs(r.URL.Query().Get("job"), limit))
+}
+
+func (s *Server) getRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "无效的执行记录 ID: "+r.PathValue("id"))
+		return
+	}
+	info, ok := s.scheduler.Run(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "执行记录不存在")
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// writeJSON 输出 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		logger.Debug("输出响应失败", "error", err)
+	}
+}
+
+// writeError 输出错误响应
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
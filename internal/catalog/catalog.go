@@ -0,0 +1,273 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backup-to-oss/internal/checksum"
+	"backup-to-oss/internal/compress"
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/incremental"
+	"backup-to-oss/internal/report"
+	"backup-to-oss/internal/storage"
+)
+
+// 备份类型（优先使用对象元数据，没有元数据时根据备份文件名判断）
+const (
+	TypeDir    = "dir"
+	TypeFile   = "file"
+	TypeEtcd   = "etcd"
+	TypeConsul = "consul"
+	// TypeUnknown 无法确定类型的备份：更早版本生成的、没有类型元数据的 *_files.tar.* 可能是多个文件的备份，也可能是目录备份
+	TypeUnknown = "unknown"
+)
+
+// Types 所有备份类型
+var Types = []string{TypeDir, TypeFile, TypeEtcd, TypeConsul}
+
+// MetadataType 对象元数据中的备份类型
+const MetadataType = "backup-type"
+
+// etcd 和 consul 备份文件名的前缀
+const (
+	EtcdPrefix   = "etcd-snapshot-"
+	ConsulPrefix = "consul-snapshot-"
+)
+
+// IncrementalSuffix 增量目录备份文件名中扩展名前的标记，如 20250101-020000_etc_nginx.incr.tar.zst
+const IncrementalSuffix = ".incr"
+
+// statWorkers 读取对象元数据确定备份类型的并发数
+const statWorkers = 8
+
+// Backup 备份目标中的一个备份文件
+// 对象路径为 {ip}/{date}/{name}，获取公网 IP 失败时为 {date}/{name}
+type Backup struct {
+	Key          string    `json:"key"`           // 对象键（相对于备份目标根路径）
+	Host         string    `json:"host"`          // 备份主机的公网 IP（可能为空）
+	Date         string    `json:"date"`          // 日期目录，如 20250101
+	Name         string    `json:"name"`          // 备份文件名
+	Type         string    `json:"type"`          // 备份类型 (dir/file/etcd/consul/unknown)
+	Source       string    `json:"source"`        // 备份来源（文件名去掉时间和扩展名），如目录路径 etc_nginx，etcd/consul 为类型名
+	Incremental  bool      `json:"incremental"`   // 是否为增量目录备份（恢复时需要之前最近的完整备份和之间的增量备份）
+	Compression  string    `json:"compression"`   // 压缩方式 (zstd/gzip/none)
+	Encrypted    bool      `json:"encrypted"`     // 是否使用 age 加密
+	Size         int64     `json:"size"`          // 文件大小（字节）
+	Time         time.Time `json:"time"`          // 备份时间（从文件名解析，解析失败时为对象的修改时间）
+	LastModified time.Time `json:"last_modified"` // 对象的修改时间
+}
+
+// datePattern 对象路径中的日期目录
+var datePattern = regexp.MustCompile(`^\d{8}$`)
+
+// timePattern 备份文件名中的时间，如 20250101-020000_etc.tar.zst、etcd-snapshot-20250101-020000.db.zst
+var timePattern = regexp.MustCompile(`^(?:etcd-snapshot-|consul-snapshot-)?(\d{8}-\d{6})[_.]`)
+
+// Parse 解析对象信息，对象不是备份文件（如 .sha256 校验文件、.manifest.json 运行报告、文件索引、不符合路径结构的对象）时返回 false
+// 对象元数据中有备份类型时使用元数据中的类型，否则根据文件名判断（见 TypeOf）
+func Parse(obj storage.ObjectInfo) (Backup, bool) {
+	parts := strings.Split(obj.Key, "/")
+	var host, date, name string
+	switch {
+	case len(parts) == 3 && datePattern.MatchString(parts[1]):
+		host, date, name = parts[0], parts[1], parts[2]
+	case len(parts) == 2 && datePattern.MatchString(parts[0]):
+		date, name = parts[0], parts[1]
+	default:
+		return Backup{}, false
+	}
+	if name == "" || strings.HasSuffix(name, checksum.SidecarSuffix) || strings.HasSuffix(name, report.ManifestSuffix) || incremental.IsIndex(name) {
+		return Backup{}, false
+	}
+
+	b := Backup{
+		Key:          obj.Key,
+		Host:         host,
+		Date:         date,
+		Name:         name,
+		Type:         typeOf(name, obj.Metadata),
+		Source:       sourceOf(name),
+		Encrypted:    strings.HasSuffix(name, crypt.Suffix),
+		Size:         obj.Size,
+		Time:         obj.LastModified,
+		LastModified: obj.LastModified,
+	}
+	var base string
+	b.Compression, _, base = compress.DetectFormat(strings.TrimSuffix(name, crypt.Suffix))
+	b.Incremental = b.Type == TypeDir && strings.HasSuffix(base, IncrementalSuffix)
+	if m := timePattern.FindStringSubmatch(name); m != nil {
+		if t, err := time.ParseInLocation("20060102-150405", m[1], time.Local); err == nil {
+			b.Time = t
+		}
+	}
+	return b, true
+}
+
+// typeOf 返回对象元数据中的备份类型，没有元数据时根据文件名判断
+func typeOf(name string, metadata map[string]string) string {
+	if t := metadata[MetadataType]; slices.Contains(Types, t) {
+		return t
+	}
+	return TypeOf(name)
+}
+
+// TypeOf 根据备份文件名判断备份类型：
+// etcd/consul 备份以快照前缀开头，不是 tar 归档的为单个文件的备份，增量备份和其他 tar 归档为目录备份；
+// 目录备份和多个文件的备份都是 tar 归档，文件名以 _files 结尾的无法区分，返回 TypeUnknown。
+// 单个文件的备份也可能以 .tar 结尾（如 report.tar.gz 备份为 report.tar.zst），需要使用对象元数据判断（见 List）
+func TypeOf(name string) string {
+	name = strings.TrimSuffix(name, crypt.Suffix)
+	switch {
+	case strings.HasPrefix(name, EtcdPrefix):
+		return TypeEtcd
+	case strings.HasPrefix(name, ConsulPrefix):
+		return TypeConsul
+	}
+	_, isTar, base := compress.DetectFormat(name)
+	switch {
+	case !isTar || strings.HasSuffix(name, ".tar.gz"): // 目录和多个文件的备份使用 gzip 压缩时扩展名为 .tgz
+		return TypeFile
+	case strings.HasSuffix(base, IncrementalSuffix):
+		return TypeDir
+	case strings.HasSuffix(base, "_files"):
+		return TypeUnknown
+	}
+	return TypeDir
+}
+
+// ambiguous 判断备份文件名是否无法确定是目录备份还是文件备份（不是增量备份的 tar 归档）
+func ambiguous(name string) bool {
+	switch TypeOf(name) {
+	case TypeDir:
+		_, _, base := compress.DetectFormat(strings.TrimSuffix(name, crypt.Suffix))
+		return !strings.HasSuffix(base, IncrementalSuffix)
+	case TypeUnknown:
+		return true
+	}
+	return false
+}
+
+// sourcePattern 目录和文件备份文件名中的时间
+var sourcePattern = regexp.MustCompile(`^\d{8}-\d{6}_`)
+
+// sourceOf 根据备份文件名获取备份来源，同一来源的备份文件属于同一个备份序列
+func sourceOf(name string) string {
+	switch t := TypeOf(name); t {
+	case TypeEtcd, TypeConsul:
+		return t
+	}
+	_, isTar, base := compress.DetectFormat(strings.TrimSuffix(name, crypt.Suffix))
+	if isTar {
+		// 增量备份与完整备份属于同一个备份序列
+		base = strings.TrimSuffix(base, IncrementalSuffix)
+	}
+	return sourcePattern.ReplaceAllString(base, "")
+}
+
+// List 列出 prefix 下的所有备份文件，按备份时间排序
+// prefix 为空时列出所有主机和日期的备份，如 "1.2.3.4/" 只列出该主机的备份
+// 有文件索引的 tar 归档为目录备份，其他无法根据文件名确定类型的备份读取对象元数据中的备份类型
+func List(ctx context.Context, s storage.Storage, prefix string) ([]Backup, error) {
+	objects, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[string]bool)
+	for _, obj := range objects {
+		if incremental.IsIndex(path.Base(obj.Key)) {
+			indexes[obj.Key] = true
+		}
+	}
+	var backups []Backup
+	var pending []int
+	for _, obj := range objects {
+		b, ok := Parse(obj)
+		if !ok {
+			continue
+		}
+		if ambiguous(b.Name) && obj.Metadata[MetadataType] == "" {
+			if indexes[incremental.IndexKey(b.Key)] {
+				b.Type = TypeDir
+			} else {
+				pending = append(pending, len(backups))
+			}
+		}
+		backups = append(backups, b)
+	}
+	if err := resolveTypes(ctx, s, backups, pending, func(key string) string { return key }); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		if !backups[i].Time.Equal(backups[j].Time) {
+			return backups[i].Time.Before(backups[j].Time)
+		}
+		return backups[i].Key < backups[j].Key
+	})
+	return backups, nil
+}
+
+// ResolveTypes 读取对象元数据中的备份类型，确定 backups 中无法根据文件名区分目录备份和文件备份的备份的类型
+// objectKey 返回备份对应的对象键（如去重仓库中的快照索引）；对象没有类型元数据（更早版本的备份）时保留根据文件名判断的类型
+func ResolveTypes(ctx context.Context, s storage.Storage, backups []Backup, objectKey func(key string) string) error {
+	var pending []int
+	for i, b := range backups {
+		if ambiguous(b.Name) {
+			pending = append(pending, i)
+		}
+	}
+	return resolveTypes(ctx, s, backups, pending, objectKey)
+}
+
+// resolveTypes 并发读取 backups 中 pending 对应的对象元数据，更新备份类型
+func resolveTypes(ctx context.Context, s storage.Storage, backups []Backup, pending []int, objectKey func(key string) string) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := make(chan struct{}, statWorkers)
+	for _, i := range pending {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(b *Backup) {
+			defer func() { <-sem; wg.Done() }()
+			info, err := s.Stat(ctx, objectKey(b.Key))
+			if errors.Is(err, storage.ErrNotExist) {
+				return // 列出后被删除
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("读取备份 %s 的类型失败: %v", b.Key, err))
+				mu.Unlock()
+				return
+			}
+			if t := info.Metadata[MetadataType]; slices.Contains(Types, t) {
+				b.Type = t
+				b.Incremental = b.Incremental && t == TypeDir
+			}
+		}(&backups[i])
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// HostPrefix 返回主机和日期对应的对象前缀
+func HostPrefix(host, date string) string {
+	prefix := ""
+	if host != "" {
+		prefix = host + "/"
+	}
+	if date != "" {
+		prefix = path.Join(prefix, date) + "/"
+	}
+	return prefix
+}
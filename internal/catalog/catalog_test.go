@@ -0,0 +1,109 @@
+package catalog
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"backup-to-oss/internal/localfs"
+	"backup-to-oss/internal/storage"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		key         string
+		metadata    map[string]string
+		typ         string
+		source      string
+		compression string
+		incremental bool
+		encrypted   bool
+	}{
+		{key: "1.2.3.4/20250101/20250101-020000_etc_nginx.tar.zst", typ: TypeDir, source: "etc_nginx", compression: "zstd"},
+		{key: "1.2.3.4/20250101/20250101-020000_etc.incr.tgz.age", typ: TypeDir, source: "etc", compression: "gzip", incremental: true, encrypted: true},
+		{key: "1.2.3.4/20250101/20250101-020000_app.zst", typ: TypeFile, source: "app", compression: "zstd"},
+		{key: "1.2.3.4/20250101/20250101-020000_report.tar.gz", typ: TypeFile, source: "report", compression: "gzip"},
+		{key: "1.2.3.4/20250101/20250101-020000_app_files.tar.zst", typ: TypeUnknown, source: "app_files", compression: "zstd"},
+		{key: "1.2.3.4/20250101/etcd-snapshot-20250101-020000.db.zst", typ: TypeEtcd, source: TypeEtcd, compression: "zstd"},
+		{key: "20250101/consul-snapshot-20250101-020000.snap.gz", typ: TypeConsul, source: TypeConsul, compression: "gzip"},
+		// 对象元数据中的备份类型优先
+		{key: "1.2.3.4/20250101/20250101-020000_app_files.tar.zst", metadata: map[string]string{MetadataType: TypeFile}, typ: TypeFile, source: "app_files", compression: "zstd"},
+		{key: "1.2.3.4/20250101/20250101-020000_data_user_files.tar.zst", metadata: map[string]string{MetadataType: TypeDir}, typ: TypeDir, source: "data_user_files", compression: "zstd"},
+		{key: "1.2.3.4/20250101/20250101-020000_report.tar.zst", metadata: map[string]string{MetadataType: TypeFile}, typ: TypeFile, source: "report", compression: "zstd"},
+		{key: "1.2.3.4/20250101/20250101-020000_etc.tar", metadata: map[string]string{MetadataType: "bogus"}, typ: TypeDir, source: "etc", compression: "none"},
+	}
+	want := time.Date(2025, 1, 1, 2, 0, 0, 0, time.Local)
+	for _, tt := range tests {
+		b, ok := Parse(storage.ObjectInfo{Key: tt.key, Metadata: tt.metadata})
+		if !ok {
+			t.Errorf("Parse(%s): not a backup", tt.key)
+			continue
+		}
+		if b.Type != tt.typ || b.Source != tt.source || b.Compression != tt.compression || b.Incremental != tt.incremental || b.Encrypted != tt.encrypted {
+			t.Errorf("Parse(%s, %v) = type %s, source %s, compression %s, incremental %v, encrypted %v; want %s, %s, %s, %v, %v",
+				tt.key, tt.metadata, b.Type, b.Source, b.Compression, b.Incremental, b.Encrypted, tt.typ, tt.source, tt.compression, tt.incremental, tt.encrypted)
+		}
+		if !b.Time.Equal(want) {
+			t.Errorf("Parse(%s).Time = %v, want %v", tt.key, b.Time, want)
+		}
+	}
+}
+
+func TestParseSkipsAuxiliaryObjects(t *testing.T) {
+	for _, key := range []string{
+		"1.2.3.4/20250101/20250101-020000_etc.tar.zst.sha256",
+		"1.2.3.4/20250101/20250101-020000_etc.tar.zst.manifest.json",
+		"1.2.3.4/20250101/20250101-020000_etc.tar.zst.index.json.zst",
+		"1.2.3.4/20250101-020000_etc.tar.zst",
+		"1.2.3.4/latest/20250101-020000_etc.tar.zst",
+	} {
+		if b, ok := Parse(storage.ObjectInfo{Key: key}); ok {
+			t.Errorf("Parse(%s) = %+v, want not a backup", key, b)
+		}
+	}
+}
+
+func TestListResolvesTypes(t *testing.T) {
+	s, err := localfs.New(localfs.Config{RootDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	objects := []struct {
+		key      string
+		metadata map[string]string
+	}{
+		{"1.2.3.4/20250101/20250101-020000_app_files.tar.zst", map[string]string{MetadataType: TypeFile}},
+		{"1.2.3.4/20250101/20250101-020100_report.tar.zst", map[string]string{MetadataType: TypeFile}},
+		{"1.2.3.4/20250101/20250101-020200_data_files.tar.zst", nil},
+		{"1.2.3.4/20250101/20250101-020200_data_files.tar.zst.index.json.zst", nil},
+		{"1.2.3.4/20250101/20250101-020300_old_files.tar.zst", nil},
+		{"1.2.3.4/20250101/20250101-020400_etc.tar.zst", nil},
+	}
+	for _, obj := range objects {
+		if err := s.Put(ctx, obj.key, strings.NewReader("data"), storage.PutOptions{Metadata: obj.metadata}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := List(ctx, s, "1.2.3.4/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	want := map[string]string{
+		"20250101-020000_app_files.tar.zst":  TypeFile,    // 对象元数据
+		"20250101-020100_report.tar.zst":     TypeFile,    // 对象元数据（单个文件的备份以 .tar.zst 结尾）
+		"20250101-020200_data_files.tar.zst": TypeDir,     // 有文件索引
+		"20250101-020300_old_files.tar.zst":  TypeUnknown, // 没有元数据，无法区分
+		"20250101-020400_etc.tar.zst":        TypeDir,     // 没有元数据，按文件名判断
+	}
+	if len(backups) != len(want) {
+		t.Fatalf("List returned %d backups, want %d: %+v", len(backups), len(want), backups)
+	}
+	for _, b := range backups {
+		if b.Type != want[b.Name] {
+			t.Errorf("%s: type = %s, want %s", b.Name, b.Type, want[b.Name])
+		}
+	}
+}
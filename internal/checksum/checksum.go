@@ -0,0 +1,109 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"backup-to-oss/internal/storage"
+)
+
+const (
+	MetadataKey   = "sha256"  // 对象元数据中保存 SHA-256 的键
+	SidecarSuffix = ".sha256" // 校验文件后缀（sha256sum 格式）
+)
+
+// crcTable 与 OSS 的 x-oss-hash-crc64ecma 使用相同的多项式
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Digest 数据摘要
+type Digest struct {
+	Size   int64  // 数据大小（字节）
+	SHA256 string // SHA-256（十六进制）
+	MD5    string // MD5（十六进制，用于与 ETag 比较）
+	CRC64  uint64 // CRC64-ECMA
+}
+
+// Hasher 在写入数据的同时计算摘要，实现 io.Writer 接口
+type Hasher struct {
+	sha256 hash.Hash
+	md5    hash.Hash
+	crc64  hash.Hash64
+	size   int64
+}
+
+// New 创建 Hasher
+func New() *Hasher {
+	return &Hasher{
+		sha256: sha256.New(),
+		md5:    md5.New(),
+		crc64:  crc64.New(crcTable),
+	}
+}
+
+// Write 写入数据并更新摘要
+func (h *Hasher) Write(p []byte) (int, error) {
+	h.sha256.Write(p)
+	h.md5.Write(p)
+	h.crc64.Write(p)
+	h.size += int64(len(p))
+	return len(p), nil
+}
+
+// Digest 返回已写入数据的摘要
+func (h *Hasher) Digest() Digest {
+	return Digest{
+		Size:   h.size,
+		SHA256: hex.EncodeToString(h.sha256.Sum(nil)),
+		MD5:    hex.EncodeToString(h.md5.Sum(nil)),
+		CRC64:  h.crc64.Sum64(),
+	}
+}
+
+// File 计算文件摘要
+func File(path string) (Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Digest{}, fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer f.Close()
+
+	h := New()
+	if _, err := io.Copy(h, f); err != nil {
+		return Digest{}, fmt.Errorf("读取文件失败: %v", err)
+	}
+	return h.Digest(), nil
+}
+
+// Sidecar 返回 sha256sum 格式的校验文件内容，可直接使用 sha256sum -c 校验
+func (d Digest) Sidecar(name string) string {
+	return fmt.Sprintf("%s  %s\n", d.SHA256, name)
+}
+
+// Verify 将摘要与存储后端返回的对象信息比较
+// 大小总是比较；CRC64 和 MD5 仅在后端提供时比较
+func (d Digest) Verify(info *storage.ObjectInfo) error {
+	if info.Size != d.Size {
+		return fmt.Errorf("对象大小不一致: 本地 %d 字节，远端 %d 字节", d.Size, info.Size)
+	}
+	if info.CRC64 != "" {
+		remote, err := strconv.ParseUint(info.CRC64, 10, 64)
+		if err != nil {
+			return fmt.Errorf("解析远端 CRC64 失败: %s, %v", info.CRC64, err)
+		}
+		if remote != d.CRC64 {
+			return fmt.Errorf("CRC64 不一致: 本地 %d，远端 %d", d.CRC64, remote)
+		}
+	}
+	if info.MD5 != "" && !strings.EqualFold(info.MD5, d.MD5) {
+		return fmt.Errorf("MD5 不一致: 本地 %s，远端 %s", d.MD5, info.MD5)
+	}
+	return nil
+}
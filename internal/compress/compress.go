@@ -18,6 +18,23 @@ import (
 // excludePatterns: 排除模式列表，支持 glob 模式（如 *.log, node_modules, .git）
 // compressMethod: 压缩方式 (zstd/gzip/none)，默认为 zstd
 func CompressDir(sourceDir, outputFile string, excludePatterns []string, compressMethod string) error {
+	return writeToFile(outputFile, func(w io.Writer) error {
+		return WriteDir(w, sourceDir, excludePatterns, compressMethod)
+	})
+}
+
+// WriteDir 将整个目录打包压缩后写入 w（用于流式上传，不生成本地文件）
+// 参数含义与 CompressDir 相同
+func WriteDir(w io.Writer, sourceDir string, excludePatterns []string, compressMethod string) error {
+	return WriteDirFiltered(w, sourceDir, excludePatterns, compressMethod, nil)
+}
+
+// Filter 决定目录中未被排除的文件是否写入归档（不写入的目录仍然会继续遍历）
+// relPath 为相对于源目录的路径，link 为符号链接的目标；写入普通文件时 content 同时接收文件内容（可以为 nil）
+type Filter func(relPath string, info os.FileInfo, link string) (include bool, content io.Writer)
+
+// WriteDirFiltered 与 WriteDir 相同，但只将 filter 返回 true 的文件写入归档（用于增量备份），filter 为 nil 时写入所有文件
+func WriteDirFiltered(w io.Writer, sourceDir string, excludePatterns []string, compressMethod string, filter Filter) (err error) {
 	// 验证源目录是否存在
 	info, err := os.Stat(sourceDir)
 	if err != nil {
@@ -27,36 +44,20 @@ func CompressDir(sourceDir, outputFile string, excludePatterns []string, compres
 		return fmt.Errorf("源路径不是目录: %s", sourceDir)
 	}
 
-	// 创建输出文件
-	outFile, err := os.Create(outputFile)
+	// 根据压缩方式创建压缩 writer
+	compressWriter, err := NewWriter(w, compressMethod)
 	if err != nil {
-		return fmt.Errorf("创建输出文件失败: %v", err)
+		return err
 	}
-	defer outFile.Close()
 
-	// 根据压缩方式创建压缩 writer
-	var compressWriter io.WriteCloser
-	switch compressMethod {
-	case "gzip":
-		compressWriter = gzip.NewWriter(outFile)
-	case "zstd", "":
-		// 默认为 zstd
-		zstdWriter, err := zstd.NewWriter(outFile)
+	defer func() {
 		if err != nil {
-			return fmt.Errorf("创建 zstd writer 失败: %v", err)
+			compressWriter.Close() // 出错时释放压缩 writer 资源
 		}
-		compressWriter = zstdWriter
-	case "none":
-		// 不压缩，直接使用文件
-		compressWriter = &nopCloser{Writer: outFile}
-	default:
-		return fmt.Errorf("不支持的压缩方式: %s，支持的方式: zstd, gzip, none", compressMethod)
-	}
-	defer compressWriter.Close()
+	}()
 
 	// 创建 tar writer
 	tarWriter := tar.NewWriter(compressWriter)
-	defer tarWriter.Close()
 
 	// 获取源目录的绝对路径
 	absSourceDir, err := filepath.Abs(sourceDir)
@@ -84,8 +85,21 @@ func CompressDir(sourceDir, outputFile string, excludePatterns []string, compres
 			return nil // 跳过文件
 		}
 
-		// 创建 tar header
-		header, err := tar.FileInfoHeader(info, "")
+		// 创建 tar header（符号链接需要记录链接目标）
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return fmt.Errorf("读取符号链接失败: %v", err)
+			}
+		}
+		var content io.Writer
+		if filter != nil {
+			var include bool
+			if include, content = filter(relPath, info, link); !include {
+				return nil
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
 		if err != nil {
 			return fmt.Errorf("创建tar header失败: %v", err)
 		}
@@ -109,7 +123,11 @@ func CompressDir(sourceDir, outputFile string, excludePatterns []string, compres
 		defer file.Close()
 
 		// 复制文件内容
-		_, err = io.Copy(tarWriter, file)
+		var dst io.Writer = tarWriter
+		if content != nil {
+			dst = io.MultiWriter(tarWriter, content)
+		}
+		_, err = io.Copy(dst, file)
 		if err != nil {
 			return fmt.Errorf("复制文件内容失败: %v", err)
 		}
@@ -121,7 +139,7 @@ func CompressDir(sourceDir, outputFile string, excludePatterns []string, compres
 		return fmt.Errorf("压缩目录失败: %v", err)
 	}
 
-	return nil
+	return closeWriters(tarWriter, compressWriter)
 }
 
 // shouldExclude 检查文件或目录是否应该被排除
@@ -209,6 +227,13 @@ func shouldExclude(absPath, relPath, sourceDir string, excludePatterns []string)
 // outputFile: 输出文件路径
 // compressMethod: 压缩方式 (zstd/gzip/none)，默认为 zstd
 func CompressFile(sourceFile, outputFile string, compressMethod string) error {
+	return writeToFile(outputFile, func(w io.Writer) error {
+		return WriteFile(w, sourceFile, compressMethod)
+	})
+}
+
+// WriteFile 将单个文件压缩后写入 w（用于流式上传，不生成本地文件）
+func WriteFile(w io.Writer, sourceFile string, compressMethod string) error {
 	// 打开源文件
 	source, err := os.Open(sourceFile)
 	if err != nil {
@@ -216,40 +241,19 @@ func CompressFile(sourceFile, outputFile string, compressMethod string) error {
 	}
 	defer source.Close()
 
-	// 创建输出文件
-	outFile, err := os.Create(outputFile)
-	if err != nil {
-		return fmt.Errorf("创建输出文件失败: %v", err)
-	}
-	defer outFile.Close()
-
 	// 根据压缩方式创建压缩 writer
-	var compressWriter io.WriteCloser
-	switch compressMethod {
-	case "gzip":
-		compressWriter = gzip.NewWriter(outFile)
-	case "zstd", "":
-		// 默认为 zstd
-		zstdWriter, err := zstd.NewWriter(outFile)
-		if err != nil {
-			return fmt.Errorf("创建 zstd writer 失败: %v", err)
-		}
-		compressWriter = zstdWriter
-	case "none":
-		// 不压缩，直接使用文件
-		compressWriter = &nopCloser{Writer: outFile}
-	default:
-		return fmt.Errorf("不支持的压缩方式: %s，支持的方式: zstd, gzip, none", compressMethod)
+	compressWriter, err := NewWriter(w, compressMethod)
+	if err != nil {
+		return err
 	}
-	defer compressWriter.Close()
 
 	// 复制文件内容到压缩 writer
-	_, err = io.Copy(compressWriter, source)
-	if err != nil {
+	if _, err := io.Copy(compressWriter, source); err != nil {
+		compressWriter.Close()
 		return fmt.Errorf("压缩文件失败: %v", err)
 	}
 
-	return nil
+	return closeWriters(compressWriter)
 }
 
 // CompressFiles 压缩多个文件到一个 tar 归档中（支持 zstd、gzip 或不压缩）
@@ -261,36 +265,31 @@ func CompressFiles(sourceFiles []string, outputFile string, compressMethod strin
 		return fmt.Errorf("没有指定要压缩的文件")
 	}
 
-	// 创建输出文件
-	outFile, err := os.Create(outputFile)
-	if err != nil {
-		return fmt.Errorf("创建输出文件失败: %v", err)
+	return writeToFile(outputFile, func(w io.Writer) error {
+		return WriteFiles(w, sourceFiles, compressMethod)
+	})
+}
+
+// WriteFiles 将多个文件打包压缩后写入 w（用于流式上传，不生成本地文件）
+func WriteFiles(w io.Writer, sourceFiles []string, compressMethod string) (err error) {
+	if len(sourceFiles) == 0 {
+		return fmt.Errorf("没有指定要压缩的文件")
 	}
-	defer outFile.Close()
 
 	// 根据压缩方式创建压缩 writer
-	var compressWriter io.WriteCloser
-	switch compressMethod {
-	case "gzip":
-		compressWriter = gzip.NewWriter(outFile)
-	case "zstd", "":
-		// 默认为 zstd
-		zstdWriter, err := zstd.NewWriter(outFile)
+	compressWriter, err := NewWriter(w, compressMethod)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
 		if err != nil {
-			return fmt.Errorf("创建 zstd writer 失败: %v", err)
+			compressWriter.Close() // 出错时释放压缩 writer 资源
 		}
-		compressWriter = zstdWriter
-	case "none":
-		// 不压缩，直接使用文件
-		compressWriter = &nopCloser{Writer: outFile}
-	default:
-		return fmt.Errorf("不支持的压缩方式: %s，支持的方式: zstd, gzip, none", compressMethod)
-	}
-	defer compressWriter.Close()
+	}()
 
 	// 创建 tar writer
 	tarWriter := tar.NewWriter(compressWriter)
-	defer tarWriter.Close()
 
 	// 遍历每个文件并添加到 tar 归档中
 	for _, sourceFile := range sourceFiles {
@@ -336,12 +335,91 @@ func CompressFiles(sourceFiles []string, outputFile string, compressMethod strin
 		}
 	}
 
+	return closeWriters(tarWriter, compressWriter)
+}
+
+// SourceCounter 由 w 实现时，NewWriter 创建的压缩 writer 会通过 AddSource 报告压缩前的字节数（用于计算压缩率）
+type SourceCounter interface {
+	AddSource(n int64)
+}
+
+// NewWriter 根据压缩方式创建压缩 writer（zstd/gzip/none，默认为 zstd）
+// 调用方必须调用 Close 才能写出完整的压缩数据，Close 不会关闭 w
+func NewWriter(w io.Writer, compressMethod string) (io.WriteCloser, error) {
+	compressWriter, err := newWriter(w, compressMethod)
+	if err != nil {
+		return nil, err
+	}
+	if counter, ok := w.(SourceCounter); ok {
+		return &countingWriter{WriteCloser: compressWriter, counter: counter}, nil
+	}
+	return compressWriter, nil
+}
+
+// newWriter 根据压缩方式创建压缩 writer
+func newWriter(w io.Writer, compressMethod string) (io.WriteCloser, error) {
+	switch compressMethod {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd", "":
+		// 默认为 zstd
+		zstdWriter, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, fmt.Errorf("创建 zstd writer 失败: %v", err)
+		}
+		return zstdWriter, nil
+	case "none":
+		// 不压缩，直接写入
+		return &nopCloser{Writer: w}, nil
+	default:
+		return nil, fmt.Errorf("不支持的压缩方式: %s，支持的方式: zstd, gzip, none", compressMethod)
+	}
+}
+
+// writeToFile 创建输出文件并通过 write 写入内容
+func writeToFile(outputFile string, write func(w io.Writer) error) error {
+	// 创建输出文件
+	outFile, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("创建输出文件失败: %v", err)
+	}
+
+	if err := write(outFile); err != nil {
+		outFile.Close()
+		return err
+	}
+	if err := outFile.Close(); err != nil {
+		return fmt.Errorf("关闭输出文件失败: %v", err)
+	}
 	return nil
 }
 
+// closeWriters 依次关闭 writer（先关闭 tar writer，再关闭压缩 writer），返回第一个错误
+func closeWriters(writers ...io.Closer) error {
+	var firstErr error
+	for _, w := range writers {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("写入压缩数据失败: %v", err)
+		}
+	}
+	return firstErr
+}
+
 // nopCloser 是一个包装器，将 io.Writer 转换为 io.WriteCloser（Close 方法为空操作）
 type nopCloser struct {
 	io.Writer
 }
 
 func (nopCloser) Close() error { return nil }
+
+// countingWriter 记录写入压缩 writer 的字节数
+type countingWriter struct {
+	io.WriteCloser
+	counter SourceCounter
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.WriteCloser.Write(p)
+	c.counter.AddSource(int64(n))
+	return n, err
+}
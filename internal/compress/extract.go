@@ -0,0 +1,322 @@
+package compress
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"backup-to-oss/internal/logger"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveExts 备份文件扩展名与压缩方式的对应关系（与备份时生成的文件名一致，长的扩展名在前）
+var archiveExts = []struct {
+	ext    string
+	method string
+	tar    bool
+}{
+	{".tar.zst", "zstd", true},
+	{".tar.gz", "gzip", true},
+	{".tgz", "gzip", true},
+	{".tar", "none", true},
+	{".zst", "zstd", false},
+	{".gz", "gzip", false},
+}
+
+// DetectFormat 根据备份文件名判断压缩方式和是否为 tar 归档
+// 返回去掉扩展名后的文件名；无法识别的扩展名视为未压缩的单个文件
+func DetectFormat(name string) (method string, isTar bool, base string) {
+	for _, a := range archiveExts {
+		if strings.HasSuffix(name, a.ext) {
+			return a.method, a.tar, strings.TrimSuffix(name, a.ext)
+		}
+	}
+	return "none", false, name
+}
+
+// NewReader 根据压缩方式创建解压 reader（zstd/gzip/none）
+// 调用方必须调用 Close 释放资源，Close 不会关闭 r
+func NewReader(r io.Reader, compressMethod string) (io.ReadCloser, error) {
+	switch compressMethod {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("创建 gzip reader 失败: %v", err)
+		}
+		return gzipReader, nil
+	case "zstd", "":
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("创建 zstd reader 失败: %v", err)
+		}
+		return zstdReader.IOReadCloser(), nil
+	case "none":
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("不支持的压缩方式: %s，支持的方式: zstd, gzip, none", compressMethod)
+	}
+}
+
+// ExtractOptions 解压 tar 归档的选项
+type ExtractOptions struct {
+	Strip     int      // 去掉路径中前 N 级目录（与 tar --strip-components 相同）
+	Includes  []string // 只解压匹配的路径（glob 模式，匹配去掉前缀后的路径或其上级目录），为空时解压全部
+	Overwrite bool     // 是否覆盖已存在的文件
+}
+
+// ExtractTar 将 tar 归档解压到 targetDir，返回解压的文件数量
+// 拒绝绝对路径、包含 .. 的路径以及经由符号链接写到 targetDir 之外的条目，避免路径穿越
+func ExtractTar(r io.Reader, targetDir string, opts ExtractOptions) (int, error) {
+	absTarget, err := filepath.Abs(targetDir)
+	if err != nil {
+		return 0, fmt.Errorf("获取绝对路径失败: %v", err)
+	}
+	if err := os.MkdirAll(absTarget, 0755); err != nil {
+		return 0, fmt.Errorf("创建目标目录失败: %v", err)
+	}
+	// 使用真实路径判断，避免目标目录本身是符号链接时误判
+	if absTarget, err = filepath.EvalSymlinks(absTarget); err != nil {
+		return 0, fmt.Errorf("获取目标目录真实路径失败: %v", err)
+	}
+
+	tarReader := tar.NewReader(r)
+	count := 0
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("读取 tar 归档失败: %v", err)
+		}
+
+		if unsafePath(header.Name) {
+			return count, fmt.Errorf("归档中包含不安全的路径: %s", header.Name)
+		}
+		name, ok := stripComponents(header.Name, opts.Strip)
+		if !ok || !matchIncludes(name, opts.Includes) {
+			continue
+		}
+		target, err := safeJoin(absTarget, name)
+		if err != nil {
+			return count, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := checkParent(absTarget, target); err != nil {
+				return count, err
+			}
+			// 目录可能已作为符号链接解压，确认其真实路径位于解压目录内，避免修改解压目录之外的目录权限
+			if realDir, err := filepath.EvalSymlinks(target); err == nil && !within(absTarget, realDir) {
+				return count, fmt.Errorf("归档中的路径通过符号链接指向解压目录之外: %s", target)
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return count, fmt.Errorf("创建目录失败: %v", err)
+			}
+			os.Chmod(target, header.FileInfo().Mode().Perm())
+			continue
+		case tar.TypeReg:
+			if err := prepareTarget(absTarget, target, opts.Overwrite); err != nil {
+				return count, err
+			}
+			if err := extractFile(tarReader, target, header); err != nil {
+				return count, err
+			}
+		case tar.TypeSymlink:
+			if header.Linkname == "" {
+				// 早期版本的备份没有记录符号链接的目标
+				logger.Warn("符号链接没有记录链接目标，跳过", "path", header.Name)
+				continue
+			}
+			if err := prepareTarget(absTarget, target, opts.Overwrite); err != nil {
+				return count, err
+			}
+			// 与 tar 相同，按原样创建链接（包括绝对路径和指向解压目录之外的链接，如 etc/localtime -> /usr/share/zoneinfo/UTC）
+			// 之后的条目通过 checkParent 检查，不会经由这些链接写到解压目录之外
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return count, fmt.Errorf("创建符号链接失败: %v", err)
+			}
+		case tar.TypeLink:
+			linkName, ok := stripComponents(header.Linkname, opts.Strip)
+			if unsafePath(header.Linkname) || !ok {
+				return count, fmt.Errorf("硬链接目标无效: %s -> %s", header.Name, header.Linkname)
+			}
+			linkTarget, err := safeJoin(absTarget, linkName)
+			if err != nil {
+				return count, err
+			}
+			if err := checkParent(absTarget, linkTarget); err != nil {
+				return count, err
+			}
+			if err := prepareTarget(absTarget, target, opts.Overwrite); err != nil {
+				return count, err
+			}
+			if err := os.Link(linkTarget, target); err != nil {
+				return count, fmt.Errorf("创建硬链接失败: %v", err)
+			}
+		default:
+			// 设备文件、FIFO 等不恢复
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// RemovePaths 删除 targetDir 中归档路径对应的文件或目录（用于恢复增量备份时删除备份之间被删除的文件），返回删除的数量
+// 路径按 opts 中的 Strip 和 Includes 处理（与 ExtractTar 相同），不存在的路径忽略
+func RemovePaths(targetDir string, names []string, opts ExtractOptions) (int, error) {
+	absTarget, err := filepath.Abs(targetDir)
+	if err != nil {
+		return 0, fmt.Errorf("获取绝对路径失败: %v", err)
+	}
+	if absTarget, err = filepath.EvalSymlinks(absTarget); err != nil {
+		return 0, fmt.Errorf("获取目标目录真实路径失败: %v", err)
+	}
+
+	count := 0
+	for _, name := range names {
+		if unsafePath(name) {
+			return count, fmt.Errorf("删除列表中包含不安全的路径: %s", name)
+		}
+		stripped, ok := stripComponents(name, opts.Strip)
+		if !ok || !matchIncludes(stripped, opts.Includes) {
+			continue
+		}
+		target, err := safeJoin(absTarget, stripped)
+		if err != nil {
+			return count, err
+		}
+		if _, err := os.Lstat(target); err != nil {
+			continue
+		}
+		if err := checkParent(absTarget, target); err != nil {
+			return count, err
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return count, fmt.Errorf("删除文件失败: %v", err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// extractFile 将 tar 中的普通文件写入 target，并恢复权限和修改时间
+func extractFile(r io.Reader, target string, header *tar.Header) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("创建文件失败: %v", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("写入文件失败: %s, %v", target, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("关闭文件失败: %v", err)
+	}
+	os.Chtimes(target, header.ModTime, header.ModTime)
+	return nil
+}
+
+// prepareTarget 检查并创建上级目录，并处理已存在的文件
+func prepareTarget(root, target string, overwrite bool) error {
+	if err := checkParent(root, target); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+	if _, err := os.Lstat(target); err == nil {
+		if !overwrite {
+			return fmt.Errorf("文件已存在: %s（使用 --overwrite 覆盖）", target)
+		}
+		if err := os.Remove(target); err != nil {
+			return fmt.Errorf("删除已存在的文件失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkParent 检查 target 已存在的上级目录的真实路径位于 root 之内
+// 防止通过归档中先创建的符号链接（如 a -> ..）将文件写到解压目录之外
+func checkParent(root, target string) error {
+	dir := filepath.Dir(target)
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fmt.Errorf("获取真实路径失败: %v", err)
+	}
+	if !within(root, realDir) {
+		return fmt.Errorf("归档中的路径通过符号链接指向解压目录之外: %s", target)
+	}
+	return nil
+}
+
+// unsafePath 判断归档中的路径是否为绝对路径或包含 .. 路径
+func unsafePath(name string) bool {
+	if path.IsAbs(name) || filepath.IsAbs(name) {
+		return true
+	}
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// stripComponents 去掉路径中前 n 级目录，路径层级不足时返回 false
+func stripComponents(name string, n int) (string, bool) {
+	name = path.Clean(name)
+	if name == "." {
+		return "", false
+	}
+	parts := strings.Split(name, "/")
+	if len(parts) <= n {
+		return "", false
+	}
+	return strings.Join(parts[n:], "/"), true
+}
+
+// matchIncludes 判断路径或其上级目录是否匹配任意一个模式
+func matchIncludes(name string, includes []string) bool {
+	if len(includes) == 0 {
+		return true
+	}
+	for _, pattern := range includes {
+		pattern = strings.Trim(pattern, "/")
+		for p := name; p != "." && p != ""; p = path.Dir(p) {
+			if matched, _ := path.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// safeJoin 将归档中的路径拼接到目标目录，拒绝穿越到目标目录之外的路径
+func safeJoin(targetDir, name string) (string, error) {
+	target := filepath.Join(targetDir, filepath.FromSlash(name))
+	if !within(targetDir, target) {
+		return "", fmt.Errorf("归档中包含不安全的路径: %s", name)
+	}
+	return target, nil
+}
+
+// within 判断 target 是否位于 dir 之内
+func within(dir, target string) bool {
+	rel, err := filepath.Rel(dir, filepath.Clean(target))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
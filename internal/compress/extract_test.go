@@ -0,0 +1,111 @@
+package compress
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry 测试归档中的条目
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+// buildTar 生成包含 entries 的 tar 归档
+func buildTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.content))}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTarSymlinks(t *testing.T) {
+	target := t.TempDir()
+	archive := buildTar(t, []tarEntry{
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/localtime", typeflag: tar.TypeSymlink, linkname: "/usr/share/zoneinfo/UTC"},
+		{name: "etc/parent", typeflag: tar.TypeSymlink, linkname: "../../.."},
+		{name: "etc/hosts", typeflag: tar.TypeReg, content: "127.0.0.1 localhost\n"},
+		{name: "etc/hosts.link", typeflag: tar.TypeSymlink, linkname: "hosts"},
+	})
+
+	count, err := ExtractTar(archive, target, ExtractOptions{})
+	if err != nil {
+		t.Fatalf("ExtractTar: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("count = %d, want 4", count)
+	}
+	// 绝对路径和指向解压目录之外的链接按原样创建
+	for name, want := range map[string]string{
+		"etc/localtime":  "/usr/share/zoneinfo/UTC",
+		"etc/parent":     "../../..",
+		"etc/hosts.link": "hosts",
+	} {
+		got, err := os.Readlink(filepath.Join(target, name))
+		if err != nil || got != want {
+			t.Errorf("Readlink(%s) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestExtractTarRejectsWriteThroughSymlink(t *testing.T) {
+	outside := t.TempDir()
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{
+			name: "file",
+			entries: []tarEntry{
+				{name: "escape", typeflag: tar.TypeSymlink, linkname: outside},
+				{name: "escape/passwd", typeflag: tar.TypeReg, content: "x"},
+			},
+		},
+		{
+			name: "dir",
+			entries: []tarEntry{
+				{name: "escape", typeflag: tar.TypeSymlink, linkname: outside},
+				{name: "escape/", typeflag: tar.TypeDir},
+			},
+		},
+		{
+			name: "hardlink",
+			entries: []tarEntry{
+				{name: "escape", typeflag: tar.TypeSymlink, linkname: outside},
+				{name: "passwd", typeflag: tar.TypeLink, linkname: "escape/passwd"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ExtractTar(buildTar(t, tt.entries), t.TempDir(), ExtractOptions{}); err == nil {
+				t.Fatal("expected error for write through symlink")
+			}
+			entries, _ := os.ReadDir(outside)
+			if len(entries) != 0 {
+				t.Fatalf("files written outside target: %v", entries)
+			}
+		})
+	}
+}
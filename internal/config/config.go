@@ -3,94 +3,252 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/hook"
+	"backup-to-oss/internal/incremental"
+	"backup-to-oss/internal/notify"
+	"backup-to-oss/internal/repo"
+	"backup-to-oss/internal/retention"
+	"backup-to-oss/internal/retry"
+	"backup-to-oss/internal/storage"
 
 	"github.com/joho/godotenv"
 )
 
 // Config 应用配置
 type Config struct {
-	DirPaths        []string // 支持多个目录
-	FilePaths       []string // 支持多个文件
-	ExcludePatterns []string // 排除模式列表
-	CompressMethod  string   // 压缩方式 (zstd/gzip/none)
-	OSSEndpoint     string
-	OSSAccessKey    string
-	OSSSecretKey    string
-	OSSBucket       string
-	OSSObjectPrefix string
+	DirPaths           []string // 支持多个目录
+	FilePaths          []string // 支持多个文件
+	ExcludePatterns    []string // 排除模式列表
+	CompressMethod     string   // 压缩方式 (zstd/gzip/none)
+	OSSEndpoint        string
+	OSSAccessKey       string
+	OSSSecretKey       string
+	OSSBucket          string
+	OSSObjectPrefix    string
+	OSSSSE             string            // OSS 服务端加密方式（AES256/KMS/SM4）
+	OSSKMSKeyID        string            // OSS KMS 密钥 ID
+	OSSStorageClass    string            // OSS 存储类型（Standard/IA/Archive/ColdArchive）
+	JobName            string            // 备份任务名称（写入对象标签 job）
+	Tags               map[string]string // 自定义对象标签
+	Destinations       []string          // 备份目标地址列表，如 oss://bucket/prefix
+	Repository         bool              // 是否使用去重仓库（内容定义分块）存储备份
+	S3Endpoint         string            // S3 自定义端点（MinIO、Ceph RGW 等）
+	S3Region           string
+	S3AccessKey        string
+	S3SecretKey        string
+	S3PathStyle        bool             // 是否使用 path-style 地址
+	SFTPKeyFile        string           // SFTP 私钥文件路径
+	SFTPKeyPassphrase  string           // SFTP 私钥密码（可选）
+	SFTPKnownHostsFile string           // SFTP known_hosts 文件路径
+	PartSizeMB         int              // 分片上传的分片大小（MB）
+	UploadParallel     int              // 分片上传并发数
+	CheckpointDir      string           // 断点续传 checkpoint 目录（为空时不启用断点续传）
+	Stream             bool             // 是否流式上传（不生成本地临时文件）
+	FailFast           bool             // 一个目录或文件备份失败时立即停止
+	Incremental        bool             // 目录备份是否增量备份
+	FullInterval       time.Duration    // 增量备份时完整备份的间隔（为 0 时使用默认值）
+	IndexCacheDir      string           // 增量备份文件索引的本地缓存目录
+	KeyCacheDir        string           // 去重仓库密钥的本地缓存目录（公钥加密时没有私钥也可以写入仓库）
+	Retry              retry.Policy     // 上传和获取 snapshot 的重试策略
+	Encryption         crypt.Config     // 客户端加密配置
+	Retention          retention.Policy // 备份保留规则
+	Prune              bool             // 备份成功后是否按保留规则清理旧备份
+	MetricsTextfile    string           // node_exporter textfile collector 指标文件路径（为空时不写入）
+	Notify             notify.Config    // 备份结果通知配置
+	Output             string           // 运行报告输出格式（text 不输出，json 输出到标准输出）
+	ReportFile         string           // 运行报告文件路径（为空时不写入）
+	Hooks              hook.Config      // 备份前后执行的命令
 }
 
+// 分片上传参数默认值及限制
+const (
+	DefaultPartSizeMB     = 100
+	DefaultUploadParallel = 3
+	maxPartSizeMB         = 5 * 1024 // OSS/S3 单个分片最大 5GB
+)
+
 // LoadConfig 加载配置，优先从命令行参数，其次从环境变量，最后从 .env 文件
 // envFile 参数指定 .env 文件路径，如果为空则使用默认路径（当前目录下的 .env）
 func LoadConfig(envFile string) (*Config, error) {
-	// 尝试加载 .env 文件（如果存在）
+	LoadEnvFile(envFile)
+
+	cfg := newConfig()
+	// 解析多个目录、文件和排除模式（逗号分隔）
+	cfg.DirPaths = splitList(getEnvOrDefault("DIRS_TO_BACKUP", ""))
+	cfg.FilePaths = splitList(getEnvOrDefault("FILES_TO_BACKUP", ""))
+	cfg.ExcludePatterns = splitList(getEnvOrDefault("EXCLUDE_PATTERNS", ""))
+	if err := cfg.mergeEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadEnvFile 加载 .env 文件（如果存在），文件中的变量不会覆盖已设置的环境变量
+func LoadEnvFile(envFile string) {
 	if envFile != "" {
-		// 如果指定了 .env 文件路径，使用指定的路径
-		if err := godotenv.Load(envFile); err != nil {
-			// 如果文件不存在，不报错，继续使用环境变量
-			_ = err
-		}
+		// 如果指定了 .env 文件路径，使用指定的路径（文件不存在时不报错，继续使用环境变量）
+		_ = godotenv.Load(envFile)
 	} else {
 		// 如果没有指定路径，尝试加载当前目录下的 .env 文件
 		_ = godotenv.Load()
 	}
+}
 
-	// 解析多个目录（逗号分隔）
-	dirsStr := getEnvOrDefault("DIRS_TO_BACKUP", "")
-	var dirPaths []string
-	if dirsStr != "" {
-		dirs := strings.Split(dirsStr, ",")
-		for _, dir := range dirs {
-			dir = strings.TrimSpace(dir)
-			if dir != "" {
-				dirPaths = append(dirPaths, dir)
-			}
-		}
+// newConfig 返回使用默认值的配置
+func newConfig() *Config {
+	return &Config{
+		CompressMethod: "zstd", // 默认使用 zstd
+		PartSizeMB:     DefaultPartSizeMB,
+		UploadParallel: DefaultUploadParallel,
+		Retry:          retry.DefaultPolicy(),
+		IndexCacheDir:  incremental.DefaultCacheDir(),
+		KeyCacheDir:    repo.DefaultKeyCacheDir(),
 	}
+}
 
-	// 解析多个文件（逗号分隔）
-	filesStr := getEnvOrDefault("FILES_TO_BACKUP", "")
-	var filePaths []string
-	if filesStr != "" {
-		files := strings.Split(filesStr, ",")
-		for _, file := range files {
-			file = strings.TrimSpace(file)
-			if file != "" {
-				filePaths = append(filePaths, file)
-			}
-		}
+// mergeEnv 将环境变量合并到配置中（未设置的环境变量保持原值）
+// 备份来源（DIRS_TO_BACKUP、FILES_TO_BACKUP、EXCLUDE_PATTERNS）不在这里处理
+func (c *Config) mergeEnv() error {
+	var err error
+	c.CompressMethod = getEnvOrDefault("COMPRESS_METHOD", c.CompressMethod)
+	c.OSSEndpoint = getEnvOrDefault("OSS_ENDPOINT", c.OSSEndpoint)
+	c.OSSAccessKey = getEnvOrDefault("OSS_ACCESS_KEY", c.OSSAccessKey)
+	c.OSSSecretKey = getEnvOrDefault("OSS_SECRET_KEY", c.OSSSecretKey)
+	c.OSSBucket = getEnvOrDefault("OSS_BUCKET", c.OSSBucket)
+	c.OSSObjectPrefix = getEnvOrDefault("OSS_OBJECT_PREFIX", c.OSSObjectPrefix)
+	c.OSSSSE = getEnvOrDefault("OSS_SSE", c.OSSSSE)
+	c.OSSKMSKeyID = getEnvOrDefault("OSS_KMS_KEY_ID", c.OSSKMSKeyID)
+	c.OSSStorageClass = getEnvOrDefault("OSS_STORAGE_CLASS", c.OSSStorageClass)
+	c.JobName = getEnvOrDefault("JOB_NAME", c.JobName)
+
+	// 解析对象标签（k=v 格式，逗号分隔），与已有的标签合并
+	tags, err := parseTags(getEnvOrDefault("OBJECT_TAGS", ""))
+	if err != nil {
+		return fmt.Errorf("无效的环境变量 OBJECT_TAGS: %v", err)
+	}
+	if c.Tags == nil {
+		c.Tags = make(map[string]string, len(tags))
+	}
+	for k, v := range tags {
+		c.Tags[k] = v
 	}
 
-	// 解析排除模式（逗号分隔）
-	excludeStr := getEnvOrDefault("EXCLUDE_PATTERNS", "")
-	var excludePatterns []string
-	if excludeStr != "" {
-		patterns := strings.Split(excludeStr, ",")
-		for _, pattern := range patterns {
-			pattern = strings.TrimSpace(pattern)
-			if pattern != "" {
-				excludePatterns = append(excludePatterns, pattern)
-			}
+	// 解析备份目标地址（逗号分隔）
+	if destinations := splitList(getEnvOrDefault("BACKUP_DEST", "")); len(destinations) > 0 {
+		c.Destinations = destinations
+	}
+	c.Repository = c.Repository || getEnvBool("REPOSITORY")
+	c.S3Endpoint = getEnvOrDefault("S3_ENDPOINT", c.S3Endpoint)
+	c.S3Region = getEnvOrDefault("S3_REGION", c.S3Region)
+	c.S3AccessKey = getEnvOrDefault("S3_ACCESS_KEY", c.S3AccessKey)
+	c.S3SecretKey = getEnvOrDefault("S3_SECRET_KEY", c.S3SecretKey)
+	c.S3PathStyle = c.S3PathStyle || getEnvBool("S3_PATH_STYLE")
+	c.SFTPKeyFile = getEnvOrDefault("SFTP_KEY_FILE", c.SFTPKeyFile)
+	c.SFTPKeyPassphrase = getEnvOrDefault("SFTP_KEY_PASSPHRASE", c.SFTPKeyPassphrase)
+	c.SFTPKnownHostsFile = getEnvOrDefault("SFTP_KNOWN_HOSTS", c.SFTPKnownHostsFile)
+
+	// 解析分片上传参数
+	if c.PartSizeMB, err = getEnvInt("PART_SIZE_MB", c.PartSizeMB); err != nil {
+		return err
+	}
+	if c.UploadParallel, err = getEnvInt("UPLOAD_PARALLEL", c.UploadParallel); err != nil {
+		return err
+	}
+	c.CheckpointDir = getEnvOrDefault("CHECKPOINT_DIR", c.CheckpointDir)
+	c.Stream = c.Stream || getEnvBool("STREAM_UPLOAD")
+	c.FailFast = c.FailFast || getEnvBool("FAIL_FAST")
+
+	// 解析增量备份配置
+	c.Incremental = c.Incremental || getEnvBool("INCREMENTAL")
+	if value := os.Getenv("FULL_INTERVAL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("无效的环境变量 FULL_INTERVAL: %v", err)
 		}
+		c.FullInterval = d
 	}
+	c.IndexCacheDir = getEnvOrDefault("INDEX_CACHE_DIR", c.IndexCacheDir)
+	c.KeyCacheDir = getEnvOrDefault("REPO_KEY_CACHE_DIR", c.KeyCacheDir)
 
-	// 获取压缩方式，默认为 zstd
-	compressMethod := getEnvOrDefault("COMPRESS_METHOD", "zstd")
+	// 解析全局重试策略
+	if err := mergeRetryEnv(&c.Retry, ""); err != nil {
+		return err
+	}
 
-	cfg := &Config{
-		DirPaths:        dirPaths,
-		FilePaths:       filePaths,
-		ExcludePatterns: excludePatterns,
-		CompressMethod:  compressMethod,
-		OSSEndpoint:     getEnvOrDefault("OSS_ENDPOINT", ""),
-		OSSAccessKey:    getEnvOrDefault("OSS_ACCESS_KEY", ""),
-		OSSSecretKey:    getEnvOrDefault("OSS_SECRET_KEY", ""),
-		OSSBucket:       getEnvOrDefault("OSS_BUCKET", ""),
-		OSSObjectPrefix: getEnvOrDefault("OSS_OBJECT_PREFIX", ""),
+	// 解析客户端加密配置
+	if recipients := splitList(getEnvOrDefault("ENCRYPT_RECIPIENTS", "")); len(recipients) > 0 {
+		c.Encryption.Recipients = recipients
 	}
+	c.Encryption.RecipientsFile = getEnvOrDefault("ENCRYPT_RECIPIENTS_FILE", c.Encryption.RecipientsFile)
+	c.Encryption.Passphrase = getEnvOrDefault("ENCRYPT_PASSPHRASE", c.Encryption.Passphrase)
+	c.Encryption.IdentityFile = getEnvOrDefault("DECRYPT_IDENTITY_FILE", c.Encryption.IdentityFile)
 
-	return cfg, nil
+	// 解析备份保留规则
+	for _, item := range []struct {
+		key   string
+		value *int
+	}{
+		{"PRUNE_KEEP_LAST", &c.Retention.KeepLast},
+		{"PRUNE_KEEP_DAILY", &c.Retention.KeepDaily},
+		{"PRUNE_KEEP_WEEKLY", &c.Retention.KeepWeekly},
+		{"PRUNE_KEEP_MONTHLY", &c.Retention.KeepMonthly},
+		{"PRUNE_KEEP_YEARLY", &c.Retention.KeepYearly},
+	} {
+		if *item.value, err = getEnvInt(item.key, *item.value); err != nil {
+			return err
+		}
+	}
+	c.Prune = c.Prune || getEnvBool("PRUNE_AFTER_BACKUP")
+	c.MetricsTextfile = getEnvOrDefault("METRICS_TEXTFILE", c.MetricsTextfile)
+	c.ReportFile = getEnvOrDefault("REPORT_FILE", c.ReportFile)
+
+	// 解析通知配置
+	c.Notify.On = getEnvOrDefault("NOTIFY_ON", c.Notify.On)
+	c.Notify.Template = getEnvOrDefault("NOTIFY_TEMPLATE", c.Notify.Template)
+	c.Notify.WebhookURL = getEnvOrDefault("NOTIFY_WEBHOOK_URL", c.Notify.WebhookURL)
+	c.Notify.DingTalkURL = getEnvOrDefault("NOTIFY_DINGTALK_WEBHOOK", c.Notify.DingTalkURL)
+	c.Notify.DingTalkSecret = getEnvOrDefault("NOTIFY_DINGTALK_SECRET", c.Notify.DingTalkSecret)
+	c.Notify.FeishuURL = getEnvOrDefault("NOTIFY_FEISHU_WEBHOOK", c.Notify.FeishuURL)
+	c.Notify.FeishuSecret = getEnvOrDefault("NOTIFY_FEISHU_SECRET", c.Notify.FeishuSecret)
+	c.Notify.SlackURL = getEnvOrDefault("NOTIFY_SLACK_WEBHOOK", c.Notify.SlackURL)
+	c.Notify.SMTP.Host = getEnvOrDefault("NOTIFY_SMTP_HOST", c.Notify.SMTP.Host)
+	if c.Notify.SMTP.Port, err = getEnvInt("NOTIFY_SMTP_PORT", c.Notify.SMTP.Port); err != nil {
+		return err
+	}
+	c.Notify.SMTP.Username = getEnvOrDefault("NOTIFY_SMTP_USERNAME", c.Notify.SMTP.Username)
+	c.Notify.SMTP.Password = getEnvOrDefault("NOTIFY_SMTP_PASSWORD", c.Notify.SMTP.Password)
+	c.Notify.SMTP.From = getEnvOrDefault("NOTIFY_SMTP_FROM", c.Notify.SMTP.From)
+	if to := splitList(getEnvOrDefault("NOTIFY_EMAIL_TO", "")); len(to) > 0 {
+		c.Notify.SMTP.To = to
+	}
+
+	// 解析 hook 配置（环境变量中每个阶段只能设置一个命令）
+	for _, item := range []struct {
+		key      string
+		commands *[]string
+	}{
+		{"HOOK_PRE", &c.Hooks.Pre},
+		{"HOOK_POST_SUCCESS", &c.Hooks.PostSuccess},
+		{"HOOK_POST_FAILURE", &c.Hooks.PostFailure},
+		{"HOOK_ALWAYS", &c.Hooks.Always},
+	} {
+		if command := strings.TrimSpace(os.Getenv(item.key)); command != "" {
+			*item.commands = []string{command}
+		}
+	}
+	if value := os.Getenv("HOOK_TIMEOUT"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("无效的环境变量 HOOK_TIMEOUT: %v", err)
+		}
+		c.Hooks.Timeout = d
+	}
+	return nil
 }
 
 // MergeWithFlags 将命令行参数合并到配置中（命令行参数优先级更高）
@@ -184,26 +342,414 @@ func (c *Config) Validate() error {
 	if len(c.DirPaths) == 0 {
 		return fmt.Errorf("目录路径未设置（通过 --path 参数或 DIRS_TO_BACKUP 环境变量，支持多个目录用逗号分隔）")
 	}
-	if c.OSSEndpoint == "" {
-		return fmt.Errorf("OSS端点未设置（通过 --endpoint 参数或 OSS_ENDPOINT 环境变量）")
+	return c.ValidateStorage()
+}
+
+// ValidateFileConfig 验证文件备份配置是否完整
+func (c *Config) ValidateFileConfig() error {
+	if len(c.FilePaths) == 0 {
+		return fmt.Errorf("文件路径未设置（通过 --path 参数或 FILES_TO_BACKUP 环境变量，支持多个文件用逗号分隔）")
 	}
-	if c.OSSAccessKey == "" {
-		return fmt.Errorf("OSS AccessKey未设置（通过 --access-key 参数或 OSS_ACCESS_KEY 环境变量）")
+	return c.ValidateStorage()
+}
+
+// MergeWithDestFlag 将命令行指定的备份目标合并到配置中（命令行参数优先级更高）
+func (c *Config) MergeWithDestFlag(dest string) {
+	if destinations := splitList(dest); len(destinations) > 0 {
+		c.Destinations = destinations
 	}
-	if c.OSSSecretKey == "" {
-		return fmt.Errorf("OSS SecretKey未设置（通过 --secret-key 参数或 OSS_SECRET_KEY 环境变量）")
+}
+
+// MergeWithRepositoryFlag 将 --repository 合并到配置中（命令行参数只能开启）
+func (c *Config) MergeWithRepositoryFlag(repository bool) {
+	if repository {
+		c.Repository = true
+	}
+}
+
+// MergeWithS3Flags 将 S3 相关命令行参数合并到配置中（命令行参数优先级更高）
+func (c *Config) MergeWithS3Flags(endpoint, region, accessKey, secretKey string, pathStyle bool) {
+	if endpoint != "" {
+		c.S3Endpoint = endpoint
 	}
-	if c.OSSBucket == "" {
-		return fmt.Errorf("OSS存储桶未设置（通过 --bucket 参数或 OSS_BUCKET 环境变量）")
+	if region != "" {
+		c.S3Region = region
+	}
+	if accessKey != "" {
+		c.S3AccessKey = accessKey
+	}
+	if secretKey != "" {
+		c.S3SecretKey = secretKey
+	}
+	if pathStyle {
+		c.S3PathStyle = true
+	}
+}
+
+// MergeWithSFTPFlags 将 SFTP 相关命令行参数合并到配置中（命令行参数优先级更高）
+func (c *Config) MergeWithSFTPFlags(keyFile, keyPassphrase, knownHostsFile string) {
+	if keyFile != "" {
+		c.SFTPKeyFile = keyFile
+	}
+	if keyPassphrase != "" {
+		c.SFTPKeyPassphrase = keyPassphrase
+	}
+	if knownHostsFile != "" {
+		c.SFTPKnownHostsFile = knownHostsFile
+	}
+}
+
+// MergeWithUploadFlags 将上传相关命令行参数合并到配置中（命令行参数优先级更高，0 表示未设置）
+func (c *Config) MergeWithUploadFlags(partSizeMB, parallel int, checkpointDir string, stream bool) {
+	if partSizeMB != 0 {
+		c.PartSizeMB = partSizeMB
+	}
+	if parallel != 0 {
+		c.UploadParallel = parallel
+	}
+	if checkpointDir != "" {
+		c.CheckpointDir = checkpointDir
+	}
+	if stream {
+		c.Stream = true
+	}
+}
+
+// MergeWithFailFastFlag 将 --fail-fast 合并到配置中（命令行参数只能开启）
+func (c *Config) MergeWithFailFastFlag(failFast bool) {
+	if failFast {
+		c.FailFast = true
+	}
+}
+
+// MergeWithIncrementalFlags 将增量备份相关命令行参数合并到配置中（命令行参数优先级更高）
+func (c *Config) MergeWithIncrementalFlags(incremental bool, fullInterval string) error {
+	if incremental {
+		c.Incremental = true
+	}
+	if fullInterval != "" {
+		d, err := time.ParseDuration(fullInterval)
+		if err != nil {
+			return fmt.Errorf("无效的完整备份间隔: %v", err)
+		}
+		c.FullInterval = d
+	}
+	if c.FullInterval < 0 {
+		return fmt.Errorf("完整备份间隔不能为负数: %s", c.FullInterval)
 	}
 	return nil
 }
 
-// ValidateFileConfig 验证文件备份配置是否完整
-func (c *Config) ValidateFileConfig() error {
-	if len(c.FilePaths) == 0 {
-		return fmt.Errorf("文件路径未设置（通过 --path 参数或 FILES_TO_BACKUP 环境变量，支持多个文件用逗号分隔）")
+// MergeWithOSSOptionFlags 将 OSS 服务端加密和存储类型命令行参数合并到配置中（命令行参数优先级更高）
+func (c *Config) MergeWithOSSOptionFlags(sse, kmsKeyID, storageClass string) {
+	if sse != "" {
+		c.OSSSSE = sse
+	}
+	if kmsKeyID != "" {
+		c.OSSKMSKeyID = kmsKeyID
 	}
+	if storageClass != "" {
+		c.OSSStorageClass = storageClass
+	}
+}
+
+// MergeWithTagFlags 将任务名称和对象标签命令行参数合并到配置中（命令行参数优先级更高）
+// tags 为 k=v 格式，多个标签用逗号分隔，与环境变量中的标签合并
+func (c *Config) MergeWithTagFlags(jobName, tags string) error {
+	if jobName != "" {
+		c.JobName = jobName
+	}
+	flagTags, err := parseTags(tags)
+	if err != nil {
+		return fmt.Errorf("无效的 tags 格式: %v", err)
+	}
+	if len(flagTags) > 0 && c.Tags == nil {
+		c.Tags = make(map[string]string)
+	}
+	for k, v := range flagTags {
+		c.Tags[k] = v
+	}
+	return nil
+}
+
+// ObjectTags 返回上传对象时使用的标签（自定义标签和 job 标签）
+func (c *Config) ObjectTags() map[string]string {
+	tags := make(map[string]string, len(c.Tags)+1)
+	for k, v := range c.Tags {
+		tags[k] = v
+	}
+	if c.JobName != "" {
+		tags["job"] = c.JobName
+	}
+	return tags
+}
+
+// MergeWithEncryptFlags 将加密相关命令行参数合并到配置中（命令行参数优先级更高）
+// recipients 为逗号分隔的 age 公钥列表
+func (c *Config) MergeWithEncryptFlags(recipients, recipientsFile, passphrase string) {
+	if list := splitList(recipients); len(list) > 0 {
+		c.Encryption.Recipients = list
+	}
+	if recipientsFile != "" {
+		c.Encryption.RecipientsFile = recipientsFile
+	}
+	if passphrase != "" {
+		c.Encryption.Passphrase = passphrase
+	}
+}
+
+// MergeWithDecryptFlags 将解密相关命令行参数合并到配置中（命令行参数优先级更高）
+func (c *Config) MergeWithDecryptFlags(identityFile, passphrase string) {
+	if identityFile != "" {
+		c.Encryption.IdentityFile = identityFile
+	}
+	if passphrase != "" {
+		c.Encryption.Passphrase = passphrase
+	}
+}
+
+// MergeWithRetryFlags 合并子命令的重试配置
+// 优先级：命令行参数 > 子命令环境变量（如 ETCD_RETRY_MAX_ATTEMPTS）> 全局环境变量（如 RETRY_MAX_ATTEMPTS）
+// maxAttempts 为 0、时间参数为空表示未设置
+func (c *Config) MergeWithRetryFlags(command string, maxAttempts int, initialDelay, maxDelay string) error {
+	if err := mergeRetryEnv(&c.Retry, strings.ToUpper(command)+"_"); err != nil {
+		return err
+	}
+
+	if maxAttempts != 0 {
+		c.Retry.MaxAttempts = maxAttempts
+	}
+	if initialDelay != "" {
+		d, err := time.ParseDuration(initialDelay)
+		if err != nil {
+			return fmt.Errorf("无效的 retry-initial-delay 格式: %v", err)
+		}
+		c.Retry.InitialDelay = d
+	}
+	if maxDelay != "" {
+		d, err := time.ParseDuration(maxDelay)
+		if err != nil {
+			return fmt.Errorf("无效的 retry-max-delay 格式: %v", err)
+		}
+		c.Retry.MaxDelay = d
+	}
+	return nil
+}
+
+// MergeWithPruneFlags 合并备份保留规则（保留数量为 0 表示未设置）
+func (c *Config) MergeWithPruneFlags(keepLast, keepDaily, keepWeekly, keepMonthly, keepYearly int, prune bool) error {
+	for _, item := range []struct {
+		flag  int
+		value *int
+	}{
+		{keepLast, &c.Retention.KeepLast},
+		{keepDaily, &c.Retention.KeepDaily},
+		{keepWeekly, &c.Retention.KeepWeekly},
+		{keepMonthly, &c.Retention.KeepMonthly},
+		{keepYearly, &c.Retention.KeepYearly},
+	} {
+		if item.flag != 0 {
+			*item.value = item.flag
+		}
+	}
+	if prune {
+		c.Prune = true
+	}
+
+	if err := c.Retention.Validate(); err != nil {
+		return err
+	}
+	if c.Prune && !c.Retention.Enabled() {
+		return fmt.Errorf("启用 --prune 时需要指定保留规则（--keep-last/--keep-daily/--keep-weekly/--keep-monthly/--keep-yearly 或 PRUNE_KEEP_* 环境变量）")
+	}
+	return nil
+}
+
+// MergeWithMetricsFlags 将指标文件路径合并到配置中（命令行参数优先级更高）
+func (c *Config) MergeWithMetricsFlags(textfile string) {
+	if textfile != "" {
+		c.MetricsTextfile = textfile
+	}
+}
+
+// MergeWithReportFlags 将运行报告输出格式和报告文件路径合并到配置中（命令行参数优先级更高）
+func (c *Config) MergeWithReportFlags(output, reportFile string) error {
+	switch output {
+	case "", "text", "json":
+		c.Output = output
+	default:
+		return fmt.Errorf("不支持的输出格式: %s，支持的格式: text, json", output)
+	}
+	if reportFile != "" {
+		c.ReportFile = reportFile
+	}
+	return nil
+}
+
+// MergeWithNotifyFlags 将通知相关命令行参数合并到配置中（命令行参数优先级更高），并校验通知配置
+func (c *Config) MergeWithNotifyFlags(on, template, webhook, dingTalk, feishu, slack, emailTo string) error {
+	for _, item := range []struct {
+		flag  string
+		value *string
+	}{
+		{on, &c.Notify.On},
+		{template, &c.Notify.Template},
+		{webhook, &c.Notify.WebhookURL},
+		{dingTalk, &c.Notify.DingTalkURL},
+		{feishu, &c.Notify.FeishuURL},
+		{slack, &c.Notify.SlackURL},
+	} {
+		if item.flag != "" {
+			*item.value = item.flag
+		}
+	}
+	if to := splitList(emailTo); len(to) > 0 {
+		c.Notify.SMTP.To = to
+	}
+	return c.Notify.Validate()
+}
+
+// MergeWithHookFlags 将 hook 相关命令行参数合并到配置中（命令行参数优先级更高，指定时替换该阶段的所有命令），并校验 hook 配置
+func (c *Config) MergeWithHookFlags(pre, postSuccess, postFailure, always []string, timeout string) error {
+	for _, item := range []struct {
+		flag     []string
+		commands *[]string
+	}{
+		{pre, &c.Hooks.Pre},
+		{postSuccess, &c.Hooks.PostSuccess},
+		{postFailure, &c.Hooks.PostFailure},
+		{always, &c.Hooks.Always},
+	} {
+		if len(item.flag) > 0 {
+			*item.commands = item.flag
+		}
+	}
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("无效的 hook 超时时间: %v", err)
+		}
+		c.Hooks.Timeout = d
+	}
+	return c.Hooks.Validate()
+}
+
+// PrunePolicy 返回备份成功后使用的保留规则，未启用 --prune 时返回空规则（不清理）
+func (c *Config) PrunePolicy() retention.Policy {
+	if !c.Prune {
+		return retention.Policy{}
+	}
+	return c.Retention
+}
+
+// mergeRetryEnv 从带前缀的环境变量读取重试配置（未设置的保持原值）
+func mergeRetryEnv(policy *retry.Policy, prefix string) error {
+	if value := os.Getenv(prefix + "RETRY_MAX_ATTEMPTS"); value != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("无效的环境变量 %sRETRY_MAX_ATTEMPTS: %v", prefix, err)
+		}
+		policy.MaxAttempts = n
+	}
+	if value := os.Getenv(prefix + "RETRY_INITIAL_DELAY"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("无效的环境变量 %sRETRY_INITIAL_DELAY: %v", prefix, err)
+		}
+		policy.InitialDelay = d
+	}
+	if value := os.Getenv(prefix + "RETRY_MAX_DELAY"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("无效的环境变量 %sRETRY_MAX_DELAY: %v", prefix, err)
+		}
+		policy.MaxDelay = d
+	}
+	return nil
+}
+
+// StorageDestinations 返回最终使用的备份目标地址列表
+// 未指定备份目标时，使用 OSS 配置生成默认目标 oss://{bucket}/{prefix}
+func (c *Config) StorageDestinations() []string {
+	if len(c.Destinations) > 0 {
+		return c.Destinations
+	}
+	return []string{fmt.Sprintf("oss://%s/%s", c.OSSBucket, strings.TrimPrefix(c.OSSObjectPrefix, "/"))}
+}
+
+// ValidateStorage 验证备份目标配置是否完整
+func (c *Config) ValidateStorage() error {
+	if c.PartSizeMB < 1 || c.PartSizeMB > maxPartSizeMB {
+		return fmt.Errorf("分片大小必须在 1 到 %d MB 之间（通过 --part-size-mb 参数或 PART_SIZE_MB 环境变量）: %d", maxPartSizeMB, c.PartSizeMB)
+	}
+	if c.UploadParallel < 1 {
+		return fmt.Errorf("上传并发数必须大于 0（通过 --parallel 参数或 UPLOAD_PARALLEL 环境变量）: %d", c.UploadParallel)
+	}
+	if c.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("最大尝试次数必须大于 0（通过 --retry-max-attempts 参数或 RETRY_MAX_ATTEMPTS 环境变量）: %d", c.Retry.MaxAttempts)
+	}
+	if c.Retry.InitialDelay < 0 || c.Retry.MaxDelay < c.Retry.InitialDelay {
+		return fmt.Errorf("重试等待时间无效: initial_delay=%s, max_delay=%s", c.Retry.InitialDelay, c.Retry.MaxDelay)
+	}
+	if c.Encryption.Passphrase != "" && (len(c.Encryption.Recipients) > 0 || c.Encryption.RecipientsFile != "") {
+		return fmt.Errorf("口令加密（ENCRYPT_PASSPHRASE）不能与公钥加密（ENCRYPT_RECIPIENTS/ENCRYPT_RECIPIENTS_FILE）同时使用")
+	}
+
+	if len(c.Destinations) == 0 {
+		// 未指定备份目标时沿用原有的 OSS 配置
+		if err := c.validateOSSCredentials(); err != nil {
+			return err
+		}
+		if c.OSSBucket == "" {
+			return fmt.Errorf("OSS存储桶未设置（通过 --bucket 参数或 OSS_BUCKET 环境变量）")
+		}
+		return nil
+	}
+
+	for _, dest := range c.Destinations {
+		loc, err := storage.ParseLocation(dest)
+		if err != nil {
+			return err
+		}
+		switch loc.Scheme {
+		case "oss":
+			if loc.Host == "" {
+				return fmt.Errorf("备份目标缺少存储桶名称: %s", dest)
+			}
+			if err := c.validateOSSCredentials(); err != nil {
+				return err
+			}
+		case "s3":
+			if loc.Host == "" {
+				return fmt.Errorf("备份目标缺少存储桶名称: %s", dest)
+			}
+			// 未设置 AccessKey 时使用 AWS 默认凭证链，但两者必须同时设置
+			if (c.S3AccessKey == "") != (c.S3SecretKey == "") {
+				return fmt.Errorf("S3 AccessKey 和 SecretKey 必须同时设置（通过 --s3-access-key/--s3-secret-key 参数或 S3_ACCESS_KEY/S3_SECRET_KEY 环境变量）")
+			}
+		case "file":
+			if loc.Host != "" {
+				return fmt.Errorf("本地备份目标必须使用绝对路径，如 file:///mnt/backup: %s", dest)
+			}
+			if loc.Path == "" || loc.Path == "/" {
+				return fmt.Errorf("本地备份目标缺少目录路径: %s", dest)
+			}
+		case "sftp":
+			if loc.Host == "" {
+				return fmt.Errorf("SFTP 备份目标缺少主机地址: %s", dest)
+			}
+			if c.SFTPKeyFile == "" {
+				return fmt.Errorf("SFTP 私钥文件未设置（通过 --sftp-key 参数或 SFTP_KEY_FILE 环境变量）")
+			}
+		default:
+			return fmt.Errorf("不支持的备份目标协议: %s（目标地址: %s）", loc.Scheme, dest)
+		}
+	}
+	return nil
+}
+
+// validateOSSCredentials 验证 OSS 端点和访问密钥
+func (c *Config) validateOSSCredentials() error {
 	if c.OSSEndpoint == "" {
 		return fmt.Errorf("OSS端点未设置（通过 --endpoint 参数或 OSS_ENDPOINT 环境变量）")
 	}
@@ -213,12 +759,54 @@ func (c *Config) ValidateFileConfig() error {
 	if c.OSSSecretKey == "" {
 		return fmt.Errorf("OSS SecretKey未设置（通过 --secret-key 参数或 OSS_SECRET_KEY 环境变量）")
 	}
-	if c.OSSBucket == "" {
-		return fmt.Errorf("OSS存储桶未设置（通过 --bucket 参数或 OSS_BUCKET 环境变量）")
-	}
 	return nil
 }
 
+// parseTags 解析 k=v 格式的标签列表（逗号分隔）
+func parseTags(value string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, item := range splitList(value) {
+		k, v, ok := strings.Cut(item, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("标签必须为 key=value 格式: %s", item)
+		}
+		tags[k] = strings.TrimSpace(v)
+	}
+	return tags, nil
+}
+
+// splitList 解析逗号分隔的列表，忽略空白项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvBool 获取布尔类型的环境变量（true 或 1 表示开启）
+func getEnvBool(key string) bool {
+	value := os.Getenv(key)
+	return value == "true" || value == "1"
+}
+
+// getEnvInt 获取整数类型的环境变量，如果不存在则返回默认值
+func getEnvInt(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("无效的环境变量 %s: %v", key, err)
+	}
+	return n, nil
+}
+
 // getEnvOrDefault 获取环境变量，如果不存在则返回默认值
 func getEnvOrDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
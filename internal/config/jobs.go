@@ -0,0 +1,360 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/hook"
+	"backup-to-oss/internal/notify"
+	"backup-to-oss/internal/retention"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultJobsFile 默认的备份任务配置文件路径
+const DefaultJobsFile = "backup.yaml"
+
+// Job 备份任务配置文件（backup.yaml）中的一个任务
+// 任务中未设置的配置使用 defaults 中的配置
+type Job struct {
+	Name            string            `yaml:"-"`                 // 任务名称（jobs 中的键）
+	Type            string            `yaml:"type"`              // 备份类型 (dir/file/etcd/consul)
+	Schedule        string            `yaml:"schedule"`          // 定时执行的 cron 表达式（daemon 命令使用，可选）
+	Jitter          time.Duration     `yaml:"jitter"`            // 定时执行的最大随机延迟（daemon 命令使用，可选）
+	Paths           []string          `yaml:"paths"`             // 要备份的目录或文件（dir/file）
+	Exclude         []string          `yaml:"exclude"`           // 排除模式（dir）
+	Etcd            EtcdJob           `yaml:"etcd"`              // etcd 配置（etcd）
+	Consul          ConsulJob         `yaml:"consul"`            // Consul 配置（consul）
+	Compress        string            `yaml:"compress"`          // 压缩方式 (zstd/gzip/none)
+	KeepBackupFiles bool              `yaml:"keep_backup_files"` // 是否保留备份文件
+	Stream          bool              `yaml:"stream"`            // 是否流式上传
+	FailFast        bool              `yaml:"fail_fast"`         // 一个目录或文件备份失败时立即停止
+	Incremental     bool              `yaml:"incremental"`       // 是否增量备份（dir）
+	FullInterval    time.Duration     `yaml:"full_interval"`     // 增量备份时完整备份的间隔（dir）
+	Dest            []string          `yaml:"dest"`              // 备份目标地址列表
+	Repository      bool              `yaml:"repository"`        // 是否使用去重仓库存储备份
+	OSS             OSSJob            `yaml:"oss"`               // OSS 配置
+	S3              S3Job             `yaml:"s3"`                // S3 配置
+	SFTP            SFTPJob           `yaml:"sftp"`              // SFTP 配置
+	PartSizeMB      int               `yaml:"part_size_mb"`      // 分片大小（MB）
+	Parallel        int               `yaml:"parallel"`          // 分片上传并发数
+	CheckpointDir   string            `yaml:"checkpoint_dir"`    // 断点续传 checkpoint 目录
+	Tags            map[string]string `yaml:"tags"`              // 自定义对象标签
+	Encrypt         EncryptJob        `yaml:"encrypt"`           // 客户端加密配置
+	Retry           RetryJob          `yaml:"retry"`             // 重试策略
+	Retention       RetentionJob      `yaml:"retention"`         // 备份保留规则
+	Prune           bool              `yaml:"prune"`             // 备份成功后是否清理旧备份
+	Notify          NotifyJob         `yaml:"notify"`            // 备份结果通知
+	ReportFile      string            `yaml:"report_file"`       // 运行报告文件路径
+	Hooks           HooksJob          `yaml:"hooks"`             // 备份前后执行的命令
+}
+
+// EtcdJob 任务的 etcd 配置
+type EtcdJob struct {
+	Endpoints      []string      `yaml:"endpoints"`
+	CACert         string        `yaml:"cacert"`
+	Cert           string        `yaml:"cert"`
+	Key            string        `yaml:"key"`
+	User           string        `yaml:"user"`
+	Password       string        `yaml:"password"`
+	DialTimeout    time.Duration `yaml:"dial_timeout"`
+	CommandTimeout time.Duration `yaml:"command_timeout"`
+}
+
+// ConsulJob 任务的 Consul 配置
+type ConsulJob struct {
+	Address string `yaml:"address"`
+	Token   string `yaml:"token"`
+	Stale   bool   `yaml:"stale"`
+}
+
+// OSSJob 任务的 OSS 配置
+type OSSJob struct {
+	Endpoint     string `yaml:"endpoint"`
+	AccessKey    string `yaml:"access_key"`
+	SecretKey    string `yaml:"secret_key"`
+	Bucket       string `yaml:"bucket"`
+	Prefix       string `yaml:"prefix"`
+	SSE          string `yaml:"sse"`
+	KMSKeyID     string `yaml:"kms_key_id"`
+	StorageClass string `yaml:"storage_class"`
+}
+
+// S3Job 任务的 S3 配置
+type S3Job struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"`
+}
+
+// SFTPJob 任务的 SFTP 配置
+type SFTPJob struct {
+	KeyFile       string `yaml:"key_file"`
+	KeyPassphrase string `yaml:"key_passphrase"`
+	KnownHosts    string `yaml:"known_hosts"`
+}
+
+// EncryptJob 任务的客户端加密配置
+type EncryptJob struct {
+	Recipients     []string `yaml:"recipients"`
+	RecipientsFile string   `yaml:"recipients_file"`
+	Passphrase     string   `yaml:"passphrase"`
+}
+
+// RetryJob 任务的重试策略（0 表示使用默认值）
+type RetryJob struct {
+	MaxAttempts  int           `yaml:"max_attempts"`
+	InitialDelay time.Duration `yaml:"initial_delay"`
+	MaxDelay     time.Duration `yaml:"max_delay"`
+}
+
+// RetentionJob 任务的备份保留规则
+type RetentionJob struct {
+	KeepLast    int `yaml:"keep_last"`
+	KeepDaily   int `yaml:"keep_daily"`
+	KeepWeekly  int `yaml:"keep_weekly"`
+	KeepMonthly int `yaml:"keep_monthly"`
+	KeepYearly  int `yaml:"keep_yearly"`
+}
+
+// NotifyJob 任务的备份结果通知配置
+type NotifyJob struct {
+	On       string   `yaml:"on"`       // 发送通知的时机 (failure/always/never)
+	Template string   `yaml:"template"` // 消息模板文件路径
+	Webhook  string   `yaml:"webhook"`  // 通用 JSON webhook 地址
+	DingTalk RobotJob `yaml:"dingtalk"` // 钉钉机器人
+	Feishu   RobotJob `yaml:"feishu"`   // 飞书机器人
+	Slack    string   `yaml:"slack"`    // Slack incoming webhook 地址
+	Email    EmailJob `yaml:"email"`    // 邮件通知
+}
+
+// RobotJob 钉钉/飞书机器人配置
+type RobotJob struct {
+	Webhook string `yaml:"webhook"`
+	Secret  string `yaml:"secret"`
+}
+
+// EmailJob 邮件通知配置
+type EmailJob struct {
+	To       []string `yaml:"to"`
+	From     string   `yaml:"from"`
+	SMTPHost string   `yaml:"smtp_host"`
+	SMTPPort int      `yaml:"smtp_port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+}
+
+// HooksJob 任务的 hook 配置，每个阶段可以有多个命令
+type HooksJob struct {
+	Pre         []string      `yaml:"pre"`          // 备份前执行，失败时中止备份
+	PostSuccess []string      `yaml:"post_success"` // 备份成功后执行
+	PostFailure []string      `yaml:"post_failure"` // 备份失败后执行
+	Always      []string      `yaml:"always"`       // 无论备份是否成功都执行
+	Timeout     time.Duration `yaml:"timeout"`      // 每个命令的超时时间
+}
+
+// JobsFile 备份任务配置文件
+type JobsFile struct {
+	Path string
+	Jobs []Job // 按配置文件中的顺序排列
+}
+
+// jobsDocument 备份任务配置文件的结构（用于检查未知字段）
+type jobsDocument struct {
+	Defaults Job            `yaml:"defaults"`
+	Jobs     map[string]Job `yaml:"jobs"`
+}
+
+// LoadJobsFile 加载备份任务配置文件
+func LoadJobsFile(path string) (*JobsFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	// 检查未知字段（如拼写错误的配置项）
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var doc jobsDocument
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+
+	// 依次解析 defaults 和任务配置，任务中设置的配置覆盖 defaults
+	var root struct {
+		Defaults yaml.Node `yaml:"defaults"`
+		Jobs     yaml.Node `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+	file := &JobsFile{Path: path}
+	for i := 0; i+1 < len(root.Jobs.Content); i += 2 {
+		var job Job
+		if !root.Defaults.IsZero() {
+			if err := root.Defaults.Decode(&job); err != nil {
+				return nil, fmt.Errorf("解析配置文件 %s 的 defaults 失败: %v", path, err)
+			}
+		}
+		job.Name = root.Jobs.Content[i].Value
+		if err := root.Jobs.Content[i+1].Decode(&job); err != nil {
+			return nil, fmt.Errorf("解析任务 %s 失败: %v", job.Name, err)
+		}
+		if err := job.validate(); err != nil {
+			return nil, fmt.Errorf("任务 %s 配置错误: %v", job.Name, err)
+		}
+		file.Jobs = append(file.Jobs, job)
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("配置文件 %s 中没有定义任务（jobs）", path)
+	}
+	return file, nil
+}
+
+// Job 按名称查找任务
+func (f *JobsFile) Job(name string) (Job, bool) {
+	for _, job := range f.Jobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// Names 返回所有任务名称
+func (f *JobsFile) Names() []string {
+	names := make([]string, 0, len(f.Jobs))
+	for _, job := range f.Jobs {
+		names = append(names, job.Name)
+	}
+	return names
+}
+
+// validate 校验任务配置
+func (j Job) validate() error {
+	if !slices.Contains(catalog.Types, j.Type) {
+		return fmt.Errorf("不支持的备份类型: %q，支持的类型: %v", j.Type, catalog.Types)
+	}
+	switch j.Type {
+	case catalog.TypeDir, catalog.TypeFile:
+		if len(j.Paths) == 0 {
+			return fmt.Errorf("%s 任务需要设置 paths", j.Type)
+		}
+	}
+	switch j.Compress {
+	case "", "zstd", "gzip", "none":
+	default:
+		return fmt.Errorf("不支持的压缩方式: %s", j.Compress)
+	}
+	switch j.Notify.On {
+	case "", notify.OnFailure, notify.OnAlways, notify.OnNever:
+	default:
+		return fmt.Errorf("不支持的通知时机: %s", j.Notify.On)
+	}
+	if j.FullInterval < 0 {
+		return fmt.Errorf("完整备份间隔不能为负数: %s", j.FullInterval)
+	}
+	if j.Hooks.Timeout < 0 {
+		return fmt.Errorf("hook 超时时间不能为负数: %s", j.Hooks.Timeout)
+	}
+	return nil
+}
+
+// LoadJobConfig 加载任务的配置
+// 优先级：命令行参数 > 环境变量 > 任务配置 > defaults 配置；备份来源（paths、exclude）只从任务配置读取
+func LoadJobConfig(envFile string, job Job) (*Config, error) {
+	LoadEnvFile(envFile)
+
+	cfg := newConfig()
+	switch job.Type {
+	case catalog.TypeDir:
+		cfg.DirPaths = job.Paths
+		cfg.ExcludePatterns = job.Exclude
+	case catalog.TypeFile:
+		cfg.FilePaths = job.Paths
+	}
+	if job.Compress != "" {
+		cfg.CompressMethod = job.Compress
+	}
+	cfg.Destinations = job.Dest
+	cfg.Repository = job.Repository
+	cfg.OSSEndpoint = job.OSS.Endpoint
+	cfg.OSSAccessKey = job.OSS.AccessKey
+	cfg.OSSSecretKey = job.OSS.SecretKey
+	cfg.OSSBucket = job.OSS.Bucket
+	cfg.OSSObjectPrefix = job.OSS.Prefix
+	cfg.OSSSSE = job.OSS.SSE
+	cfg.OSSKMSKeyID = job.OSS.KMSKeyID
+	cfg.OSSStorageClass = job.OSS.StorageClass
+	cfg.Tags = maps.Clone(job.Tags)
+	cfg.S3Endpoint = job.S3.Endpoint
+	cfg.S3Region = job.S3.Region
+	cfg.S3AccessKey = job.S3.AccessKey
+	cfg.S3SecretKey = job.S3.SecretKey
+	cfg.S3PathStyle = job.S3.PathStyle
+	cfg.SFTPKeyFile = job.SFTP.KeyFile
+	cfg.SFTPKeyPassphrase = job.SFTP.KeyPassphrase
+	cfg.SFTPKnownHostsFile = job.SFTP.KnownHosts
+	if job.PartSizeMB != 0 {
+		cfg.PartSizeMB = job.PartSizeMB
+	}
+	if job.Parallel != 0 {
+		cfg.UploadParallel = job.Parallel
+	}
+	cfg.CheckpointDir = job.CheckpointDir
+	cfg.Stream = job.Stream
+	cfg.FailFast = job.FailFast
+	cfg.Incremental = job.Incremental
+	cfg.FullInterval = job.FullInterval
+	if job.Retry.MaxAttempts != 0 {
+		cfg.Retry.MaxAttempts = job.Retry.MaxAttempts
+	}
+	if job.Retry.InitialDelay != 0 {
+		cfg.Retry.InitialDelay = job.Retry.InitialDelay
+	}
+	if job.Retry.MaxDelay != 0 {
+		cfg.Retry.MaxDelay = job.Retry.MaxDelay
+	}
+	cfg.Encryption = crypt.Config{
+		Recipients:     job.Encrypt.Recipients,
+		RecipientsFile: job.Encrypt.RecipientsFile,
+		Passphrase:     job.Encrypt.Passphrase,
+	}
+	cfg.Retention = retention.Policy(job.Retention)
+	cfg.Prune = job.Prune
+	cfg.ReportFile = job.ReportFile
+	cfg.Hooks = hook.Config(job.Hooks)
+	cfg.Notify = notify.Config{
+		On:             job.Notify.On,
+		Template:       job.Notify.Template,
+		WebhookURL:     job.Notify.Webhook,
+		DingTalkURL:    job.Notify.DingTalk.Webhook,
+		DingTalkSecret: job.Notify.DingTalk.Secret,
+		FeishuURL:      job.Notify.Feishu.Webhook,
+		FeishuSecret:   job.Notify.Feishu.Secret,
+		SlackURL:       job.Notify.Slack,
+		SMTP: notify.SMTPConfig{
+			Host:     job.Notify.Email.SMTPHost,
+			Port:     job.Notify.Email.SMTPPort,
+			Username: job.Notify.Email.Username,
+			Password: job.Notify.Email.Password,
+			From:     job.Notify.Email.From,
+			To:       job.Notify.Email.To,
+		},
+	}
+
+	if err := cfg.mergeEnv(); err != nil {
+		return nil, err
+	}
+	// 任务名称写入对象标签 job，不使用 JOB_NAME 环境变量
+	cfg.JobName = job.Name
+	return cfg, nil
+}
@@ -0,0 +1,116 @@
+package consul
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"backup-to-oss/internal/logger"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// RestoreConfig Consul snapshot 恢复配置
+// 未指定的 TLS 配置使用 Consul 的环境变量（CONSUL_CACERT、CONSUL_CLIENT_CERT、CONSUL_CLIENT_KEY 等）
+type RestoreConfig struct {
+	Address       string // Consul 地址，如 http://localhost:8500
+	Token         string // Consul ACL Token（需要 operator:write 权限）
+	CACert        string // CA 证书文件路径（可选）
+	Cert          string // 客户端证书文件路径（可选）
+	Key           string // 客户端私钥文件路径（可选）
+	TLSServerName string // 校验服务端证书时使用的主机名（可选）
+	TLSSkipVerify bool   // 是否跳过服务端证书校验
+}
+
+// ClusterIndex 集群当前的 raft 索引
+type ClusterIndex struct {
+	Index uint64 // 最后一条日志的索引
+	Term  uint64 // 最后一条日志的任期（连接的 agent 不是 server 时为 0）
+}
+
+// Restorer 通过 Consul HTTP API 恢复 snapshot
+type Restorer struct {
+	client  *api.Client
+	address string
+}
+
+// NewRestorer 创建 Consul 客户端
+func NewRestorer(cfg RestoreConfig) (*Restorer, error) {
+	config := api.DefaultConfig()
+	if cfg.Address != "" {
+		config.Address = cfg.Address
+	}
+	if cfg.Token != "" {
+		config.Token = cfg.Token
+	}
+	if cfg.CACert != "" {
+		config.TLSConfig.CAFile = cfg.CACert
+	}
+	if cfg.Cert != "" {
+		config.TLSConfig.CertFile = cfg.Cert
+	}
+	if cfg.Key != "" {
+		config.TLSConfig.KeyFile = cfg.Key
+	}
+	if cfg.TLSServerName != "" {
+		config.TLSConfig.Address = cfg.TLSServerName
+	}
+	if cfg.TLSSkipVerify {
+		config.TLSConfig.InsecureSkipVerify = true
+	}
+
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("创建 Consul 客户端失败: %v", err)
+	}
+	return &Restorer{client: client, address: config.Address}, nil
+}
+
+// ClusterIndex 获取集群当前的 raft 索引
+// 连接的 agent 是 server 时使用 raft 统计信息，否则使用 catalog 查询返回的索引
+func (r *Restorer) ClusterIndex() (*ClusterIndex, error) {
+	logger.Info("正在获取 Consul 集群索引", "address", r.address)
+	self, err := r.client.Agent().Self()
+	if err != nil {
+		return nil, fmt.Errorf("获取 Consul agent 信息失败: %v", err)
+	}
+	if raftStats, ok := self["Stats"]["raft"].(map[string]interface{}); ok {
+		index, indexErr := parseStat(raftStats["last_log_index"])
+		term, termErr := parseStat(raftStats["last_log_term"])
+		if indexErr == nil && termErr == nil {
+			return &ClusterIndex{Index: index, Term: term}, nil
+		}
+	}
+
+	_, qm, err := r.client.Catalog().Services(nil)
+	if err != nil {
+		return nil, fmt.Errorf("获取 Consul 集群索引失败: %v", err)
+	}
+	return &ClusterIndex{Index: qm.LastIndex}, nil
+}
+
+// Restore 通过 PUT /v1/snapshot 将 snapshot 文件恢复到集群
+// 恢复会覆盖集群的全部状态，调用方需要在恢复前确认
+func (r *Restorer) Restore(snapshotPath string) error {
+	f, err := os.Open(snapshotPath)
+	if err != nil {
+		return fmt.Errorf("打开 snapshot 文件失败: %v", err)
+	}
+	defer f.Close()
+
+	logger.Info("正在恢复 Consul snapshot", "address", r.address)
+	if err := r.client.Snapshot().Restore(nil, f); err != nil {
+		return fmt.Errorf("恢复 Consul snapshot 失败: %v", err)
+	}
+	logger.Info("Consul snapshot 恢复完成")
+	return nil
+}
+
+// parseStat 解析 agent 统计信息中的数值（以字符串返回）
+func parseStat(v interface{}) (uint64, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("统计信息格式错误: %v", v)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
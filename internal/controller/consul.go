@@ -1,39 +1,60 @@
 package controller
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 
 	"path/filepath"
-	"strings"
 	"time"
 
+	"backup-to-oss/internal/catalog"
 	"backup-to-oss/internal/compress"
 	"backup-to-oss/internal/consul"
-	"backup-to-oss/internal/ipfetcher"
 	"backup-to-oss/internal/logger"
-	"backup-to-oss/internal/oss"
+	"backup-to-oss/internal/retention"
+	"backup-to-oss/internal/retry"
 
 	"github.com/rboyer/safeio"
 )
 
 // ConsulBackupRequest Consul snapshot 备份请求
 type ConsulBackupRequest struct {
-	ConsulAddress   string // Consul 地址，如 http://localhost:8500
-	ConsulToken     string // Consul ACL Token（可选）
-	Stale           bool   // 是否允许从非 leader 节点获取快照
-	CompressMethod  string // 压缩方式 (zstd/gzip/none)
-	KeepBackupFiles bool   // 是否保留备份文件
-	OSSEndpoint     string
-	OSSAccessKey    string
-	OSSSecretKey    string
-	OSSBucket       string
-	OSSObjectPrefix string
+	ConsulAddress   string           // Consul 地址，如 http://localhost:8500
+	ConsulToken     string           // Consul ACL Token（可选）
+	Stale           bool             // 是否允许从非 leader 节点获取快照
+	CompressMethod  string           // 压缩方式 (zstd/gzip/none)
+	KeepBackupFiles bool             // 是否保留备份文件
+	Stream          bool             // 是否流式上传（不生成本地临时文件）
+	Retry           retry.Policy     // 上传和获取 snapshot 的重试策略
+	Retention       retention.Policy // 备份成功后按该规则清理旧备份（未设置规则时不清理）
+	Storage         StorageConfig    // 备份目标配置
 }
 
 // ConsulBackup 执行 Consul snapshot 备份
-func ConsulBackup(req ConsulBackupRequest) error {
+func ConsulBackup(ctx context.Context, req ConsulBackupRequest) error {
+	// 去重仓库只支持流式上传，snapshot 不压缩（数据块单独压缩）
+	if req.Storage.Repository {
+		req.Stream, req.CompressMethod = true, "none"
+	}
+	if req.Stream && req.KeepBackupFiles {
+		logger.Warn("流式模式不生成本地备份文件，忽略保留备份文件选项")
+	}
+
+	// 创建存储后端
+	up, err := newUploader(ctx, req.Storage, req.Retry)
+	if err != nil {
+		return err
+	}
+	defer up.Close()
+	up.setType(catalog.TypeConsul)
+	up.setSource(map[string]any{"address": req.ConsulAddress, "stale": req.Stale, "compress": req.CompressMethod, "stream": req.Stream})
+	item := up.startItem(req.ConsulAddress)
+
+	// 继续上传之前运行中未完成的上传
+	up.ResumePending(ctx)
+
 	// 调用 consul 包执行备份
 	backupCfg := consul.BackupConfig{
 		Address: req.ConsulAddress,
@@ -41,23 +62,49 @@ func ConsulBackup(req ConsulBackupRequest) error {
 		Stale:   req.Stale,
 	}
 
-	result, err := consul.Backup(backupCfg)
-	if err != nil {
-		return err
+	// 流式模式：snapshot 数据压缩后直接上传，不生成本地临时文件
+	// 数据流无法重放，只对获取 snapshot 的请求重试
+	if req.Stream {
+		var result *consul.BackupResult
+		err = retry.Do(ctx, req.Retry, "获取 Consul snapshot", func(ctx context.Context) error {
+			r, err := consul.Backup(backupCfg)
+			result = r
+			return err
+		})
+		if err != nil {
+			item.Fail(err)
+			return err
+		}
+		defer result.Snapshot.Close()
+		item.SetMetadata(map[string]any{"last_index": result.LastIndex})
+		return consulBackupStream(ctx, up, req, result)
 	}
-	defer result.Snapshot.Close()
 
 	// 创建临时文件用于保存 snapshot
 	now := time.Now()
 	timeStr := now.Format("20060102-150405")
-	snapshotName := fmt.Sprintf("consul-snapshot-%s.snap", timeStr)
+	snapshotName := fmt.Sprintf("%s%s.snap", catalog.ConsulPrefix, timeStr)
 	tempSnapshotPath := filepath.Join(os.TempDir(), snapshotName)
 	unverifiedPath := tempSnapshotPath + ".unverified"
 
-	// 先写入未验证的文件
+	// 先写入未验证的文件，获取或写入过程中连接中断时重新获取 snapshot
 	logger.Info("正在保存 snapshot 到临时文件", "path", unverifiedPath)
-	if _, err := safeio.WriteToFile(result.Snapshot, unverifiedPath, 0600); err != nil {
-		return fmt.Errorf("写入 snapshot 文件失败: %v", err)
+	var result *consul.BackupResult
+	err = retry.Do(ctx, req.Retry, "获取 Consul snapshot", func(ctx context.Context) error {
+		r, err := consul.Backup(backupCfg)
+		if err != nil {
+			return err
+		}
+		defer r.Snapshot.Close()
+		if _, err := safeio.WriteToFile(r.Snapshot, unverifiedPath, 0600); err != nil {
+			return fmt.Errorf("写入 snapshot 文件失败: %v", err)
+		}
+		result = r
+		return nil
+	})
+	if err != nil {
+		item.Fail(err)
+		return err
 	}
 	defer os.Remove(unverifiedPath)
 
@@ -102,8 +149,11 @@ func ConsulBackup(req ConsulBackupRequest) error {
 	logger.Info("正在执行 snapshot inspect 操作")
 	snapshotInfo, err := consul.InspectSnapshot(tempSnapshotPath)
 	if err != nil {
-		return fmt.Errorf("snapshot inspect 失败，文件可能已损坏: %v", err)
+		err = fmt.Errorf("snapshot inspect 失败，文件可能已损坏: %v", err)
+		item.Fail(err)
+		return err
 	}
+	item.SetMetadata(consulMetadata(result, snapshotInfo))
 
 	// 输出基本信息
 	logger.Info("Snapshot inspect 成功",
@@ -152,13 +202,21 @@ func ConsulBackup(req ConsulBackupRequest) error {
 	}
 	compressedPath := tempSnapshotPath + ext
 	logger.Info("正在压缩 snapshot 文件", "method", compressMethod)
-	if err := compress.CompressFile(tempSnapshotPath, compressedPath, compressMethod); err != nil {
+	compressedPath, digest, err := up.writeArchive(compressedPath, func(w io.Writer) error {
+		return compress.WriteFile(w, tempSnapshotPath, compressMethod)
+	})
+	if err != nil {
 		return fmt.Errorf("压缩 snapshot 文件失败: %v", err)
 	}
 	// 压缩完成后删除未压缩的临时文件
 	if !req.KeepBackupFiles {
 		os.Remove(tempSnapshotPath)
-		defer os.Remove(compressedPath)
+		defer func() {
+			// 等待续传或校验失败的文件需要保留
+			if !up.Retained(compressedPath) {
+				os.Remove(compressedPath)
+			}
+		}()
 	} else {
 		os.Remove(tempSnapshotPath) // 只保留压缩后的文件
 		logger.Info("备份文件已保留", "path", compressedPath)
@@ -176,44 +234,18 @@ func ConsulBackup(req ConsulBackupRequest) error {
 			"compression_ratio", fmt.Sprintf("%.1f%%", ratio))
 	}
 
-	// 获取公网IP（用于 OSS 路径）
-	publicIP, err := ipfetcher.NewPublicIPFetcher().Fetch()
+	// 获取公网IP（用于对象路径）
+	publicIP, err := fetchPublicIP()
 	if err != nil {
 		logger.Warn("获取公网IP失败，将不使用IP前缀", "error", err)
 		publicIP = ""
 	}
+	up.setHost(publicIP)
 
-	// 构建OSS对象前缀：{prefix}/{ip}/{date}/
-	dateStr := now.Format("20060102")
-	objectPrefix := req.OSSObjectPrefix
-	if publicIP != "" {
-		if objectPrefix != "" {
-			if !strings.HasSuffix(objectPrefix, "/") {
-				objectPrefix += "/"
-			}
-		}
-		objectPrefix += publicIP + "/" + dateStr + "/"
-	} else {
-		if objectPrefix != "" {
-			if !strings.HasSuffix(objectPrefix, "/") {
-				objectPrefix += "/"
-			}
-		}
-		objectPrefix += dateStr + "/"
-	}
-
-	// 上传到OSS
-	logger.Info("正在上传 snapshot 到 OSS")
-	ossConfig := oss.Config{
-		Endpoint:     req.OSSEndpoint,
-		AccessKey:    req.OSSAccessKey,
-		SecretKey:    req.OSSSecretKey,
-		Bucket:       req.OSSBucket,
-		ObjectPrefix: objectPrefix,
-	}
-
-	if err := oss.UploadFile(compressedPath, ossConfig); err != nil {
-		return fmt.Errorf("上传到 OSS 失败: %v", err)
+	// 上传到所有备份目标：{prefix}/{ip}/{date}/
+	logger.Info("正在上传 snapshot")
+	if err := up.Upload(ctx, compressedPath, objectDir(publicIP, now), digest, req.KeepBackupFiles); err != nil {
+		return fmt.Errorf("上传 snapshot 失败: %v", err)
 	}
 
 	if req.KeepBackupFiles {
@@ -221,9 +253,28 @@ func ConsulBackup(req ConsulBackupRequest) error {
 	} else {
 		logger.Info("Consul snapshot 备份完成", "last_index", result.LastIndex)
 	}
+	up.Prune(ctx, publicIP, catalog.TypeConsul, req.Retention)
 	return nil
 }
 
+// consulMetadata 返回运行报告中记录的 snapshot inspect 信息
+func consulMetadata(result *consul.BackupResult, info *consul.SnapshotInfo) map[string]any {
+	stats := make([]map[string]any, len(info.Stats))
+	for i, stat := range info.Stats {
+		stats[i] = map[string]any{"type": stat.Name, "count": stat.Count, "size": stat.Size}
+	}
+	return map[string]any{
+		"last_index": result.LastIndex,
+		"id":         info.ID,
+		"index":      info.Index,
+		"term":       info.Term,
+		"version":    info.Version,
+		"size":       info.Size,
+		"stats":      stats,
+		"total_size": info.TotalSize,
+	}
+}
+
 // formatByteSize 格式化字节大小为人类可读的格式
 func formatByteSize(bytes int64) string {
 	const (
@@ -259,3 +310,63 @@ func formatByteSize(bytes int64) string {
 	}
 	return fmt.Sprintf("%.1f%s", value, unit)
 }
+
+// consulBackupStream 流式执行 Consul snapshot 备份
+// 流式模式下没有本地文件，无法执行 snapshot inspect 操作
+func consulBackupStream(ctx context.Context, up *uploader, req ConsulBackupRequest, result *consul.BackupResult) error {
+	compressMethod := req.CompressMethod
+	if compressMethod == "" {
+		compressMethod = "zstd" // 默认使用 zstd
+	}
+
+	// 根据压缩方式确定文件扩展名
+	var ext string
+	switch compressMethod {
+	case "gzip":
+		ext = ".gz"
+	case "zstd":
+		ext = ".zst"
+	case "none":
+		ext = ""
+	default:
+		ext = ".zst" // 默认使用 zstd
+	}
+	now := time.Now()
+	snapshotName := fmt.Sprintf("%s%s.snap%s", catalog.ConsulPrefix, now.Format("20060102-150405"), ext)
+
+	// 获取公网IP（用于对象路径）
+	publicIP, err := fetchPublicIP()
+	if err != nil {
+		logger.Warn("获取公网IP失败，将不使用IP前缀", "error", err)
+		publicIP = ""
+	}
+	up.setHost(publicIP)
+
+	// 上传到所有备份目标：{prefix}/{ip}/{date}/
+	logger.Warn("流式模式不执行 snapshot inspect 操作")
+	logger.Info("正在流式上传 snapshot", "method", compressMethod)
+	var size int64
+	err = up.Stream(ctx, objectDir(publicIP, now)+snapshotName, func(w io.Writer) error {
+		compressWriter, err := compress.NewWriter(w, compressMethod)
+		if err != nil {
+			return err
+		}
+		size, err = io.Copy(compressWriter, result.Snapshot)
+		if err != nil {
+			compressWriter.Close()
+			return fmt.Errorf("读取 snapshot 数据失败: %v", err)
+		}
+		if size == 0 {
+			compressWriter.Close()
+			return fmt.Errorf("snapshot 数据为空")
+		}
+		return compressWriter.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("上传 snapshot 失败: %v", err)
+	}
+
+	logger.Info("Consul snapshot 备份完成", "last_index", result.LastIndex, "size_bytes", size, "size_mb", fmt.Sprintf("%.2f", float64(size)/(1024*1024)))
+	up.Prune(ctx, publicIP, catalog.TypeConsul, req.Retention)
+	return nil
+}
@@ -0,0 +1,488 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/checksum"
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/localfs"
+	"backup-to-oss/internal/retention"
+	"backup-to-oss/internal/retry"
+	"backup-to-oss/internal/storage"
+
+	"filippo.io/age"
+)
+
+// testIP 测试中使用的公网 IP（不访问外部服务）
+const testIP = "203.0.113.10"
+
+func init() {
+	fetchPublicIP = func() (string, error) { return testIP, nil }
+}
+
+// writeTree 在 dir 下创建文件，files 的键为相对路径
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree 读取 dir 下的所有文件，返回相对路径到内容的映射
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// openDest 打开测试的备份目标
+func openDest(t *testing.T, root string) storage.Storage {
+	t.Helper()
+	s, err := localfs.New(localfs.Config{RootDir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDirBackupRestore(t *testing.T) {
+	files := map[string]string{
+		"nginx.conf":        "worker_processes 1;\n",
+		"conf.d/site.conf":  "server { listen 80; }\n",
+		"conf.d/empty.conf": "",
+	}
+	tests := []struct {
+		name       string
+		compress   string
+		stream     bool
+		repository bool
+		encryption crypt.Config
+	}{
+		{name: "zstd", compress: "zstd"},
+		{name: "gzip", compress: "gzip"},
+		{name: "none", compress: "none"},
+		{name: "stream", compress: "zstd", stream: true},
+		{name: "encrypted", compress: "zstd", encryption: crypt.Config{Passphrase: "secret"}},
+		{name: "repository", repository: true},
+		{name: "repository-encrypted", repository: true, encryption: crypt.Config{Passphrase: "secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			src := filepath.Join(t.TempDir(), "etc", "nginx")
+			writeTree(t, src, files)
+			dest := t.TempDir()
+			cfg := StorageConfig{Destinations: []string{"file://" + dest}, Repository: tt.repository, Encryption: tt.encryption}
+
+			err := DirBackup(ctx, DirBackupRequest{
+				DirPaths:       []string{src},
+				CompressMethod: tt.compress,
+				Stream:         tt.stream,
+				Storage:        cfg,
+			})
+			if err != nil {
+				t.Fatalf("DirBackup: %v", err)
+			}
+
+			backups, err := ListBackups(ctx, ListRequest{Type: catalog.TypeDir, Storage: cfg})
+			if err != nil {
+				t.Fatalf("ListBackups: %v", err)
+			}
+			if len(backups) != 1 || backups[0].Host != testIP {
+				t.Fatalf("ListBackups = %+v, want one dir backup of host %s", backups, testIP)
+			}
+			key := backups[0].Key
+			if got := strings.HasSuffix(key, crypt.Suffix); got != tt.encryption.Enabled() {
+				t.Errorf("key %s encrypted = %v, want %v", key, got, tt.encryption.Enabled())
+			}
+			if !tt.repository {
+				// 上传后校验通过时写入 .sha256 校验文件
+				s := openDest(t, dest)
+				if _, err := s.Stat(ctx, key+checksum.SidecarSuffix); err != nil {
+					t.Errorf("sidecar missing: %v", err)
+				}
+			}
+
+			target := t.TempDir()
+			err = RestoreDir(ctx, RestoreRequest{
+				Host:       testIP,
+				TargetDir:  target,
+				Encryption: tt.encryption,
+				Storage:    cfg,
+			})
+			if err != nil {
+				t.Fatalf("RestoreDir: %v", err)
+			}
+			got := readTree(t, target)
+			if len(got) != len(files) {
+				t.Fatalf("restored %v, want %v", got, files)
+			}
+			for name, content := range files {
+				if got[name] != content {
+					t.Errorf("%s = %q, want %q", name, got[name], content)
+				}
+			}
+		})
+	}
+}
+
+func TestFileBackupRestore(t *testing.T) {
+	// report.tar.gz 的备份文件名为 {时间}_report.tar.zst，根据对象元数据中的备份类型识别为文件备份，仍按单个文件恢复
+	for _, tt := range []struct {
+		name       string
+		repository bool
+	}{
+		{"app.db", false},
+		{"report.tar.gz", false},
+		{"report.tar.gz", true},
+	} {
+		name := tt.name
+		t.Run(fmt.Sprintf("%s/repository=%v", name, tt.repository), func(t *testing.T) {
+			ctx := context.Background()
+			src := filepath.Join(t.TempDir(), name)
+			content := strings.Repeat("data", 1024)
+			if err := os.WriteFile(src, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cfg := StorageConfig{Destinations: []string{"file://" + t.TempDir()}, Repository: tt.repository}
+
+			if err := FileBackup(ctx, FileBackupRequest{FilePaths: []string{src}, CompressMethod: "zstd", Stream: tt.repository, Storage: cfg}); err != nil {
+				t.Fatalf("FileBackup: %v", err)
+			}
+			backups, err := ListBackups(ctx, ListRequest{Type: catalog.TypeFile, Storage: cfg})
+			if err != nil || len(backups) != 1 {
+				t.Fatalf("ListBackups = %+v, %v; want one file backup", backups, err)
+			}
+
+			output := filepath.Join(t.TempDir(), "restored")
+			if err := RestoreFile(ctx, RestoreRequest{Host: testIP, Output: output, Storage: cfg}); err != nil {
+				t.Fatalf("RestoreFile: %v", err)
+			}
+			data, err := os.ReadFile(output)
+			if err != nil || string(data) != content {
+				t.Fatalf("restored %d bytes, err %v; want %d bytes", len(data), err, len(content))
+			}
+
+			// 已存在的文件不覆盖
+			if err := RestoreFile(ctx, RestoreRequest{Host: testIP, Output: output, Storage: cfg}); err == nil {
+				t.Fatal("expected error when output exists")
+			}
+		})
+	}
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	ctx := context.Background()
+	dest := t.TempDir()
+	up, err := newUploader(ctx, StorageConfig{Destinations: []string{"file://" + dest}}, retry.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+	s := up.storages[0]
+
+	data := []byte("backup data")
+	hasher := checksum.New()
+	hasher.Write(data)
+	digest := hasher.Digest()
+
+	key := "20250101/20250101-020000_a.tar"
+	if err := s.Put(ctx, key, bytes.NewReader(data), storage.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := up.verify(ctx, s, key, digest); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	sidecar, err := s.Get(ctx, key+checksum.SidecarSuffix)
+	if err != nil {
+		t.Fatalf("sidecar: %v", err)
+	}
+	sidecar.Close()
+
+	// 远端对象与本地摘要不一致时校验失败，并删除远端对象
+	if err := s.Put(ctx, key, strings.NewReader("corrupted"), storage.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := up.verify(ctx, s, key, digest); err == nil {
+		t.Fatal("expected verify error")
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("corrupted object should be deleted: %v", err)
+	}
+}
+
+func TestSFTPUser(t *testing.T) {
+	if got, err := sftpUser("backup"); err != nil || got != "backup" {
+		t.Fatalf("sftpUser(backup) = %q, %v", got, err)
+	}
+	// 未指定用户名时不依赖 USER 环境变量
+	t.Setenv("USER", "")
+	if got, err := sftpUser(""); err != nil || got == "" {
+		t.Fatalf("sftpUser() = %q, %v; want current user", got, err)
+	}
+}
+
+func TestPruneOnlyRunSources(t *testing.T) {
+	for _, repository := range []bool{false, true} {
+		t.Run(fmt.Sprintf("repository=%v", repository), func(t *testing.T) {
+			testPruneOnlyRunSources(t, repository)
+		})
+	}
+}
+
+func testPruneOnlyRunSources(t *testing.T, repository bool) {
+	ctx := context.Background()
+	cfg := StorageConfig{Destinations: []string{"file://" + t.TempDir()}, Repository: repository}
+	other := filepath.Join(t.TempDir(), "data")
+	src := filepath.Join(t.TempDir(), "etc")
+	writeTree(t, other, map[string]string{"a.txt": "a"})
+	writeTree(t, src, map[string]string{"hosts": "127.0.0.1 localhost\n"})
+
+	// 其他任务备份的目录有两个备份（备份文件名精确到秒）
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		if err := DirBackup(ctx, DirBackupRequest{DirPaths: []string{other}, Storage: cfg}); err != nil {
+			t.Fatalf("DirBackup: %v", err)
+		}
+	}
+	err := DirBackup(ctx, DirBackupRequest{DirPaths: []string{src}, Retention: retention.Policy{KeepLast: 1}, Storage: cfg})
+	if err != nil {
+		t.Fatalf("DirBackup: %v", err)
+	}
+
+	backups, err := ListBackups(ctx, ListRequest{Type: catalog.TypeDir, Storage: cfg})
+	if err != nil {
+		t.Fatalf("ListBackups: %v", err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("ListBackups = %+v, want backups of other source kept", backups)
+	}
+}
+
+func TestRepositoryKeyPerEncryption(t *testing.T) {
+	ctx := context.Background()
+	dest := t.TempDir()
+	first := filepath.Join(t.TempDir(), "first")
+	second := filepath.Join(t.TempDir(), "second")
+	// 两个目录内容相同，加密配置不同时数据块 ID 不同，数据块不共用
+	content := strings.Repeat("a", 4096)
+	writeTree(t, first, map[string]string{"a.txt": content})
+	writeTree(t, second, map[string]string{"a.txt": content})
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	passphrase := crypt.Config{Passphrase: "secret"}
+	recipients := crypt.Config{Recipients: []string{identity.Recipient().String()}, IdentityFile: identityFile}
+	backup := func(dir string, encryption crypt.Config) error {
+		cfg := StorageConfig{Destinations: []string{"file://" + dest}, Repository: true, Encryption: encryption}
+		return DirBackup(ctx, DirBackupRequest{DirPaths: []string{dir}, Storage: cfg})
+	}
+
+	if err := backup(first, passphrase); err != nil {
+		t.Fatalf("DirBackup: %v", err)
+	}
+	check := CheckRequest{ReadData: true, Encryption: passphrase, Storage: StorageConfig{Destinations: []string{"file://" + dest}}}
+	if err := Check(ctx, check); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	// 更换口令后无法解密已有的仓库密钥，不能使用其他口令的仓库密钥写入
+	if err := backup(first, crypt.Config{Passphrase: "other"}); err == nil {
+		t.Fatal("expected error for changed passphrase")
+	}
+	// 其他加密配置生成自己的仓库密钥
+	if err := backup(second, recipients); err != nil {
+		t.Fatalf("DirBackup: %v", err)
+	}
+	// 已有仓库密钥并且没有本地缓存时，公钥加密需要私钥解密仓库密钥才能计算数据块 ID
+	if err := backup(second, crypt.Config{Recipients: recipients.Recipients}); err == nil {
+		t.Fatal("expected error without identity file")
+	}
+	if err := backup(second, recipients); err != nil {
+		t.Fatalf("DirBackup: %v", err)
+	}
+
+	cfg := StorageConfig{Destinations: []string{"file://" + dest}, Repository: true}
+	for _, tt := range []struct {
+		name       string
+		encryption crypt.Config
+	}{
+		{"first", passphrase},
+		{"second", crypt.Config{IdentityFile: identityFile}},
+	} {
+		target := t.TempDir()
+		err := RestoreDir(ctx, RestoreRequest{Host: testIP, Name: tt.name, TargetDir: target, Encryption: tt.encryption, Storage: cfg})
+		if err != nil {
+			t.Fatalf("RestoreDir %s: %v", tt.name, err)
+		}
+		if got := readTree(t, target); got["a.txt"] != content {
+			t.Errorf("RestoreDir %s: restored %v", tt.name, got)
+		}
+	}
+}
+
+// chunkFiles 返回仓库中数据块文件的路径
+func chunkFiles(t *testing.T, dest string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(filepath.Join(dest, "repo", "chunks"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && !strings.HasSuffix(path, ".meta") {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestRepositoryPruneAndCheck(t *testing.T) {
+	ctx := context.Background()
+	dest := t.TempDir()
+	cfg := StorageConfig{Destinations: []string{"file://" + dest}, Repository: true}
+	src := filepath.Join(t.TempDir(), "data")
+	for i, content := range []string{"first version", "second version"} {
+		if i > 0 {
+			time.Sleep(time.Second) // 备份文件名精确到秒
+		}
+		writeTree(t, src, map[string]string{"a.txt": content})
+		if err := DirBackup(ctx, DirBackupRequest{DirPaths: []string{src}, Storage: cfg}); err != nil {
+			t.Fatalf("DirBackup: %v", err)
+		}
+	}
+	if n := len(chunkFiles(t, dest)); n != 2 {
+		t.Fatalf("%d chunks after two backups, want 2", n)
+	}
+
+	// 删除旧快照后回收只被该快照引用的数据块
+	if err := Prune(ctx, PruneRequest{Policy: retention.Policy{KeepLast: 1}, Storage: cfg}); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	chunks := chunkFiles(t, dest)
+	if len(chunks) != 1 {
+		t.Fatalf("%d chunks after prune, want 1", len(chunks))
+	}
+	check := CheckRequest{ReadData: true, Storage: cfg}
+	if err := Check(ctx, check); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	target := t.TempDir()
+	if err := RestoreDir(ctx, RestoreRequest{Host: testIP, TargetDir: target, Storage: cfg}); err != nil {
+		t.Fatalf("RestoreDir: %v", err)
+	}
+	if got := readTree(t, target); got["a.txt"] != "second version" {
+		t.Fatalf("RestoreDir: restored %v", got)
+	}
+
+	// 快照引用的数据块缺失时检查失败
+	if err := os.Remove(chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(ctx, check); err == nil {
+		t.Fatal("expected Check error for missing chunk")
+	}
+}
+
+func TestRepositoryRecipientOnly(t *testing.T) {
+	ctx := context.Background()
+	dest := t.TempDir()
+	src := filepath.Join(t.TempDir(), "data")
+	writeTree(t, src, map[string]string{"a.txt": strings.Repeat("data", 1024)})
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	recipients := []string{identity.Recipient().String()}
+	backup := func(encryption crypt.Config, keyCacheDir string) error {
+		cfg := StorageConfig{Destinations: []string{"file://" + dest}, Repository: true, Encryption: encryption, KeyCacheDir: keyCacheDir}
+		return DirBackup(ctx, DirBackupRequest{DirPaths: []string{src}, Storage: cfg})
+	}
+
+	// 备份主机只有公钥：第一次备份生成仓库密钥并缓存，之后使用缓存的数据块 ID 密钥
+	hostA := t.TempDir()
+	for i := 0; i < 2; i++ {
+		if err := backup(crypt.Config{Recipients: recipients}, hostA); err != nil {
+			t.Fatalf("DirBackup %d: %v", i, err)
+		}
+	}
+	// 相同的内容使用相同的数据块 ID，不重复上传
+	if n := len(chunkFiles(t, dest)); n != 1 {
+		t.Fatalf("%d chunks after two identical backups, want 1", n)
+	}
+
+	// 没有缓存的主机需要配置一次私钥
+	hostB := t.TempDir()
+	if err := backup(crypt.Config{Recipients: recipients}, hostB); err == nil {
+		t.Fatal("expected error without identity file or cached key")
+	}
+	// 私钥文件无法读取时返回错误，不忽略
+	if err := backup(crypt.Config{Recipients: recipients, IdentityFile: filepath.Join(t.TempDir(), "missing.txt")}, hostA); err == nil {
+		t.Fatal("expected error for unreadable identity file")
+	}
+	if err := backup(crypt.Config{Recipients: recipients, IdentityFile: identityFile}, hostB); err != nil {
+		t.Fatalf("DirBackup with identity: %v", err)
+	}
+	if err := backup(crypt.Config{Recipients: recipients}, hostB); err != nil {
+		t.Fatalf("DirBackup with cached key: %v", err)
+	}
+	if n := len(chunkFiles(t, dest)); n != 1 {
+		t.Fatalf("%d chunks after backups from two hosts, want 1", n)
+	}
+
+	cfg := StorageConfig{Destinations: []string{"file://" + dest}, Repository: true}
+	decrypt := crypt.Config{IdentityFile: identityFile}
+	if err := Check(ctx, CheckRequest{ReadData: true, Encryption: decrypt, Storage: cfg}); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	target := t.TempDir()
+	if err := RestoreDir(ctx, RestoreRequest{Host: testIP, TargetDir: target, Encryption: decrypt, Storage: cfg}); err != nil {
+		t.Fatalf("RestoreDir: %v", err)
+	}
+	if got := readTree(t, target); got["a.txt"] != strings.Repeat("data", 1024) {
+		t.Fatalf("RestoreDir: restored %v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/logger"
+)
+
+// DecryptRequest 解密请求
+type DecryptRequest struct {
+	InputFile  string       // 加密的备份文件路径（.age）
+	OutputFile string       // 解密后的文件路径（为空时去掉 .age 后缀）
+	Encryption crypt.Config // 解密使用的私钥文件或口令
+}
+
+// Decrypt 解密下载的备份文件
+func Decrypt(req DecryptRequest) error {
+	if req.InputFile == "" {
+		return fmt.Errorf("没有指定要解密的文件")
+	}
+
+	outputFile := req.OutputFile
+	if outputFile == "" {
+		if !strings.HasSuffix(req.InputFile, crypt.Suffix) {
+			return fmt.Errorf("输入文件没有 %s 后缀，请通过 --output 指定输出文件", crypt.Suffix)
+		}
+		outputFile = strings.TrimSuffix(req.InputFile, crypt.Suffix)
+	}
+	if _, err := os.Stat(outputFile); err == nil {
+		return fmt.Errorf("输出文件已存在: %s", outputFile)
+	}
+
+	identities, err := req.Encryption.Identities()
+	if err != nil {
+		return err
+	}
+
+	logger.Info("正在解密备份文件", "input", req.InputFile, "output", outputFile)
+	if err := crypt.DecryptFile(req.InputFile, outputFile, identities); err != nil {
+		return err
+	}
+	logger.Info("解密完成", "output", outputFile)
+	return nil
+}
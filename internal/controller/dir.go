@@ -1,147 +1,226 @@
 package controller
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"backup-to-oss/internal/catalog"
 	"backup-to-oss/internal/compress"
-	"backup-to-oss/internal/ipfetcher"
+	"backup-to-oss/internal/incremental"
 	"backup-to-oss/internal/logger"
-	"backup-to-oss/internal/oss"
+	"backup-to-oss/internal/retention"
+	"backup-to-oss/internal/retry"
 )
 
 // DirBackupRequest 目录备份请求
 type DirBackupRequest struct {
-	DirPaths        []string // 支持多个目录
-	ExcludePatterns []string // 排除模式列表
-	CompressMethod  string   // 压缩方式 (zstd/gzip/none)
-	KeepBackupFiles bool     // 是否保留备份文件
-	OSSEndpoint     string
-	OSSAccessKey    string
-	OSSSecretKey    string
-	OSSBucket       string
-	OSSObjectPrefix string
+	DirPaths        []string         // 支持多个目录
+	ExcludePatterns []string         // 排除模式列表
+	CompressMethod  string           // 压缩方式 (zstd/gzip/none)
+	KeepBackupFiles bool             // 是否保留备份文件
+	Stream          bool             // 是否流式上传（不生成本地临时文件）
+	FailFast        bool             // 一个目录备份失败时立即停止，不再备份剩余的目录
+	Incremental     bool             // 是否增量备份（只打包上一次备份之后新增或修改的文件）
+	FullInterval    time.Duration    // 增量备份时完整备份的间隔（为 0 时使用 incremental.DefaultFullInterval）
+	IndexCacheDir   string           // 文件索引的本地缓存目录（为空时每次从备份目标下载上一次备份的文件索引）
+	Retry           retry.Policy     // 上传重试策略
+	Retention       retention.Policy // 备份成功后按该规则清理旧备份（未设置规则时不清理）
+	Storage         StorageConfig    // 备份目标配置
 }
 
 // DirBackup 执行目录备份
-func DirBackup(req DirBackupRequest) error {
+// 部分目录备份失败时返回 *PartialError，所有目录都失败时返回所有失败原因
+func DirBackup(ctx context.Context, req DirBackupRequest) error {
 	if len(req.DirPaths) == 0 {
 		return fmt.Errorf("没有指定要备份的目录")
 	}
 
+	// 去重仓库只支持流式上传，归档不压缩（压缩后的数据无法按内容去重，数据块单独压缩），也不需要增量备份
+	if req.Storage.Repository {
+		if req.Incremental {
+			logger.Warn("使用去重仓库时忽略增量备份选项")
+		}
+		req.Stream, req.CompressMethod, req.Incremental = true, "none", false
+	}
+	if req.Stream && req.KeepBackupFiles {
+		logger.Warn("流式模式不生成本地备份文件，忽略保留备份文件选项")
+	}
+
+	// 创建存储后端
+	up, err := newUploader(ctx, req.Storage, req.Retry)
+	if err != nil {
+		return err
+	}
+	defer up.Close()
+	up.setType(catalog.TypeDir)
+	up.setSource(map[string]any{"paths": req.DirPaths, "exclude": req.ExcludePatterns, "compress": req.CompressMethod, "stream": req.Stream})
+
+	// 继续上传之前运行中未完成的上传
+	up.ResumePending(ctx)
+
 	// 获取公网IP（所有目录共享同一个IP）
-	publicIP, err := ipfetcher.NewPublicIPFetcher().Fetch()
+	publicIP, err := fetchPublicIP()
 	if err != nil {
 		logger.Warn("获取公网IP失败，将不使用IP前缀", "error", err)
 		publicIP = ""
 	}
+	up.setHost(publicIP)
 
-	// 获取当前日期（用于目录结构）
+	// 获取当前时间（用于目录结构和文件名，所有目录使用同一个时间）
 	now := time.Now()
-	dateStr := now.Format("20060102")
-	timeStr := now.Format("20060102-150405")
+	if req.Incremental && req.FullInterval == 0 {
+		req.FullInterval = incremental.DefaultFullInterval
+	}
 
-	// 遍历每个目录进行备份
+	// 遍历每个目录进行备份，一个目录失败不影响其他目录（启用 FailFast 时立即停止）
+	var errs []error
+	succeeded := 0
 	for i, dirPath := range req.DirPaths {
-		// 验证目录路径
-		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-			logger.Warn("目录不存在，跳过", "path", dirPath)
+		logger.Info("开始备份目录", "index", i+1, "total", len(req.DirPaths), "path", dirPath)
+		item := up.startItem(dirPath)
+		if err := backupDir(ctx, up, req, publicIP, now, dirPath); err != nil {
+			logger.Error("目录备份失败", "path", dirPath, "error", err)
+			item.Fail(err)
+			errs = append(errs, fmt.Errorf("%s: %v", dirPath, err))
+			if req.FailFast {
+				logger.Warn("已启用 fail-fast，停止备份剩余的目录", "remaining", len(req.DirPaths)-i-1)
+				break
+			}
 			continue
 		}
+		succeeded++
+	}
 
-		logger.Info("开始备份目录", "index", i+1, "total", len(req.DirPaths), "path", dirPath)
+	logger.Info("所有备份任务完成", "total", len(req.DirPaths), "succeeded", succeeded, "failed", len(errs))
 
-		// 生成文件名：将目录路径转换为文件名格式（斜杠替换为下划线）
-		dirPathForName := strings.Trim(dirPath, "/")
-		dirPathForName = strings.ReplaceAll(dirPathForName, "/", "_")
-		if dirPathForName == "" {
-			dirPathForName = "backup"
+	// 有目录备份失败时不清理旧备份，避免在新备份缺失的情况下删除旧备份
+	if err := joinFailures(succeeded, len(req.DirPaths), errs); err != nil {
+		if req.Retention.Enabled() {
+			logger.Warn("部分目录备份失败，跳过清理旧备份", "failed", len(errs))
 		}
+		return err
+	}
+	up.Prune(ctx, publicIP, catalog.TypeDir, req.Retention)
+	return nil
+}
 
-		// 根据压缩方式确定文件扩展名
-		var ext string
-		switch req.CompressMethod {
-		case "gzip":
-			ext = ".tgz"
-		case "zstd", "":
-			ext = ".tar.zst"
-		case "none":
-			ext = ".tar"
-		default:
-			ext = ".tar.zst" // 默认使用 zstd
-		}
-		archiveName := fmt.Sprintf("%s_%s%s", timeStr, dirPathForName, ext)
-		archivePath := filepath.Join(os.TempDir(), archiveName)
+// backupDir 打包压缩一个目录并上传到所有备份目标
+// 增量备份时根据上一次备份的文件索引只打包新增或修改的文件，并上传本次备份的文件索引
+func backupDir(ctx context.Context, up *uploader, req DirBackupRequest, publicIP string, now time.Time, dirPath string) error {
+	// 验证目录路径
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		return fmt.Errorf("目录不存在")
+	}
+
+	// 生成文件名：将目录路径转换为文件名格式（斜杠替换为下划线）
+	dirPathForName := strings.Trim(dirPath, "/")
+	dirPathForName = strings.ReplaceAll(dirPathForName, "/", "_")
+	if dirPathForName == "" {
+		dirPathForName = "backup"
+	}
+
+	compressMethod := req.CompressMethod
+	if compressMethod == "" {
+		compressMethod = "zstd" // 默认使用 zstd
+	}
+
+	// 根据压缩方式确定文件扩展名
+	var ext string
+	switch req.CompressMethod {
+	case "gzip":
+		ext = ".tgz"
+	case "zstd", "":
+		ext = ".tar.zst"
+	case "none":
+		ext = ".tar"
+	default:
+		ext = ".tar.zst" // 默认使用 zstd
+	}
 
-		// 压缩目录
-		compressMethod := req.CompressMethod
-		if compressMethod == "" {
-			compressMethod = "zstd" // 默认使用 zstd
+	// 增量备份：上一次备份的文件索引不可用或距离完整备份已超过间隔时执行完整备份
+	var scanner *incremental.Scanner
+	if req.Incremental {
+		prev := up.previousIndex(ctx, publicIP, dirPathForName, req.IndexCacheDir)
+		if prev != nil && now.Sub(prev.BaseTime) >= req.FullInterval {
+			logger.Info("距离上一次完整备份已超过完整备份间隔，执行完整备份", "base", prev.Base, "full_interval", req.FullInterval)
+			prev = nil
 		}
-		logger.Info("正在压缩目录", "method", compressMethod)
-		if len(req.ExcludePatterns) > 0 {
-			logger.Info("排除模式", "patterns", req.ExcludePatterns)
+		scanner = incremental.NewScanner(dirPath, prev, now)
+		up.files = scanner
+		if scanner.Incremental() {
+			logger.Info("执行增量备份", "parent", prev.Key, "base", prev.Base)
+			ext = catalog.IncrementalSuffix + ext
 		}
-		if err := compress.CompressDir(dirPath, archivePath, req.ExcludePatterns, compressMethod); err != nil {
-			logger.Error("压缩目录失败", "error", err)
-			continue
+	}
+	write := func(w io.Writer) error {
+		if scanner == nil {
+			return compress.WriteDir(w, dirPath, req.ExcludePatterns, compressMethod)
 		}
+		return compress.WriteDirFiltered(w, dirPath, req.ExcludePatterns, compressMethod, scanner.Filter)
+	}
 
-		// 获取文件大小
-		fileInfo, err := os.Stat(archivePath)
-		if err == nil {
-			sizeMB := float64(fileInfo.Size()) / (1024 * 1024)
-			logger.Info("压缩完成", "path", archivePath, "size_bytes", fileInfo.Size(), "size_mb", fmt.Sprintf("%.2f", sizeMB))
-		}
+	dir := objectDir(publicIP, now)
+	archiveName := fmt.Sprintf("%s_%s%s", now.Format("20060102-150405"), dirPathForName, ext)
+	archivePath := filepath.Join(os.TempDir(), archiveName)
 
-		// 构建OSS对象前缀：{prefix}/{ip}/{date}/
-		objectPrefix := req.OSSObjectPrefix
-		if publicIP != "" {
-			if objectPrefix != "" {
-				if !strings.HasSuffix(objectPrefix, "/") {
-					objectPrefix += "/"
-				}
-			}
-			objectPrefix += publicIP + "/" + dateStr + "/"
-		} else {
-			if objectPrefix != "" {
-				if !strings.HasSuffix(objectPrefix, "/") {
-					objectPrefix += "/"
-				}
-			}
-			objectPrefix += dateStr + "/"
-		}
+	// 压缩目录
+	logger.Info("正在压缩目录", "method", compressMethod)
+	if len(req.ExcludePatterns) > 0 {
+		logger.Info("排除模式", "patterns", req.ExcludePatterns)
+	}
 
-		// 上传到OSS
-		logger.Info("正在上传到OSS")
-		ossConfig := oss.Config{
-			Endpoint:     req.OSSEndpoint,
-			AccessKey:    req.OSSAccessKey,
-			SecretKey:    req.OSSSecretKey,
-			Bucket:       req.OSSBucket,
-			ObjectPrefix: objectPrefix,
+	// 流式模式：打包压缩的数据直接上传，不生成本地临时文件
+	if req.Stream {
+		if err := up.Stream(ctx, dir+archiveName, write); err != nil {
+			return fmt.Errorf("流式备份目录失败: %v", err)
 		}
+		saveIndexCache(up, req, publicIP, dirPathForName)
+		logger.Info("目录备份完成", "path", dirPath)
+		return nil
+	}
 
-		if err := oss.UploadFile(archivePath, ossConfig); err != nil {
-			logger.Error("上传到OSS失败", "error", err)
-			if !req.KeepBackupFiles {
-				os.Remove(archivePath) // 清理临时文件
-			}
-			continue
-		}
+	archivePath, digest, err := up.writeArchive(archivePath, write)
+	if err != nil {
+		return fmt.Errorf("压缩目录失败: %v", err)
+	}
 
-		// 上传成功后根据配置决定是否删除临时文件
-		if req.KeepBackupFiles {
-			logger.Info("目录备份完成，备份文件已保留", "path", dirPath, "backup_file", archivePath)
-		} else {
-			os.Remove(archivePath)
-			logger.Info("目录备份完成", "path", dirPath)
-		}
+	// 获取文件大小
+	fileInfo, err := os.Stat(archivePath)
+	if err == nil {
+		sizeMB := float64(fileInfo.Size()) / (1024 * 1024)
+		logger.Info("压缩完成", "path", archivePath, "size_bytes", fileInfo.Size(), "size_mb", fmt.Sprintf("%.2f", sizeMB))
 	}
 
-	logger.Info("所有备份任务完成", "total", len(req.DirPaths))
+	// 上传到所有备份目标：{prefix}/{ip}/{date}/
+	if err := up.Upload(ctx, archivePath, dir, digest, req.KeepBackupFiles); err != nil {
+		if !req.KeepBackupFiles && !up.Retained(archivePath) {
+			os.Remove(archivePath) // 清理临时文件（等待续传或校验失败的文件需要保留）
+		}
+		return fmt.Errorf("上传备份文件失败: %v", err)
+	}
+	saveIndexCache(up, req, publicIP, dirPathForName)
+
+	// 上传成功后根据配置决定是否删除临时文件
+	if req.KeepBackupFiles {
+		logger.Info("目录备份完成，备份文件已保留", "path", dirPath, "backup_file", archivePath)
+	} else {
+		os.Remove(archivePath)
+		logger.Info("目录备份完成", "path", dirPath)
+	}
 	return nil
 }
+
+// saveIndexCache 所有备份目标上传成功后缓存本次备份的文件索引，下一次增量备份时不需要下载，失败只记录日志
+func saveIndexCache(up *uploader, req DirBackupRequest, publicIP, source string) {
+	if up.files == nil {
+		return
+	}
+	if err := incremental.SaveCache(req.IndexCacheDir, up.storages[0].String(), publicIP, source, up.files.Finish()); err != nil {
+		logger.Warn("缓存文件索引失败", "error", err)
+	}
+}
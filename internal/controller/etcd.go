@@ -3,74 +3,93 @@ package controller
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
+	"backup-to-oss/internal/catalog"
 	"backup-to-oss/internal/compress"
 	"backup-to-oss/internal/etcd"
-	"backup-to-oss/internal/ipfetcher"
 	"backup-to-oss/internal/logger"
-	"backup-to-oss/internal/oss"
+	"backup-to-oss/internal/report"
+	"backup-to-oss/internal/retention"
+	"backup-to-oss/internal/retry"
 )
 
 // EtcdBackupRequest etcd snapshot 备份请求
 type EtcdBackupRequest struct {
-	Endpoints       []string      // etcd 服务器地址列表
-	CACert          string        // CA 证书文件路径（可选）
-	Cert            string        // 客户端证书文件路径（可选）
-	Key             string        // 客户端私钥文件路径（可选）
-	User            string        // etcd 用户名（可选）
-	Password        string        // etcd 密码（可选）
-	DialTimeout     time.Duration // 连接超时时间
-	CommandTimeout  time.Duration // 命令超时时间（0 表示无超时）
-	CompressMethod  string        // 压缩方式 (zstd/gzip/none)
-	KeepBackupFiles bool          // 是否保留备份文件
-	OSSEndpoint     string
-	OSSAccessKey    string
-	OSSSecretKey    string
-	OSSBucket       string
-	OSSObjectPrefix string
+	Endpoints       []string         // etcd 服务器地址列表
+	CACert          string           // CA 证书文件路径（可选）
+	Cert            string           // 客户端证书文件路径（可选）
+	Key             string           // 客户端私钥文件路径（可选）
+	User            string           // etcd 用户名（可选）
+	Password        string           // etcd 密码（可选）
+	DialTimeout     time.Duration    // 连接超时时间
+	CommandTimeout  time.Duration    // 命令超时时间（0 表示无超时）
+	CompressMethod  string           // 压缩方式 (zstd/gzip/none)
+	KeepBackupFiles bool             // 是否保留备份文件
+	Stream          bool             // 是否流式上传（不生成本地临时文件）
+	Retry           retry.Policy     // 上传和获取 snapshot 的重试策略
+	Retention       retention.Policy // 备份成功后按该规则清理旧备份（未设置规则时不清理）
+	Storage         StorageConfig    // 备份目标配置
 }
 
 // EtcdBackup 执行 etcd snapshot 备份
-func EtcdBackup(req EtcdBackupRequest) error {
-	// 创建上下文（如果设置了命令超时）
-	ctx := context.Background()
-	var cancel context.CancelFunc
-	if req.CommandTimeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), req.CommandTimeout)
-		defer cancel()
-	} else {
-		ctx, cancel = context.WithCancel(context.Background())
+func EtcdBackup(ctx context.Context, req EtcdBackupRequest) error {
+	// 去重仓库只支持流式上传，snapshot 不压缩（数据块单独压缩）
+	if req.Storage.Repository {
+		req.Stream, req.CompressMethod = true, "none"
+	}
+	if req.Stream && req.KeepBackupFiles {
+		logger.Warn("流式模式不生成本地备份文件，忽略保留备份文件选项")
+	}
+
+	// 创建存储后端
+	up, err := newUploader(ctx, req.Storage, req.Retry)
+	if err != nil {
+		return err
+	}
+	defer up.Close()
+	up.setType(catalog.TypeEtcd)
+	up.setSource(map[string]any{"endpoints": req.Endpoints, "compress": req.CompressMethod, "stream": req.Stream})
+	item := up.startItem(req.Endpoints...)
+
+	// 继续上传之前运行中未完成的上传
+	up.ResumePending(ctx)
+
+	// 流式模式：snapshot 数据压缩后直接上传，不生成本地临时文件
+	if req.Stream {
+		snapshotCtx, cancel := snapshotContext(ctx, req.CommandTimeout)
 		defer cancel()
+		return etcdBackupStream(ctx, snapshotCtx, up, item, req)
 	}
 
 	// 创建临时文件用于保存 snapshot
 	now := time.Now()
 	timeStr := now.Format("20060102-150405")
-	snapshotName := fmt.Sprintf("etcd-snapshot-%s.db", timeStr)
+	snapshotName := fmt.Sprintf("%s%s.db", catalog.EtcdPrefix, timeStr)
 	tempSnapshotPath := filepath.Join(os.TempDir(), snapshotName)
 
 	logger.Info("正在获取 etcd snapshot", "path", tempSnapshotPath)
 
 	// 调用 etcd 包执行备份
-	backupCfg := etcd.BackupConfig{
-		Endpoints:      req.Endpoints,
-		CACert:         req.CACert,
-		Cert:           req.Cert,
-		Key:            req.Key,
-		User:           req.User,
-		Password:       req.Password,
-		DialTimeout:    req.DialTimeout,
-		CommandTimeout: req.CommandTimeout,
-	}
+	backupCfg := etcdBackupConfig(req)
 
-	result, err := etcd.Backup(ctx, backupCfg, tempSnapshotPath)
+	// 连接失败、leader 切换等临时故障时重试，每次尝试单独计算命令超时
+	var result *etcd.BackupResult
+	err = retry.Do(ctx, req.Retry, "获取 etcd snapshot", func(ctx context.Context) error {
+		snapshotCtx, cancel := snapshotContext(ctx, req.CommandTimeout)
+		defer cancel()
+		r, err := etcd.Backup(snapshotCtx, backupCfg, tempSnapshotPath)
+		result = r
+		return err
+	})
 	if err != nil {
+		item.Fail(err)
 		return err
 	}
+	item.SetMetadata(etcdMetadata(result))
 	// tempSnapshotPath 在压缩完成后会被删除
 
 	// 压缩 snapshot 文件
@@ -93,13 +112,21 @@ func EtcdBackup(req EtcdBackupRequest) error {
 	}
 	compressedPath := tempSnapshotPath + ext
 	logger.Info("正在压缩 snapshot 文件", "method", compressMethod)
-	if err := compress.CompressFile(tempSnapshotPath, compressedPath, compressMethod); err != nil {
+	compressedPath, digest, err := up.writeArchive(compressedPath, func(w io.Writer) error {
+		return compress.WriteFile(w, tempSnapshotPath, compressMethod)
+	})
+	if err != nil {
 		return fmt.Errorf("压缩 snapshot 文件失败: %v", err)
 	}
 	// 压缩完成后删除未压缩的临时文件
 	if !req.KeepBackupFiles {
 		os.Remove(tempSnapshotPath)
-		defer os.Remove(compressedPath)
+		defer func() {
+			// 等待续传或校验失败的文件需要保留
+			if !up.Retained(compressedPath) {
+				os.Remove(compressedPath)
+			}
+		}()
 	} else {
 		os.Remove(tempSnapshotPath) // 只保留压缩后的文件
 		logger.Info("备份文件已保留", "path", compressedPath)
@@ -120,50 +147,115 @@ func EtcdBackup(req EtcdBackupRequest) error {
 		}
 	}
 
-	// 获取公网IP（用于 OSS 路径）
-	publicIP, err := ipfetcher.NewPublicIPFetcher().Fetch()
+	// 获取公网IP（用于对象路径）
+	publicIP, err := fetchPublicIP()
 	if err != nil {
 		logger.Warn("获取公网IP失败，将不使用IP前缀", "error", err)
 		publicIP = ""
 	}
+	up.setHost(publicIP)
 
-	// 构建OSS对象前缀：{prefix}/{ip}/{date}/
-	dateStr := now.Format("20060102")
-	objectPrefix := req.OSSObjectPrefix
-	if publicIP != "" {
-		if objectPrefix != "" {
-			if !strings.HasSuffix(objectPrefix, "/") {
-				objectPrefix += "/"
-			}
-		}
-		objectPrefix += publicIP + "/" + dateStr + "/"
+	// 上传到所有备份目标：{prefix}/{ip}/{date}/
+	logger.Info("正在上传 snapshot")
+	if err := up.Upload(ctx, compressedPath, objectDir(publicIP, now), digest, req.KeepBackupFiles); err != nil {
+		return fmt.Errorf("上传 snapshot 失败: %v", err)
+	}
+
+	if req.KeepBackupFiles {
+		logger.Info("etcd snapshot 备份完成，备份文件已保留", "version", result.Version, "backup_file", compressedPath)
 	} else {
-		if objectPrefix != "" {
-			if !strings.HasSuffix(objectPrefix, "/") {
-				objectPrefix += "/"
-			}
-		}
-		objectPrefix += dateStr + "/"
+		logger.Info("etcd snapshot 备份完成", "version", result.Version)
 	}
+	up.Prune(ctx, publicIP, catalog.TypeEtcd, req.Retention)
+	return nil
+}
 
-	// 上传到OSS
-	logger.Info("正在上传 snapshot 到 OSS")
-	ossConfig := oss.Config{
-		Endpoint:     req.OSSEndpoint,
-		AccessKey:    req.OSSAccessKey,
-		SecretKey:    req.OSSSecretKey,
-		Bucket:       req.OSSBucket,
-		ObjectPrefix: objectPrefix,
+// etcdBackupStream 流式执行 etcd snapshot 备份
+// snapshot 的传输速度受上传速度限制，命令超时时间包含上传时间；数据流无法重放，失败时不重试
+func etcdBackupStream(ctx, snapshotCtx context.Context, up *uploader, item *report.Item, req EtcdBackupRequest) error {
+	compressMethod := req.CompressMethod
+	if compressMethod == "" {
+		compressMethod = "zstd" // 默认使用 zstd
 	}
 
-	if err := oss.UploadFile(compressedPath, ossConfig); err != nil {
-		return fmt.Errorf("上传到 OSS 失败: %v", err)
+	// 根据压缩方式确定文件扩展名
+	var ext string
+	switch compressMethod {
+	case "gzip":
+		ext = ".gz"
+	case "zstd":
+		ext = ".zst"
+	case "none":
+		ext = ""
+	default:
+		ext = ".zst" // 默认使用 zstd
 	}
+	now := time.Now()
+	snapshotName := fmt.Sprintf("%s%s.db%s", catalog.EtcdPrefix, now.Format("20060102-150405"), ext)
 
-	if req.KeepBackupFiles {
-		logger.Info("etcd snapshot 备份完成，备份文件已保留", "version", result.Version, "backup_file", compressedPath)
-	} else {
-		logger.Info("etcd snapshot 备份完成", "version", result.Version)
+	// 获取公网IP（用于对象路径）
+	publicIP, err := fetchPublicIP()
+	if err != nil {
+		logger.Warn("获取公网IP失败，将不使用IP前缀", "error", err)
+		publicIP = ""
 	}
+	up.setHost(publicIP)
+
+	// 上传到所有备份目标：{prefix}/{ip}/{date}/
+	logger.Info("正在流式获取并上传 etcd snapshot", "method", compressMethod)
+	var result *etcd.BackupResult
+	err = up.Stream(ctx, objectDir(publicIP, now)+snapshotName, func(w io.Writer) error {
+		compressWriter, err := compress.NewWriter(w, compressMethod)
+		if err != nil {
+			return err
+		}
+		result, err = etcd.BackupStream(snapshotCtx, etcdBackupConfig(req), compressWriter)
+		if err != nil {
+			compressWriter.Close()
+			return err
+		}
+		item.SetMetadata(etcdMetadata(result))
+		return compressWriter.Close()
+	})
+	if err != nil {
+		return fmt.Errorf("上传 snapshot 失败: %v", err)
+	}
+
+	logger.Info("etcd snapshot 备份完成", "version", result.Version)
+	up.Prune(ctx, publicIP, catalog.TypeEtcd, req.Retention)
 	return nil
 }
+
+// snapshotContext 创建获取 snapshot 的上下文（如果设置了命令超时）
+func snapshotContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// etcdMetadata 返回运行报告中记录的 snapshot 信息（流式备份时只有 etcd 版本）
+func etcdMetadata(result *etcd.BackupResult) map[string]any {
+	metadata := map[string]any{"version": result.Version}
+	if result.Status != nil {
+		metadata["revision"] = result.Status.Revision
+		metadata["hash"] = result.Status.Hash
+		metadata["total_key"] = result.Status.TotalKey
+		metadata["total_size"] = result.Status.TotalSize
+	}
+	return metadata
+}
+
+// etcdBackupConfig 根据备份请求构建 etcd 备份配置
+func etcdBackupConfig(req EtcdBackupRequest) etcd.BackupConfig {
+	return etcd.BackupConfig{
+		Endpoints:      req.Endpoints,
+		CACert:         req.CACert,
+		Cert:           req.Cert,
+		Key:            req.Key,
+		User:           req.User,
+		Password:       req.Password,
+		DialTimeout:    req.DialTimeout,
+		CommandTimeout: req.CommandTimeout,
+	}
+}
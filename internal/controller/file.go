@@ -1,75 +1,100 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"backup-to-oss/internal/catalog"
 	"backup-to-oss/internal/compress"
-	"backup-to-oss/internal/ipfetcher"
 	"backup-to-oss/internal/logger"
-	"backup-to-oss/internal/oss"
+	"backup-to-oss/internal/retention"
+	"backup-to-oss/internal/retry"
 )
 
 // FileBackupRequest 文件备份请求
 type FileBackupRequest struct {
-	FilePaths       []string // 支持多个文件
-	CompressMethod  string   // 压缩方式 (zstd/gzip/none)
-	KeepBackupFiles bool     // 是否保留备份文件
-	OSSEndpoint     string
-	OSSAccessKey    string
-	OSSSecretKey    string
-	OSSBucket       string
-	OSSObjectPrefix string
+	FilePaths       []string         // 支持多个文件
+	CompressMethod  string           // 压缩方式 (zstd/gzip/none)
+	KeepBackupFiles bool             // 是否保留备份文件
+	Stream          bool             // 是否流式上传（不生成本地临时文件）
+	FailFast        bool             // 有文件不存在或无法访问时立即停止，不备份其他文件
+	Retry           retry.Policy     // 上传重试策略
+	Retention       retention.Policy // 备份成功后按该规则清理旧备份（未设置规则时不清理）
+	Storage         StorageConfig    // 备份目标配置
 }
 
 // FileBackup 执行文件备份
-func FileBackup(req FileBackupRequest) error {
+// 不存在或无法访问的文件计为失败：其他文件备份成功时返回 *PartialError，启用 FailFast 时不执行备份
+func FileBackup(ctx context.Context, req FileBackupRequest) error {
 	if len(req.FilePaths) == 0 {
 		return fmt.Errorf("没有指定要备份的文件")
 	}
 
+	// 去重仓库只支持流式上传，数据块单独压缩
+	if req.Storage.Repository {
+		req.Stream, req.CompressMethod = true, "none"
+	}
+	if req.Stream && req.KeepBackupFiles {
+		logger.Warn("流式模式不生成本地备份文件，忽略保留备份文件选项")
+	}
+
+	// 创建存储后端
+	up, err := newUploader(ctx, req.Storage, req.Retry)
+	if err != nil {
+		return err
+	}
+	defer up.Close()
+	up.setType(catalog.TypeFile)
+	up.setSource(map[string]any{"paths": req.FilePaths, "compress": req.CompressMethod, "stream": req.Stream})
+
+	// 继续上传之前运行中未完成的上传
+	up.ResumePending(ctx)
+
 	// 获取公网IP（所有文件共享同一个IP）
-	publicIP, err := ipfetcher.NewPublicIPFetcher().Fetch()
+	publicIP, err := fetchPublicIP()
 	if err != nil {
 		logger.Warn("获取公网IP失败，将不使用IP前缀", "error", err)
 		publicIP = ""
 	}
+	up.setHost(publicIP)
 
 	// 获取当前日期（用于目录结构）
 	now := time.Now()
-	dateStr := now.Format("20060102")
+	dir := objectDir(publicIP, now)
 	timeStr := now.Format("20060102-150405")
 
 	// 验证所有文件是否存在
 	var validFiles []string
+	var errs []error
 	for _, filePath := range req.FilePaths {
-		info, err := os.Stat(filePath)
-		if os.IsNotExist(err) {
-			logger.Warn("文件不存在，跳过", "path", filePath)
-			continue
-		}
-		if err != nil {
-			logger.Warn("无法访问文件，跳过", "path", filePath, "error", err)
-			continue
-		}
-		if info.IsDir() {
-			logger.Warn("路径是目录而不是文件，跳过", "path", filePath)
+		if err := checkFile(filePath); err != nil {
+			logger.Warn("文件无法备份，跳过", "path", filePath, "error", err)
+			up.startItem(filePath).Fail(err)
+			errs = append(errs, fmt.Errorf("%s: %v", filePath, err))
 			continue
 		}
 		validFiles = append(validFiles, filePath)
 	}
 
 	if len(validFiles) == 0 {
-		return fmt.Errorf("没有有效的文件可以备份")
+		return fmt.Errorf("没有有效的文件可以备份: %v", errors.Join(errs...))
+	}
+	if len(errs) > 0 && req.FailFast {
+		return fmt.Errorf("已启用 fail-fast，有文件无法备份: %v", errors.Join(errs...))
 	}
 
 	logger.Info("开始备份文件", "count", len(validFiles), "files", validFiles)
+	up.startItem(validFiles...)
 
 	// 根据文件数量决定处理方式
 	var archivePath string
+	var write func(w io.Writer) error // 压缩函数
 	if len(validFiles) == 1 {
 		// 单个文件：直接压缩
 		sourceFile := validFiles[0]
@@ -97,10 +122,7 @@ func FileBackup(req FileBackupRequest) error {
 			compressMethod = "zstd" // 默认使用 zstd
 		}
 		logger.Info("正在压缩文件", "method", compressMethod, "file", sourceFile)
-		if err := compress.CompressFile(sourceFile, archivePath, compressMethod); err != nil {
-			logger.Error("压缩文件失败", "error", err)
-			return err
-		}
+		write = func(w io.Writer) error { return compress.WriteFile(w, sourceFile, compressMethod) }
 	} else {
 		// 多个文件：打包成 tar 归档
 		// 生成文件名：使用第一个文件的基础名称
@@ -129,10 +151,23 @@ func FileBackup(req FileBackupRequest) error {
 			compressMethod = "zstd" // 默认使用 zstd
 		}
 		logger.Info("正在压缩多个文件", "method", compressMethod, "count", len(validFiles))
-		if err := compress.CompressFiles(validFiles, archivePath, compressMethod); err != nil {
-			logger.Error("压缩文件失败", "error", err)
+		write = func(w io.Writer) error { return compress.WriteFiles(w, validFiles, compressMethod) }
+	}
+
+	// 流式模式：压缩的数据直接上传，不生成本地临时文件
+	if req.Stream {
+		if err := up.Stream(ctx, dir+filepath.Base(archivePath), write); err != nil {
+			logger.Error("流式备份文件失败", "error", err)
 			return err
 		}
+		logger.Info("文件备份完成", "count", len(validFiles))
+		return up.pruneFiles(ctx, publicIP, req, len(validFiles), errs)
+	}
+
+	archivePath, digest, err := up.writeArchive(archivePath, write)
+	if err != nil {
+		logger.Error("压缩文件失败", "error", err)
+		return err
 	}
 
 	// 获取文件大小
@@ -142,38 +177,11 @@ func FileBackup(req FileBackupRequest) error {
 		logger.Info("压缩完成", "path", archivePath, "size_bytes", fileInfo.Size(), "size_mb", fmt.Sprintf("%.2f", sizeMB))
 	}
 
-	// 构建OSS对象前缀：{prefix}/{ip}/{date}/
-	objectPrefix := req.OSSObjectPrefix
-	if publicIP != "" {
-		if objectPrefix != "" {
-			if !strings.HasSuffix(objectPrefix, "/") {
-				objectPrefix += "/"
-			}
-		}
-		objectPrefix += publicIP + "/" + dateStr + "/"
-	} else {
-		if objectPrefix != "" {
-			if !strings.HasSuffix(objectPrefix, "/") {
-				objectPrefix += "/"
-			}
-		}
-		objectPrefix += dateStr + "/"
-	}
-
-	// 上传到OSS
-	logger.Info("正在上传到OSS")
-	ossConfig := oss.Config{
-		Endpoint:     req.OSSEndpoint,
-		AccessKey:    req.OSSAccessKey,
-		SecretKey:    req.OSSSecretKey,
-		Bucket:       req.OSSBucket,
-		ObjectPrefix: objectPrefix,
-	}
-
-	if err := oss.UploadFile(archivePath, ossConfig); err != nil {
-		logger.Error("上传到OSS失败", "error", err)
-		if !req.KeepBackupFiles {
-			os.Remove(archivePath) // 清理临时文件
+	// 上传到所有备份目标：{prefix}/{ip}/{date}/
+	if err := up.Upload(ctx, archivePath, dir, digest, req.KeepBackupFiles); err != nil {
+		logger.Error("上传备份文件失败", "error", err)
+		if !req.KeepBackupFiles && !up.Retained(archivePath) {
+			os.Remove(archivePath) // 清理临时文件（等待续传或校验失败的文件需要保留）
 		}
 		return err
 	}
@@ -186,5 +194,32 @@ func FileBackup(req FileBackupRequest) error {
 		logger.Info("文件备份完成", "count", len(validFiles))
 	}
 
+	return up.pruneFiles(ctx, publicIP, req, len(validFiles), errs)
+}
+
+// checkFile 检查文件是否存在且不是目录
+func checkFile(filePath string) error {
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("文件不存在")
+	}
+	if err != nil {
+		return fmt.Errorf("无法访问文件: %v", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("路径是目录而不是文件")
+	}
+	return nil
+}
+
+// pruneFiles 文件备份上传成功后清理旧备份，有文件无法备份时不清理并返回 *PartialError
+func (u *uploader) pruneFiles(ctx context.Context, publicIP string, req FileBackupRequest, succeeded int, errs []error) error {
+	if err := joinFailures(succeeded, len(req.FilePaths), errs); err != nil {
+		if req.Retention.Enabled() {
+			logger.Warn("部分文件无法备份，跳过清理旧备份", "failed", len(errs))
+		}
+		return err
+	}
+	u.Prune(ctx, publicIP, catalog.TypeFile, req.Retention)
 	return nil
 }
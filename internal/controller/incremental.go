@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/incremental"
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/retry"
+	"backup-to-oss/internal/storage"
+)
+
+// finishIndex 归档写入完成后生成文件索引，并在运行报告中记录备份级别和文件数量
+func (u *uploader) finishIndex() {
+	if u.files == nil {
+		return
+	}
+	u.item.SetMetadata(u.files.Finish().Metadata())
+}
+
+// putIndex 将当前目录备份的文件索引上传到备份文件旁边，备份文件加密时索引同样加密，失败只记录日志
+// 缺少文件索引时下一次增量备份执行完整备份，恢复该增量备份时无法删除备份之间被删除的文件
+func (u *uploader) putIndex(ctx context.Context, s storage.Storage, key string) {
+	if u.files == nil {
+		return
+	}
+	var buf bytes.Buffer
+	if _, err := u.encrypt(&buf, func(w io.Writer) error {
+		return incremental.Encode(w, u.files.Finish())
+	}); err != nil {
+		logger.Warn("生成文件索引失败", "error", err)
+		return
+	}
+	indexKey := incremental.IndexKey(key)
+	err := retry.Do(ctx, u.retry, "上传文件索引", func(ctx context.Context) error {
+		return s.Put(ctx, indexKey, bytes.NewReader(buf.Bytes()), storage.PutOptions{Tags: u.tags})
+	})
+	if err != nil {
+		logger.Warn("上传文件索引失败", "dest", s.String(), "key", indexKey, "error", err)
+	}
+}
+
+// previousIndex 返回第一个备份目标中该目录最新的备份的文件索引（优先使用本地缓存），没有之前的备份或无法读取文件索引时返回 nil
+func (u *uploader) previousIndex(ctx context.Context, host, source, cacheDir string) *incremental.Index {
+	s := u.storages[0]
+	backups, err := catalog.List(ctx, s, catalog.HostPrefix(host, ""))
+	if err != nil {
+		logger.Warn("列出之前的备份失败，执行完整备份", "dest", s.String(), "error", err)
+		return nil
+	}
+	var latest *catalog.Backup
+	for i, b := range backups {
+		if b.Host == host && b.Type == catalog.TypeDir && b.Source == source {
+			latest = &backups[i]
+		}
+	}
+	if latest == nil {
+		logger.Info("没有找到之前的备份，执行完整备份", "source", source)
+		return nil
+	}
+
+	cached, err := incremental.LoadCache(cacheDir, s.String(), host, source)
+	if err != nil {
+		logger.Warn("读取文件索引缓存失败", "error", err)
+	}
+	if cached != nil && cached.Key == latest.Key {
+		logger.Debug("使用缓存的文件索引", "key", latest.Key)
+		return cached
+	}
+
+	idx, err := loadIndex(ctx, s, latest.Key, u.encryption)
+	if err != nil {
+		logger.Warn("读取上一次备份的文件索引失败，执行完整备份", "key", latest.Key, "error", err)
+		return nil
+	}
+	return idx
+}
+
+// loadIndex 下载并解析备份文件对应的文件索引，备份文件加密时使用 encryption 中的私钥或口令解密
+func loadIndex(ctx context.Context, s storage.Storage, backupKey string, encryption crypt.Config) (*incremental.Index, error) {
+	indexKey := incremental.IndexKey(backupKey)
+	body, err := s.Get(ctx, indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("下载文件索引 %s 失败: %v", indexKey, err)
+	}
+	defer body.Close()
+
+	var r io.Reader = body
+	if strings.HasSuffix(indexKey, crypt.Suffix) {
+		identities, err := encryption.Identities()
+		if err != nil {
+			return nil, fmt.Errorf("文件索引已加密: %v", err)
+		}
+		if r, err = crypt.Decrypt(body, identities); err != nil {
+			return nil, err
+		}
+	}
+	return incremental.Decode(r)
+}
+
+// backupChain 返回恢复备份需要的备份文件（按备份时间从旧到新）：
+// 完整备份只需要自身，增量备份需要之前最近的完整备份和之间的所有增量备份
+func backupChain(ctx context.Context, s storage.Storage, key string) ([]catalog.Backup, error) {
+	target, ok := catalog.Parse(storage.ObjectInfo{Key: key})
+	if !ok || !target.Incremental {
+		return []catalog.Backup{target}, nil
+	}
+
+	backups, err := catalog.List(ctx, s, catalog.HostPrefix(target.Host, ""))
+	if err != nil {
+		return nil, err
+	}
+	var chain []catalog.Backup
+	for _, b := range backups {
+		if b.Host != target.Host || b.Type != target.Type || b.Source != target.Source {
+			continue
+		}
+		if !b.Incremental {
+			chain = chain[:0] // 从最近的完整备份开始
+		}
+		chain = append(chain, b)
+		if b.Key == key {
+			if chain[0].Incremental {
+				return nil, fmt.Errorf("没有找到增量备份 %s 依赖的完整备份", key)
+			}
+			return chain, nil
+		}
+	}
+	return nil, fmt.Errorf("备份文件不存在: %s", key)
+}
@@ -0,0 +1,55 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/storage"
+)
+
+// ListRequest 列出备份请求
+type ListRequest struct {
+	Host    string        // 只列出该主机的备份（为空时列出所有主机）
+	Since   time.Time     // 只列出该时间之后的备份（零值表示不限制）
+	Type    string        // 只列出该类型的备份 (dir/file/etcd/consul/unknown)，为空时列出所有类型
+	Storage StorageConfig // 备份目标配置（多个目标时使用第一个）
+}
+
+// ListBackups 列出备份目标中的备份文件，按备份时间排序
+func ListBackups(ctx context.Context, req ListRequest) ([]catalog.Backup, error) {
+	if types := append(slices.Clone(catalog.Types), catalog.TypeUnknown); req.Type != "" && !slices.Contains(types, req.Type) {
+		return nil, fmt.Errorf("不支持的备份类型: %s，支持的类型: %v", req.Type, types)
+	}
+
+	s, err := openPrimaryStorage(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStorages([]storage.Storage{s})
+
+	prefix := catalog.HostPrefix(req.Host, "")
+	logger.Debug("正在列出备份文件", "dest", s.String(), "prefix", prefix)
+	backups, err := listBackups(ctx, s, prefix, req.Storage.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []catalog.Backup
+	for _, b := range backups {
+		if req.Host != "" && b.Host != req.Host {
+			continue
+		}
+		if req.Type != "" && b.Type != req.Type {
+			continue
+		}
+		if !req.Since.IsZero() && b.Time.Before(req.Since) {
+			continue
+		}
+		result = append(result, b)
+	}
+	return result, nil
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PartialError 部分备份失败：多个目录或文件中至少有一个备份成功，其余失败
+type PartialError struct {
+	Succeeded int   // 备份成功的数量
+	Failed    int   // 备份失败的数量（启用 fail-fast 时不包括未执行的）
+	Total     int   // 总数
+	Err       error // 所有失败的原因
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("部分备份失败（成功 %d 个，失败 %d 个，共 %d 个）: %v", e.Succeeded, e.Failed, e.Total, e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// IsPartial 判断错误是否为部分备份失败
+func IsPartial(err error) bool {
+	var partial *PartialError
+	return errors.As(err, &partial)
+}
+
+// joinFailures 汇总多个备份项的失败原因：没有失败时返回 nil，没有成功的备份项时返回所有失败原因，否则返回 *PartialError
+func joinFailures(succeeded, total int, errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	err := errors.Join(errs...)
+	if succeeded == 0 {
+		return err
+	}
+	return &PartialError{Succeeded: succeeded, Failed: len(errs), Total: total, Err: err}
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/checksum"
+	"backup-to-oss/internal/incremental"
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/progress"
+	"backup-to-oss/internal/report"
+	"backup-to-oss/internal/retention"
+	"backup-to-oss/internal/storage"
+)
+
+// PruneRequest 清理旧备份请求
+type PruneRequest struct {
+	Host    string           // 只清理该主机的备份（为空时清理所有主机）
+	Type    string           // 只清理该类型的备份 (dir/file/etcd/consul)，为空时清理所有类型
+	Policy  retention.Policy // 保留规则
+	DryRun  bool             // 只输出清理计划，不删除
+	Storage StorageConfig    // 备份目标配置（清理所有目标）
+}
+
+// Prune 按保留规则清理所有备份目标中的旧备份
+// 备份文件按主机、备份类型和来源分组，每组独立计算保留的备份；无法确定类型的备份（catalog.TypeUnknown）不清理
+func Prune(ctx context.Context, req PruneRequest) error {
+	if !req.Policy.Enabled() {
+		return fmt.Errorf("没有指定保留规则（--keep-last/--keep-daily/--keep-weekly/--keep-monthly/--keep-yearly）")
+	}
+	if err := req.Policy.Validate(); err != nil {
+		return err
+	}
+	if req.Type != "" && !slices.Contains(catalog.Types, req.Type) {
+		return fmt.Errorf("不支持的备份类型: %s，支持的类型: %v", req.Type, catalog.Types)
+	}
+
+	storages, err := openStorages(req.Storage)
+	if err != nil {
+		return err
+	}
+	defer closeStorages(storages)
+
+	var failed []string
+	for _, s := range storages {
+		match := func(b catalog.Backup) bool {
+			return (req.Host == "" || b.Host == req.Host) && (req.Type == "" || b.Type == req.Type) && b.Type != catalog.TypeUnknown
+		}
+		removed, err := pruneStorage(ctx, s, catalog.HostPrefix(req.Host, ""), match, req.Policy, req.DryRun, req.Storage.Repository)
+		if err != nil {
+			logger.Error("清理旧备份失败", "dest", s.String(), "error", err)
+			failed = append(failed, s.String())
+			continue
+		}
+		// 使用去重仓库时回收没有被引用的数据块（包括之前失败的备份上传的数据块）
+		if req.Storage.Repository {
+			if err := gcRepository(ctx, s, removed, req.DryRun); err != nil {
+				logger.Error("回收数据块失败", "dest", s.String(), "error", err)
+				failed = append(failed, s.String())
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("清理旧备份失败的备份目标: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// Prune 备份成功后按保留规则清理该主机、该类型中本次运行备份的来源的旧备份
+// 其他任务备份的来源（如另一个目录备份任务的目录）使用各自的保留规则，不在这里清理
+// 获取公网 IP 失败时 host 为空，只清理没有 IP 前缀的备份；清理失败不影响备份结果，只输出错误日志
+func (u *uploader) Prune(ctx context.Context, host, backupType string, policy retention.Policy) {
+	if !policy.Enabled() {
+		return
+	}
+	u.progress.Set(progress.StagePruning, "", 0)
+	match := func(b catalog.Backup) bool { return b.Host == host && b.Type == backupType && u.sources[b.Source] }
+	for _, s := range u.storages {
+		removed, err := pruneStorage(ctx, s, catalog.HostPrefix(host, ""), match, policy, false, u.repository)
+		if err != nil {
+			logger.Error("清理旧备份失败", "dest", s.String(), "error", err)
+		}
+		if u.repository && len(removed) > 0 {
+			if err := gcRepository(ctx, s, nil, false); err != nil {
+				logger.Error("回收数据块失败", "dest", s.String(), "error", err)
+			}
+		}
+	}
+}
+
+// pruneStorage 按保留规则清理一个备份目标中 prefix 下的旧备份，只处理 match 返回 true 的备份文件
+// 返回已删除（dry-run 时为将删除）的备份；使用去重仓库时只删除快照，数据块由 gcRepository 回收
+func pruneStorage(ctx context.Context, s storage.Storage, prefix string, match func(b catalog.Backup) bool, policy retention.Policy, dryRun, repository bool) ([]string, error) {
+	logger.Info("正在计算需要清理的备份", "dest", s.String(), "prefix", prefix, "policy", policy.String(), "dry_run", dryRun)
+	backups, err := listBackups(ctx, s, prefix, repository)
+	if err != nil {
+		return nil, err
+	}
+	remove := deleteBackup
+	if repository {
+		remove = deleteSnapshot
+	}
+
+	var filtered []catalog.Backup
+	for _, b := range backups {
+		if match(b) {
+			filtered = append(filtered, b)
+		}
+	}
+
+	kept, removed := 0, 0
+	var removedKeys []string
+	var errs []error
+	for _, group := range retention.Apply(filtered, policy) {
+		groupKept, groupRemoved := 0, 0
+		for _, d := range group.Decisions {
+			if d.Keep {
+				groupKept++
+				logger.Debug("保留备份", "key", d.Backup.Key, "reasons", strings.Join(d.Reasons, ","))
+				continue
+			}
+			groupRemoved++
+			if dryRun {
+				logger.Info("将删除备份（dry-run）", "key", d.Backup.Key, "size_bytes", d.Backup.Size)
+				removedKeys = append(removedKeys, d.Backup.Key)
+				continue
+			}
+			if err := remove(ctx, s, d.Backup.Key); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			removedKeys = append(removedKeys, d.Backup.Key)
+			logger.Info("已删除备份", "key", d.Backup.Key, "size_bytes", d.Backup.Size)
+		}
+		logger.Info("备份分组清理结果", "host", group.Host, "type", group.Type, "source", group.Source, "keep", groupKept, "remove", groupRemoved)
+		kept += groupKept
+		removed += groupRemoved
+	}
+
+	if dryRun {
+		logger.Info("清理计划（dry-run，未删除任何文件）", "dest", s.String(), "keep", kept, "remove", removed)
+	} else {
+		logger.Info("清理完成", "dest", s.String(), "keep", kept, "removed", removed-len(errs))
+	}
+	return removedKeys, errors.Join(errs...)
+}
+
+// deleteBackup 删除备份文件及其 .sha256 校验文件、.manifest.json 运行报告和目录备份的文件索引
+func deleteBackup(ctx context.Context, s storage.Storage, key string) error {
+	if err := s.Delete(ctx, key); err != nil {
+		return fmt.Errorf("删除备份文件 %s 失败: %v", key, err)
+	}
+	if err := s.Delete(ctx, key+checksum.SidecarSuffix); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("删除校验文件 %s 失败: %v", key+checksum.SidecarSuffix, err)
+	}
+	if err := s.Delete(ctx, key+report.ManifestSuffix); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("删除运行报告 %s 失败: %v", key+report.ManifestSuffix, err)
+	}
+	indexKey := incremental.IndexKey(key)
+	if err := s.Delete(ctx, indexKey); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("删除文件索引 %s 失败: %v", indexKey, err)
+	}
+	return nil
+}
@@ -0,0 +1,518 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/checksum"
+	"backup-to-oss/internal/compress"
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/progress"
+	"backup-to-oss/internal/repo"
+	"backup-to-oss/internal/retry"
+	"backup-to-oss/internal/storage"
+
+	"filippo.io/age"
+)
+
+// repoTarget 一个备份目标中的仓库和本次备份上传数据块的结果
+type repoTarget struct {
+	s    storage.Storage
+	repo *repo.Repository
+	lock *repo.Lock
+	key  *repo.Key    // 加密数据块和计算数据块 ID 使用的仓库密钥（不加密时为 nil）
+	refs []repo.Chunk // 本次快照引用的数据块（加密时数据块 ID 与仓库密钥有关，每个目标分别记录）
+
+	mu        sync.Mutex
+	chunks    map[string]int64 // 仓库中已有和本次已开始上传的数据块
+	newChunks int              // 本次上传的数据块数量
+	newBytes  int64            // 本次上传的数据块大小（压缩和加密后）
+	err       error            // 第一个错误，出错后不再上传该目标的数据块，也不写入快照
+}
+
+// claim 判断数据块是否需要上传，需要上传时标记为已上传（同一数据块只上传一次）
+func (t *repoTarget) claim(chunkKey string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return false
+	}
+	if _, ok := t.chunks[chunkKey]; ok {
+		return false
+	}
+	t.chunks[chunkKey] = 0
+	return true
+}
+
+// done 记录一个数据块的上传结果
+func (t *repoTarget) done(size int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		if t.err == nil {
+			t.err = err
+		}
+		return
+	}
+	t.newChunks++
+	t.newBytes += int64(size)
+}
+
+// failed 判断该目标是否已失败
+func (t *repoTarget) failed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err != nil
+}
+
+// openRepositories 打开（不存在时创建）每个备份目标中的仓库并加共享锁，失败的目标记录在 err 中
+func (u *uploader) openRepositories(ctx context.Context) []*repoTarget {
+	targets := make([]*repoTarget, len(u.storages))
+	for i, s := range u.storages {
+		t := &repoTarget{s: s}
+		t.err = func() error {
+			r, err := repo.OpenOrInit(ctx, s)
+			if err != nil {
+				return err
+			}
+			t.repo = r
+			if t.lock, err = r.Lock(ctx, false); err != nil {
+				return err
+			}
+			if t.chunks, err = r.Chunks(ctx); err != nil {
+				return err
+			}
+			if u.encryptor != nil {
+				// 已有的仓库密钥使用口令或私钥（DECRYPT_IDENTITY_FILE）解密；
+				// 公钥加密时缓存仓库公钥和数据块 ID 密钥，备份主机上没有私钥时使用本地缓存
+				var identities []age.Identity
+				if u.encryption.Passphrase != "" || u.encryption.IdentityFile != "" {
+					if identities, err = u.encryption.Identities(); err != nil {
+						return err
+					}
+				}
+				cacheDir := ""
+				if u.encryption.Passphrase == "" {
+					cacheDir = u.keyCacheDir
+				}
+				t.key, err = r.WriteKey(ctx, u.encryptor, identities, cacheDir)
+			}
+			return err
+		}()
+		targets[i] = t
+	}
+	return targets
+}
+
+// storeSnapshot 将 write 写入的数据按内容分块后保存到所有备份目标的仓库中，并写入快照索引
+// 数据块以内容的 SHA-256（加密时为使用仓库密钥计算的 HMAC-SHA256）命名，仓库中已有的数据块不再上传；
+// 启用加密时数据块使用仓库公钥加密，快照名称添加 .age 后缀
+// 分块参数使用第一个可用仓库的配置（仓库配置不同时其他仓库的去重效果变差，但不影响恢复）
+func (u *uploader) storeSnapshot(ctx context.Context, key string, write func(w io.Writer) error) error {
+	encrypted := u.encryptor != nil
+	if encrypted {
+		key += crypt.Suffix
+	}
+	u.item.SetKey(key, "zstd", encrypted)
+	u.recordSource(key)
+
+	targets := u.openRepositories(ctx)
+	defer func() {
+		for _, t := range targets {
+			if t.lock != nil {
+				if err := t.lock.Unlock(ctx); err != nil {
+					logger.Warn("删除仓库锁失败", "dest", t.s.String(), "error", err)
+				}
+			}
+		}
+	}()
+	var cfg *repo.Config
+	for _, t := range targets {
+		if t.err == nil {
+			c := t.repo.Config()
+			cfg = &c
+			break
+		}
+	}
+	if cfg == nil {
+		return u.finishSnapshot(ctx, key, targets, nil)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parallel := max(u.parallel, 1)
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	allFailed := false
+	chunker := repo.NewChunker(*cfg, func(chunk []byte) error {
+		alive := false
+		for _, t := range targets {
+			if t.failed() {
+				continue
+			}
+			alive = true
+			id := repo.ChunkID(chunk, t.key)
+			t.refs = append(t.refs, repo.Chunk{ID: id, Size: len(chunk)})
+			chunkKey := repo.ChunkKey(id, encrypted)
+			if !t.claim(chunkKey) {
+				continue
+			}
+			data, err := repo.EncodeChunk(chunk, t.key)
+			if err != nil {
+				t.done(0, err)
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				// 数据块不添加对象标签，避免按标签配置的生命周期规则删除仍被其他快照引用的数据块
+				err := retry.Do(ctx, u.retry, "上传数据块", func(ctx context.Context) error {
+					return t.s.Put(ctx, chunkKey, bytes.NewReader(data), storage.PutOptions{})
+				})
+				if err != nil {
+					err = fmt.Errorf("上传数据块 %s 失败: %v", chunkKey, err)
+				}
+				t.done(len(data), err)
+			}()
+		}
+		if !alive {
+			allFailed = true
+			return fmt.Errorf("所有备份目标都上传失败")
+		}
+		return nil
+	})
+
+	u.progress.Set(progress.StageStreaming, key, 0)
+	hasher := checksum.New()
+	sw := &sourceWriter{Writer: io.MultiWriter(chunker, hasher, u.progress)}
+	writeErr := write(sw)
+	if writeErr == nil {
+		writeErr = chunker.Close()
+	}
+	if writeErr != nil {
+		cancel()
+	}
+	wg.Wait()
+
+	// 生成数据失败（而不是所有目标都失败导致写入中止）时返回生成数据的错误
+	if writeErr != nil && !allFailed {
+		err := fmt.Errorf("生成备份数据失败: %v", writeErr)
+		u.item.Fail(err)
+		return err
+	}
+
+	digest := hasher.Digest()
+	u.metrics.AddArchive(digest.Size, sw.size)
+	u.item.SetArchive("", digest, sw.size)
+	hostname, _ := os.Hostname()
+	snap := &repo.Snapshot{
+		Name:      path.Base(key),
+		Hostname:  hostname,
+		Time:      time.Now(),
+		Size:      digest.Size,
+		SHA256:    digest.SHA256,
+		Encrypted: encrypted,
+	}
+	if b, ok := catalog.Parse(storage.ObjectInfo{Key: key}); ok {
+		snap.Host, snap.Time = b.Host, b.Time
+	}
+	return u.finishSnapshot(ctx, key, targets, snap)
+}
+
+// finishSnapshot 向数据块全部上传成功的仓库写入快照索引（snap 中的数据块为各目标的 refs），记录每个目标的结果（snap 为 nil 时所有目标都已失败）
+func (u *uploader) finishSnapshot(ctx context.Context, key string, targets []*repoTarget, snap *repo.Snapshot) error {
+	var errs []error
+	chunks, newChunks, newBytes := 0, 0, int64(0)
+	for _, t := range targets {
+		err := t.err
+		var data []byte
+		if err == nil {
+			ts := *snap
+			ts.Chunks = t.refs
+			if t.key != nil {
+				ts.Key = t.key.ID()
+			}
+			data, err = repo.EncodeSnapshot(&ts)
+		}
+		if err == nil {
+			err = retry.Do(ctx, u.retry, "上传快照", func(ctx context.Context) error {
+				return t.s.Put(ctx, repo.SnapshotKey(key), bytes.NewReader(data), storage.PutOptions{Metadata: u.metadata(repo.SnapshotKey(key), ""), Tags: u.tags})
+			})
+		}
+		u.item.AddDestination(t.s.String(), err)
+		if err != nil {
+			logger.Error("保存备份到仓库失败", "dest", t.s.String(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %v", t.s, err))
+			continue
+		}
+		chunks = len(t.refs)
+		newChunks += t.newChunks
+		newBytes += t.newBytes
+		u.metrics.AddUploaded(t.newBytes + int64(len(data)))
+		logger.Info("备份已保存到仓库", "dest", t.s.String(), "key", key, "chunks", len(t.refs), "new_chunks", t.newChunks, "new_bytes", t.newBytes)
+	}
+	if len(errs) < len(targets) {
+		u.metrics.AddObject(key)
+		// 新数据块为所有成功的备份目标合计
+		u.item.AddMetadata(map[string]any{"chunks": chunks, "new_chunks": newChunks, "new_bytes": newBytes})
+	}
+	err := errors.Join(errs...)
+	u.item.Fail(err)
+	return err
+}
+
+// listBackups 列出备份目标中 prefix 下的备份，使用去重仓库时列出仓库中的快照（仓库不存在时没有备份）
+func listBackups(ctx context.Context, s storage.Storage, prefix string, repository bool) ([]catalog.Backup, error) {
+	if !repository {
+		return catalog.List(ctx, s, prefix)
+	}
+	r, err := repo.Open(ctx, s)
+	if errors.Is(err, repo.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.List(ctx, prefix)
+}
+
+// extractSnapshot 按顺序读取快照引用的数据块，解密、解压后交给 extract 处理
+// 每个数据块读取时校验数据块 ID，全部读取后校验整个数据的大小和 SHA-256
+func extractSnapshot(ctx context.Context, s storage.Storage, key string, encryption crypt.Config, extract func(r io.Reader, isTar bool, name string) error) error {
+	r, err := repo.Open(ctx, s)
+	if err != nil {
+		return err
+	}
+	snap, err := r.LoadSnapshot(ctx, key)
+	if err != nil {
+		return err
+	}
+	var repoKey *repo.Key
+	if snap.Encrypted {
+		identities, err := encryption.Identities()
+		if err != nil {
+			return fmt.Errorf("备份文件已加密: %v", err)
+		}
+		if repoKey, err = r.LoadKey(ctx, snap.Key, identities); err != nil {
+			return err
+		}
+	}
+
+	logger.Info("正在从仓库恢复备份", "dest", s.String(), "key", key, "chunks", len(snap.Chunks), "size_bytes", snap.Size)
+	rd := r.Reader(ctx, snap, repoKey)
+	_, isTar, base := compress.DetectFormat(strings.TrimSuffix(snap.Name, crypt.Suffix))
+	if err := extract(rd, isTar, base); err != nil {
+		return err
+	}
+	// extract 可能没有读到末尾（如 tar 归档末尾的填充），读完剩余的数据以校验整个数据
+	if _, err := io.Copy(io.Discard, rd); err != nil {
+		return err
+	}
+	return nil
+}
+
+// deleteSnapshot 删除仓库中的快照索引（数据块由 gcRepository 回收）
+func deleteSnapshot(ctx context.Context, s storage.Storage, key string) error {
+	if err := s.Delete(ctx, repo.SnapshotKey(key)); err != nil {
+		return fmt.Errorf("删除快照 %s 失败: %v", key, err)
+	}
+	return nil
+}
+
+// gcRepository 回收仓库中没有被任何快照引用的数据块，加排他锁避免删除正在备份的快照引用的数据块
+// 仓库正在被其他进程使用时跳过（下次清理时回收）；dry-run 时不加锁，只输出回收计划，removed 为 dry-run 中将删除的快照
+func gcRepository(ctx context.Context, s storage.Storage, removed []string, dryRun bool) error {
+	r, err := repo.Open(ctx, s)
+	if errors.Is(err, repo.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		lock, err := r.Lock(ctx, true)
+		if errors.Is(err, repo.ErrLocked) {
+			logger.Warn("仓库正在使用，跳过回收数据块", "dest", s.String(), "error", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock(ctx)
+	}
+
+	// 任何一个快照无法读取时都不回收，避免删除该快照引用的数据块
+	chunks, total, err := r.UnusedChunks(ctx, func(key string) bool { return dryRun && slices.Contains(removed, key) })
+	if err != nil {
+		return fmt.Errorf("不回收数据块: %v", err)
+	}
+
+	unused := slices.Collect(maps.Keys(chunks))
+	var size int64
+	for _, n := range chunks {
+		size += n
+	}
+	sort.Strings(unused)
+	if dryRun {
+		for _, key := range unused {
+			logger.Debug("将回收数据块（dry-run）", "key", key, "size_bytes", chunks[key])
+		}
+		logger.Info("数据块回收计划（dry-run，未删除任何数据块）", "dest", s.String(), "chunks", total, "remove", len(unused), "size_bytes", size)
+		return nil
+	}
+
+	var errs []error
+	for _, key := range unused {
+		if err := s.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("删除数据块 %s 失败: %v", key, err))
+			continue
+		}
+		logger.Debug("已回收数据块", "key", key, "size_bytes", chunks[key])
+	}
+	logger.Info("数据块回收完成", "dest", s.String(), "chunks", total, "removed", len(unused)-len(errs), "size_bytes", size)
+	return errors.Join(errs...)
+}
+
+// CheckRequest 检查仓库请求
+type CheckRequest struct {
+	ReadData   bool          // 是否下载并校验所有被引用的数据块
+	Encryption crypt.Config  // 解密使用的私钥文件或口令（ReadData 检查加密的数据块时需要）
+	Storage    StorageConfig // 备份目标配置（检查所有目标）
+}
+
+// Check 检查所有备份目标中的仓库：快照是否可以读取、引用的数据块是否存在，ReadData 时下载并校验数据块的内容
+func Check(ctx context.Context, req CheckRequest) error {
+	storages, err := openStorages(req.Storage)
+	if err != nil {
+		return err
+	}
+	defer closeStorages(storages)
+
+	var failed []string
+	for _, s := range storages {
+		if err := checkRepository(ctx, s, req); err != nil {
+			logger.Error("仓库检查失败", "dest", s.String(), "error", err)
+			failed = append(failed, s.String())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("仓库检查失败的备份目标: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// checkRepository 检查一个备份目标中的仓库，发现的问题输出错误日志，有问题时返回错误
+func checkRepository(ctx context.Context, s storage.Storage, req CheckRequest) error {
+	r, err := repo.Open(ctx, s)
+	if err != nil {
+		return err
+	}
+	logger.Info("正在检查仓库", "dest", s.String(), "read_data", req.ReadData)
+
+	// 先列出快照再列出数据块，检查期间新写入的快照引用的数据块一定已经存在
+	keys, err := r.SnapshotKeys(ctx)
+	if err != nil {
+		return err
+	}
+	chunks, err := r.Chunks(ctx)
+	if err != nil {
+		return err
+	}
+
+	var identities []age.Identity
+	repoKeys := make(map[string]*repo.Key) // 已解密的仓库密钥
+	referenced := make(map[string]bool)
+	verified := make(map[string]error)
+	problems := 0
+	for _, key := range keys {
+		snap, err := r.LoadSnapshot(ctx, key)
+		if err != nil {
+			logger.Error("快照无法读取", "key", key, "error", err)
+			problems++
+			continue
+		}
+		broken := 0
+		for i, chunkKey := range snap.ChunkKeys() {
+			referenced[chunkKey] = true
+			if _, ok := chunks[chunkKey]; !ok {
+				logger.Error("快照引用的数据块不存在", "snapshot", key, "chunk", chunkKey)
+				broken++
+				continue
+			}
+			if !req.ReadData {
+				continue
+			}
+			chunkErr, ok := verified[chunkKey]
+			if !ok {
+				var repoKey *repo.Key
+				if snap.Encrypted {
+					if identities == nil {
+						if identities, err = req.Encryption.Identities(); err != nil {
+							return fmt.Errorf("数据块已加密: %v", err)
+						}
+					}
+					if repoKey = repoKeys[snap.Key]; repoKey == nil {
+						if repoKey, err = r.LoadKey(ctx, snap.Key, identities); err != nil {
+							return err
+						}
+						repoKeys[snap.Key] = repoKey
+					}
+				}
+				chunk := snap.Chunks[i]
+				data, err := r.ReadChunk(ctx, chunk.ID, repoKey)
+				if err == nil && len(data) != chunk.Size {
+					err = fmt.Errorf("数据块 %s 大小不匹配: 期望 %d，实际 %d", chunkKey, chunk.Size, len(data))
+				}
+				chunkErr = err
+				verified[chunkKey] = err
+			}
+			if chunkErr != nil {
+				logger.Error("数据块校验失败", "snapshot", key, "error", chunkErr)
+				broken++
+			}
+		}
+		if broken > 0 {
+			problems++
+			continue
+		}
+		logger.Debug("快照检查通过", "key", key, "chunks", len(snap.Chunks))
+	}
+
+	unused, unusedSize := 0, int64(0)
+	for key, n := range chunks {
+		if !referenced[key] {
+			unused++
+			unusedSize += n
+		}
+	}
+	if unused > 0 {
+		logger.Info("仓库中有未被引用的数据块（执行 prune 时回收）", "dest", s.String(), "chunks", unused, "size_bytes", unusedSize)
+	}
+	logger.Info("仓库检查完成", "dest", s.String(), "snapshots", len(keys), "chunks", len(chunks), "verified", len(verified), "problems", problems)
+	if problems > 0 {
+		return fmt.Errorf("%d 个快照有问题", problems)
+	}
+	return nil
+}
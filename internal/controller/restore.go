@@ -0,0 +1,508 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/checksum"
+	"backup-to-oss/internal/compress"
+	"backup-to-oss/internal/consul"
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/etcd"
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/storage"
+
+	"github.com/mattn/go-isatty"
+	"github.com/rboyer/safeio"
+)
+
+// RestoreRequest 恢复请求
+type RestoreRequest struct {
+	Key        string        // 备份文件的对象键（相对于备份目标根路径），指定后忽略 Host/Date/Name
+	Host       string        // 备份主机的公网 IP（为空时使用本机公网 IP）
+	Date       string        // 备份日期，如 20250101（为空时查找最新的备份）
+	Name       string        // 备份文件名包含的关键字，如目录路径 etc_nginx（可选）
+	TargetDir  string        // 解压目录
+	Output     string        // 单个文件备份的输出路径（为空时输出到解压目录）
+	Strip      int           // 去掉归档路径中前 N 级目录
+	Includes   []string      // 只恢复匹配的路径（glob 模式）
+	Overwrite  bool          // 是否覆盖已存在的文件
+	Encryption crypt.Config  // 解密使用的私钥文件或口令（备份文件加密时需要）
+	Storage    StorageConfig // 备份目标配置（从第一个目标下载）
+}
+
+// RestoreDir 恢复目录备份：下载备份文件并解压到目标目录
+// 增量备份依次恢复之前最近的完整备份和之后的每个增量备份，解压每个增量备份前删除该备份中已删除的文件
+func RestoreDir(ctx context.Context, req RestoreRequest) error {
+	s, key, err := locateBackup(ctx, req, catalog.TypeDir)
+	if err != nil {
+		return err
+	}
+	defer closeStorages([]storage.Storage{s})
+
+	chain, err := backupChain(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if len(chain) > 1 {
+		logger.Info("恢复增量备份需要依次恢复完整备份和增量备份", "base", chain[0].Key, "count", len(chain))
+	}
+	for i, b := range chain {
+		stepReq := req
+		if i > 0 {
+			// 后面的增量备份覆盖之前恢复的文件
+			stepReq.Overwrite = true
+		}
+		if b.Incremental {
+			if err := removeDeleted(ctx, s, b.Key, stepReq); err != nil {
+				return err
+			}
+		}
+		err := extractBackup(ctx, s, b.Key, req.Encryption, req.Storage.Repository, func(r io.Reader, isTar bool, name string) error {
+			if !isTar {
+				return fmt.Errorf("备份文件不是 tar 归档，请使用 restore file 恢复: %s", name)
+			}
+			return extractArchive(r, stepReq)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDeleted 删除增量备份的文件索引中记录的已删除的文件，没有文件索引时只记录日志（之前恢复的文件会保留）
+func removeDeleted(ctx context.Context, s storage.Storage, key string, req RestoreRequest) error {
+	idx, err := loadIndex(ctx, s, key, req.Encryption)
+	if err != nil {
+		logger.Warn("读取增量备份的文件索引失败，不删除备份之间被删除的文件", "key", key, "error", err)
+		return nil
+	}
+	count, err := compress.RemovePaths(req.TargetDir, idx.Deleted, compress.ExtractOptions{Strip: req.Strip, Includes: req.Includes})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		logger.Info("已删除增量备份中已删除的文件", "key", key, "count", count)
+	}
+	return nil
+}
+
+// RestoreFile 恢复文件备份：单个文件解压到输出路径，多个文件的 tar 归档解压到目标目录
+func RestoreFile(ctx context.Context, req RestoreRequest) error {
+	return restore(ctx, req, catalog.TypeFile, func(r io.Reader, isTar bool, name string) error {
+		// 只有多个文件的备份（以 _files 结尾）是 tar 归档，单个文件的备份文件名可能以 .tar 结尾（如 report.tar.gz 备份为 report.tar.zst）
+		if isTar && strings.HasSuffix(name, "_files") {
+			return extractArchive(r, req)
+		}
+
+		output := req.Output
+		if output == "" {
+			// 备份文件名为 {时间}_{文件名（不含扩展名）}，原始扩展名需要通过 --output 指定
+			output = filepath.Join(req.TargetDir, timePrefix.ReplaceAllString(name, ""))
+		}
+		if _, err := os.Stat(output); err == nil && !req.Overwrite {
+			return fmt.Errorf("文件已存在: %s（使用 --overwrite 覆盖）", output)
+		}
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return fmt.Errorf("创建目录失败: %v", err)
+		}
+		if _, err := safeio.WriteToFile(r, output, 0600); err != nil {
+			return fmt.Errorf("写入文件失败: %v", err)
+		}
+		logger.Info("文件恢复完成", "output", output)
+		return nil
+	})
+}
+
+// EtcdRestoreRequest etcd snapshot 恢复请求
+type EtcdRestoreRequest struct {
+	RestoreRequest                  // 备份文件的查找、下载和解密配置（忽略解压相关的配置）
+	MemberName               string // etcd 成员名称
+	DataDir                  string // 恢复的数据目录（为空时为 {MemberName}.etcd）
+	InitialCluster           string // 初始集群配置
+	InitialClusterToken      string // 初始集群 token
+	InitialAdvertisePeerURLs string // 成员的 peer 地址
+	SkipHashCheck            bool   // 是否跳过 snapshot 的 sha256 校验
+}
+
+// RestoreEtcd 恢复 etcd snapshot 备份：下载并解压 snapshot，校验后恢复到新的数据目录
+func RestoreEtcd(ctx context.Context, req EtcdRestoreRequest) error {
+	return restore(ctx, req.RestoreRequest, catalog.TypeEtcd, func(r io.Reader, isTar bool, name string) error {
+		if isTar {
+			return fmt.Errorf("备份文件不是 etcd snapshot: %s", name)
+		}
+
+		snapshotPath, err := writeTempFile(r, "etcd-restore-*.db")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(snapshotPath)
+
+		return etcd.Restore(etcd.RestoreConfig{
+			SnapshotPath:             snapshotPath,
+			Name:                     req.MemberName,
+			DataDir:                  req.DataDir,
+			InitialCluster:           req.InitialCluster,
+			InitialClusterToken:      req.InitialClusterToken,
+			InitialAdvertisePeerURLs: req.InitialAdvertisePeerURLs,
+			SkipHashCheck:            req.SkipHashCheck,
+		})
+	})
+}
+
+// ConsulRestoreRequest Consul snapshot 恢复请求
+type ConsulRestoreRequest struct {
+	RestoreRequest        // 备份文件的查找、下载和解密配置（忽略解压相关的配置）
+	ConsulAddress  string // Consul 地址，如 http://localhost:8500
+	ConsulToken    string // Consul ACL Token（需要 operator:write 权限）
+	CACert         string // CA 证书文件路径（可选）
+	Cert           string // 客户端证书文件路径（可选）
+	Key            string // 客户端私钥文件路径（可选）
+	TLSServerName  string // 校验服务端证书时使用的主机名（可选）
+	TLSSkipVerify  bool   // 是否跳过服务端证书校验
+	Yes            bool   // 是否跳过确认
+}
+
+// RestoreConsul 恢复 Consul snapshot 备份：下载并解压 snapshot，检查后通过 Consul API 恢复到集群
+func RestoreConsul(ctx context.Context, req ConsulRestoreRequest) error {
+	restorer, err := consul.NewRestorer(consul.RestoreConfig{
+		Address:       req.ConsulAddress,
+		Token:         req.ConsulToken,
+		CACert:        req.CACert,
+		Cert:          req.Cert,
+		Key:           req.Key,
+		TLSServerName: req.TLSServerName,
+		TLSSkipVerify: req.TLSSkipVerify,
+	})
+	if err != nil {
+		return err
+	}
+
+	return restore(ctx, req.RestoreRequest, catalog.TypeConsul, func(r io.Reader, isTar bool, name string) error {
+		if isTar {
+			return fmt.Errorf("备份文件不是 Consul snapshot: %s", name)
+		}
+
+		snapshotPath, err := writeTempFile(r, "consul-restore-*.snap")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(snapshotPath)
+
+		// 检查 snapshot 完整性
+		logger.Info("正在检查 snapshot")
+		info, err := consul.InspectSnapshot(snapshotPath)
+		if err != nil {
+			return fmt.Errorf("snapshot 检查失败，文件可能已损坏: %v", err)
+		}
+		logger.Info("Snapshot 检查成功", "id", info.ID, "index", info.Index, "term", info.Term, "version", info.Version)
+
+		cluster, err := restorer.ClusterIndex()
+		if err != nil {
+			return err
+		}
+		logger.Info("Consul 集群当前索引", "index", cluster.Index, "term", cluster.Term)
+		if info.Index < cluster.Index {
+			logger.Warn("snapshot 的索引小于集群当前索引，恢复后集群状态将回退到 snapshot 的时间点",
+				"snapshot_index", info.Index, "cluster_index", cluster.Index)
+		}
+
+		if !req.Yes {
+			prompt := fmt.Sprintf("将 snapshot（index %d, term %d）恢复到 %s（当前 index %d, term %d），集群的全部数据将被覆盖，是否继续？",
+				info.Index, info.Term, req.ConsulAddress, cluster.Index, cluster.Term)
+			ok, err := confirm(prompt)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("已取消恢复")
+			}
+		}
+
+		return restorer.Restore(snapshotPath)
+	})
+}
+
+// writeTempFile 将解压后的数据写入临时文件，返回文件路径
+// 未压缩的备份文件名与下载的文件相同，因此使用单独的临时文件
+func writeTempFile(r io.Reader, pattern string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("创建临时文件失败: %v", err)
+	}
+
+	logger.Info("正在解压 snapshot", "path", f.Name())
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("写入 snapshot 文件失败: %v", err)
+	}
+	return f.Name(), nil
+}
+
+// confirm 在终端中提示确认，非交互式运行时返回错误（需要使用 --yes 跳过确认）
+func confirm(prompt string) (bool, error) {
+	if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return false, fmt.Errorf("非交互式运行时需要指定 --yes 确认恢复")
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("读取输入失败: %v", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// timePrefix 备份文件名中的时间前缀
+var timePrefix = regexp.MustCompile(`^\d{8}-\d{6}_`)
+
+// extractArchive 将 tar 归档解压到目标目录
+func extractArchive(r io.Reader, req RestoreRequest) error {
+	logger.Info("正在解压备份文件", "target", req.TargetDir, "strip", req.Strip, "includes", req.Includes)
+	count, err := compress.ExtractTar(r, req.TargetDir, compress.ExtractOptions{
+		Strip:     req.Strip,
+		Includes:  req.Includes,
+		Overwrite: req.Overwrite,
+	})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		logger.Warn("没有解压任何文件，请检查 --strip 和 --include 参数")
+	}
+	logger.Info("解压完成", "target", req.TargetDir, "count", count)
+	return nil
+}
+
+// restore 查找并下载备份文件，解密、解压后交给 extract 处理
+// extract 的参数为解压后的数据、是否为 tar 归档以及去掉扩展名后的备份文件名
+func restore(ctx context.Context, req RestoreRequest, kind string, extract func(r io.Reader, isTar bool, name string) error) error {
+	s, key, err := locateBackup(ctx, req, kind)
+	if err != nil {
+		return err
+	}
+	defer closeStorages([]storage.Storage{s})
+	return extractBackup(ctx, s, key, req.Encryption, req.Storage.Repository, extract)
+}
+
+// locateBackup 打开备份目标，返回 --key 指定的或按主机、日期和名称查找到的最新的备份文件，调用方负责关闭返回的存储后端
+func locateBackup(ctx context.Context, req RestoreRequest, kind string) (storage.Storage, string, error) {
+	s, err := openPrimaryStorage(req.Storage)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := req.Key
+	if key == "" {
+		key, err = findBackup(ctx, s, req, kind)
+		if err != nil {
+			closeStorages([]storage.Storage{s})
+			return nil, "", err
+		}
+	}
+	return s, key, nil
+}
+
+// extractBackup 下载备份文件，解密、解压后交给 extract 处理（使用去重仓库时从仓库读取快照）
+func extractBackup(ctx context.Context, s storage.Storage, key string, encryption crypt.Config, repository bool, extract func(r io.Reader, isTar bool, name string) error) error {
+	if repository {
+		return extractSnapshot(ctx, s, key, encryption, extract)
+	}
+	localPath, err := downloadBackup(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(localPath)
+
+	r, err := openBackup(localPath, encryption)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	name := strings.TrimSuffix(path.Base(key), crypt.Suffix)
+	_, isTar, base := compress.DetectFormat(name)
+	return extract(r, isTar, base)
+}
+
+// findBackup 按主机、日期和名称查找最新的备份文件
+// 对象路径为 {ip}/{date}/{name}，未指定日期时在所有日期中查找
+func findBackup(ctx context.Context, s storage.Storage, req RestoreRequest, kind string) (string, error) {
+	host := req.Host
+	if host == "" {
+		publicIP, err := fetchPublicIP()
+		if err != nil {
+			logger.Warn("获取公网IP失败，将不使用IP前缀", "error", err)
+			publicIP = ""
+		}
+		host = publicIP
+	}
+	prefix := catalog.HostPrefix(host, strings.ReplaceAll(req.Date, "-", ""))
+
+	logger.Info("正在查找备份文件", "dest", s.String(), "prefix", prefix, "name", req.Name, "type", kind)
+	backups, err := listBackups(ctx, s, prefix, req.Storage.Repository)
+	if err != nil {
+		return "", err
+	}
+
+	// 跳过其他主机的备份（未使用 IP 前缀时列出的是所有主机的备份）
+	var candidates []catalog.Backup
+	for _, b := range backups {
+		if b.Host != host || b.Type != kind {
+			continue
+		}
+		if req.Name != "" && !strings.Contains(b.Name, req.Name) {
+			continue
+		}
+		candidates = append(candidates, b)
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("没有找到匹配的备份文件（prefix: %s, name: %s）", prefix, req.Name)
+	}
+
+	// 按备份时间排序后最后一个为最新的备份
+	key := candidates[len(candidates)-1].Key
+	logger.Info("找到备份文件", "key", key, "candidates", len(candidates))
+	return key, nil
+}
+
+// downloadBackup 下载备份文件到临时目录，并使用对象元数据或 .sha256 校验文件校验 SHA-256
+func downloadBackup(ctx context.Context, s storage.Storage, key string) (string, error) {
+	expected, err := expectedSHA256(ctx, s, key)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	// 使用 os.CreateTemp 创建名称随机的临时文件（O_EXCL），文件名保留备份文件的扩展名用于判断压缩方式
+	f, err := os.CreateTemp("", "restore-*-"+path.Base(key))
+	if err != nil {
+		return "", fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	localPath := f.Name()
+	logger.Info("正在下载备份文件", "dest", s.String(), "key", key, "path", localPath)
+	digest, err := writeDownload(f, body)
+	if err != nil {
+		return "", err
+	}
+
+	if expected == "" {
+		logger.Warn("备份文件没有 SHA-256 信息，跳过完整性校验", "key", key)
+	} else if !strings.EqualFold(expected, digest.SHA256) {
+		os.Remove(localPath)
+		return "", fmt.Errorf("备份文件校验失败: 期望 SHA-256 %s，实际 %s", expected, digest.SHA256)
+	} else {
+		logger.Info("备份文件校验通过", "sha256", digest.SHA256)
+	}
+	logger.Info("下载完成", "size_bytes", digest.Size, "size_mb", fmt.Sprintf("%.2f", float64(digest.Size)/(1024*1024)))
+	return localPath, nil
+}
+
+// writeDownload 将下载的数据写入本地临时文件并关闭，写入的同时计算摘要；失败时删除临时文件
+func writeDownload(f *os.File, r io.Reader) (checksum.Digest, error) {
+	localPath := f.Name()
+	hasher := checksum.New()
+	if _, err := io.Copy(io.MultiWriter(f, hasher), r); err != nil {
+		f.Close()
+		os.Remove(localPath)
+		return checksum.Digest{}, fmt.Errorf("下载备份文件失败: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(localPath)
+		return checksum.Digest{}, fmt.Errorf("关闭临时文件失败: %v", err)
+	}
+	return hasher.Digest(), nil
+}
+
+// expectedSHA256 获取备份文件的 SHA-256：优先使用对象元数据，其次使用 .sha256 校验文件
+// 都不存在时返回空字符串（如更早版本上传的备份）
+func expectedSHA256(ctx context.Context, s storage.Storage, key string) (string, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if sum := info.Metadata[checksum.MetadataKey]; sum != "" {
+		return sum, nil
+	}
+
+	body, err := s.Get(ctx, key+checksum.SidecarSuffix)
+	if errors.Is(err, storage.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("下载校验文件失败: %v", err)
+	}
+	defer body.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(body, 1024)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("读取校验文件失败: %v", err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("校验文件格式错误: %s", key+checksum.SidecarSuffix)
+	}
+	return fields[0], nil
+}
+
+// openBackup 打开下载的备份文件，根据文件名解密和解压
+func openBackup(localPath string, encryption crypt.Config) (io.ReadCloser, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("打开备份文件失败: %v", err)
+	}
+
+	name := filepath.Base(localPath)
+	var r io.Reader = f
+	if strings.HasSuffix(name, crypt.Suffix) {
+		identities, err := encryption.Identities()
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("备份文件已加密: %v", err)
+		}
+		if r, err = crypt.Decrypt(f, identities); err != nil {
+			f.Close()
+			return nil, err
+		}
+		name = strings.TrimSuffix(name, crypt.Suffix)
+	}
+
+	method, _, _ := compress.DetectFormat(name)
+	decompressReader, err := compress.NewReader(r, method)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &backupReader{ReadCloser: decompressReader, file: f}, nil
+}
+
+// backupReader 关闭解压 reader 的同时关闭备份文件
+type backupReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r *backupReader) Close() error {
+	r.ReadCloser.Close()
+	return r.file.Close()
+}
@@ -0,0 +1,758 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/checksum"
+	"backup-to-oss/internal/compress"
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/incremental"
+	"backup-to-oss/internal/ipfetcher"
+	"backup-to-oss/internal/localfs"
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/metrics"
+	"backup-to-oss/internal/oss"
+	"backup-to-oss/internal/progress"
+	"backup-to-oss/internal/report"
+	"backup-to-oss/internal/retry"
+	"backup-to-oss/internal/s3"
+	"backup-to-oss/internal/sftp"
+	"backup-to-oss/internal/storage"
+
+	"github.com/rboyer/safeio"
+)
+
+// StorageConfig 存储目标配置
+type StorageConfig struct {
+	Destinations       []string // 备份目标地址列表，如 oss://bucket/prefix
+	Repository         bool     // 是否使用去重仓库（repo/ 下的数据块和快照索引）存储备份
+	OSSEndpoint        string
+	OSSAccessKey       string
+	OSSSecretKey       string
+	OSSSSE             string // OSS 服务端加密方式（AES256/KMS/SM4）
+	OSSKMSKeyID        string // OSS KMS 密钥 ID
+	OSSStorageClass    string // OSS 存储类型（Standard/IA/Archive/ColdArchive）
+	S3Endpoint         string // S3 自定义端点（MinIO、Ceph RGW 等）
+	S3Region           string
+	S3AccessKey        string
+	S3SecretKey        string
+	S3PathStyle        bool              // 是否使用 path-style 地址
+	SFTPKeyFile        string            // SFTP 私钥文件路径
+	SFTPKeyPassphrase  string            // SFTP 私钥密码（可选）
+	SFTPKnownHostsFile string            // SFTP known_hosts 文件路径
+	PartSize           int64             // 分片大小（字节）
+	Parallel           int               // 分片上传并发数
+	CheckpointDir      string            // 断点续传 checkpoint 目录（为空时不启用断点续传）
+	KeyCacheDir        string            // 去重仓库密钥的本地缓存目录（公钥加密时没有私钥也可以写入仓库，为空时不缓存）
+	Encryption         crypt.Config      // 客户端加密配置（未设置公钥和口令时不加密）
+	Tags               map[string]string // 对象标签（如 job），source、host 标签由各备份任务添加
+}
+
+// openStorages 根据目标地址创建存储后端
+func openStorages(cfg StorageConfig) ([]storage.Storage, error) {
+	if len(cfg.Destinations) == 0 {
+		return nil, fmt.Errorf("没有指定备份目标")
+	}
+
+	var storages []storage.Storage
+	for _, dest := range cfg.Destinations {
+		s, err := openStorage(cfg, dest)
+		if err != nil {
+			return nil, err
+		}
+		storages = append(storages, s)
+	}
+	return storages, nil
+}
+
+// openStorage 根据目标地址的协议选择存储后端
+func openStorage(cfg StorageConfig, dest string) (storage.Storage, error) {
+	loc, err := storage.ParseLocation(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch loc.Scheme {
+	case "oss":
+		return oss.New(oss.Config{
+			Endpoint:      cfg.OSSEndpoint,
+			AccessKey:     cfg.OSSAccessKey,
+			SecretKey:     cfg.OSSSecretKey,
+			Bucket:        loc.Host,
+			ObjectPrefix:  loc.Path,
+			PartSize:      cfg.PartSize,
+			Routines:      cfg.Parallel,
+			CheckpointDir: cfg.CheckpointDir,
+
+			ServerSideEncryption: cfg.OSSSSE,
+			KMSKeyID:             cfg.OSSKMSKeyID,
+			StorageClass:         cfg.OSSStorageClass,
+		})
+	case "s3":
+		return s3.New(s3.Config{
+			Endpoint:     cfg.S3Endpoint,
+			Region:       cfg.S3Region,
+			AccessKey:    cfg.S3AccessKey,
+			SecretKey:    cfg.S3SecretKey,
+			PathStyle:    cfg.S3PathStyle,
+			Bucket:       loc.Host,
+			ObjectPrefix: loc.Path,
+			PartSize:     cfg.PartSize,
+			Concurrency:  cfg.Parallel,
+		})
+	case "file":
+		return localfs.New(localfs.Config{
+			RootDir: loc.Path,
+		})
+	case "sftp":
+		user, err := sftpUser(loc.User)
+		if err != nil {
+			return nil, err
+		}
+		return sftp.New(sftp.Config{
+			Host:           loc.Host,
+			User:           user,
+			KeyFile:        cfg.SFTPKeyFile,
+			KeyPassphrase:  cfg.SFTPKeyPassphrase,
+			KnownHostsFile: cfg.SFTPKnownHostsFile,
+			RemoteDir:      loc.Path,
+		})
+	default:
+		return nil, fmt.Errorf("不支持的备份目标协议: %s（目标地址: %s）", loc.Scheme, dest)
+	}
+}
+
+// sftpUser 返回 SFTP 登录用户名，目标地址未指定时使用当前系统用户
+// （cron、systemd 等环境中可能没有 USER 环境变量，因此通过 user.Current 获取）
+func sftpUser(name string) (string, error) {
+	if name != "" {
+		return name, nil
+	}
+	current, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("获取当前系统用户失败，请在 sftp:// 地址中指定用户名: %v", err)
+	}
+	if current.Username == "" {
+		return "", fmt.Errorf("无法确定当前系统用户，请在 sftp:// 地址中指定用户名")
+	}
+	return current.Username, nil
+}
+
+// openPrimaryStorage 打开用于恢复和查询的备份目标（多个目标时使用第一个）
+func openPrimaryStorage(cfg StorageConfig) (storage.Storage, error) {
+	if len(cfg.Destinations) == 0 {
+		return nil, fmt.Errorf("没有指定备份目标")
+	}
+	if len(cfg.Destinations) > 1 {
+		logger.Info("指定了多个备份目标，使用第一个目标", "dest", cfg.Destinations[0])
+	}
+	return openStorage(cfg, cfg.Destinations[0])
+}
+
+// closeStorages 关闭需要释放连接的存储后端
+func closeStorages(storages []storage.Storage) {
+	for _, s := range storages {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				logger.Warn("关闭存储连接失败", "dest", s.String(), "error", err)
+			}
+		}
+	}
+}
+
+// fetchPublicIP 获取本机公网 IP，作为对象路径中的主机标识（测试中替换为固定的 IP）
+var fetchPublicIP = func() (string, error) {
+	return ipfetcher.NewPublicIPFetcher().Fetch()
+}
+
+// objectDir 构建对象目录：{ip}/{date}/
+// 目标地址中的前缀由各存储后端自行拼接，最终路径为 {prefix}/{ip}/{date}/
+func objectDir(publicIP string, now time.Time) string {
+	dateStr := now.Format("20060102")
+	if publicIP != "" {
+		return publicIP + "/" + dateStr + "/"
+	}
+	return dateStr + "/"
+}
+
+// writeArchive 创建归档文件并通过 write 写入内容，写入的同时计算摘要
+// 启用加密时数据先经过 age 加密，归档文件名添加 .age 后缀，返回实际的归档文件路径
+func (u *uploader) writeArchive(archivePath string, write func(w io.Writer) error) (string, checksum.Digest, error) {
+	if u.encryptor != nil {
+		archivePath += crypt.Suffix
+	}
+	f, err := os.Create(archivePath)
+	if err != nil {
+		err = fmt.Errorf("创建输出文件失败: %v", err)
+		u.item.Fail(err)
+		return "", checksum.Digest{}, err
+	}
+
+	u.progress.Set(progress.StageArchiving, filepath.Base(archivePath), 0)
+	hasher := checksum.New()
+	sourceSize, err := u.encrypt(io.MultiWriter(f, hasher, u.progress), write)
+	if err != nil {
+		f.Close()
+		os.Remove(archivePath)
+		u.item.Fail(err)
+		return "", checksum.Digest{}, err
+	}
+	if err := f.Close(); err != nil {
+		err = fmt.Errorf("关闭输出文件失败: %v", err)
+		u.item.Fail(err)
+		return "", checksum.Digest{}, err
+	}
+	digest := hasher.Digest()
+	u.metrics.AddArchive(digest.Size, sourceSize)
+	u.item.SetArchive(archivePath, digest, sourceSize)
+	u.finishIndex()
+	return archivePath, digest, nil
+}
+
+// encrypt 通过 write 写入数据，启用加密时数据加密后再写入 w
+// 返回压缩前的字节数（write 通过 compress.NewWriter 压缩数据时记录，否则为 0）
+func (u *uploader) encrypt(w io.Writer, write func(w io.Writer) error) (int64, error) {
+	if u.encryptor == nil {
+		sw := &sourceWriter{Writer: w}
+		err := write(sw)
+		return sw.size, err
+	}
+
+	encryptWriter, err := u.encryptor.Encrypt(w)
+	if err != nil {
+		return 0, err
+	}
+	sw := &sourceWriter{Writer: encryptWriter}
+	if err := write(sw); err != nil {
+		return 0, err
+	}
+	if err := encryptWriter.Close(); err != nil {
+		return 0, fmt.Errorf("加密数据失败: %v", err)
+	}
+	return sw.size, nil
+}
+
+// sourceWriter 实现 compress.SourceCounter，记录压缩前的字节数
+type sourceWriter struct {
+	io.Writer
+	size int64
+}
+
+func (s *sourceWriter) AddSource(n int64) {
+	s.size += n
+}
+
+// metadata 返回对象元数据：SHA-256（为空时不写入）、备份类型和加密密钥指纹
+func (u *uploader) metadata(key, sha256 string) map[string]string {
+	metadata := make(map[string]string)
+	if u.backupType != "" {
+		metadata[catalog.MetadataType] = u.backupType
+	}
+	if sha256 != "" {
+		metadata[checksum.MetadataKey] = sha256
+	}
+	if u.encryptor != nil && strings.HasSuffix(key, crypt.Suffix) {
+		metadata[crypt.MetadataKey] = u.encryptor.Fingerprint()
+	}
+	return metadata
+}
+
+// pendingUpload 未完成的上传记录
+// 启用断点续传时，上传失败的归档文件会被保留，并在下次运行时继续上传
+type pendingUpload struct {
+	Dest        string    `json:"dest"`         // 备份目标地址
+	Key         string    `json:"key"`          // 对象键
+	ArchivePath string    `json:"archive_path"` // 本地归档文件路径
+	KeepArchive bool      `json:"keep_archive"` // 上传完成后是否保留归档文件
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// uploader 将归档文件上传到所有备份目标，并管理未完成的上传记录
+type uploader struct {
+	storages      []storage.Storage
+	checkpointDir string
+	retry         retry.Policy         // 上传失败时的重试策略
+	encryptor     *crypt.Encryptor     // 为 nil 时不加密
+	encryption    crypt.Config         // 加密配置（读取上一次增量备份的加密文件索引时使用其中的私钥或口令）
+	tags          map[string]string    // 对象标签
+	backupType    string               // 备份类型（写入备份文件的对象元数据，为空时不写入）
+	mismatched    map[string]bool      // 校验失败的归档文件（需要保留以便重试）
+	progress      *progress.Tracker    // 执行进度（为 nil 时不记录）
+	metrics       *metrics.Run         // 归档大小和上传字节数（为 nil 时不记录）
+	report        *report.Report       // 运行报告（为 nil 时不记录，也不上传 .manifest.json）
+	item          *report.Item         // 当前备份文件的记录
+	files         *incremental.Scanner // 当前目录备份的文件索引（为 nil 时不上传文件索引）
+	repository    bool                 // 是否使用去重仓库存储备份（只支持流式上传）
+	keyCacheDir   string               // 仓库密钥的本地缓存目录
+	parallel      int                  // 仓库模式下上传数据块的并发数
+	sources       map[string]bool      // 本次运行备份的来源（备份文件名中的来源，清理旧备份时只处理这些来源）
+}
+
+// newUploader 根据存储目标配置和重试策略创建 uploader，ctx 中有进度记录、统计记录和运行报告时分别记录执行进度、统计数据和运行报告
+func newUploader(ctx context.Context, cfg StorageConfig, policy retry.Policy) (*uploader, error) {
+	encryptor, err := crypt.NewEncryptor(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	if encryptor != nil {
+		logger.Info("已启用客户端加密", "fingerprint", encryptor.Fingerprint())
+	}
+
+	storages, err := openStorages(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &uploader{
+		storages:      storages,
+		checkpointDir: cfg.CheckpointDir,
+		retry:         policy,
+		encryptor:     encryptor,
+		encryption:    cfg.Encryption,
+		tags:          maps.Clone(cfg.Tags),
+		mismatched:    make(map[string]bool),
+		sources:       make(map[string]bool),
+		progress:      progress.FromContext(ctx),
+		metrics:       metrics.FromContext(ctx),
+		report:        report.FromContext(ctx),
+		repository:    cfg.Repository,
+		keyCacheDir:   cfg.KeyCacheDir,
+		parallel:      cfg.Parallel,
+	}, nil
+}
+
+// setHost 设置上传对象的 host 标签，并记录到统计数据和运行报告中
+func (u *uploader) setHost(publicIP string) {
+	u.setTag("host", publicIP)
+	u.metrics.SetHost(publicIP)
+	u.report.SetHost(publicIP)
+}
+
+// setSource 在运行报告中记录备份来源配置和备份目标
+func (u *uploader) setSource(source map[string]any) {
+	dests := make([]string, len(u.storages))
+	for i, s := range u.storages {
+		dests[i] = s.String()
+	}
+	u.report.SetSource(source, dests)
+}
+
+// startItem 在运行报告中添加一个备份文件的记录，之后的打包和上传结果记录到该记录中
+func (u *uploader) startItem(sources ...string) *report.Item {
+	u.files = nil
+	u.item = u.report.AddItem(sources...)
+	return u.item
+}
+
+// recordKey 在当前备份文件的记录中记录对象键、压缩方式和是否加密，并记录备份来源
+func (u *uploader) recordKey(key string) {
+	u.recordSource(key)
+	name := path.Base(key)
+	compression, _, _ := compress.DetectFormat(strings.TrimSuffix(name, crypt.Suffix))
+	u.item.SetKey(key, compression, strings.HasSuffix(name, crypt.Suffix))
+	if u.files != nil {
+		u.files.Index().SetKey(key)
+	}
+}
+
+// recordSource 记录备份文件的来源，备份后清理旧备份时只处理本次运行备份的来源
+func (u *uploader) recordSource(key string) {
+	if b, ok := catalog.Parse(storage.ObjectInfo{Key: key}); ok {
+		u.sources[b.Source] = true
+	}
+}
+
+// putManifest 将当前备份文件的运行报告上传到备份文件旁边（{key}.manifest.json），失败只记录日志
+func (u *uploader) putManifest(ctx context.Context, s storage.Storage, key string) {
+	if u.item == nil {
+		return
+	}
+	data, err := u.report.Manifest(u.item)
+	if err != nil {
+		logger.Warn("生成运行报告失败", "error", err)
+		return
+	}
+	err = retry.Do(ctx, u.retry, "上传运行报告", func(ctx context.Context) error {
+		return s.Put(ctx, key+report.ManifestSuffix, bytes.NewReader(data), storage.PutOptions{Tags: u.tags})
+	})
+	if err != nil {
+		logger.Warn("上传运行报告失败", "dest", s.String(), "key", key+report.ManifestSuffix, "error", err)
+	}
+}
+
+// setType 设置备份类型，写入备份文件的对象元数据（list、清理旧备份和恢复时用于区分目录备份和文件备份）和 source 标签
+func (u *uploader) setType(backupType string) {
+	u.backupType = backupType
+	u.setTag("source", backupType)
+}
+
+// setTag 设置上传对象的标签，value 为空时不设置
+func (u *uploader) setTag(key, value string) {
+	if value == "" {
+		return
+	}
+	if u.tags == nil {
+		u.tags = make(map[string]string)
+	}
+	u.tags[key] = value
+}
+
+// Close 关闭存储后端连接
+func (u *uploader) Close() {
+	closeStorages(u.storages)
+}
+
+// Upload 将归档文件上传到所有备份目标，并使用写入归档时计算的摘要校验上传结果
+// 某个目标上传失败不会影响其他目标，所有错误会合并后返回
+// 启用断点续传时，上传失败的目标会记录到 checkpoint 目录，下次运行时继续上传
+func (u *uploader) Upload(ctx context.Context, archivePath, dir string, digest checksum.Digest, keepArchive bool) error {
+	key := dir + filepath.Base(archivePath)
+	u.recordKey(key)
+
+	var errs []error
+	for _, s := range u.storages {
+		err := u.uploadFile(ctx, s, key, archivePath, digest)
+		u.item.AddDestination(s.String(), err)
+		if err != nil {
+			logger.Error("上传备份文件失败", "dest", s.String(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %v", s, err))
+			u.savePending(s, key, archivePath, keepArchive)
+			continue
+		}
+		u.putIndex(ctx, s, key)
+		u.putManifest(ctx, s, key)
+	}
+	if len(errs) < len(u.storages) {
+		u.metrics.AddObject(key)
+	}
+
+	err := errors.Join(errs...)
+	u.item.Fail(err)
+	return err
+}
+
+// uploadFile 上传归档文件到单个备份目标并校验
+// 网络错误、服务端 5xx 等临时故障按重试策略重试，校验失败不重试
+func (u *uploader) uploadFile(ctx context.Context, s storage.Storage, key, archivePath string, digest checksum.Digest) error {
+	logger.Info("正在上传备份文件", "dest", s.String(), "key", key)
+	u.progress.Set(progress.StageUploading, key, digest.Size)
+	opts := storage.PutOptions{Metadata: u.metadata(key, digest.SHA256), Tags: u.tags}
+	err := retry.Do(ctx, u.retry, "上传备份文件", func(ctx context.Context) error {
+		return storage.PutFile(ctx, s, key, archivePath, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := u.verify(ctx, s, key, digest); err != nil {
+		// 校验失败时保留本地归档文件，以便重试
+		u.mismatched[archivePath] = true
+		logger.Error("备份文件校验失败，保留本地文件", "dest", s.String(), "path", archivePath)
+		return err
+	}
+	u.metrics.AddUploaded(digest.Size)
+	return nil
+}
+
+// verify 比较远端对象与本地摘要，校验通过后写入 .sha256 校验文件
+// 校验失败时删除远端对象，避免留下损坏的备份
+func (u *uploader) verify(ctx context.Context, s storage.Storage, key string, digest checksum.Digest) error {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return fmt.Errorf("获取上传后的对象信息失败: %v", err)
+	}
+	if err := digest.Verify(info); err != nil {
+		if delErr := s.Delete(ctx, key); delErr != nil {
+			logger.Warn("删除校验失败的对象失败", "dest", s.String(), "key", key, "error", delErr)
+		}
+		return fmt.Errorf("上传校验失败: %v", err)
+	}
+
+	sidecar := digest.Sidecar(path.Base(key))
+	err = retry.Do(ctx, u.retry, "上传校验文件", func(ctx context.Context) error {
+		return s.Put(ctx, key+checksum.SidecarSuffix, strings.NewReader(sidecar), storage.PutOptions{Tags: u.tags})
+	})
+	if err != nil {
+		return fmt.Errorf("上传校验文件失败: %v", err)
+	}
+	logger.Info("备份文件校验通过", "dest", s.String(), "sha256", digest.SHA256, "crc64", digest.CRC64)
+	return nil
+}
+
+// Stream 将 write 写入的数据流式上传到所有备份目标，不生成本地临时文件
+// 每个目标通过 io.Pipe 读取数据，某个目标失败不会影响其他目标；write 返回错误时所有目标的上传都会被中止
+// 数据流无法重放，因此流式上传失败时不重试；启用加密时对象键添加 .age 后缀
+// 使用去重仓库时数据按内容分块后保存到仓库中（见 storeSnapshot）
+func (u *uploader) Stream(ctx context.Context, key string, write func(w io.Writer) error) error {
+	if u.repository {
+		return u.storeSnapshot(ctx, key, write)
+	}
+	if u.encryptor != nil {
+		key += crypt.Suffix
+	}
+	opts := storage.PutOptions{Metadata: u.metadata(key, ""), Tags: u.tags}
+	u.recordKey(key)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	pipes := make([]*io.PipeWriter, len(u.storages))
+	errs := make([]error, len(u.storages))
+	for i, s := range u.storages {
+		pr, pw := io.Pipe()
+		pipes[i] = pw
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Info("正在流式上传备份文件", "dest", s.String(), "key", key)
+			errs[i] = s.Put(ctx, key, pr, opts)
+			// 关闭读端，避免写端阻塞
+			if errs[i] != nil {
+				pr.CloseWithError(errs[i])
+			} else {
+				pr.CloseWithError(errStreamClosed)
+			}
+		}()
+	}
+
+	u.progress.Set(progress.StageStreaming, key, 0)
+	fw := &fanoutWriter{writers: slices.Clone(pipes), hasher: checksum.New(), progress: u.progress}
+	sourceSize, writeErr := u.encrypt(fw, write)
+	if writeErr != nil {
+		cancel()
+	}
+	for _, pw := range pipes {
+		// writeErr 为 nil 时读端收到 EOF，否则上传会以该错误中止
+		pw.CloseWithError(writeErr)
+	}
+	wg.Wait()
+
+	// 生成数据失败（而不是所有目标都失败导致写入中止）时返回生成数据的错误
+	if writeErr != nil && !fw.allFailed() {
+		err := fmt.Errorf("生成备份数据失败: %v", writeErr)
+		u.item.Fail(err)
+		return err
+	}
+
+	// 流式上传时数据写完才能得到摘要，因此对象元数据中不包含 SHA-256，只写入 .sha256 校验文件
+	digest := fw.hasher.Digest()
+	u.metrics.AddArchive(digest.Size, sourceSize)
+	u.item.SetArchive("", digest, sourceSize)
+	u.finishIndex()
+	var destErrs []error
+	for i, s := range u.storages {
+		if errs[i] == nil {
+			errs[i] = u.verify(ctx, s, key, digest)
+		}
+		u.item.AddDestination(s.String(), errs[i])
+		if errs[i] != nil {
+			logger.Error("流式上传备份文件失败", "dest", s.String(), "error", errs[i])
+			destErrs = append(destErrs, fmt.Errorf("%s: %v", s, errs[i]))
+			continue
+		}
+		u.metrics.AddUploaded(digest.Size)
+		u.putIndex(ctx, s, key)
+		u.putManifest(ctx, s, key)
+	}
+	if len(destErrs) < len(u.storages) {
+		u.metrics.AddObject(key)
+	}
+	if len(destErrs) == 0 {
+		logger.Info("流式上传完成", "key", key, "size_bytes", digest.Size, "size_mb", fmt.Sprintf("%.2f", float64(digest.Size)/(1024*1024)))
+	}
+	err := errors.Join(destErrs...)
+	u.item.Fail(err)
+	return err
+}
+
+// errStreamClosed 上传提前结束后继续写入时返回的错误
+var errStreamClosed = errors.New("上传已结束")
+
+// fanoutWriter 将数据依次写入多个 pipe，写入失败的目标会被移除（其错误由对应的上传协程返回）
+type fanoutWriter struct {
+	writers  []*io.PipeWriter
+	hasher   *checksum.Hasher  // 计算写入数据的摘要
+	progress *progress.Tracker // 记录已写入的字节数
+}
+
+func (f *fanoutWriter) Write(p []byte) (int, error) {
+	for i, w := range f.writers {
+		if w == nil {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			f.writers[i] = nil
+		}
+	}
+	if f.allFailed() {
+		return 0, fmt.Errorf("所有备份目标都上传失败")
+	}
+	f.hasher.Write(p)
+	f.progress.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// allFailed 判断是否所有目标都已失败
+func (f *fanoutWriter) allFailed() bool {
+	for _, w := range f.writers {
+		if w != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Retained 判断归档文件是否需要保留（有未完成的上传或校验失败，此时不能删除该文件）
+func (u *uploader) Retained(archivePath string) bool {
+	return u.mismatched[archivePath] || u.isPending(archivePath)
+}
+
+// isPending 判断归档文件是否有未完成的上传
+func (u *uploader) isPending(archivePath string) bool {
+	pendings, err := u.loadPendings()
+	if err != nil {
+		return false
+	}
+	for _, p := range pendings {
+		if p.upload.ArchivePath == archivePath {
+			return true
+		}
+	}
+	return false
+}
+
+// ResumePending 继续上传之前运行中未完成的上传
+// 续传失败只记录日志，不影响本次备份
+func (u *uploader) ResumePending(ctx context.Context) {
+	pendings, err := u.loadPendings()
+	if err != nil {
+		logger.Warn("读取未完成的上传记录失败", "error", err)
+		return
+	}
+
+	for _, p := range pendings {
+		var target storage.Storage
+		for _, s := range u.storages {
+			if s.String() == p.upload.Dest {
+				target = s
+				break
+			}
+		}
+		if target == nil {
+			// 不属于本次运行的备份目标，留给对应的任务处理
+			continue
+		}
+
+		if _, err := os.Stat(p.upload.ArchivePath); err != nil {
+			logger.Warn("未完成上传的归档文件不存在，放弃续传", "path", p.upload.ArchivePath, "error", err)
+			os.Remove(p.path)
+			continue
+		}
+
+		logger.Info("正在继续上传未完成的备份文件", "dest", p.upload.Dest, "key", p.upload.Key, "created_at", p.upload.CreatedAt)
+		digest, err := checksum.File(p.upload.ArchivePath)
+		if err != nil {
+			logger.Error("计算归档文件摘要失败，将在下次运行时重试", "path", p.upload.ArchivePath, "error", err)
+			continue
+		}
+		if err := u.uploadFile(ctx, target, p.upload.Key, p.upload.ArchivePath, digest); err != nil {
+			logger.Error("继续上传备份文件失败，将在下次运行时重试", "dest", p.upload.Dest, "error", err)
+			continue
+		}
+		os.Remove(p.path)
+		logger.Info("未完成的备份文件上传完成", "dest", p.upload.Dest, "key", p.upload.Key)
+
+		// 所有目标都上传完成后再清理归档文件
+		if !p.upload.KeepArchive && !u.Retained(p.upload.ArchivePath) {
+			os.Remove(p.upload.ArchivePath)
+		}
+	}
+}
+
+// pendingFile 已保存的未完成上传记录
+type pendingFile struct {
+	path   string
+	upload pendingUpload
+}
+
+// pendingPath 返回未完成上传记录的文件路径（同一目标的同一对象只保留一条记录）
+func (u *uploader) pendingPath(dest, key string) string {
+	sum := sha1.Sum([]byte(dest + "|" + key))
+	return filepath.Join(u.checkpointDir, fmt.Sprintf("pending-%x.json", sum[:8]))
+}
+
+// savePending 保存未完成的上传记录（未启用断点续传时不做任何事）
+func (u *uploader) savePending(s storage.Storage, key, archivePath string, keepArchive bool) {
+	if u.checkpointDir == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(pendingUpload{
+		Dest:        s.String(),
+		Key:         key,
+		ArchivePath: archivePath,
+		KeepArchive: keepArchive,
+		CreatedAt:   time.Now(),
+	}, "", "  ")
+	if err != nil {
+		logger.Warn("序列化未完成的上传记录失败", "error", err)
+		return
+	}
+	if err := os.MkdirAll(u.checkpointDir, 0755); err != nil {
+		logger.Warn("创建 checkpoint 目录失败", "path", u.checkpointDir, "error", err)
+		return
+	}
+	if _, err := safeio.WriteToFile(bytes.NewReader(data), u.pendingPath(s.String(), key), 0600); err != nil {
+		logger.Warn("保存未完成的上传记录失败", "error", err)
+		return
+	}
+	logger.Info("已保存未完成的上传记录，下次运行时将继续上传", "dest", s.String(), "archive", archivePath)
+}
+
+// loadPendings 读取 checkpoint 目录中所有未完成的上传记录
+func (u *uploader) loadPendings() ([]pendingFile, error) {
+	if u.checkpointDir == "" {
+		return nil, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(u.checkpointDir, "pending-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pendings []pendingFile
+	for _, file := range paths {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		var upload pendingUpload
+		if err := json.Unmarshal(data, &upload); err != nil {
+			logger.Warn("解析未完成的上传记录失败，跳过", "path", file, "error", err)
+			continue
+		}
+		pendings = append(pendings, pendingFile{path: file, upload: upload})
+	}
+	return pendings, nil
+}
@@ -0,0 +1,180 @@
+package crypt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/rboyer/safeio"
+)
+
+const (
+	Suffix      = ".age"            // 加密后的文件后缀
+	MetadataKey = "age-fingerprint" // 对象元数据中保存密钥指纹的键
+)
+
+// Config 加密配置
+// Recipients/RecipientsFile 与 Passphrase 只能二选一，都为空时不加密
+type Config struct {
+	Recipients     []string // age 公钥列表（age1...）
+	RecipientsFile string   // age 公钥文件路径（每行一个公钥，支持 # 注释）
+	Passphrase     string   // 口令（使用 scrypt 派生密钥）
+	IdentityFile   string   // age 私钥文件路径（用于解密）
+}
+
+// Enabled 判断是否启用加密
+func (c Config) Enabled() bool {
+	return len(c.Recipients) > 0 || c.RecipientsFile != "" || c.Passphrase != ""
+}
+
+// Encryptor 使用 age 加密数据
+type Encryptor struct {
+	recipients  []age.Recipient
+	fingerprint string
+}
+
+// NewEncryptor 根据加密配置创建 Encryptor，未启用加密时返回 nil
+func NewEncryptor(cfg Config) (*Encryptor, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	if cfg.Passphrase != "" {
+		if len(cfg.Recipients) > 0 || cfg.RecipientsFile != "" {
+			return nil, fmt.Errorf("口令加密不能与公钥加密同时使用")
+		}
+		recipient, err := age.NewScryptRecipient(cfg.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("创建口令加密失败: %v", err)
+		}
+		return &Encryptor{recipients: []age.Recipient{recipient}, fingerprint: "scrypt"}, nil
+	}
+
+	var keys []string
+	for _, r := range cfg.Recipients {
+		if r = strings.TrimSpace(r); r != "" {
+			keys = append(keys, r)
+		}
+	}
+	if cfg.RecipientsFile != "" {
+		fileKeys, err := readRecipientsFile(cfg.RecipientsFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("没有可用的加密公钥")
+	}
+
+	e := &Encryptor{}
+	var fingerprints []string
+	for _, key := range keys {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("解析加密公钥失败: %s, %v", key, err)
+		}
+		e.recipients = append(e.recipients, recipient)
+		fingerprints = append(fingerprints, Fingerprint(recipient.String()))
+	}
+	e.fingerprint = strings.Join(fingerprints, ",")
+	return e, nil
+}
+
+// readRecipientsFile 读取公钥文件，忽略空行和 # 开头的注释
+func readRecipientsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取加密公钥文件失败: %v", err)
+	}
+
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, nil
+}
+
+// Fingerprint 返回公钥指纹（公钥 SHA-256 的前 8 字节，十六进制）
+func Fingerprint(recipient string) string {
+	sum := sha256.Sum256([]byte(recipient))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Fingerprint 返回加密使用的密钥指纹，多个公钥用逗号分隔，口令加密时为 scrypt
+func (e *Encryptor) Fingerprint() string {
+	return e.fingerprint
+}
+
+// Encrypt 返回加密写入 w 的 WriteCloser，必须调用 Close 才能写入最后一个数据块
+func (e *Encryptor) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	wc, err := age.Encrypt(w, e.recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("创建加密写入器失败: %v", err)
+	}
+	return wc, nil
+}
+
+// Identities 根据加密配置读取解密使用的私钥文件或口令
+func (c Config) Identities() ([]age.Identity, error) {
+	var identities []age.Identity
+	if c.IdentityFile != "" {
+		f, err := os.Open(c.IdentityFile)
+		if err != nil {
+			return nil, fmt.Errorf("打开私钥文件失败: %v", err)
+		}
+		defer f.Close()
+
+		fileIdentities, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("解析私钥文件失败: %v", err)
+		}
+		identities = append(identities, fileIdentities...)
+	}
+	if c.Passphrase != "" {
+		identity, err := age.NewScryptIdentity(c.Passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("创建口令解密失败: %v", err)
+		}
+		identities = append(identities, identity)
+	}
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("没有指定解密私钥或口令")
+	}
+	return identities, nil
+}
+
+// Decrypt 返回解密 r 的 Reader
+func Decrypt(r io.Reader, identities []age.Identity) (io.Reader, error) {
+	dr, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("解密失败: %v", err)
+	}
+	return dr, nil
+}
+
+// DecryptFile 解密文件，解密成功后才会生成输出文件
+func DecryptFile(inputFile, outputFile string, identities []age.Identity) error {
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return fmt.Errorf("打开加密文件失败: %v", err)
+	}
+	defer in.Close()
+
+	dr, err := Decrypt(in, identities)
+	if err != nil {
+		return err
+	}
+	if _, err := safeio.WriteToFile(dr, outputFile, 0600); err != nil {
+		return fmt.Errorf("写入解密文件失败: %v", err)
+	}
+	return nil
+}
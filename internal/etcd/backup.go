@@ -1,10 +1,14 @@
 package etcd
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"time"
 
@@ -30,8 +34,9 @@ type BackupConfig struct {
 
 // BackupResult etcd snapshot 备份结果
 type BackupResult struct {
-	Version string // etcd 版本
-	Path    string // snapshot 文件路径
+	Version string              // etcd 版本
+	Path    string              // snapshot 文件路径（流式备份时为空）
+	Status  *SnapshotStatusInfo // snapshot status 信息（流式备份时为 nil）
 }
 
 // Backup 执行 etcd snapshot 备份
@@ -44,6 +49,93 @@ func Backup(ctx context.Context, cfg BackupConfig, snapshotPath string) (*Backup
 	}
 
 	// 构建 etcd 客户端配置
+	clientCfg, err := newClientConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("正在连接 etcd", "endpoints", cfg.Endpoints)
+
+	// 使用 etcd 官方库保存 snapshot
+	version, err := snapshot.SaveWithVersion(ctx, lg, *clientCfg, snapshotPath)
+	if err != nil {
+		return nil, fmt.Errorf("保存 etcd snapshot 失败: %v", err)
+	}
+
+	logger.Info("Snapshot 保存成功", "path", snapshotPath, "version", version)
+
+	// 验证 snapshot 文件
+	fileInfo, err := os.Stat(snapshotPath)
+	if err != nil {
+		return nil, fmt.Errorf("获取 snapshot 文件信息失败: %v", err)
+	}
+	if fileInfo.Size() == 0 {
+		return nil, fmt.Errorf("snapshot 文件为空")
+	}
+
+	logger.Info("Snapshot 验证成功", "size_bytes", fileInfo.Size(), "size_mb", fmt.Sprintf("%.2f", float64(fileInfo.Size())/(1024*1024)))
+
+	// 执行 snapshot status 操作验证 snapshot 完整性
+	logger.Info("正在执行 snapshot status 操作")
+	statusInfo, err := CheckSnapshotStatus(snapshotPath)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot status 失败，文件可能已损坏: %v", err)
+	}
+	logger.Info("Snapshot status 成功",
+		"hash", statusInfo.Hash,
+		"revision", statusInfo.Revision,
+		"total_key", statusInfo.TotalKey,
+		"total_size", statusInfo.TotalSize)
+
+	return &BackupResult{
+		Version: version,
+		Path:    snapshotPath,
+		Status:  statusInfo,
+	}, nil
+}
+
+// BackupStream 执行 etcd snapshot 备份，将 snapshot 数据直接写入 w（不生成本地文件）
+// 流式备份无法执行 snapshot status 操作，改为校验 snapshot 末尾的 sha256 校验和
+func BackupStream(ctx context.Context, cfg BackupConfig, w io.Writer) (*BackupResult, error) {
+	if len(cfg.Endpoints) != 1 {
+		return nil, fmt.Errorf("snapshot 只能从单个 etcd 节点获取，当前指定了 %d 个地址: %v", len(cfg.Endpoints), cfg.Endpoints)
+	}
+
+	clientCfg, err := newClientConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("正在连接 etcd", "endpoints", cfg.Endpoints)
+	cli, err := clientv3.New(*clientCfg)
+	if err != nil {
+		return nil, fmt.Errorf("连接 etcd 失败: %v", err)
+	}
+	defer cli.Close()
+
+	resp, err := cli.SnapshotWithVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("获取 etcd snapshot 失败: %v", err)
+	}
+	defer resp.Snapshot.Close()
+
+	verifier := newSnapshotVerifier()
+	if _, err := io.Copy(io.MultiWriter(w, verifier), resp.Snapshot); err != nil {
+		return nil, fmt.Errorf("传输 etcd snapshot 失败: %v", err)
+	}
+	if err := verifier.Verify(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("Snapshot 传输完成", "version", resp.Version, "size_bytes", verifier.size, "size_mb", fmt.Sprintf("%.2f", float64(verifier.size)/(1024*1024)))
+
+	return &BackupResult{
+		Version: resp.Version,
+	}, nil
+}
+
+// newClientConfig 根据备份配置构建 etcd 客户端配置
+func newClientConfig(cfg BackupConfig) (*clientv3.Config, error) {
 	clientCfg := clientv3.Config{
 		Endpoints:   cfg.Endpoints,
 		DialTimeout: cfg.DialTimeout,
@@ -84,41 +176,42 @@ func Backup(ctx context.Context, cfg BackupConfig, snapshotPath string) (*Backup
 		clientCfg.Password = cfg.Password
 	}
 
-	logger.Info("正在连接 etcd", "endpoints", cfg.Endpoints)
+	return &clientCfg, nil
+}
 
-	// 使用 etcd 官方库保存 snapshot
-	version, err := snapshot.SaveWithVersion(ctx, lg, clientCfg, snapshotPath)
-	if err != nil {
-		return nil, fmt.Errorf("保存 etcd snapshot 失败: %v", err)
-	}
+// snapshotVerifier 在流式传输时计算 snapshot 的 sha256
+// etcd 返回的 snapshot 数据末尾附带 32 字节的 sha256 校验和
+type snapshotVerifier struct {
+	hash hash.Hash
+	tail []byte // 最近写入的 32 字节（可能是校验和）
+	size int64
+}
 
-	logger.Info("Snapshot 保存成功", "path", snapshotPath, "version", version)
+func newSnapshotVerifier() *snapshotVerifier {
+	return &snapshotVerifier{hash: sha256.New()}
+}
 
-	// 验证 snapshot 文件
-	fileInfo, err := os.Stat(snapshotPath)
-	if err != nil {
-		return nil, fmt.Errorf("获取 snapshot 文件信息失败: %v", err)
-	}
-	if fileInfo.Size() == 0 {
-		return nil, fmt.Errorf("snapshot 文件为空")
+func (v *snapshotVerifier) Write(p []byte) (int, error) {
+	v.size += int64(len(p))
+	v.tail = append(v.tail, p...)
+	if n := len(v.tail) - sha256.Size; n > 0 {
+		v.hash.Write(v.tail[:n])
+		v.tail = append(v.tail[:0], v.tail[n:]...)
 	}
+	return len(p), nil
+}
 
-	logger.Info("Snapshot 验证成功", "size_bytes", fileInfo.Size(), "size_mb", fmt.Sprintf("%.2f", float64(fileInfo.Size())/(1024*1024)))
-
-	// 执行 snapshot status 操作验证 snapshot 完整性
-	logger.Info("正在执行 snapshot status 操作")
-	statusInfo, err := CheckSnapshotStatus(snapshotPath)
-	if err != nil {
-		return nil, fmt.Errorf("snapshot status 失败，文件可能已损坏: %v", err)
+// Verify 校验 snapshot 末尾的 sha256 校验和
+func (v *snapshotVerifier) Verify() error {
+	// snapshot 数据按 512 字节对齐，末尾附加 32 字节的校验和
+	if v.size == 0 {
+		return fmt.Errorf("snapshot 数据为空")
 	}
-	logger.Info("Snapshot status 成功",
-		"hash", statusInfo.Hash,
-		"revision", statusInfo.Revision,
-		"total_key", statusInfo.TotalKey,
-		"total_size", statusInfo.TotalSize)
-
-	return &BackupResult{
-		Version: version,
-		Path:    snapshotPath,
-	}, nil
+	if v.size%512 != sha256.Size {
+		return fmt.Errorf("snapshot 缺少 sha256 校验和（大小: %d 字节）", v.size)
+	}
+	if !bytes.Equal(v.hash.Sum(nil), v.tail) {
+		return fmt.Errorf("snapshot sha256 校验失败，数据可能已损坏")
+	}
+	return nil
 }
@@ -0,0 +1,312 @@
+package etcd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"backup-to-oss/internal/logger"
+
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+	"go.etcd.io/etcd/client/pkg/v3/logutil"
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/server/v3/config"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/membership"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/v2store"
+	"go.etcd.io/etcd/server/v3/etcdserver/cindex"
+	"go.etcd.io/etcd/server/v3/storage/backend"
+	"go.etcd.io/etcd/server/v3/storage/datadir"
+	"go.etcd.io/etcd/server/v3/storage/schema"
+	"go.etcd.io/etcd/server/v3/storage/wal"
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+	"go.etcd.io/raft/v3/raftpb"
+	"go.uber.org/zap"
+)
+
+// v2 store 的目录前缀（与 etcdserver.StoreClusterPrefix、etcdserver.StoreKeysPrefix 相同，避免引入整个 etcdserver 包）
+const (
+	storeClusterPrefix = "/0"
+	storeKeysPrefix    = "/1"
+)
+
+// RestoreConfig etcd snapshot 恢复配置（与 etcdutl snapshot restore 的参数对应）
+type RestoreConfig struct {
+	SnapshotPath             string // snapshot 文件路径
+	Name                     string // 成员名称
+	DataDir                  string // 数据目录（为空时为 {Name}.etcd），必须不存在或为空目录
+	InitialCluster           string // 初始集群配置，如 infra0=http://10.0.0.1:2380,infra1=http://10.0.0.2:2380
+	InitialClusterToken      string // 初始集群 token
+	InitialAdvertisePeerURLs string // 成员的 peer 地址，多个地址用逗号分隔
+	SkipHashCheck            bool   // 是否跳过 snapshot 的 sha256 校验（从数据目录直接复制的 db 文件没有校验和）
+}
+
+// Restore 将 snapshot 恢复到新的数据目录，等同于 etcdutl snapshot restore
+// 恢复后的数据目录只包含 InitialCluster 中的成员，集群中的每个成员都需要使用同一个 snapshot 分别恢复
+func Restore(cfg RestoreConfig) error {
+	lg, err := logutil.CreateDefaultZapLogger(zap.InfoLevel)
+	if err != nil {
+		return fmt.Errorf("创建 logger 失败: %v", err)
+	}
+
+	peerURLs, err := types.NewURLs(splitURLs(cfg.InitialAdvertisePeerURLs))
+	if err != nil {
+		return fmt.Errorf("无效的 initial-advertise-peer-urls: %v", err)
+	}
+	initialCluster, err := types.NewURLsMap(cfg.InitialCluster)
+	if err != nil {
+		return fmt.Errorf("无效的 initial-cluster: %v", err)
+	}
+	srv := config.ServerConfig{
+		Logger:              lg,
+		Name:                cfg.Name,
+		PeerURLs:            peerURLs,
+		InitialPeerURLsMap:  initialCluster,
+		InitialClusterToken: cfg.InitialClusterToken,
+	}
+	if err := srv.VerifyBootstrap(); err != nil {
+		return fmt.Errorf("集群配置无效: %v", err)
+	}
+	cluster, err := membership.NewClusterFromURLsMap(lg, cfg.InitialClusterToken, initialCluster)
+	if err != nil {
+		return fmt.Errorf("创建集群成员信息失败: %v", err)
+	}
+
+	dataDir := cfg.DataDir
+	if dataDir == "" {
+		dataDir = cfg.Name + ".etcd"
+	}
+	dataDirExists := fileutil.Exist(dataDir)
+	if dataDirExists && !fileutil.DirEmpty(dataDir) {
+		return fmt.Errorf("数据目录已存在且不为空: %s", dataDir)
+	}
+
+	r := &restorer{
+		lg:        lg,
+		cfg:       cfg,
+		cluster:   cluster,
+		walDir:    datadir.ToWALDir(dataDir),
+		snapDir:   datadir.ToSnapDir(dataDir),
+		outDbPath: datadir.ToBackendFileName(dataDir),
+	}
+
+	logger.Info("正在恢复 etcd snapshot", "snapshot", cfg.SnapshotPath, "data_dir", dataDir, "name", cfg.Name, "initial_cluster", cfg.InitialCluster)
+	if err := r.restore(); err != nil {
+		// 恢复失败时删除不完整的数据目录，避免误用
+		if dataDirExists {
+			os.RemoveAll(datadir.ToMemberDir(dataDir))
+		} else {
+			os.RemoveAll(dataDir)
+		}
+		return err
+	}
+	logger.Info("etcd snapshot 恢复完成", "data_dir", dataDir, "wal_dir", r.walDir)
+	return nil
+}
+
+// restorer 执行 snapshot 恢复的各个步骤（参考 etcdutl 的实现）
+type restorer struct {
+	lg        *zap.Logger
+	cfg       RestoreConfig
+	cluster   *membership.RaftCluster
+	walDir    string
+	snapDir   string
+	outDbPath string
+}
+
+func (r *restorer) restore() error {
+	if err := r.copyAndVerifyDB(); err != nil {
+		return err
+	}
+
+	// 校验 snapshot 中的数据可以正常读取
+	statusInfo, err := CheckSnapshotStatus(r.outDbPath)
+	if err != nil {
+		return fmt.Errorf("snapshot status 失败，文件可能已损坏: %v", err)
+	}
+	logger.Info("Snapshot status 成功",
+		"hash", statusInfo.Hash,
+		"revision", statusInfo.Revision,
+		"total_key", statusInfo.TotalKey,
+		"total_size", statusInfo.TotalSize)
+
+	if err := r.trimMembership(); err != nil {
+		return err
+	}
+	hardState, err := r.saveWALAndSnap()
+	if err != nil {
+		return err
+	}
+	return r.updateConsistentIndex(hardState.Commit, hardState.Term)
+}
+
+// copyAndVerifyDB 将 snapshot 复制到数据目录，校验并去掉末尾的 sha256 校验和
+func (r *restorer) copyAndVerifyDB() error {
+	src, err := os.Open(r.cfg.SnapshotPath)
+	if err != nil {
+		return fmt.Errorf("打开 snapshot 文件失败: %v", err)
+	}
+	defer src.Close()
+
+	if err := fileutil.CreateDirAll(r.lg, filepath.Dir(r.outDbPath)); err != nil {
+		return fmt.Errorf("创建数据目录失败: %v", err)
+	}
+	db, err := os.OpenFile(r.outDbPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("创建 db 文件失败: %v", err)
+	}
+	defer db.Close()
+
+	size, err := io.Copy(db, src)
+	if err != nil {
+		return fmt.Errorf("复制 snapshot 文件失败: %v", err)
+	}
+
+	if !hasChecksum(size) {
+		if !r.cfg.SkipHashCheck {
+			return fmt.Errorf("snapshot 没有 sha256 校验和（从数据目录复制的 db 文件需要指定 --skip-hash-check）")
+		}
+		return nil
+	}
+
+	expected := make([]byte, sha256.Size)
+	if _, err := db.ReadAt(expected, size-sha256.Size); err != nil {
+		return fmt.Errorf("读取 snapshot 校验和失败: %v", err)
+	}
+	if err := db.Truncate(size - sha256.Size); err != nil {
+		return fmt.Errorf("去掉 snapshot 校验和失败: %v", err)
+	}
+	if r.cfg.SkipHashCheck {
+		return nil
+	}
+
+	if _, err := db.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("读取 db 文件失败: %v", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, db); err != nil {
+		return fmt.Errorf("计算 snapshot 校验和失败: %v", err)
+	}
+	if actual := h.Sum(nil); !bytes.Equal(expected, actual) {
+		return fmt.Errorf("snapshot 校验失败: 期望 sha256 %x，实际 %x", expected, actual)
+	}
+	logger.Info("Snapshot sha256 校验通过", "sha256", fmt.Sprintf("%x", expected))
+	return nil
+}
+
+// trimMembership 删除 snapshot 中原集群的成员信息
+func (r *restorer) trimMembership() error {
+	be := backend.NewDefaultBackend(r.lg, r.outDbPath)
+	defer be.Close()
+
+	if err := schema.NewMembershipBackend(r.lg, be).TrimMembershipFromBackend(); err != nil {
+		return fmt.Errorf("删除原集群成员信息失败: %v", err)
+	}
+	return nil
+}
+
+// saveWALAndSnap 写入新集群的成员信息，并生成 WAL 和 raft snapshot
+func (r *restorer) saveWALAndSnap() (*raftpb.HardState, error) {
+	if err := fileutil.CreateDirAll(r.lg, r.walDir); err != nil {
+		return nil, fmt.Errorf("创建 WAL 目录失败: %v", err)
+	}
+
+	// 将新集群的成员写入 v2 store 和 backend
+	st := v2store.New(storeClusterPrefix, storeKeysPrefix)
+	r.cluster.SetStore(st)
+	be := backend.NewDefaultBackend(r.lg, r.outDbPath)
+	defer be.Close()
+	r.cluster.SetBackend(schema.NewMembershipBackend(r.lg, be))
+	for _, m := range r.cluster.Members() {
+		r.cluster.AddMember(m, true)
+	}
+
+	m := r.cluster.MemberByName(r.cfg.Name)
+	md := &etcdserverpb.Metadata{NodeID: uint64(m.ID), ClusterID: uint64(r.cluster.ID())}
+	metadata, err := md.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("序列化 WAL 元数据失败: %v", err)
+	}
+	w, err := wal.Create(r.lg, r.walDir, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("创建 WAL 失败: %v", err)
+	}
+	defer w.Close()
+
+	// 每个成员对应一条 ConfChangeAddNode 日志
+	memberIDs := r.cluster.MemberIDs()
+	ents := make([]raftpb.Entry, len(memberIDs))
+	nodeIDs := make([]uint64, len(memberIDs))
+	for i, id := range memberIDs {
+		ctx, err := json.Marshal(r.cluster.Member(id))
+		if err != nil {
+			return nil, fmt.Errorf("序列化成员信息失败: %v", err)
+		}
+		cc := raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: uint64(id), Context: ctx}
+		data, err := cc.Marshal()
+		if err != nil {
+			return nil, fmt.Errorf("序列化 ConfChange 失败: %v", err)
+		}
+		nodeIDs[i] = uint64(id)
+		ents[i] = raftpb.Entry{Type: raftpb.EntryConfChange, Term: 1, Index: uint64(i + 1), Data: data}
+	}
+
+	commit, term := uint64(len(ents)), uint64(1)
+	hardState := raftpb.HardState{Term: term, Vote: nodeIDs[0], Commit: commit}
+	if err := w.Save(hardState, ents); err != nil {
+		return nil, fmt.Errorf("写入 WAL 失败: %v", err)
+	}
+
+	data, err := st.Save()
+	if err != nil {
+		return nil, fmt.Errorf("序列化 v2 store 失败: %v", err)
+	}
+	confState := raftpb.ConfState{Voters: nodeIDs}
+	raftSnap := raftpb.Snapshot{
+		Data: data,
+		Metadata: raftpb.SnapshotMetadata{
+			Index:     commit,
+			Term:      term,
+			ConfState: confState,
+		},
+	}
+	if err := snap.New(r.lg, r.snapDir).SaveSnap(raftSnap); err != nil {
+		return nil, fmt.Errorf("写入 raft snapshot 失败: %v", err)
+	}
+	if err := w.SaveSnapshot(walpb.Snapshot{Index: commit, Term: term, ConfState: &confState}); err != nil {
+		return nil, fmt.Errorf("写入 WAL snapshot 失败: %v", err)
+	}
+	return &hardState, nil
+}
+
+// updateConsistentIndex 将 backend 的 consistent index 更新为 WAL 中最后提交的日志
+func (r *restorer) updateConsistentIndex(commit, term uint64) error {
+	be := backend.NewDefaultBackend(r.lg, r.outDbPath)
+	defer be.Close()
+
+	cindex.UpdateConsistentIndexForce(be.BatchTx(), commit, term)
+	return nil
+}
+
+// hasChecksum 判断 snapshot 末尾是否有 sha256 校验和
+// db 文件大小是 512 的整数倍，追加校验和后余数为 sha256.Size
+func hasChecksum(n int64) bool {
+	return n%512 == sha256.Size
+}
+
+// splitURLs 解析逗号分隔的地址列表
+func splitURLs(s string) []string {
+	var urls []string
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
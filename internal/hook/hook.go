@@ -0,0 +1,158 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"backup-to-oss/internal/logger"
+)
+
+// 执行 hook 的阶段
+const (
+	StagePre         = "pre"          // 备份前执行，失败时中止备份
+	StagePostSuccess = "post_success" // 备份成功后执行
+	StagePostFailure = "post_failure" // 备份失败（包括部分失败和 pre hook 失败）后执行
+	StageAlways      = "always"       // 无论备份是否成功都执行（在 post_success/post_failure 之后）
+)
+
+// DefaultTimeout 每个命令默认的超时时间
+const DefaultTimeout = 10 * time.Minute
+
+// maxOutput 日志中记录的命令输出的最大字节数（超过时只保留最后的部分）
+const maxOutput = 16 * 1024
+
+// Config hook 配置，每个阶段可以有多个命令，按顺序通过 sh -c 执行
+type Config struct {
+	Pre         []string      // 备份前执行的命令
+	PostSuccess []string      // 备份成功后执行的命令
+	PostFailure []string      // 备份失败后执行的命令
+	Always      []string      // 无论备份是否成功都执行的命令
+	Timeout     time.Duration // 每个命令的超时时间（为 0 时使用 DefaultTimeout）
+}
+
+// Validate 校验 hook 配置
+func (c Config) Validate() error {
+	if c.Timeout < 0 {
+		return fmt.Errorf("hook 超时时间不能为负数: %s", c.Timeout)
+	}
+	return nil
+}
+
+// Env 执行 hook 时通过环境变量传递的运行信息
+type Env struct {
+	Job          string
+	Type         string   // 备份类型 (dir/file/etcd/consul)
+	Host         string   // 公网 IP（pre hook 中为空）
+	Status       string   // 备份结果 success/failure/partial（pre hook 中为空）
+	Error        string   // 失败原因
+	ObjectKeys   []string // 上传成功的对象键
+	ArchivePaths []string // 本地归档文件路径（未保留备份文件时 post hook 执行时已删除，流式上传时为空）
+}
+
+// environ 返回执行命令的环境变量：当前进程的环境变量和 BACKUP_* 运行信息（多个值用换行分隔）
+func (e Env) environ(stage string) []string {
+	return append(os.Environ(),
+		"BACKUP_HOOK_STAGE="+stage,
+		"BACKUP_JOB="+e.Job,
+		"BACKUP_TYPE="+e.Type,
+		"BACKUP_HOST="+e.Host,
+		"BACKUP_STATUS="+e.Status,
+		"BACKUP_ERROR="+e.Error,
+		"BACKUP_OBJECT_KEYS="+strings.Join(e.ObjectKeys, "\n"),
+		"BACKUP_ARCHIVE_PATHS="+strings.Join(e.ArchivePaths, "\n"),
+	)
+}
+
+// RunPre 依次执行 pre hook，一个命令失败时不再执行后面的命令并返回错误
+func (c Config) RunPre(ctx context.Context, env Env) error {
+	for _, command := range c.Pre {
+		if err := c.run(ctx, StagePre, command, env); err != nil {
+			return fmt.Errorf("pre hook 执行失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// RunPost 按备份结果执行 post_success 或 post_failure hook，然后执行 always hook
+// 命令失败只记录日志，不影响备份结果和后面的命令；备份被取消（如 daemon 退出）时仍然执行
+func (c Config) RunPost(ctx context.Context, env Env, failed bool) {
+	ctx = context.WithoutCancel(ctx)
+	stage, commands := StagePostSuccess, c.PostSuccess
+	if failed {
+		stage, commands = StagePostFailure, c.PostFailure
+	}
+	for _, command := range commands {
+		c.run(ctx, stage, command, env)
+	}
+	for _, command := range c.Always {
+		c.run(ctx, StageAlways, command, env)
+	}
+}
+
+// run 通过 sh -c 执行一个命令，并将命令输出（标准输出和标准错误）记录到日志中
+func (c Config) run(ctx context.Context, stage, command string, env Env) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	logger.Info("正在执行 hook", "stage", stage, "command", command)
+	output := &tailBuffer{}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = env.environ(stage)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// 在新的进程组中执行，超时时终止整个进程组（包括 sh 启动的子进程）
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// 子进程脱离进程组后仍然持有输出管道时，等待一段时间后不再读取输出
+	cmd.WaitDelay = 5 * time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("执行超时（%s）", timeout)
+	}
+	attrs := []any{"stage", stage, "command", command, "duration", time.Since(start).Round(time.Millisecond)}
+	if out := strings.TrimSpace(output.String()); out != "" {
+		attrs = append(attrs, "output", out)
+	}
+	if err != nil {
+		logger.Error("hook 执行失败", append(attrs, "error", err)...)
+		return err
+	}
+	logger.Info("hook 执行完成", attrs...)
+	return nil
+}
+
+// tailBuffer 只保留最后 maxOutput 字节的输出
+type tailBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf.Write(p)
+	if n := t.buf.Len() - maxOutput; n > 0 {
+		t.buf.Next(n)
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	if t.truncated {
+		return "...（省略前面的输出）\n" + t.buf.String()
+	}
+	return t.buf.String()
+}
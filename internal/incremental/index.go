@@ -0,0 +1,288 @@
+package incremental
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"backup-to-oss/internal/compress"
+	"backup-to-oss/internal/crypt"
+
+	"github.com/rboyer/safeio"
+)
+
+// 备份级别
+const (
+	LevelFull        = "full"        // 完整备份：归档包含目录中的所有文件
+	LevelIncremental = "incremental" // 增量备份：归档只包含上一次备份之后新增或修改的文件
+)
+
+// IndexSuffix 与目录备份文件一起上传的文件索引的后缀（zstd 压缩的 JSON，备份文件加密时索引同样加密并添加 .age 后缀）
+const IndexSuffix = ".index.json.zst"
+
+// DefaultFullInterval 默认的完整备份间隔
+const DefaultFullInterval = 7 * 24 * time.Hour
+
+// indexVersion 文件索引格式版本
+const indexVersion = 1
+
+// Entry 文件索引中的一个文件、目录或符号链接
+type Entry struct {
+	Path    string      `json:"path"`             // 相对于备份目录的路径（使用 / 分隔）
+	Mode    os.FileMode `json:"mode"`             // 文件类型和权限
+	Size    int64       `json:"size"`             // 文件大小（字节）
+	ModTime time.Time   `json:"mtime"`            // 修改时间
+	Inode   uint64      `json:"inode,omitempty"`  // inode 编号
+	Link    string      `json:"link,omitempty"`   // 符号链接的目标
+	SHA256  string      `json:"sha256,omitempty"` // 普通文件内容的 SHA-256（十六进制）
+}
+
+// Index 一次目录备份的文件索引：备份时目录中的所有文件，以及增量备份相对上一次备份删除的文件
+type Index struct {
+	Version   int       `json:"version"`
+	Key       string    `json:"key"`              // 备份文件的对象键
+	Level     string    `json:"level"`            // 备份级别 (full/incremental)
+	Base      string    `json:"base"`             // 所属备份链的完整备份的对象键（完整备份为自身）
+	BaseTime  time.Time `json:"base_time"`        // 完整备份的时间
+	Parent    string    `json:"parent,omitempty"` // 上一个备份的对象键（完整备份为空）
+	Dir       string    `json:"dir"`              // 备份目录
+	CreatedAt time.Time `json:"created_at"`       // 备份时间
+	Changed   int       `json:"changed"`          // 写入归档的文件数量
+	Files     []Entry   `json:"files"`            // 备份时目录中的所有文件（包括未写入归档的文件）
+	Deleted   []string  `json:"deleted"`          // 上一次备份之后删除（或类型改变）的路径，恢复时在解压前删除
+}
+
+// Metadata 返回写入运行报告的备份级别和文件数量
+func (idx *Index) Metadata() map[string]any {
+	metadata := map[string]any{
+		"level":   idx.Level,
+		"files":   len(idx.Files),
+		"changed": idx.Changed,
+		"deleted": len(idx.Deleted),
+	}
+	if idx.Level == LevelIncremental {
+		metadata["base"] = idx.Base
+		metadata["parent"] = idx.Parent
+	}
+	return metadata
+}
+
+// SetKey 记录备份文件的对象键，完整备份同时作为备份链的完整备份
+func (idx *Index) SetKey(key string) {
+	idx.Key = key
+	if idx.Level == LevelFull {
+		idx.Base = key
+	}
+}
+
+// IndexKey 返回备份文件对应的文件索引的对象键
+func IndexKey(backupKey string) string {
+	if strings.HasSuffix(backupKey, crypt.Suffix) {
+		return backupKey + IndexSuffix + crypt.Suffix
+	}
+	return backupKey + IndexSuffix
+}
+
+// IsIndex 判断对象名是否为文件索引
+func IsIndex(name string) bool {
+	return strings.HasSuffix(name, IndexSuffix) || strings.HasSuffix(name, IndexSuffix+crypt.Suffix)
+}
+
+// Encode 将文件索引编码为 zstd 压缩的 JSON 写入 w
+func Encode(w io.Writer, idx *Index) error {
+	zw, err := compress.NewWriter(w, "zstd")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(zw).Encode(idx); err != nil {
+		zw.Close()
+		return fmt.Errorf("编码文件索引失败: %v", err)
+	}
+	return zw.Close()
+}
+
+// Decode 从 r 读取 zstd 压缩的 JSON 文件索引
+func Decode(r io.Reader) (*Index, error) {
+	zr, err := compress.NewReader(r, "zstd")
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var idx Index
+	if err := json.NewDecoder(zr).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("解析文件索引失败: %v", err)
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("不支持的文件索引版本: %d", idx.Version)
+	}
+	return &idx, nil
+}
+
+// Scanner 打包目录时记录文件索引，并与上一次备份的文件索引比较，只将新增或修改的文件写入归档
+// 文件的类型、权限、大小、修改时间、inode 或符号链接目标任意一项改变即视为修改
+type Scanner struct {
+	index   *Index
+	prev    map[string]Entry // 上一次备份的文件（完整备份时为 nil）
+	seen    map[string]bool  // 本次备份中存在的文件
+	hashers []pendingHash    // 写入归档的普通文件（归档写入完成后计算 SHA-256）
+	done    bool
+}
+
+// pendingHash 写入归档时计算摘要的文件
+type pendingHash struct {
+	index  int
+	hasher hash.Hash
+}
+
+// NewScanner 创建 Scanner，prev 为上一次备份的文件索引，为 nil 时为完整备份
+func NewScanner(dir string, prev *Index, now time.Time) *Scanner {
+	s := &Scanner{
+		index: &Index{
+			Version:   indexVersion,
+			Level:     LevelFull,
+			BaseTime:  now,
+			Dir:       dir,
+			CreatedAt: now,
+			Files:     []Entry{},
+			Deleted:   []string{},
+		},
+		seen: make(map[string]bool),
+	}
+	if prev != nil {
+		s.index.Level = LevelIncremental
+		s.index.Base = prev.Base
+		s.index.BaseTime = prev.BaseTime
+		s.index.Parent = prev.Key
+		s.prev = make(map[string]Entry, len(prev.Files))
+		for _, e := range prev.Files {
+			s.prev[e.Path] = e
+		}
+	}
+	return s
+}
+
+// Incremental 是否为增量备份
+func (s *Scanner) Incremental() bool {
+	return s.index.Level == LevelIncremental
+}
+
+// Filter 实现 compress.Filter：记录文件，并判断文件是否需要写入归档
+func (s *Scanner) Filter(relPath string, info os.FileInfo, link string) (bool, io.Writer) {
+	entry := Entry{
+		Path:    filepath.ToSlash(relPath),
+		Mode:    info.Mode(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Link:    link,
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.Inode = uint64(stat.Ino)
+	}
+	s.seen[entry.Path] = true
+
+	if old, ok := s.prev[entry.Path]; ok {
+		if old.Mode == entry.Mode && old.Size == entry.Size && old.ModTime.Equal(entry.ModTime) && old.Inode == entry.Inode && old.Link == entry.Link {
+			entry.SHA256 = old.SHA256
+			s.index.Files = append(s.index.Files, entry)
+			return false, nil
+		}
+		// 类型改变（如文件变为目录）时先删除原来的路径，再解压新的文件
+		if old.Mode.Type() != entry.Mode.Type() {
+			s.index.Deleted = append(s.index.Deleted, entry.Path)
+		}
+	}
+
+	s.index.Changed++
+	s.index.Files = append(s.index.Files, entry)
+	if !entry.Mode.IsRegular() {
+		return true, nil
+	}
+	hasher := sha256.New()
+	s.hashers = append(s.hashers, pendingHash{index: len(s.index.Files) - 1, hasher: hasher})
+	return true, hasher
+}
+
+// Finish 归档写入完成后计算写入归档的文件的 SHA-256 和删除的文件，返回文件索引（可以多次调用）
+func (s *Scanner) Finish() *Index {
+	if s.done {
+		return s.index
+	}
+	s.done = true
+	for _, p := range s.hashers {
+		s.index.Files[p.index].SHA256 = hex.EncodeToString(p.hasher.Sum(nil))
+	}
+	s.hashers = nil
+	for path := range s.prev {
+		if !s.seen[path] {
+			s.index.Deleted = append(s.index.Deleted, path)
+		}
+	}
+	slices.Sort(s.index.Deleted)
+	return s.index
+}
+
+// Index 返回文件索引（Finish 之前文件的 SHA-256 和删除的文件不完整）
+func (s *Scanner) Index() *Index {
+	return s.index
+}
+
+// DefaultCacheDir 返回文件索引的默认缓存目录（用户缓存目录下的 backup-to-oss/index），无法获取时返回空字符串（不使用缓存）
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "backup-to-oss", "index")
+}
+
+// cachePath 返回备份来源的最新文件索引的缓存文件路径（同一备份目标、主机和来源只保留一个）
+func cachePath(cacheDir, dest, host, source string) string {
+	sum := sha1.Sum([]byte(dest + "|" + host + "|" + source))
+	return filepath.Join(cacheDir, fmt.Sprintf("index-%x.json.zst", sum[:8]))
+}
+
+// LoadCache 读取缓存的最新文件索引，不存在时返回 nil
+func LoadCache(cacheDir, dest, host, source string) (*Index, error) {
+	if cacheDir == "" {
+		return nil, nil
+	}
+	f, err := os.Open(cachePath(cacheDir, dest, host, source))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// SaveCache 缓存最新的文件索引（不加密），下一次增量备份时不需要从备份目标下载
+func SaveCache(cacheDir, dest, host, source string, idx *Index) error {
+	if cacheDir == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, idx); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return fmt.Errorf("创建文件索引缓存目录失败: %v", err)
+	}
+	if _, err := safeio.WriteToFile(&buf, cachePath(cacheDir, dest, host, source), 0600); err != nil {
+		return fmt.Errorf("写入文件索引缓存失败: %v", err)
+	}
+	return nil
+}
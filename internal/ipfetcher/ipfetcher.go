@@ -1,6 +1,7 @@
 package ipfetcher
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,24 +10,32 @@ import (
 	"time"
 
 	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/retry"
 )
 
 const (
 	maxRetries = 3
+	retryDelay = 2 * time.Second
 	timeout    = 10 * time.Second
 )
 
 // PublicIPFetcher 公网IP获取器
 type PublicIPFetcher struct {
-	maxRetries int
-	timeout    time.Duration
+	policy  retry.Policy
+	timeout time.Duration
 }
 
 // NewPublicIPFetcher 创建新的公网IP获取器
 func NewPublicIPFetcher() *PublicIPFetcher {
 	return &PublicIPFetcher{
-		maxRetries: maxRetries,
-		timeout:    timeout,
+		policy: retry.Policy{
+			MaxAttempts:  maxRetries,
+			InitialDelay: retryDelay,
+			MaxDelay:     retryDelay,
+			Retryable:    retry.RetryAll,
+			Quiet:        true,
+		},
+		timeout: timeout,
 	}
 }
 
@@ -64,40 +73,13 @@ func (f *PublicIPFetcher) fromIPInfo() (string, error) {
 // fromHTTPBin 从 httpbin.org 获取公网IP
 func (f *PublicIPFetcher) fromHTTPBin() (string, error) {
 	url := "https://httpbin.org/ip"
-	client := &http.Client{Timeout: f.timeout}
-
-	for attempt := 0; attempt < f.maxRetries; attempt++ {
-		resp, err := client.Get(url)
-		if err != nil {
-			if attempt < f.maxRetries-1 {
-				time.Sleep(f.sleepDuration(attempt))
-				continue
-			}
-			return "", err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			if attempt < f.maxRetries-1 {
-				time.Sleep(f.sleepDuration(attempt))
-				continue
-			}
-			return "", fmt.Errorf("HTTP status: %d", resp.StatusCode)
-		}
-
-		var data struct {
-			Origin string `json:"origin"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-			return "", err
-		}
-
-		if data.Origin != "" {
-			return strings.TrimSpace(data.Origin), nil
-		}
+	var data struct {
+		Origin string `json:"origin"`
 	}
-
-	return "", fmt.Errorf("failed to get IP from httpbin")
+	if err := f.fetchJSON(url, &data, func() bool { return data.Origin != "" }); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(data.Origin), nil
 }
 
 // fromIPSB 从 ip.sb 获取公网IP
@@ -109,85 +91,68 @@ func (f *PublicIPFetcher) fromIPSB() (string, error) {
 // fromIPScanAdspower 从 ip-scan.adspower.net 获取公网IP
 func (f *PublicIPFetcher) fromIPScanAdspower() (string, error) {
 	url := "https://ip-scan.adspower.net/sys/config/ip/get-visitor-ip"
-	client := &http.Client{Timeout: f.timeout}
+	var data struct {
+		Data struct {
+			IP string `json:"ip"`
+		} `json:"data"`
+	}
+	if err := f.fetchJSON(url, &data, func() bool { return data.Data.IP != "" }); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(data.Data.IP), nil
+}
 
-	for attempt := 0; attempt < f.maxRetries; attempt++ {
-		resp, err := client.Get(url)
+// fetchSimple 从简单文本API获取IP
+func (f *PublicIPFetcher) fetchSimple(url string) (string, error) {
+	var ip string
+	err := f.fetch(url, func(body io.Reader) error {
+		data, err := io.ReadAll(body)
 		if err != nil {
-			if attempt < f.maxRetries-1 {
-				time.Sleep(f.sleepDuration(attempt))
-				continue
-			}
-			return "", err
+			return retry.Permanent(err)
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			if attempt < f.maxRetries-1 {
-				time.Sleep(f.sleepDuration(attempt))
-				continue
-			}
-			return "", fmt.Errorf("HTTP status: %d", resp.StatusCode)
+		ip = strings.TrimSpace(string(data))
+		if ip == "" {
+			return fmt.Errorf("empty response")
 		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return ip, nil
+}
 
-		var data struct {
-			Data struct {
-				IP string `json:"ip"`
-			} `json:"data"`
+// fetchJSON 从 JSON API 获取数据，ok 返回 false 时视为未获取到IP并重试
+func (f *PublicIPFetcher) fetchJSON(url string, v any, ok func() bool) error {
+	return f.fetch(url, func(body io.Reader) error {
+		if err := json.NewDecoder(body).Decode(v); err != nil {
+			return retry.Permanent(err)
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-			return "", err
+		if !ok() {
+			return fmt.Errorf("empty response")
 		}
-
-		if data.Data.IP != "" {
-			return strings.TrimSpace(data.Data.IP), nil
-		}
-	}
-
-	return "", fmt.Errorf("failed to get IP from ip-scan.adspower")
+		return nil
+	})
 }
 
-// fetchSimple 从简单文本API获取IP
-func (f *PublicIPFetcher) fetchSimple(url string) (string, error) {
+// fetch 请求 url 并使用 parse 解析响应，请求失败或状态码不为 200 时重试
+func (f *PublicIPFetcher) fetch(url string, parse func(body io.Reader) error) error {
 	client := &http.Client{Timeout: f.timeout}
 
-	for attempt := 0; attempt < f.maxRetries; attempt++ {
-		resp, err := client.Get(url)
+	return retry.Do(context.Background(), f.policy, "获取公网IP", func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 		if err != nil {
-			if attempt < f.maxRetries-1 {
-				time.Sleep(f.sleepDuration(attempt))
-				continue
-			}
-			return "", err
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			if attempt < f.maxRetries-1 {
-				time.Sleep(f.sleepDuration(attempt))
-				continue
-			}
-			return "", fmt.Errorf("HTTP status: %d", resp.StatusCode)
+			return retry.Permanent(err)
 		}
-
-		body, err := io.ReadAll(resp.Body)
+		resp, err := client.Do(req)
 		if err != nil {
-			return "", err
+			return err
 		}
+		defer resp.Body.Close()
 
-		ip := strings.TrimSpace(string(body))
-		if ip != "" {
-			return ip, nil
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("HTTP status: %d", resp.StatusCode)
 		}
-	}
-
-	return "", fmt.Errorf("failed to get IP")
+		return parse(resp.Body)
+	})
 }
-
-// sleepDuration 计算重试等待时间（带抖动）
-func (f *PublicIPFetcher) sleepDuration(attempt int) time.Duration {
-	base := 2 * time.Second
-	jitter := time.Duration(attempt) * 100 * time.Millisecond
-	return base + jitter
-}
-
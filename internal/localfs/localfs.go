@@ -0,0 +1,222 @@
+package localfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/storage"
+
+	"github.com/rboyer/safeio"
+)
+
+// tempFilePattern 匹配 safeio 写入过程中产生的临时文件（如 name.tmp123456）
+var tempFilePattern = regexp.MustCompile(`\.tmp\d+$`)
+
+// Config 本地文件系统存储配置
+type Config struct {
+	RootDir string // 备份根目录，可以是本地目录或 NFS 挂载目录
+}
+
+// Storage 本地文件系统 / NFS 存储后端，实现 storage.Storage 接口
+// 文件写入使用临时文件 + 重命名的方式保证原子性
+type Storage struct {
+	config Config
+}
+
+// New 创建本地文件系统存储后端
+func New(config Config) (*Storage, error) {
+	if config.RootDir == "" {
+		return nil, fmt.Errorf("备份根目录未设置")
+	}
+	if !filepath.IsAbs(config.RootDir) {
+		return nil, fmt.Errorf("备份根目录必须是绝对路径: %s", config.RootDir)
+	}
+	return &Storage{config: config}, nil
+}
+
+// String 返回目标地址
+func (s *Storage) String() string {
+	return "file://" + filepath.ToSlash(s.config.RootDir)
+}
+
+// filePath 将对象键转换为本地文件路径，拒绝越出根目录的键
+func (s *Storage) filePath(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("无效的对象键: %q", key)
+	}
+	return filepath.Join(s.config.RootDir, filepath.FromSlash(cleaned)), nil
+}
+
+// metaPath 返回对象元数据文件路径（与对象同目录的隐藏文件）
+func metaPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".meta")
+}
+
+// Put 从 reader 写入对象
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+	logger.Info("本地存储路径", "path", filePath)
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %v", err)
+	}
+
+	// 先写入元数据，确保对象可见时元数据已经存在；没有元数据时删除覆盖前对象的元数据
+	if len(opts.Metadata) > 0 {
+		data, err := json.Marshal(opts.Metadata)
+		if err != nil {
+			return fmt.Errorf("序列化元数据失败: %v", err)
+		}
+		if _, err := safeio.WriteToFile(bytes.NewReader(data), metaPath(filePath), 0600); err != nil {
+			return fmt.Errorf("写入元数据失败: %v", err)
+		}
+	} else if err := os.Remove(metaPath(filePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("删除元数据失败: %v", err)
+	}
+
+	if _, err := safeio.WriteToFile(r, filePath, 0600); err != nil {
+		return fmt.Errorf("写入文件失败: %v", err)
+	}
+	return nil
+}
+
+// Get 读取对象
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, wrapError(key, err)
+	}
+	return f, nil
+}
+
+// List 列出指定前缀下的所有对象
+func (s *Storage) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	// 前缀可能只是文件名的一部分，从前缀所在的目录开始遍历
+	prefix = strings.TrimPrefix(prefix, "/")
+	walkDir := s.config.RootDir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		walkDir = filepath.Join(s.config.RootDir, filepath.FromSlash(path.Clean("/"+prefix[:i])))
+	}
+
+	var objects []storage.ObjectInfo
+	err := filepath.WalkDir(walkDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		// 跳过元数据文件和未完成写入的临时文件
+		name := d.Name()
+		if strings.HasPrefix(name, ".") || tempFilePattern.MatchString(name) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.config.RootDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, storage.ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("列出对象失败: %v", err)
+	}
+
+	return objects, nil
+}
+
+// Delete 删除对象及其元数据
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("删除对象失败: %v", err)
+	}
+	if err := os.Remove(metaPath(filePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("删除元数据失败: %v", err)
+	}
+	return nil
+}
+
+// Stat 获取对象信息
+func (s *Storage) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	filePath, err := s.filePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, wrapError(key, err)
+	}
+
+	info := &storage.ObjectInfo{
+		Key:          key,
+		Size:         fileInfo.Size(),
+		LastModified: fileInfo.ModTime(),
+		Metadata:     make(map[string]string),
+	}
+	if data, err := os.ReadFile(metaPath(filePath)); err == nil {
+		if err := json.Unmarshal(data, &info.Metadata); err != nil {
+			return nil, fmt.Errorf("解析元数据失败: %v", err)
+		}
+	}
+
+	return info, nil
+}
+
+// wrapError 将文件不存在错误转换为 storage.ErrNotExist
+func wrapError(key string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", storage.ErrNotExist, key)
+	}
+	return fmt.Errorf("访问对象失败: %s, %v", key, err)
+}
+
+// 确保 Storage 实现了 storage.Storage 接口
+var _ storage.Storage = (*Storage)(nil)
@@ -0,0 +1,179 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"backup-to-oss/internal/storage"
+)
+
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s, err := New(Config{RootDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+func TestNewRejectsRelativeRoot(t *testing.T) {
+	if _, err := New(Config{RootDir: "backup"}); err == nil {
+		t.Fatal("expected error for relative root dir")
+	}
+	if _, err := New(Config{}); err == nil {
+		t.Fatal("expected error for empty root dir")
+	}
+}
+
+func TestPutGetStat(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	key := "1.2.3.4/20250101/20250101-020000_etc.tar.zst"
+	metadata := map[string]string{"sha256": "abc"}
+
+	if err := s.Put(ctx, key, strings.NewReader("hello"), storage.PutOptions{Metadata: metadata}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Get = %q, %v; want %q", data, err, "hello")
+	}
+
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != key || info.Size != 5 || info.Metadata["sha256"] != "abc" {
+		t.Fatalf("Stat = %+v", info)
+	}
+
+	// 覆盖写入
+	if err := s.Put(ctx, key, strings.NewReader("hello world"), storage.PutOptions{}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// 没有元数据的覆盖写入不保留之前的元数据
+	if info, err := s.Stat(ctx, key); err != nil || info.Size != 11 || len(info.Metadata) != 0 {
+		t.Fatalf("Stat after overwrite = %+v, %v", info, err)
+	}
+}
+
+func TestPutCanceled(t *testing.T) {
+	s := newTestStorage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Put(ctx, "a", strings.NewReader("x"), storage.PutOptions{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Put = %v, want context.Canceled", err)
+	}
+}
+
+func TestNotExist(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	if _, err := s.Get(ctx, "missing"); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Get = %v, want ErrNotExist", err)
+	}
+	if _, err := s.Stat(ctx, "missing"); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Stat = %v, want ErrNotExist", err)
+	}
+	// 删除不存在的对象不报错
+	if err := s.Delete(ctx, "missing"); err != nil {
+		t.Fatalf("Delete = %v", err)
+	}
+}
+
+func TestKeyCannotEscapeRoot(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	if err := s.Put(ctx, "../../escape", strings.NewReader("x"), storage.PutOptions{}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.config.RootDir, "escape")); err != nil {
+		t.Fatalf("object should be written inside root: %v", err)
+	}
+	if err := s.Put(ctx, "/", strings.NewReader("x"), storage.PutOptions{}); err == nil {
+		t.Fatal("expected error for empty key")
+	}
+}
+
+func TestList(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	keys := []string{
+		"1.2.3.4/20250101/a.tar.zst",
+		"1.2.3.4/20250102/b.tar.zst",
+		"5.6.7.8/20250101/c.tar.zst",
+	}
+	for _, key := range keys {
+		if err := s.Put(ctx, key, strings.NewReader(key), storage.PutOptions{Metadata: map[string]string{"k": "v"}}); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	// 未完成写入的临时文件不应被列出
+	tmp := filepath.Join(s.config.RootDir, "1.2.3.4", "20250101", "d.tar.zst.tmp123456")
+	if err := os.WriteFile(tmp, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", keys},
+		{"1.2.3.4/", keys[:2]},
+		{"1.2.3.4/2025010", keys[:2]},
+		{"1.2.3.4/20250102/", keys[1:2]},
+		{"9.9.9.9/", nil},
+	}
+	for _, tt := range tests {
+		objects, err := s.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", tt.prefix, err)
+		}
+		var got []string
+		for _, obj := range objects {
+			got = append(got, obj.Key)
+			if obj.Size != int64(len(obj.Key)) {
+				t.Errorf("List(%q): %s size = %d", tt.prefix, obj.Key, obj.Size)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	key := "20250101/a.tar"
+	if err := s.Put(ctx, key, strings.NewReader("x"), storage.PutOptions{Metadata: map[string]string{"k": "v"}}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	filePath, _ := s.filePath(key)
+	if _, err := os.Stat(metaPath(filePath)); err != nil {
+		t.Fatalf("metadata file missing: %v", err)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Stat after Delete = %v, want ErrNotExist", err)
+	}
+	if _, err := os.Stat(metaPath(filePath)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("metadata file should be removed: %v", err)
+	}
+}
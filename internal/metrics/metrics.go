@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 指标的标签：任务名称和备份类型
+var labels = []string{"job", "type"}
+
+var (
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backup_last_success_timestamp_seconds",
+		Help: "最近一次备份成功的时间（Unix 时间戳）",
+	}, labels)
+	lastRun = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backup_last_run_timestamp_seconds",
+		Help: "最近一次备份结束的时间（Unix 时间戳，包括失败的备份）",
+	}, labels)
+	lastDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backup_last_duration_seconds",
+		Help: "最近一次备份的执行时间（秒）",
+	}, labels)
+	archiveSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backup_archive_size_bytes",
+		Help: "最近一次成功备份的归档大小（压缩和加密后，多个归档时为总大小）",
+	}, labels)
+	compressionRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "backup_compression_ratio",
+		Help: "最近一次成功备份的压缩率（压缩后大小 / 压缩前大小）",
+	}, labels)
+	uploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "backup_upload_bytes_total",
+		Help: "成功上传到备份目标的字节数（多个备份目标时分别计算）",
+	}, labels)
+	failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "backup_failures_total",
+		Help: "备份失败的次数",
+	}, labels)
+)
+
+// Registry 备份指标的注册表（不包含 Go 运行时指标）
+var Registry = prometheus.NewRegistry()
+
+// gauges 和 counters 按指标名称索引，用于从 textfile 恢复指标
+var (
+	gauges   = map[string]*prometheus.GaugeVec{}
+	counters = map[string]*prometheus.CounterVec{}
+)
+
+func init() {
+	for name, g := range map[string]*prometheus.GaugeVec{
+		"backup_last_success_timestamp_seconds": lastSuccess,
+		"backup_last_run_timestamp_seconds":     lastRun,
+		"backup_last_duration_seconds":          lastDuration,
+		"backup_archive_size_bytes":             archiveSize,
+		"backup_compression_ratio":              compressionRatio,
+	} {
+		gauges[name] = g
+		Registry.MustRegister(g)
+	}
+	for name, c := range map[string]*prometheus.CounterVec{
+		"backup_upload_bytes_total": uploadBytes,
+		"backup_failures_total":     failures,
+	} {
+		counters[name] = c
+		Registry.MustRegister(c)
+	}
+}
+
+// Run 记录一次备份的统计数据，方法可以在 nil 上调用（不记录）
+type Run struct {
+	mu          sync.Mutex
+	host        string   // 公网 IP
+	objects     []string // 上传成功的对象键
+	archiveSize int64    // 归档大小（压缩和加密后）
+	sourceSize  int64    // 压缩前的大小
+	uploaded    int64    // 成功上传的字节数
+}
+
+// Summary 一次备份的统计数据
+type Summary struct {
+	Host        string
+	Objects     []string
+	ArchiveSize int64
+	SourceSize  int64
+	Uploaded    int64
+}
+
+// SetHost 记录备份所在主机的公网 IP
+func (r *Run) SetHost(host string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.host = host
+	r.mu.Unlock()
+}
+
+// AddObject 记录上传成功（至少一个备份目标）的对象键
+func (r *Run) AddObject(key string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.objects = append(r.objects, key)
+	r.mu.Unlock()
+}
+
+// AddArchive 记录一个归档的大小和压缩前的大小（未知时为 0）
+func (r *Run) AddArchive(size, sourceSize int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.archiveSize += size
+	r.sourceSize += sourceSize
+	r.mu.Unlock()
+}
+
+// AddUploaded 记录成功上传的字节数
+func (r *Run) AddUploaded(n int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.uploaded += n
+	r.mu.Unlock()
+}
+
+// Summary 返回统计数据
+func (r *Run) Summary() Summary {
+	if r == nil {
+		return Summary{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Summary{
+		Host:        r.host,
+		Objects:     slices.Clone(r.objects),
+		ArchiveSize: r.archiveSize,
+		SourceSize:  r.sourceSize,
+		Uploaded:    r.uploaded,
+	}
+}
+
+// Observe 记录一次备份的结果
+func Observe(job, backupType string, duration time.Duration, r *Run, err error) {
+	if r == nil {
+		r = &Run{}
+	}
+	now := float64(time.Now().Unix())
+	lastRun.WithLabelValues(job, backupType).Set(now)
+	lastDuration.WithLabelValues(job, backupType).Set(duration.Seconds())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	uploadBytes.WithLabelValues(job, backupType).Add(float64(r.uploaded))
+	if err != nil {
+		failures.WithLabelValues(job, backupType).Inc()
+		return
+	}
+	// 失败次数从 0 开始，便于使用 increase() 告警
+	failures.WithLabelValues(job, backupType)
+	lastSuccess.WithLabelValues(job, backupType).Set(now)
+	archiveSize.WithLabelValues(job, backupType).Set(float64(r.archiveSize))
+	if r.sourceSize > 0 {
+		compressionRatio.WithLabelValues(job, backupType).Set(float64(r.archiveSize) / float64(r.sourceSize))
+	}
+}
+
+type contextKey struct{}
+
+// WithRun 返回携带统计记录的 context
+func WithRun(ctx context.Context, r *Run) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext 返回 context 中的统计记录，没有时返回 nil
+func FromContext(ctx context.Context) *Run {
+	r, _ := ctx.Value(contextKey{}).(*Run)
+	return r
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/rboyer/safeio"
+)
+
+// LoadTextfile 从 node_exporter textfile collector 使用的指标文件中恢复指标
+// 单次运行的命令（如 crontab 中执行的备份）通过该文件累加失败次数、保留其他任务的指标；文件不存在时不报错
+func LoadTextfile(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取指标文件失败: %v", err)
+	}
+	defer f.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return fmt.Errorf("解析指标文件 %s 失败: %v", path, err)
+	}
+	for name, family := range families {
+		for _, m := range family.GetMetric() {
+			values := make(map[string]string)
+			for _, label := range m.GetLabel() {
+				values[label.GetName()] = label.GetValue()
+			}
+			job, backupType := values["job"], values["type"]
+			if g, ok := gauges[name]; ok {
+				g.WithLabelValues(job, backupType).Set(m.GetGauge().GetValue())
+			}
+			if c, ok := counters[name]; ok {
+				c.WithLabelValues(job, backupType).Add(m.GetCounter().GetValue())
+			}
+		}
+	}
+	return nil
+}
+
+// WriteTextfile 将指标写入 node_exporter textfile collector 使用的指标文件（先写入临时文件再重命名）
+func WriteTextfile(path string) error {
+	families, err := Registry.Gather()
+	if err != nil {
+		return fmt.Errorf("收集指标失败: %v", err)
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			return fmt.Errorf("生成指标失败: %v", err)
+		}
+	}
+	if _, err := safeio.WriteToFile(&buf, path, 0644); err != nil {
+		return fmt.Errorf("写入指标文件失败: %v", err)
+	}
+	return nil
+}
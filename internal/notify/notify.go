@@ -0,0 +1,207 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"backup-to-oss/internal/logger"
+)
+
+// 发送通知的时机
+const (
+	OnFailure = "failure" // 只在备份失败时发送（默认）
+	OnAlways  = "always"  // 备份成功和失败时都发送
+	OnNever   = "never"   // 不发送
+)
+
+// 备份结果
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusPartial = "partial" // 部分目录或文件备份失败
+)
+
+// sendTimeout 发送一条通知的超时时间
+const sendTimeout = 30 * time.Second
+
+// Config 通知配置
+type Config struct {
+	On             string     // 发送通知的时机 (failure/always/never)，默认为 failure
+	Template       string     // 消息模板文件路径（Go text/template 格式，为空时使用默认模板）
+	WebhookURL     string     // 通用 JSON webhook 地址
+	DingTalkURL    string     // 钉钉机器人 webhook 地址
+	DingTalkSecret string     // 钉钉机器人加签密钥（可选）
+	FeishuURL      string     // 飞书机器人 webhook 地址
+	FeishuSecret   string     // 飞书机器人签名校验密钥（可选）
+	SlackURL       string     // Slack incoming webhook 地址
+	SMTP           SMTPConfig // 邮件通知配置
+}
+
+// Enabled 判断是否配置了通知渠道
+func (c Config) Enabled() bool {
+	return c.WebhookURL != "" || c.DingTalkURL != "" || c.FeishuURL != "" || c.SlackURL != "" || len(c.SMTP.To) > 0
+}
+
+// Validate 校验通知配置
+func (c Config) Validate() error {
+	switch c.On {
+	case "", OnFailure, OnAlways, OnNever:
+	default:
+		return fmt.Errorf("不支持的通知时机: %s，支持: %s, %s, %s", c.On, OnFailure, OnAlways, OnNever)
+	}
+	if len(c.SMTP.To) > 0 {
+		if c.SMTP.Host == "" {
+			return fmt.Errorf("发送邮件通知需要设置 SMTP 服务器地址（NOTIFY_SMTP_HOST）")
+		}
+		if c.SMTP.From == "" {
+			return fmt.Errorf("发送邮件通知需要设置发件人（NOTIFY_SMTP_FROM）")
+		}
+	}
+	return nil
+}
+
+// Event 一次备份的结果
+type Event struct {
+	Job       string        `json:"job"`
+	Type      string        `json:"type"`            // 备份类型 (dir/file/etcd/consul)
+	Status    string        `json:"status"`          // success/failure/partial
+	Host      string        `json:"host,omitempty"`  // 公网 IP（获取失败时为空）
+	Hostname  string        `json:"hostname"`        // 主机名
+	Objects   []string      `json:"objects"`         // 上传的对象键
+	Size      int64         `json:"size_bytes"`      // 归档大小（压缩和加密后）
+	StartedAt time.Time     `json:"started_at"`      // 开始时间
+	Duration  time.Duration `json:"-"`               // 执行时间
+	Error     string        `json:"error,omitempty"` // 失败原因
+}
+
+// Failed 判断备份是否失败（包括部分失败）
+func (e Event) Failed() bool {
+	return e.Status != StatusSuccess
+}
+
+// Title 返回通知标题（邮件主题）
+func (e Event) Title() string {
+	result := "备份成功"
+	switch e.Status {
+	case StatusFailure:
+		result = "备份失败"
+	case StatusPartial:
+		result = "备份部分失败"
+	}
+	return fmt.Sprintf("[backup-to-oss] %s %s: %s", e.Hostname, result, e.Job)
+}
+
+// notifier 通知渠道
+type notifier interface {
+	// send 发送通知，message 为按模板生成的消息内容
+	send(ctx context.Context, event Event, message string) error
+	String() string
+}
+
+// Dispatcher 按配置将备份结果发送到所有通知渠道
+type Dispatcher struct {
+	on        string
+	template  *template.Template
+	notifiers []notifier
+}
+
+// New 根据通知配置创建 Dispatcher，没有配置通知渠道时返回 nil（方法可以在 nil 上调用）
+func New(cfg Config) (*Dispatcher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled() || cfg.On == OnNever {
+		return nil, nil
+	}
+
+	text := defaultTemplate
+	if cfg.Template != "" {
+		data, err := os.ReadFile(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("读取通知模板失败: %v", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("notify").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("解析通知模板失败: %v", err)
+	}
+
+	d := &Dispatcher{on: cfg.On, template: tmpl}
+	if d.on == "" {
+		d.on = OnFailure
+	}
+	if cfg.WebhookURL != "" {
+		d.notifiers = append(d.notifiers, &webhook{url: cfg.WebhookURL})
+	}
+	if cfg.DingTalkURL != "" {
+		d.notifiers = append(d.notifiers, &dingTalk{url: cfg.DingTalkURL, secret: cfg.DingTalkSecret})
+	}
+	if cfg.FeishuURL != "" {
+		d.notifiers = append(d.notifiers, &feishu{url: cfg.FeishuURL, secret: cfg.FeishuSecret})
+	}
+	if cfg.SlackURL != "" {
+		d.notifiers = append(d.notifiers, &slack{url: cfg.SlackURL})
+	}
+	if len(cfg.SMTP.To) > 0 {
+		d.notifiers = append(d.notifiers, &email{cfg: cfg.SMTP})
+	}
+	return d, nil
+}
+
+// Notify 按配置的时机发送通知，发送失败只记录日志，不影响备份结果
+// 使用独立的超时时间，备份被取消（如 daemon 退出）时仍然发送失败通知
+func (d *Dispatcher) Notify(ctx context.Context, event Event) {
+	if d == nil || (d.on == OnFailure && !event.Failed()) {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := d.template.Execute(&buf, event); err != nil {
+		logger.Warn("生成通知消息失败", "error", err)
+		return
+	}
+	message := strings.TrimSpace(buf.String())
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sendTimeout)
+	defer cancel()
+	for _, n := range d.notifiers {
+		if err := n.send(ctx, event, message); err != nil {
+			logger.Warn("发送通知失败", "notifier", n.String(), "error", err)
+			continue
+		}
+		logger.Info("已发送通知", "notifier", n.String(), "status", event.Status)
+	}
+}
+
+// postJSON 以 JSON 格式发送 POST 请求，返回响应内容（状态码不是 2xx 时返回错误）
+func postJSON(ctx context.Context, url string, body any) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("生成请求内容失败: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, nil
+}
@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// defaultTemplate 默认的消息模板，模板数据为 Event
+const defaultTemplate = `{{if eq .Status "partial"}}⚠️ 备份部分失败{{else if .Failed}}❌ 备份失败{{else}}✅ 备份成功{{end}}: {{.Job}}
+类型: {{.Type}}
+主机: {{.Hostname}}{{with .Host}} ({{.}}){{end}}
+开始时间: {{.StartedAt.Format "2006-01-02 15:04:05"}}
+耗时: {{duration .Duration}}
+{{- if .Objects}}
+大小: {{size .Size}}
+备份文件:
+{{- range .Objects}}
+- {{.}}
+{{- end}}
+{{- end}}
+{{- with .Error}}
+错误: {{.}}
+{{- end}}
+`
+
+// templateFuncs 消息模板中可以使用的函数
+var templateFuncs = template.FuncMap{
+	// size 格式化字节数，如 12.34 MB
+	"size": func(n int64) string {
+		const unit = 1024
+		if n < unit {
+			return fmt.Sprintf("%d B", n)
+		}
+		value, exp := float64(n)/unit, 0
+		for value >= unit && exp < 3 {
+			value /= unit
+			exp++
+		}
+		return fmt.Sprintf("%.2f %cB", value, "KMGT"[exp])
+	},
+	// duration 格式化执行时间（精确到秒）
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	},
+}
@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// webhook 通用 JSON webhook，请求内容为备份结果和按模板生成的消息
+type webhook struct {
+	url string
+}
+
+func (w *webhook) String() string { return "webhook" }
+
+func (w *webhook) send(ctx context.Context, event Event, message string) error {
+	_, err := postJSON(ctx, w.url, struct {
+		Event
+		DurationSeconds float64 `json:"duration_seconds"`
+		Message         string  `json:"message"`
+	}{event, event.Duration.Seconds(), message})
+	return err
+}
+
+// slack Slack incoming webhook
+type slack struct {
+	url string
+}
+
+func (s *slack) String() string { return "slack" }
+
+func (s *slack) send(ctx context.Context, event Event, message string) error {
+	_, err := postJSON(ctx, s.url, map[string]string{"text": message})
+	return err
+}
+
+// dingTalk 钉钉自定义机器人，设置了加签密钥时在地址中添加 timestamp 和 sign 参数
+type dingTalk struct {
+	url    string
+	secret string
+}
+
+func (d *dingTalk) String() string { return "dingtalk" }
+
+func (d *dingTalk) send(ctx context.Context, event Event, message string) error {
+	target := d.url
+	if d.secret != "" {
+		u, err := url.Parse(d.url)
+		if err != nil {
+			return fmt.Errorf("无效的钉钉机器人地址: %v", err)
+		}
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write([]byte(timestamp + "\n" + d.secret))
+		query := u.Query()
+		query.Set("timestamp", timestamp)
+		query.Set("sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		u.RawQuery = query.Encode()
+		target = u.String()
+	}
+
+	body, err := postJSON(ctx, target, map[string]any{
+		"msgtype": "text",
+		"text":    map[string]string{"content": message},
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err := json.Unmarshal(body, &resp); err == nil && resp.ErrCode != 0 {
+		return fmt.Errorf("钉钉机器人返回错误: %d %s", resp.ErrCode, resp.ErrMsg)
+	}
+	return nil
+}
+
+// feishu 飞书自定义机器人，设置了签名校验密钥时在请求中添加 timestamp 和 sign
+type feishu struct {
+	url    string
+	secret string
+}
+
+func (f *feishu) String() string { return "feishu" }
+
+func (f *feishu) send(ctx context.Context, event Event, message string) error {
+	body := map[string]any{
+		"msg_type": "text",
+		"content":  map[string]string{"text": message},
+	}
+	if f.secret != "" {
+		// 飞书的签名以 timestamp + "\n" + secret 为密钥，对空字符串计算 HMAC-SHA256
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(timestamp+"\n"+f.secret))
+		body["timestamp"] = timestamp
+		body["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	respBody, err := postJSON(ctx, f.url, body)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err == nil && resp.Code != 0 {
+		return fmt.Errorf("飞书机器人返回错误: %d %s", resp.Code, resp.Msg)
+	}
+	return nil
+}
@@ -1,76 +1,419 @@
 package oss
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"path/filepath"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
 	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/storage"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 )
 
+// 分片上传默认参数
+const (
+	DefaultPartSize = 100 * 1024 * 1024 // 默认分片大小 100MB
+	DefaultRoutines = 3                 // 默认并发上传分片数
+	maxParts        = 10000             // OSS 单个对象最多 10000 个分片
+)
+
 // Config OSS配置
 type Config struct {
-	Endpoint     string
-	AccessKey    string
-	SecretKey    string
-	Bucket       string
-	ObjectPrefix string
+	Endpoint      string
+	AccessKey     string
+	SecretKey     string
+	Bucket        string
+	ObjectPrefix  string
+	PartSize      int64  // 分片大小（字节），文件超过该大小时使用分片上传
+	Routines      int    // 并发上传分片数
+	CheckpointDir string // 断点续传 checkpoint 文件目录（为空时不启用断点续传）
+	// 服务端加密方式（AES256/KMS/SM4），为空时使用存储桶的默认加密配置
+	ServerSideEncryption string
+	KMSKeyID             string // KMS 密钥 ID（仅 KMS 加密时有效，为空时使用 OSS 托管的默认密钥）
+	StorageClass         string // 存储类型（Standard/IA/Archive/ColdArchive），为空时使用存储桶的默认存储类型
+}
+
+// etagPattern 匹配内容 MD5 形式的 ETag
+var etagPattern = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// serverSideEncryptions 支持的服务端加密方式
+var serverSideEncryptions = []string{"AES256", "KMS", "SM4"}
+
+// storageClasses 支持的存储类型
+var storageClasses = []oss.StorageClassType{
+	oss.StorageStandard,
+	oss.StorageIA,
+	oss.StorageArchive,
+	oss.StorageColdArchive,
+	oss.StorageDeepColdArchive,
+}
+
+// Storage 阿里云 OSS 存储后端，实现 storage.Storage 接口
+type Storage struct {
+	config Config
+	bucket *oss.Bucket
 }
 
-// UploadFile 上传文件到OSS
-func UploadFile(filePath string, config Config) error {
+// New 创建 OSS 存储后端
+func New(config Config) (*Storage, error) {
+	if err := normalizeConfig(&config); err != nil {
+		return nil, err
+	}
+
 	// 创建OSS客户端
 	client, err := oss.New(config.Endpoint, config.AccessKey, config.SecretKey)
 	if err != nil {
-		return fmt.Errorf("创建OSS客户端失败: %v", err)
+		return nil, fmt.Errorf("创建OSS客户端失败: %v", err)
 	}
 
 	// 获取存储桶
 	bucket, err := client.Bucket(config.Bucket)
 	if err != nil {
-		return fmt.Errorf("获取存储桶失败: %v", err)
-	}
-
-	// 确定对象名称
-	objectName := config.ObjectPrefix
-	if objectName == "" {
-		// 如果没有指定前缀，使用时间戳
-		timestamp := time.Now().Format("20060102-150405")
-		fileName := filepath.Base(filePath)
-		objectName = fmt.Sprintf("backup-%s-%s", timestamp, fileName)
-	} else {
-		// 如果指定了前缀，添加文件名
-		fileName := filepath.Base(filePath)
-		// 确保前缀不以 / 开头，但可以以 / 结尾（作为目录分隔符）
-		objectName = strings.TrimPrefix(objectName, "/")
-		if !strings.HasSuffix(objectName, "/") {
-			objectName += "/"
-		}
-		objectName += fileName
-	}
-	
-	// 清理对象名称：移除多余的斜杠，确保符合OSS规范
-	// OSS对象名称不能以 / 开头，不能包含连续的 //
-	objectName = strings.TrimPrefix(objectName, "/")
-	objectName = strings.ReplaceAll(objectName, "//", "/")
-	// 确保对象名称不为空
-	if objectName == "" {
-		fileName := filepath.Base(filePath)
-		objectName = fileName
+		return nil, fmt.Errorf("获取存储桶失败: %v", err)
+	}
+
+	if config.PartSize <= 0 {
+		config.PartSize = DefaultPartSize
+	}
+	if config.Routines <= 0 {
+		config.Routines = DefaultRoutines
+	}
+
+	return &Storage{
+		config: config,
+		bucket: bucket,
+	}, nil
+}
+
+// normalizeConfig 校验服务端加密和存储类型配置，并统一大小写
+func normalizeConfig(config *Config) error {
+	if config.ServerSideEncryption != "" {
+		sse := strings.ToUpper(config.ServerSideEncryption)
+		if !slices.Contains(serverSideEncryptions, sse) {
+			return fmt.Errorf("不支持的服务端加密方式: %s（支持 %s）", config.ServerSideEncryption, strings.Join(serverSideEncryptions, "/"))
+		}
+		config.ServerSideEncryption = sse
+	}
+	if config.KMSKeyID != "" && config.ServerSideEncryption != "KMS" {
+		return fmt.Errorf("指定 KMS 密钥 ID 时服务端加密方式必须为 KMS")
+	}
+
+	if config.StorageClass != "" {
+		var matched bool
+		for _, class := range storageClasses {
+			if strings.EqualFold(config.StorageClass, string(class)) {
+				config.StorageClass = string(class)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("不支持的存储类型: %s（支持 Standard/IA/Archive/ColdArchive/DeepColdArchive）", config.StorageClass)
+		}
+	}
+	return nil
+}
+
+// String 返回目标地址
+func (s *Storage) String() string {
+	return fmt.Sprintf("oss://%s/%s", s.config.Bucket, strings.TrimPrefix(s.config.ObjectPrefix, "/"))
+}
+
+// objectName 根据对象前缀确定完整的对象名称
+// OSS对象名称不能以 / 开头，不能包含连续的 //
+func (s *Storage) objectName(key string) string {
+	return storage.JoinKey(s.config.ObjectPrefix, key)
+}
+
+// Put 从 reader 上传对象
+// 数据不超过一个分片时直接上传，否则使用分片上传，内存占用不超过 分片大小 ×（并发数 + 1）
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	objectName := s.objectName(key)
+	logger.Info("OSS上传路径", "bucket", s.config.Bucket, "object", objectName, "path", fmt.Sprintf("oss://%s/%s", s.config.Bucket, objectName))
+
+	// 先读取一个分片的数据，判断是否需要分片上传
+	head, err := io.ReadAll(io.LimitReader(r, s.config.PartSize))
+	if err != nil {
+		return fmt.Errorf("读取数据失败: %v", err)
+	}
+	if int64(len(head)) < s.config.PartSize {
+		if err := s.bucket.PutObject(objectName, bytes.NewReader(head), s.putOptions(ctx, opts)...); err != nil {
+			return fmt.Errorf("上传文件失败: %v", err)
+		}
+		return nil
+	}
+
+	if err := s.putMultipart(ctx, objectName, io.MultiReader(bytes.NewReader(head), r), opts); err != nil {
+		return fmt.Errorf("分片上传文件失败: %v", err)
+	}
+	return nil
+}
+
+// putMultipart 从 reader 按分片大小读取数据并发上传，任何错误都会中止分片上传
+func (s *Storage) putMultipart(ctx context.Context, objectName string, r io.Reader, opts storage.PutOptions) error {
+	imur, err := s.bucket.InitiateMultipartUpload(objectName, s.putOptions(ctx, opts)...)
+	if err != nil {
+		return fmt.Errorf("初始化分片上传失败: %v", err)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		parts   []oss.UploadPart
+		partErr error
+		readErr error
+	)
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return partErr != nil
+	}
+
+	// sem 限制同时持有的分片缓冲区数量
+	sem := make(chan struct{}, s.config.Routines)
+	for partNumber := 1; ; partNumber++ {
+		sem <- struct{}{}
+		if failed() {
+			<-sem
+			break
+		}
+		if partNumber > maxParts {
+			<-sem
+			readErr = fmt.Errorf("分片数量超过上限 %d，请增大分片大小", maxParts)
+			break
+		}
+
+		buf := make([]byte, s.config.PartSize)
+		n, err := io.ReadFull(r, buf)
+		if n == 0 {
+			<-sem
+			if err != io.EOF {
+				readErr = fmt.Errorf("读取数据失败: %v", err)
+			}
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			<-sem
+			readErr = fmt.Errorf("读取数据失败: %v", err)
+			break
+		}
+
+		wg.Add(1)
+		go func(partNumber int, data []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			part, err := s.bucket.UploadPart(imur, bytes.NewReader(data), int64(len(data)), partNumber, oss.WithContext(ctx))
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if partErr == nil {
+					partErr = fmt.Errorf("上传分片 %d 失败: %v", partNumber, err)
+				}
+				return
+			}
+			parts = append(parts, part)
+		}(partNumber, buf[:n])
+
+		// 最后一个分片
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	wg.Wait()
+
+	if err := errors.Join(readErr, partErr); err != nil {
+		if abortErr := s.bucket.AbortMultipartUpload(imur); abortErr != nil {
+			logger.Warn("中止分片上传失败", "object", objectName, "error", abortErr)
+		}
+		return err
 	}
 
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	if _, err := s.bucket.CompleteMultipartUpload(imur, parts, oss.WithContext(ctx)); err != nil {
+		if abortErr := s.bucket.AbortMultipartUpload(imur); abortErr != nil {
+			logger.Warn("中止分片上传失败", "object", objectName, "error", abortErr)
+		}
+		return fmt.Errorf("完成分片上传失败: %v", err)
+	}
+	return nil
+}
+
+// PutFile 上传本地文件到OSS
+// 文件大小超过分片大小时使用分片上传，配置了 checkpoint 目录时支持断点续传
+func (s *Storage) PutFile(ctx context.Context, key, filePath string, opts storage.PutOptions) error {
+	objectName := s.objectName(key)
+
 	// 打印OSS上传路径信息
-	logger.Info("OSS上传路径", "bucket", config.Bucket, "object", objectName, "path", fmt.Sprintf("oss://%s/%s", config.Bucket, objectName))
+	logger.Info("OSS上传路径", "bucket", s.config.Bucket, "object", objectName, "path", fmt.Sprintf("oss://%s/%s", s.config.Bucket, objectName))
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("获取文件信息失败: %v", err)
+	}
+
+	// 小文件直接上传
+	if fileInfo.Size() <= s.config.PartSize {
+		if err := s.bucket.PutObjectFromFile(objectName, filePath, s.putOptions(ctx, opts)...); err != nil {
+			return fmt.Errorf("上传文件失败: %v", err)
+		}
+		return nil
+	}
+
+	// 大文件分片上传
+	options := append(s.putOptions(ctx, opts), oss.Routines(s.config.Routines))
+	if s.config.CheckpointDir != "" {
+		if err := os.MkdirAll(s.config.CheckpointDir, 0755); err != nil {
+			return fmt.Errorf("创建 checkpoint 目录失败: %v", err)
+		}
+		options = append(options, oss.CheckpointDir(true, s.config.CheckpointDir))
+	}
+	logger.Info("使用分片上传",
+		"size_bytes", fileInfo.Size(),
+		"part_size", s.config.PartSize,
+		"routines", s.config.Routines,
+		"checkpoint", s.config.CheckpointDir != "")
+	if err := s.bucket.UploadFile(objectName, filePath, s.config.PartSize, options...); err != nil {
+		return fmt.Errorf("分片上传文件失败: %v", err)
+	}
+	return nil
+}
+
+// putOptions 将通用上传选项和服务端加密、存储类型配置转换为 OSS SDK 选项
+func (s *Storage) putOptions(ctx context.Context, opts storage.PutOptions) []oss.Option {
+	options := []oss.Option{oss.WithContext(ctx)}
+	for k, v := range opts.Metadata {
+		options = append(options, oss.Meta(k, v))
+	}
+	if len(opts.Tags) > 0 {
+		var tagging oss.Tagging
+		for _, k := range slices.Sorted(maps.Keys(opts.Tags)) {
+			tagging.Tags = append(tagging.Tags, oss.Tag{Key: k, Value: opts.Tags[k]})
+		}
+		options = append(options, oss.SetTagging(tagging))
+	}
+	if s.config.ServerSideEncryption != "" {
+		options = append(options, oss.ServerSideEncryption(s.config.ServerSideEncryption))
+		if s.config.KMSKeyID != "" {
+			options = append(options, oss.ServerSideEncryptionKeyID(s.config.KMSKeyID))
+		}
+	}
+	if s.config.StorageClass != "" {
+		options = append(options, oss.ObjectStorageClass(oss.StorageClassType(s.config.StorageClass)))
+	}
+	return options
+}
 
-	// 上传文件
-	err = bucket.PutObjectFromFile(objectName, filePath)
+// Get 下载对象
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := s.bucket.GetObject(s.objectName(key), oss.WithContext(ctx))
 	if err != nil {
-		return fmt.Errorf("上传文件失败: %v", err)
+		return nil, wrapError(key, err)
 	}
+	return body, nil
+}
+
+// List 列出指定前缀下的所有对象
+func (s *Storage) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	rootPrefix := s.objectName("")
+	listPrefix := s.objectName(prefix)
+
+	var objects []storage.ObjectInfo
+	token := ""
+	for {
+		result, err := s.bucket.ListObjectsV2(
+			oss.WithContext(ctx),
+			oss.Prefix(listPrefix),
+			oss.ContinuationToken(token),
+			oss.MaxKeys(1000),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("列出对象失败: %v", err)
+		}
+
+		for _, obj := range result.Objects {
+			objects = append(objects, storage.ObjectInfo{
+				Key:          strings.TrimPrefix(strings.TrimPrefix(obj.Key, rootPrefix), "/"),
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+				ETag:         strings.Trim(obj.ETag, `"`),
+			})
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	return objects, nil
+}
 
+// Delete 删除对象
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := s.bucket.DeleteObject(s.objectName(key), oss.WithContext(ctx)); err != nil {
+		return fmt.Errorf("删除对象失败: %v", err)
+	}
 	return nil
 }
 
+// Stat 获取对象信息
+func (s *Storage) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	header, err := s.bucket.GetObjectDetailedMeta(s.objectName(key), oss.WithContext(ctx))
+	if err != nil {
+		return nil, wrapError(key, err)
+	}
+
+	info := &storage.ObjectInfo{
+		Key:      key,
+		ETag:     strings.Trim(header.Get(oss.HTTPHeaderEtag), `"`),
+		CRC64:    header.Get(oss.HTTPHeaderOssCRC64),
+		Metadata: make(map[string]string),
+	}
+	if size, err := strconv.ParseInt(header.Get(oss.HTTPHeaderContentLength), 10, 64); err == nil {
+		info.Size = size
+	}
+	if t, err := http.ParseTime(header.Get(oss.HTTPHeaderLastModified)); err == nil {
+		info.LastModified = t
+	}
+	// 简单上传（Normal 类型）且未使用 KMS 加密的对象，ETag 即为内容的 MD5
+	// （分片上传的对象类型为 Multipart，追加上传的为 Appendable，ETag 不是 MD5）
+	if header.Get("X-Oss-Object-Type") == "Normal" && etagPattern.MatchString(info.ETag) && header.Get(oss.HTTPHeaderOssServerSideEncryption) != "KMS" {
+		info.MD5 = strings.ToLower(info.ETag)
+	}
+	for name := range header {
+		if strings.HasPrefix(name, oss.HTTPHeaderOssMetaPrefix) {
+			metaKey := strings.ToLower(strings.TrimPrefix(name, oss.HTTPHeaderOssMetaPrefix))
+			info.Metadata[metaKey] = header.Get(name)
+		}
+	}
+
+	return info, nil
+}
+
+// wrapError 将 OSS 404 错误转换为 storage.ErrNotExist
+func wrapError(key string, err error) error {
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", storage.ErrNotExist, key)
+	}
+	return fmt.Errorf("访问对象失败: %s, %v", key, err)
+}
+
+// 确保 Storage 实现了相关接口
+var (
+	_ storage.Storage      = (*Storage)(nil)
+	_ storage.FileUploader = (*Storage)(nil)
+)
@@ -0,0 +1,77 @@
+package progress
+
+import (
+	"context"
+	"sync"
+)
+
+// 备份任务的执行阶段
+const (
+	StageArchiving = "archiving" // 打包压缩
+	StageUploading = "uploading" // 上传
+	StageStreaming = "streaming" // 流式上传
+	StagePruning   = "pruning"   // 清理旧备份
+)
+
+// Info 执行进度
+type Info struct {
+	Stage string `json:"stage,omitempty"` // 当前阶段
+	Key   string `json:"key,omitempty"`   // 当前处理的对象键
+	Bytes int64  `json:"bytes"`           // 已处理的字节数（打包压缩和流式上传时为已写入的字节数）
+	Total int64  `json:"total,omitempty"` // 总字节数（上传本地文件时为文件大小，未知时为 0）
+}
+
+// Tracker 记录备份任务的执行进度，方法可以在 nil 上调用（不记录进度）
+type Tracker struct {
+	mu   sync.Mutex
+	info Info
+}
+
+// Set 设置当前阶段和对象，并清空字节数
+func (t *Tracker) Set(stage, key string, total int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.info = Info{Stage: stage, Key: key, Total: total}
+	t.mu.Unlock()
+}
+
+// Add 增加已处理的字节数
+func (t *Tracker) Add(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.info.Bytes += n
+	t.mu.Unlock()
+}
+
+// Write 实现 io.Writer，记录写入的字节数
+func (t *Tracker) Write(p []byte) (int, error) {
+	t.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// Info 返回当前进度
+func (t *Tracker) Info() Info {
+	if t == nil {
+		return Info{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.info
+}
+
+type contextKey struct{}
+
+// WithTracker 返回携带进度记录的 context
+func WithTracker(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext 返回 context 中的进度记录，没有时返回 nil
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(contextKey{}).(*Tracker)
+	return t
+}
@@ -0,0 +1,246 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/storage"
+
+	"filippo.io/age"
+	"github.com/klauspost/compress/zstd"
+)
+
+// keysDir 仓库密钥目录：repo/keys/{加密配置指纹}/{age 公钥}.age 为使用备份加密配置（age 公钥或口令）加密的仓库密钥（私钥和数据块 ID 密钥）
+// 数据块使用仓库公钥加密（每个数据块单独加密，使用口令时也不需要每个数据块执行 scrypt），以数据块 ID 密钥计算的 HMAC-SHA256 命名
+const keysDir = Dir + "keys/"
+
+// 数据块压缩和解压使用的 zstd 编码器，EncodeAll/DecodeAll 可以并发调用
+var (
+	chunkEncoder, _ = zstd.NewWriter(nil)
+	chunkDecoder, _ = zstd.NewReader(nil)
+)
+
+// Key 仓库密钥：加密数据块使用的 X25519 密钥对和计算数据块 ID 的 HMAC 密钥
+// 加密的数据块以 HMAC-SHA256 命名，不能通过数据块名称确认仓库中是否有已知内容
+type Key struct {
+	recipient *age.X25519Recipient
+	identity  *age.X25519Identity // 本地缓存的仓库密钥只能写入数据块，没有私钥
+	idKey     []byte
+}
+
+// keyData 使用备份加密配置加密保存的仓库密钥内容
+type keyData struct {
+	Identity   string `json:"identity"`     // age X25519 私钥
+	ChunkIDKey string `json:"chunk_id_key"` // 计算数据块 ID 的 HMAC-SHA256 密钥（十六进制）
+}
+
+// ID 返回仓库密钥的 ID（仓库公钥），快照中记录数据块使用的仓库密钥
+func (k *Key) ID() string {
+	return k.recipient.String()
+}
+
+// ChunkID 返回数据块的 ID：key 为 nil（不加密）时为内容的 SHA-256，否则为使用仓库密钥计算的 HMAC-SHA256
+func ChunkID(data []byte, key *Key) string {
+	if key == nil {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, key.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EncodeChunk 压缩数据块，key 不为 nil 时压缩后使用仓库公钥加密
+func EncodeChunk(data []byte, key *Key) ([]byte, error) {
+	compressed := chunkEncoder.EncodeAll(data, nil)
+	if key == nil {
+		return compressed, nil
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, key.recipient)
+	if err != nil {
+		return nil, fmt.Errorf("加密数据块失败: %v", err)
+	}
+	if _, err := w.Write(compressed); err != nil {
+		return nil, fmt.Errorf("加密数据块失败: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("加密数据块失败: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeChunk 读取数据块，解密（key 不为 nil 时使用仓库私钥）、解压后校验数据块 ID 是否与 id 一致
+func DecodeChunk(r io.Reader, id string, key *Key) ([]byte, error) {
+	if key != nil {
+		if key.identity == nil {
+			return nil, fmt.Errorf("仓库密钥 %s 没有私钥，不能解密数据块", key.ID())
+		}
+		dr, err := crypt.Decrypt(r, []age.Identity{key.identity})
+		if err != nil {
+			return nil, err
+		}
+		r = dr
+	}
+	compressed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("读取数据块失败: %v", err)
+	}
+	data, err := chunkDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("解压数据块失败: %v", err)
+	}
+	if sum := ChunkID(data, key); sum != id {
+		return nil, fmt.Errorf("数据块 ID 不匹配: %s", sum)
+	}
+	return data, nil
+}
+
+// keyDir 返回加密配置对应的仓库密钥目录：repo/keys/{加密配置指纹}/（多个公钥的指纹以逗号分隔，目录名中替换为 _）
+func keyDir(fingerprint string) string {
+	return keysDir + strings.ReplaceAll(fingerprint, ",", "_") + "/"
+}
+
+// WriteKey 返回备份时加密数据块和计算数据块 ID 使用的仓库密钥
+// 仓库密钥按加密配置的指纹（enc.Fingerprint()）分别保存，只使用当前加密配置加密的仓库密钥，没有时生成仓库密钥，使用 enc 加密后保存；
+// 已有的仓库密钥需要使用 identities（备份加密配置中的口令或私钥）解密后才能计算数据块 ID，无法解密时返回错误（如更换了口令），
+// 避免使用当前加密配置无法解密的仓库密钥加密数据块。
+// cacheDir 不为空时（公钥加密），生成或解密的仓库公钥和数据块 ID 密钥缓存在本地，之后没有私钥（identities 为 nil）时使用缓存写入仓库
+func (r *Repository) WriteKey(ctx context.Context, enc *crypt.Encryptor, identities []age.Identity, cacheDir string) (*Key, error) {
+	fingerprint := enc.Fingerprint()
+	keys, err := r.keyObjects(ctx, keyDir(fingerprint))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > 0 {
+		if identities == nil {
+			return r.cachedKey(cacheDir, fingerprint, keys)
+		}
+		var errs []string
+		for _, key := range keys {
+			k, err := r.loadKey(ctx, key, identities)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			r.cacheKey(cacheDir, fingerprint, k)
+			return k, nil
+		}
+		return nil, fmt.Errorf("当前加密配置（指纹 %s）无法解密仓库中该配置的密钥，口令或私钥与生成仓库密钥时不同: %s", fingerprint, strings.Join(errs, "; "))
+	}
+
+	// 仓库中只有其他加密配置的密钥时，为当前加密配置生成新的仓库密钥（恢复其他配置写入的备份需要对应的私钥或口令）
+	if others, err := r.keyObjects(ctx, keysDir); err != nil {
+		return nil, err
+	} else if len(others) > 0 {
+		logger.Warn("仓库中没有当前加密配置的密钥，生成新的仓库密钥（与其他加密配置写入的数据块不共用）", "fingerprint", fingerprint, "keys", len(others))
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, fmt.Errorf("生成仓库密钥失败: %v", err)
+	}
+	k := &Key{recipient: identity.Recipient(), identity: identity, idKey: make([]byte, 32)}
+	if _, err := rand.Read(k.idKey); err != nil {
+		return nil, fmt.Errorf("生成仓库密钥失败: %v", err)
+	}
+	data, err := json.Marshal(keyData{Identity: identity.String(), ChunkIDKey: hex.EncodeToString(k.idKey)})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := enc.Encrypt(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("加密仓库密钥失败: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("加密仓库密钥失败: %v", err)
+	}
+	key := keyDir(fingerprint) + k.ID() + crypt.Suffix
+	opts := storage.PutOptions{Metadata: map[string]string{crypt.MetadataKey: fingerprint}}
+	if err := r.s.Put(ctx, key, &buf, opts); err != nil {
+		return nil, fmt.Errorf("保存仓库密钥失败: %v", err)
+	}
+	logger.Info("已生成仓库密钥", "key", key, "fingerprint", fingerprint)
+	r.cacheKey(cacheDir, fingerprint, k)
+	return k, nil
+}
+
+// LoadKey 使用 identities（备份的解密私钥或口令）解密 ID 为 id 的仓库密钥（快照中记录的仓库公钥）
+func (r *Repository) LoadKey(ctx context.Context, id string, identities []age.Identity) (*Key, error) {
+	keys, err := r.keyObjects(ctx, keysDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if keyRecipient(key) == id {
+			return r.loadKey(ctx, key, identities)
+		}
+	}
+	return nil, fmt.Errorf("仓库中没有密钥 %s", id)
+}
+
+// loadKey 下载并解密一个仓库密钥，key 为仓库密钥的对象键，校验私钥与对象键中的公钥一致
+func (r *Repository) loadKey(ctx context.Context, key string, identities []age.Identity) (*Key, error) {
+	body, err := r.s.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("下载仓库密钥 %s 失败: %v", key, err)
+	}
+	defer body.Close()
+	dr, err := crypt.Decrypt(body, identities)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", key, err)
+	}
+	var kd keyData
+	if err := json.NewDecoder(dr).Decode(&kd); err != nil {
+		return nil, fmt.Errorf("解析仓库密钥 %s 失败: %v", key, err)
+	}
+	identity, err := age.ParseX25519Identity(kd.Identity)
+	if err != nil {
+		return nil, fmt.Errorf("解析仓库密钥 %s 失败: %v", key, err)
+	}
+	idKey, err := hex.DecodeString(kd.ChunkIDKey)
+	if err != nil || len(idKey) == 0 {
+		return nil, fmt.Errorf("仓库密钥 %s 中的数据块 ID 密钥无效", key)
+	}
+	if identity.Recipient().String() != keyRecipient(key) {
+		return nil, fmt.Errorf("仓库密钥 %s 与公钥不匹配", key)
+	}
+	return &Key{recipient: identity.Recipient(), identity: identity, idKey: idKey}, nil
+}
+
+// keyObjects 列出 prefix 下所有仓库密钥的对象键（按字符串排序）
+func (r *Repository) keyObjects(ctx context.Context, prefix string) ([]string, error) {
+	objects, err := r.s.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("列出仓库密钥失败: %v", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, crypt.Suffix) {
+			keys = append(keys, obj.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// keyRecipient 返回仓库密钥对象键中的公钥
+func keyRecipient(key string) string {
+	return strings.TrimSuffix(path.Base(key), crypt.Suffix)
+}
@@ -0,0 +1,84 @@
+package repo
+
+// gearWindow gear hash 的窗口大小：64 位 hash 每次左移一位，最高位只受最近 64 个字节影响
+const gearWindow = 64
+
+// gear 内容定义分块使用的随机表（固定种子生成，改变后之前的数据块无法去重）
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	seed := uint64(0x6261636b75702d74) // "backup-t"
+	for i := range table {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker 内容定义分块（基于 gear hash，FastCDC 的简化实现）：
+// 写入的数据按内容切分为数据块，数据中间插入或删除内容时只影响附近的数据块，其他数据块仍然可以去重
+type Chunker struct {
+	min, max int
+	mask     uint64
+	buf      []byte
+	pos      int    // buf 中已计算 hash 的位置
+	hash     uint64 // 当前数据块的 gear hash
+	emit     func(chunk []byte) error
+}
+
+// NewChunker 创建 Chunker，每个数据块通过 emit 输出（emit 返回后数据块的内存会被复用）
+func NewChunker(cfg Config, emit func(chunk []byte) error) *Chunker {
+	return &Chunker{
+		min:  cfg.MinSize,
+		max:  cfg.MaxSize,
+		mask: ^uint64(0) << (64 - cfg.MaskBits), // 使用 hash 的最高位判断切分点
+		emit: emit,
+	}
+}
+
+// Write 写入数据，达到切分点的数据块立即输出
+func (c *Chunker) Write(p []byte) (int, error) {
+	c.buf = append(c.buf, p...)
+	for {
+		n := c.boundary()
+		if n == 0 {
+			return len(p), nil
+		}
+		if err := c.emit(c.buf[:n]); err != nil {
+			return 0, err
+		}
+		c.buf = c.buf[:copy(c.buf, c.buf[n:])]
+		c.pos, c.hash = 0, 0
+	}
+}
+
+// Close 输出剩余的数据（最后一个数据块可能小于最小分块大小）
+func (c *Chunker) Close() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := c.emit(c.buf)
+	c.buf, c.pos, c.hash = c.buf[:0], 0, 0
+	return err
+}
+
+// boundary 返回 buf 中第一个数据块的长度，数据不足时返回 0
+// 小于最小分块大小的部分不判断切分点（只计算最后一个窗口的 hash），达到最大分块大小时强制切分
+func (c *Chunker) boundary() int {
+	n := min(len(c.buf), c.max)
+	start := max(c.pos, c.min-gearWindow, 0)
+	for i := start; i < n; i++ {
+		c.hash = c.hash<<1 + gear[c.buf[i]]
+		if i+1 >= c.min && c.hash&c.mask == 0 {
+			return i + 1
+		}
+	}
+	if n == c.max {
+		return c.max
+	}
+	c.pos = max(c.pos, n)
+	return 0
+}
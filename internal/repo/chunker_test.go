@@ -0,0 +1,122 @@
+package repo
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+)
+
+// testChunkConfig 测试使用的小分块参数：数据块 1KiB~16KiB，平均约 5KiB
+var testChunkConfig = Config{MinSize: 1024, MaxSize: 16 * 1024, MaskBits: 12}
+
+// randomData 返回固定种子生成的随机数据
+func randomData(n int) []byte {
+	r := rand.New(rand.NewPCG(1, 2))
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	return data
+}
+
+// chunkAll 将 data 每次写入 step 字节，返回切分的数据块
+func chunkAll(t *testing.T, cfg Config, data []byte, step int) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	c := NewChunker(cfg, func(chunk []byte) error {
+		chunks = append(chunks, bytes.Clone(chunk))
+		return nil
+	})
+	for len(data) > 0 {
+		n := min(step, len(data))
+		if _, err := c.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+func TestChunkerSizes(t *testing.T) {
+	data := randomData(1 << 20)
+	// 全为 0 的数据没有切分点，按最大分块大小切分
+	zeros := make([]byte, 100*1024)
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"random", data},
+		{"zeros", zeros},
+	} {
+		chunks := chunkAll(t, testChunkConfig, tt.data, len(tt.data))
+		if got := bytes.Join(chunks, nil); !bytes.Equal(got, tt.data) {
+			t.Fatalf("%s: chunks do not reassemble to the input", tt.name)
+		}
+		for i, chunk := range chunks {
+			last := i == len(chunks)-1
+			if len(chunk) > testChunkConfig.MaxSize || (!last && len(chunk) < testChunkConfig.MinSize) {
+				t.Errorf("%s: chunk %d size %d outside [%d, %d]", tt.name, i, len(chunk), testChunkConfig.MinSize, testChunkConfig.MaxSize)
+			}
+		}
+	}
+	if chunks := chunkAll(t, testChunkConfig, zeros, len(zeros)); len(chunks[0]) != testChunkConfig.MaxSize {
+		t.Errorf("zeros: first chunk size %d, want %d", len(chunks[0]), testChunkConfig.MaxSize)
+	}
+
+	// 切分点只取决于内容，与每次写入的大小无关
+	want := chunkAll(t, testChunkConfig, data, len(data))
+	for _, step := range []int{1, 100, 4096, 20000} {
+		if got := chunkAll(t, testChunkConfig, data, step); !equalChunks(got, want) {
+			t.Errorf("step %d: got %d chunks, want the same %d chunks as a single write", step, len(got), len(want))
+		}
+	}
+}
+
+func TestChunkerInsertKeepsBoundaries(t *testing.T) {
+	data := randomData(1 << 20)
+	modified := bytes.Clone(data[:500000])
+	modified = append(modified, []byte("inserted content")...)
+	modified = append(modified, data[500000:]...)
+
+	before := chunkAll(t, testChunkConfig, data, 64*1024)
+	after := chunkAll(t, testChunkConfig, modified, 64*1024)
+	seen := make(map[string]bool)
+	for _, chunk := range before {
+		seen[string(chunk)] = true
+	}
+	changed := 0
+	for _, chunk := range after {
+		if !seen[string(chunk)] {
+			changed++
+		}
+	}
+	// 插入内容只影响所在的数据块（以及可能的下一个数据块），其他数据块仍然可以去重
+	if changed == 0 || changed > 2 {
+		t.Errorf("%d of %d chunks changed after inserting 16 bytes, want 1 or 2", changed, len(after))
+	}
+}
+
+func TestChunkerSmallMinSize(t *testing.T) {
+	// 最小分块大小小于 gear hash 窗口时不能越界
+	cfg := Config{MinSize: 16, MaxSize: 256, MaskBits: 4}
+	data := randomData(10000)
+	chunks := chunkAll(t, cfg, data, 1000)
+	if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+		t.Fatal("chunks do not reassemble to the input")
+	}
+}
+
+func equalChunks(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"backup-to-oss/internal/logger"
+
+	"filippo.io/age"
+	"github.com/rboyer/safeio"
+)
+
+// cachedKeyData 本地缓存的仓库密钥：只有仓库公钥和数据块 ID 密钥，可以加密和命名数据块，不能解密数据块
+type cachedKeyData struct {
+	Recipient  string `json:"recipient"`    // 仓库公钥
+	ChunkIDKey string `json:"chunk_id_key"` // 计算数据块 ID 的 HMAC-SHA256 密钥（十六进制）
+}
+
+// DefaultKeyCacheDir 返回仓库密钥的默认本地缓存目录（~/.cache/backup-to-oss/keys），无法获取用户缓存目录时返回空字符串
+func DefaultKeyCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "backup-to-oss", "keys")
+}
+
+// keyCachePath 返回备份目标中该加密配置的仓库密钥的缓存文件路径
+func keyCachePath(cacheDir, dest, fingerprint string) string {
+	sum := sha1.Sum([]byte(dest + "|" + fingerprint))
+	return filepath.Join(cacheDir, fmt.Sprintf("key-%x.json", sum[:8]))
+}
+
+// cachedKey 读取本地缓存的仓库密钥，keys 为仓库中该加密配置的仓库密钥的对象键，缓存的密钥必须是其中之一
+func (r *Repository) cachedKey(cacheDir, fingerprint string, keys []string) (*Key, error) {
+	missing := fmt.Errorf("仓库中已有当前加密配置（指纹 %s）的密钥，本地没有缓存的数据块 ID 密钥：需要配置一次解密私钥（DECRYPT_IDENTITY_FILE）读取仓库密钥，之后的备份使用本地缓存（REPO_KEY_CACHE_DIR）", fingerprint)
+	if cacheDir == "" {
+		return nil, missing
+	}
+	path := keyCachePath(cacheDir, r.s.String(), fingerprint)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, missing
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取仓库密钥缓存失败: %v", err)
+	}
+	var kd cachedKeyData
+	if err := json.Unmarshal(data, &kd); err != nil {
+		return nil, fmt.Errorf("解析仓库密钥缓存 %s 失败: %v", path, err)
+	}
+	recipient, err := age.ParseX25519Recipient(kd.Recipient)
+	if err != nil {
+		return nil, fmt.Errorf("解析仓库密钥缓存 %s 失败: %v", path, err)
+	}
+	idKey, err := hex.DecodeString(kd.ChunkIDKey)
+	if err != nil || len(idKey) == 0 {
+		return nil, fmt.Errorf("仓库密钥缓存 %s 中的数据块 ID 密钥无效", path)
+	}
+	for _, key := range keys {
+		if keyRecipient(key) == kd.Recipient {
+			return &Key{recipient: recipient, idKey: idKey}, nil
+		}
+	}
+	return nil, fmt.Errorf("本地缓存的仓库密钥 %s 不在仓库中（仓库密钥已被删除或重新生成）: %w", kd.Recipient, missing)
+}
+
+// cacheKey 将仓库公钥和数据块 ID 密钥缓存在本地（cacheDir 为空时不缓存），失败只记录日志
+func (r *Repository) cacheKey(cacheDir, fingerprint string, k *Key) {
+	if cacheDir == "" {
+		return
+	}
+	err := func() error {
+		data, err := json.Marshal(cachedKeyData{Recipient: k.ID(), ChunkIDKey: hex.EncodeToString(k.idKey)})
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(cacheDir, 0700); err != nil {
+			return err
+		}
+		_, err = safeio.WriteToFile(bytes.NewReader(data), keyCachePath(cacheDir, r.s.String(), fingerprint), 0600)
+		return err
+	}()
+	if err != nil {
+		logger.Warn("缓存仓库密钥失败，之后没有私钥时无法写入仓库", "fingerprint", fingerprint, "error", err)
+	}
+}
@@ -0,0 +1,423 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"backup-to-oss/internal/catalog"
+	"backup-to-oss/internal/compress"
+	"backup-to-oss/internal/crypt"
+	"backup-to-oss/internal/storage"
+)
+
+// 仓库在备份目标中的路径结构（相对于备份目标根路径）：
+//
+//	repo/config.json                              仓库配置
+//	repo/keys/{指纹}/{age 公钥}.age                加密的仓库密钥（数据块加密时使用），按加密配置的指纹分别保存
+//	repo/chunks/{id[:2]}/{id}[.age]               zstd 压缩（和 age 加密）的数据块，id 为数据块内容的 SHA-256（加密时为 HMAC-SHA256）
+//	repo/snapshots/{ip}/{date}/{name}.json.zst    快照索引：备份数据依次由哪些数据块组成
+//	repo/locks/{shared|exclusive}-{host}-{pid}-{time}.json
+const (
+	Dir            = "repo/"
+	configKey      = Dir + "config.json"
+	chunksDir      = Dir + "chunks/"
+	snapshotsDir   = Dir + "snapshots/"
+	locksDir       = Dir + "locks/"
+	SnapshotSuffix = ".json.zst"
+)
+
+// formatVersion 仓库格式版本（包括分块使用的 gear 表）
+const formatVersion = 1
+
+// StaleLockAge 超过该时间的锁视为进程异常退出后遗留的锁，加锁时忽略
+const StaleLockAge = 24 * time.Hour
+
+// ErrNotExist 备份目标中没有仓库
+var ErrNotExist = errors.New("备份目标中没有仓库（repo/config.json 不存在）")
+
+// ErrLocked 仓库已被其他进程锁定
+var ErrLocked = errors.New("仓库已被锁定")
+
+// Config 仓库配置，创建仓库时写入，之后的备份使用相同的分块参数（分块参数不同时无法与之前的数据块去重）
+type Config struct {
+	Version   int       `json:"version"`
+	MinSize   int       `json:"min_size"`  // 最小分块大小（字节），不能小于 gear hash 的窗口大小（64 字节）
+	MaxSize   int       `json:"max_size"`  // 最大分块大小（字节）
+	MaskBits  int       `json:"mask_bits"` // 切分点判断的位数，超过最小分块大小后平均每 2^mask_bits 字节切分一次
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DefaultConfig 默认的仓库配置：数据块 512KiB~8MiB，平均约 1.5MiB
+func DefaultConfig() Config {
+	return Config{
+		Version:   formatVersion,
+		MinSize:   512 * 1024,
+		MaxSize:   8 * 1024 * 1024,
+		MaskBits:  20,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Chunk 快照引用的一个数据块
+type Chunk struct {
+	ID   string `json:"id"`   // 数据块 ID（十六进制）：内容的 SHA-256，加密时为使用仓库密钥计算的 HMAC-SHA256
+	Size int    `json:"size"` // 数据块大小（压缩前）
+}
+
+// Snapshot 快照索引：一次备份的数据（tar 归档、文件或 etcd/Consul snapshot）按顺序由哪些数据块组成
+type Snapshot struct {
+	Version   int       `json:"version"`
+	Name      string    `json:"name"`          // 备份文件名，如 20250101-020000_etc_nginx.tar（数据块加密时以 .age 结尾）
+	Host      string    `json:"host"`          // 公网 IP（可能为空）
+	Hostname  string    `json:"hostname"`      // 主机名
+	Time      time.Time `json:"time"`          // 备份时间
+	Size      int64     `json:"size"`          // 数据大小（字节）
+	SHA256    string    `json:"sha256"`        // 数据的 SHA-256（十六进制）
+	Encrypted bool      `json:"encrypted"`     // 数据块是否使用 age 加密
+	Key       string    `json:"key,omitempty"` // 加密数据块和计算数据块 ID 使用的仓库密钥（仓库公钥）
+	Chunks    []Chunk   `json:"chunks"`
+}
+
+// ChunkKeys 返回快照引用的数据块的对象键
+func (s *Snapshot) ChunkKeys() []string {
+	keys := make([]string, len(s.Chunks))
+	for i, c := range s.Chunks {
+		keys[i] = ChunkKey(c.ID, s.Encrypted)
+	}
+	return keys
+}
+
+// ChunkKey 返回数据块的对象键，加密的数据块添加 .age 后缀（与不加密的数据块不共用）
+func ChunkKey(id string, encrypted bool) string {
+	key := chunksDir + id[:2] + "/" + id
+	if encrypted {
+		key += crypt.Suffix
+	}
+	return key
+}
+
+// SnapshotKey 返回快照的对象键，key 为快照在 list 中显示的对象键，如 1.2.3.4/20250101/20250101-020000_etc.tar
+func SnapshotKey(key string) string {
+	return snapshotsDir + key + SnapshotSuffix
+}
+
+// Repository 一个备份目标中的仓库
+type Repository struct {
+	s      storage.Storage
+	config Config
+}
+
+// Open 打开备份目标中的仓库，仓库不存在时返回 ErrNotExist
+func Open(ctx context.Context, s storage.Storage) (*Repository, error) {
+	body, err := s.Get(ctx, configKey)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取仓库配置失败: %v", err)
+	}
+	defer body.Close()
+
+	var cfg Config
+	if err := json.NewDecoder(body).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("解析仓库配置失败: %v", err)
+	}
+	if cfg.Version != formatVersion {
+		return nil, fmt.Errorf("不支持的仓库版本: %d", cfg.Version)
+	}
+	if cfg.MinSize < gearWindow || cfg.MaxSize < cfg.MinSize || cfg.MaskBits <= 0 || cfg.MaskBits >= 64 {
+		return nil, fmt.Errorf("无效的仓库分块参数: min=%d max=%d mask_bits=%d", cfg.MinSize, cfg.MaxSize, cfg.MaskBits)
+	}
+	return &Repository{s: s, config: cfg}, nil
+}
+
+// OpenOrInit 打开备份目标中的仓库，仓库不存在时使用默认配置创建
+func OpenOrInit(ctx context.Context, s storage.Storage) (*Repository, error) {
+	r, err := Open(ctx, s)
+	if !errors.Is(err, ErrNotExist) {
+		return r, err
+	}
+	cfg := DefaultConfig()
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Put(ctx, configKey, bytes.NewReader(data), storage.PutOptions{}); err != nil {
+		return nil, fmt.Errorf("创建仓库失败: %v", err)
+	}
+	return &Repository{s: s, config: cfg}, nil
+}
+
+// Config 返回仓库配置
+func (r *Repository) Config() Config {
+	return r.config
+}
+
+// Storage 返回仓库所在的备份目标
+func (r *Repository) Storage() storage.Storage {
+	return r.s
+}
+
+// Chunks 列出仓库中所有数据块的对象键和大小
+func (r *Repository) Chunks(ctx context.Context) (map[string]int64, error) {
+	objects, err := r.s.List(ctx, chunksDir)
+	if err != nil {
+		return nil, fmt.Errorf("列出数据块失败: %v", err)
+	}
+	chunks := make(map[string]int64, len(objects))
+	for _, obj := range objects {
+		chunks[obj.Key] = obj.Size
+	}
+	return chunks, nil
+}
+
+// List 列出 prefix（如 "1.2.3.4/"）下的所有快照，按备份时间排序
+// 返回的对象键为去掉 repo/snapshots/ 前缀和 .json.zst 后缀的快照名称，大小为快照索引的大小
+// 无法根据快照名称区分目录备份和文件备份时读取快照索引的对象元数据中的备份类型
+func (r *Repository) List(ctx context.Context, prefix string) ([]catalog.Backup, error) {
+	objects, err := r.s.List(ctx, snapshotsDir+prefix)
+	if err != nil {
+		return nil, err
+	}
+	var backups []catalog.Backup
+	for _, obj := range objects {
+		key, ok := strings.CutSuffix(strings.TrimPrefix(obj.Key, snapshotsDir), SnapshotSuffix)
+		if !ok {
+			continue
+		}
+		obj.Key = key
+		if b, ok := catalog.Parse(obj); ok {
+			b.Compression = "zstd" // 数据块使用 zstd 压缩
+			backups = append(backups, b)
+		}
+	}
+	if err := catalog.ResolveTypes(ctx, r.s, backups, SnapshotKey); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		if !backups[i].Time.Equal(backups[j].Time) {
+			return backups[i].Time.Before(backups[j].Time)
+		}
+		return backups[i].Key < backups[j].Key
+	})
+	return backups, nil
+}
+
+// UnusedChunks 返回没有被快照引用的数据块的对象键和大小，以及仓库中数据块的总数，skip 返回 true 的快照（如 dry-run 中将删除的快照）不计算引用
+// 先列出快照再列出数据块，任何一个快照无法读取时返回错误，避免删除该快照引用的数据块；
+// 列出快照后新写入的快照引用的数据块也会被视为未引用，回收数据块时需要加排他锁
+func (r *Repository) UnusedChunks(ctx context.Context, skip func(key string) bool) (map[string]int64, int, error) {
+	keys, err := r.SnapshotKeys(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	referenced := make(map[string]bool)
+	for _, key := range keys {
+		if skip != nil && skip(key) {
+			continue
+		}
+		snap, err := r.LoadSnapshot(ctx, key)
+		if err != nil {
+			return nil, 0, fmt.Errorf("读取快照失败: %v", err)
+		}
+		for _, chunkKey := range snap.ChunkKeys() {
+			referenced[chunkKey] = true
+		}
+	}
+	chunks, err := r.Chunks(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	unused := make(map[string]int64)
+	for key, n := range chunks {
+		if !referenced[key] {
+			unused[key] = n
+		}
+	}
+	return unused, len(chunks), nil
+}
+
+// SnapshotKeys 列出仓库中所有快照（包括无法解析备份名称的快照）的对象键，回收数据块时使用
+func (r *Repository) SnapshotKeys(ctx context.Context) ([]string, error) {
+	objects, err := r.s.List(ctx, snapshotsDir)
+	if err != nil {
+		return nil, fmt.Errorf("列出快照失败: %v", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		if key, ok := strings.CutSuffix(strings.TrimPrefix(obj.Key, snapshotsDir), SnapshotSuffix); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// LoadSnapshot 下载并解析快照索引，key 为 List 返回的对象键
+func (r *Repository) LoadSnapshot(ctx context.Context, key string) (*Snapshot, error) {
+	body, err := r.s.Get(ctx, SnapshotKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("下载快照 %s 失败: %v", key, err)
+	}
+	defer body.Close()
+
+	zr, err := compress.NewReader(body, "zstd")
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var snap Snapshot
+	if err := json.NewDecoder(zr).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("解析快照 %s 失败: %v", key, err)
+	}
+	if snap.Version != formatVersion {
+		return nil, fmt.Errorf("不支持的快照版本: %d", snap.Version)
+	}
+	return &snap, nil
+}
+
+// EncodeSnapshot 将快照索引编码为 zstd 压缩的 JSON
+func EncodeSnapshot(snap *Snapshot) ([]byte, error) {
+	snap.Version = formatVersion
+	var buf bytes.Buffer
+	zw, err := compress.NewWriter(&buf, "zstd")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(zw).Encode(snap); err != nil {
+		zw.Close()
+		return nil, fmt.Errorf("编码快照失败: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Lock 仓库锁：备份时加共享锁，回收数据块时加排他锁，避免删除正在备份的快照引用的数据块
+type Lock struct {
+	s   storage.Storage
+	key string
+}
+
+// lockInfo 锁对象的内容（只用于排查问题）
+type lockInfo struct {
+	Exclusive bool      `json:"exclusive"`
+	Hostname  string    `json:"hostname"`
+	PID       int       `json:"pid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Lock 加锁：共享锁与其他排他锁冲突，排他锁与其他任何锁冲突，冲突时删除自己的锁并返回错误
+// 超过 StaleLockAge 的锁视为进程异常退出后遗留的锁，不影响加锁
+func (r *Repository) Lock(ctx context.Context, exclusive bool) (*Lock, error) {
+	hostname, _ := os.Hostname()
+	info := lockInfo{Exclusive: exclusive, Hostname: hostname, PID: os.Getpid(), CreatedAt: time.Now()}
+	kind := "shared"
+	if exclusive {
+		kind = "exclusive"
+	}
+	key := fmt.Sprintf("%s%s-%s-%d-%d.json", locksDir, kind, hostname, info.PID, info.CreatedAt.UnixNano())
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.s.Put(ctx, key, bytes.NewReader(data), storage.PutOptions{}); err != nil {
+		return nil, fmt.Errorf("创建仓库锁失败: %v", err)
+	}
+	lock := &Lock{s: r.s, key: key}
+
+	objects, err := r.s.List(ctx, locksDir)
+	if err != nil {
+		lock.Unlock(ctx)
+		return nil, fmt.Errorf("列出仓库锁失败: %v", err)
+	}
+	for _, obj := range objects {
+		if obj.Key == key || time.Since(obj.LastModified) > StaleLockAge {
+			continue
+		}
+		name := strings.TrimPrefix(obj.Key, locksDir)
+		if exclusive || strings.HasPrefix(name, "exclusive-") {
+			lock.Unlock(ctx)
+			return nil, fmt.Errorf("%w: %s", ErrLocked, obj.Key)
+		}
+	}
+	return lock, nil
+}
+
+// Unlock 删除锁
+func (l *Lock) Unlock(ctx context.Context) error {
+	if err := l.s.Delete(context.WithoutCancel(ctx), l.key); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return fmt.Errorf("删除仓库锁 %s 失败: %v", l.key, err)
+	}
+	return nil
+}
+
+// ReadChunk 下载数据块，解密（key 为快照使用的仓库密钥，不加密时为 nil）、解压后校验数据块 ID
+func (r *Repository) ReadChunk(ctx context.Context, id string, key *Key) ([]byte, error) {
+	chunkKey := ChunkKey(id, key != nil)
+	body, err := r.s.Get(ctx, chunkKey)
+	if err != nil {
+		return nil, fmt.Errorf("下载数据块 %s 失败: %v", chunkKey, err)
+	}
+	defer body.Close()
+	data, err := DecodeChunk(body, id, key)
+	if err != nil {
+		return nil, fmt.Errorf("数据块 %s: %v", chunkKey, err)
+	}
+	return data, nil
+}
+
+// snapshotReader 按顺序读取快照引用的数据块，读取完成后校验数据的大小和 SHA-256
+type snapshotReader struct {
+	ctx    context.Context
+	repo   *Repository
+	snap   *Snapshot
+	key    *Key
+	next   int       // 下一个数据块
+	buf    []byte    // 当前数据块未读取的部分
+	hasher hash.Hash // 计算已读取数据的 SHA-256
+	size   int64
+}
+
+// Reader 返回读取快照数据的 reader，数据块加密时需要 LoadKey 返回的快照使用的仓库密钥
+func (r *Repository) Reader(ctx context.Context, snap *Snapshot, key *Key) io.Reader {
+	return &snapshotReader{ctx: ctx, repo: r, snap: snap, key: key, hasher: sha256.New()}
+}
+
+func (sr *snapshotReader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.next == len(sr.snap.Chunks) {
+			if sr.size != sr.snap.Size {
+				return 0, fmt.Errorf("快照数据大小不匹配: 期望 %d，实际 %d", sr.snap.Size, sr.size)
+			}
+			if sum := hex.EncodeToString(sr.hasher.Sum(nil)); sr.snap.SHA256 != "" && sum != sr.snap.SHA256 {
+				return 0, fmt.Errorf("快照数据 SHA-256 不匹配: 期望 %s，实际 %s", sr.snap.SHA256, sum)
+			}
+			return 0, io.EOF
+		}
+		chunk := sr.snap.Chunks[sr.next]
+		data, err := sr.repo.ReadChunk(sr.ctx, chunk.ID, sr.key)
+		if err != nil {
+			return 0, err
+		}
+		sr.next++
+		sr.buf = data
+	}
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	sr.hasher.Write(p[:n])
+	sr.size += int64(n)
+	return n, nil
+}
@@ -0,0 +1,178 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"backup-to-oss/internal/localfs"
+	"backup-to-oss/internal/storage"
+)
+
+// newTestRepo 在临时目录中创建仓库，返回仓库和根目录
+func newTestRepo(t *testing.T) (*Repository, string) {
+	t.Helper()
+	root := t.TempDir()
+	s, err := localfs.New(localfs.Config{RootDir: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenOrInit(context.Background(), s)
+	if err != nil {
+		t.Fatalf("OpenOrInit: %v", err)
+	}
+	return r, root
+}
+
+func TestOpenRejectsInvalidConfig(t *testing.T) {
+	ctx := context.Background()
+	for _, cfg := range []Config{
+		{Version: formatVersion, MinSize: gearWindow - 1, MaxSize: 1024, MaskBits: 8},
+		{Version: formatVersion, MinSize: 1024, MaxSize: 512, MaskBits: 8},
+		{Version: formatVersion, MinSize: 1024, MaxSize: 4096, MaskBits: 64},
+		{Version: formatVersion + 1, MinSize: 1024, MaxSize: 4096, MaskBits: 8},
+	} {
+		s, err := localfs.New(localfs.Config{RootDir: t.TempDir()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(cfg)
+		if err := s.Put(ctx, configKey, bytes.NewReader(data), storage.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(ctx, s); err == nil {
+			t.Errorf("Open(%+v): expected error", cfg)
+		}
+	}
+}
+
+func TestLock(t *testing.T) {
+	r, root := newTestRepo(t)
+	ctx := context.Background()
+
+	// 共享锁之间不冲突
+	shared1, err := r.Lock(ctx, false)
+	if err != nil {
+		t.Fatalf("Lock shared: %v", err)
+	}
+	shared2, err := r.Lock(ctx, false)
+	if err != nil {
+		t.Fatalf("Lock second shared: %v", err)
+	}
+	if _, err := r.Lock(ctx, true); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock exclusive with shared locks = %v, want ErrLocked", err)
+	}
+	for _, l := range []*Lock{shared1, shared2} {
+		if err := l.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+	}
+
+	exclusive, err := r.Lock(ctx, true)
+	if err != nil {
+		t.Fatalf("Lock exclusive: %v", err)
+	}
+	if _, err := r.Lock(ctx, false); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Lock shared with exclusive lock = %v, want ErrLocked", err)
+	}
+
+	// 超过 StaleLockAge 的锁不影响加锁
+	old := time.Now().Add(-StaleLockAge - time.Hour)
+	if err := os.Chtimes(filepath.Join(root, filepath.FromSlash(exclusive.key)), old, old); err != nil {
+		t.Fatal(err)
+	}
+	l, err := r.Lock(ctx, true)
+	if err != nil {
+		t.Fatalf("Lock with stale lock: %v", err)
+	}
+
+	// 失败的加锁和解锁后不遗留锁对象
+	for _, l := range []*Lock{l, exclusive} {
+		if err := l.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+	}
+	objects, err := r.s.List(ctx, locksDir)
+	if err != nil || len(objects) != 0 {
+		t.Fatalf("locks after unlock = %v, %v; want none", objects, err)
+	}
+}
+
+// putSnapshot 写入数据并保存引用这些数据块的快照
+func putSnapshot(t *testing.T, r *Repository, key string, chunks ...[]byte) {
+	t.Helper()
+	ctx := context.Background()
+	snap := &Snapshot{Name: key}
+	for _, data := range chunks {
+		id := ChunkID(data, nil)
+		encoded, err := EncodeChunk(data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.s.Put(ctx, ChunkKey(id, false), bytes.NewReader(encoded), storage.PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		snap.Chunks = append(snap.Chunks, Chunk{ID: id, Size: len(data)})
+	}
+	data, err := EncodeSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.s.Put(ctx, SnapshotKey(key), bytes.NewReader(data), storage.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnusedChunks(t *testing.T) {
+	r, _ := newTestRepo(t)
+	ctx := context.Background()
+	a, b, c := []byte("chunk a"), []byte("chunk b"), []byte("chunk c")
+	first := "1.2.3.4/20250101/20250101-020000_etc.tar"
+	second := "1.2.3.4/20250102/20250102-020000_etc.tar"
+	putSnapshot(t, r, first, a, b)
+	putSnapshot(t, r, second, b, c)
+	// 之前失败的备份上传的、没有被快照引用的数据块
+	orphan := []byte("orphan")
+	encoded, _ := EncodeChunk(orphan, nil)
+	orphanKey := ChunkKey(ChunkID(orphan, nil), false)
+	if err := r.s.Put(ctx, orphanKey, bytes.NewReader(encoded), storage.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		skip func(key string) bool
+		want []string
+	}{
+		{"all snapshots", nil, []string{orphanKey}},
+		// 不计算将删除的快照的引用，只被该快照引用的数据块可以回收，与其他快照共用的数据块保留
+		{"skip first", func(key string) bool { return key == first }, []string{orphanKey, ChunkKey(ChunkID(a, nil), false)}},
+		{"skip all", func(string) bool { return true }, []string{orphanKey, ChunkKey(ChunkID(a, nil), false), ChunkKey(ChunkID(b, nil), false), ChunkKey(ChunkID(c, nil), false)}},
+	}
+	for _, tt := range tests {
+		unused, total, err := r.UnusedChunks(ctx, tt.skip)
+		if err != nil {
+			t.Fatalf("%s: UnusedChunks: %v", tt.name, err)
+		}
+		got := slices.Sorted(maps.Keys(unused))
+		slices.Sort(tt.want)
+		if total != 4 || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: UnusedChunks = %v (total %d), want %v (total 4)", tt.name, got, total, tt.want)
+		}
+	}
+
+	// 任何一个快照无法读取时不能回收数据块
+	if err := r.s.Put(ctx, SnapshotKey(second), bytes.NewReader([]byte("broken")), storage.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.UnusedChunks(ctx, nil); err == nil {
+		t.Fatal("expected error for unreadable snapshot")
+	}
+}
@@ -0,0 +1,258 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"backup-to-oss/internal/checksum"
+)
+
+// ManifestSuffix 与备份文件一起上传的运行报告的后缀
+const ManifestSuffix = ".manifest.json"
+
+// 备份结果
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusPartial = "partial" // 部分备份文件成功
+)
+
+// Report 一次备份的运行报告，方法可以在 nil 上调用（不记录）
+type Report struct {
+	mu              sync.Mutex
+	Job             string         `json:"job"`
+	Type            string         `json:"type"`                   // 备份类型 (dir/file/etcd/consul)
+	Status          string         `json:"status"`                 // success/failure/partial
+	Host            string         `json:"host,omitempty"`         // 公网 IP（获取失败时为空）
+	Hostname        string         `json:"hostname"`               // 主机名
+	StartedAt       time.Time      `json:"started_at"`             // 开始时间
+	FinishedAt      time.Time      `json:"finished_at"`            // 结束时间
+	DurationSeconds float64        `json:"duration_seconds"`       // 执行时间（秒）
+	Source          map[string]any `json:"source,omitempty"`       // 备份来源配置，如目录、排除模式、etcd 地址
+	Destinations    []string       `json:"destinations,omitempty"` // 备份目标地址
+	Items           []*Item        `json:"items"`                  // 备份文件（目录备份时每个目录一个）
+	Error           string         `json:"error,omitempty"`        // 失败原因
+}
+
+// Item 一个备份文件的记录
+type Item struct {
+	Sources      []string         `json:"sources"`                // 备份来源（目录或文件路径、etcd/consul 地址）
+	Key          string           `json:"key,omitempty"`          // 对象键（相对于备份目标根路径）
+	ArchivePath  string           `json:"archive_path,omitempty"` // 本地归档文件路径（流式上传时为空）
+	Compression  string           `json:"compression,omitempty"`  // 压缩方式 (zstd/gzip/none)
+	Encrypted    bool             `json:"encrypted"`              // 是否使用 age 加密
+	SourceSize   int64            `json:"source_size_bytes"`      // 压缩前的大小（未知时为 0）
+	Size         int64            `json:"size_bytes"`             // 归档大小（压缩和加密后）
+	SHA256       string           `json:"sha256,omitempty"`       // 归档的 SHA-256（十六进制）
+	MD5          string           `json:"md5,omitempty"`          // 归档的 MD5（十六进制）
+	CRC64        uint64           `json:"crc64,omitempty"`        // 归档的 CRC64-ECMA
+	Metadata     map[string]any   `json:"metadata,omitempty"`     // 备份来源的元数据，如 etcd revision、consul index
+	Destinations []DestinationLog `json:"destinations,omitempty"` // 每个备份目标的上传结果
+	Error        string           `json:"error,omitempty"`        // 失败原因
+
+	report *Report // 所属的运行报告（修改记录时使用报告的锁）
+}
+
+// DestinationLog 上传到一个备份目标的结果
+type DestinationLog struct {
+	Dest  string `json:"dest"`
+	Error string `json:"error,omitempty"` // 上传或校验失败的原因，成功时为空
+}
+
+// New 创建运行报告
+func New(job, backupType, hostname string, startedAt time.Time) *Report {
+	return &Report{
+		Job:       job,
+		Type:      backupType,
+		Hostname:  hostname,
+		StartedAt: startedAt,
+		Items:     []*Item{},
+	}
+}
+
+// SetHost 记录备份所在主机的公网 IP
+func (r *Report) SetHost(host string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.Host = host
+	r.mu.Unlock()
+}
+
+// SetSource 记录备份来源配置和备份目标
+func (r *Report) SetSource(source map[string]any, destinations []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.Source = source
+	r.Destinations = slices.Clone(destinations)
+	r.mu.Unlock()
+}
+
+// AddItem 添加一个备份文件的记录，r 为 nil 时返回 nil（Item 的方法同样可以在 nil 上调用）
+func (r *Report) AddItem(sources ...string) *Item {
+	if r == nil {
+		return nil
+	}
+	item := &Item{Sources: sources, report: r}
+	r.mu.Lock()
+	r.Items = append(r.Items, item)
+	r.mu.Unlock()
+	return item
+}
+
+// Finish 记录备份结果，失败但有备份文件上传成功（至少一个备份目标）时为部分失败
+func (r *Report) Finish(finishedAt time.Time, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedAt = finishedAt
+	r.DurationSeconds = finishedAt.Sub(r.StartedAt).Seconds()
+	r.Status = StatusSuccess
+	if err != nil {
+		r.Status = StatusFailure
+		r.Error = err.Error()
+		for _, item := range r.Items {
+			if item.Error == "" && slices.ContainsFunc(item.Destinations, func(d DestinationLog) bool { return d.Error == "" }) {
+				r.Status = StatusPartial
+				break
+			}
+		}
+	}
+}
+
+// MarshalJSON 在持有锁时序列化报告
+func (r *Report) MarshalJSON() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	type report Report
+	return json.Marshal((*report)(r))
+}
+
+// Manifest 生成与备份文件一起上传的报告：运行信息和该备份文件的记录（不包括其他备份文件和上传结果）
+func (r *Report) Manifest(item *Item) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := *item
+	i.Destinations = nil
+	return json.MarshalIndent(struct {
+		Job       string         `json:"job"`
+		Type      string         `json:"type"`
+		Host      string         `json:"host,omitempty"`
+		Hostname  string         `json:"hostname"`
+		StartedAt time.Time      `json:"started_at"`
+		Source    map[string]any `json:"source,omitempty"`
+		Item      Item           `json:"item"`
+	}{r.Job, r.Type, r.Host, r.Hostname, r.StartedAt, r.Source, i}, "", "  ")
+}
+
+// ArchivePaths 返回所有备份文件的本地归档文件路径（不包括流式上传的备份文件）
+func (r *Report) ArchivePaths() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var paths []string
+	for _, item := range r.Items {
+		if item.ArchivePath != "" {
+			paths = append(paths, item.ArchivePath)
+		}
+	}
+	return paths
+}
+
+// SetArchive 记录本地归档文件路径（流式上传时为空）、归档的摘要和压缩前的大小（未知时为 0）
+func (i *Item) SetArchive(archivePath string, digest checksum.Digest, sourceSize int64) {
+	if i == nil {
+		return
+	}
+	i.report.mu.Lock()
+	i.ArchivePath = archivePath
+	i.SourceSize = sourceSize
+	i.Size = digest.Size
+	i.SHA256 = digest.SHA256
+	i.MD5 = digest.MD5
+	i.CRC64 = digest.CRC64
+	i.report.mu.Unlock()
+}
+
+// SetKey 记录对象键、压缩方式和是否加密
+func (i *Item) SetKey(key, compression string, encrypted bool) {
+	if i == nil {
+		return
+	}
+	i.report.mu.Lock()
+	i.Key = key
+	i.Compression = compression
+	i.Encrypted = encrypted
+	i.report.mu.Unlock()
+}
+
+// SetMetadata 记录备份来源的元数据
+func (i *Item) SetMetadata(metadata map[string]any) {
+	if i == nil {
+		return
+	}
+	i.report.mu.Lock()
+	i.Metadata = metadata
+	i.report.mu.Unlock()
+}
+
+// AddMetadata 在备份来源的元数据中添加字段（保留已有的字段）
+func (i *Item) AddMetadata(metadata map[string]any) {
+	if i == nil {
+		return
+	}
+	i.report.mu.Lock()
+	if i.Metadata == nil {
+		i.Metadata = make(map[string]any, len(metadata))
+	}
+	maps.Copy(i.Metadata, metadata)
+	i.report.mu.Unlock()
+}
+
+// AddDestination 记录上传到一个备份目标的结果
+func (i *Item) AddDestination(dest string, err error) {
+	if i == nil {
+		return
+	}
+	log := DestinationLog{Dest: dest}
+	if err != nil {
+		log.Error = err.Error()
+	}
+	i.report.mu.Lock()
+	i.Destinations = append(i.Destinations, log)
+	i.report.mu.Unlock()
+}
+
+// Fail 记录备份文件失败的原因
+func (i *Item) Fail(err error) {
+	if i == nil || err == nil {
+		return
+	}
+	i.report.mu.Lock()
+	i.Error = err.Error()
+	i.report.mu.Unlock()
+}
+
+type contextKey struct{}
+
+// WithReport 返回携带运行报告的 context
+func WithReport(ctx context.Context, r *Report) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext 返回 context 中的运行报告，没有时返回 nil
+func FromContext(ctx context.Context) *Report {
+	r, _ := ctx.Value(contextKey{}).(*Report)
+	return r
+}
@@ -0,0 +1,151 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"backup-to-oss/internal/catalog"
+)
+
+// Policy 备份保留规则（GFS：祖父-父-子）
+// 每条规则独立计算，备份文件满足任意一条规则即保留
+type Policy struct {
+	KeepLast    int // 保留最近的 N 个备份
+	KeepDaily   int // 保留最近 N 天每天最新的一个备份
+	KeepWeekly  int // 保留最近 N 周每周最新的一个备份
+	KeepMonthly int // 保留最近 N 个月每月最新的一个备份
+	KeepYearly  int // 保留最近 N 年每年最新的一个备份
+}
+
+// Enabled 是否设置了保留规则
+func (p Policy) Enabled() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0 || p.KeepYearly > 0
+}
+
+// Validate 校验保留规则
+func (p Policy) Validate() error {
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 || p.KeepMonthly < 0 || p.KeepYearly < 0 {
+		return fmt.Errorf("保留数量不能为负数")
+	}
+	return nil
+}
+
+// String 返回保留规则的描述（用于日志输出）
+func (p Policy) String() string {
+	var parts []string
+	for _, r := range p.rules() {
+		if r.count > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", r.name, r.count))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// rule 一条保留规则：bucket 返回备份所属的时间段，同一时间段只保留最新的一个备份
+type rule struct {
+	name   string
+	count  int
+	bucket func(t time.Time) string
+}
+
+func (p Policy) rules() []rule {
+	return []rule{
+		{"last", p.KeepLast, nil},
+		{"daily", p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.KeepYearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+// Decision 一个备份文件的保留结果
+type Decision struct {
+	Backup  catalog.Backup
+	Keep    bool
+	Reasons []string // 保留的原因，如 last、daily
+}
+
+// Group 同一主机、备份类型和来源的备份文件
+type Group struct {
+	Host      string
+	Type      string
+	Source    string
+	Decisions []Decision // 按备份时间从新到旧排序
+}
+
+// Apply 按主机、备份类型和来源分组后计算每个备份文件是否保留
+func Apply(backups []catalog.Backup, p Policy) []Group {
+	index := make(map[string]int)
+	var groups []Group
+	for _, b := range backups {
+		id := b.Host + "\x00" + b.Type + "\x00" + b.Source
+		i, ok := index[id]
+		if !ok {
+			i = len(groups)
+			index[id] = i
+			groups = append(groups, Group{Host: b.Host, Type: b.Type, Source: b.Source})
+		}
+		groups[i].Decisions = append(groups[i].Decisions, Decision{Backup: b})
+	}
+
+	for i := range groups {
+		apply(groups[i].Decisions, p)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Source < b.Source
+	})
+	return groups
+}
+
+// apply 计算一组备份文件是否保留
+// 保留的增量备份依赖之前最近的完整备份和之间的增量备份，这些备份同样保留（原因为 chain）
+func apply(decisions []Decision, p Policy) {
+	sort.SliceStable(decisions, func(i, j int) bool {
+		return decisions[i].Backup.Time.After(decisions[j].Backup.Time)
+	})
+
+	for _, r := range p.rules() {
+		remaining := r.count
+		lastBucket := ""
+		for i := range decisions {
+			if remaining <= 0 {
+				break
+			}
+			// 从新到旧遍历，每个时间段的第一个备份即为该时间段最新的备份
+			if r.bucket != nil {
+				bucket := r.bucket(decisions[i].Backup.Time)
+				if bucket == lastBucket {
+					continue
+				}
+				lastBucket = bucket
+			}
+			decisions[i].Keep = true
+			decisions[i].Reasons = append(decisions[i].Reasons, r.name)
+			remaining--
+		}
+	}
+
+	// 从新到旧遍历，保留的增量备份之后（更旧）的备份直到完整备份都需要保留
+	needed := false
+	for i := range decisions {
+		if needed && !decisions[i].Keep {
+			decisions[i].Keep = true
+			decisions[i].Reasons = append(decisions[i].Reasons, "chain")
+		}
+		if decisions[i].Keep {
+			needed = decisions[i].Backup.Incremental
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"backup-to-oss/internal/logger"
+)
+
+// 默认重试参数
+const (
+	DefaultMaxAttempts  = 3
+	DefaultInitialDelay = 2 * time.Second
+	DefaultMaxDelay     = 1 * time.Minute
+	defaultMultiplier   = 2.0
+	defaultJitter       = 0.2
+)
+
+// Policy 重试策略
+type Policy struct {
+	MaxAttempts  int                  // 最大尝试次数（包含第一次），小于等于 1 表示不重试
+	InitialDelay time.Duration        // 第一次重试前的等待时间
+	MaxDelay     time.Duration        // 最长等待时间
+	Multiplier   float64              // 退避倍数，默认为 2
+	Jitter       float64              // 随机抖动比例（0~1），默认为 0.2
+	Retryable    func(err error) bool // 判断错误是否可以重试，为空时使用 IsRetryable
+	Quiet        bool                 // 重试时只输出 debug 日志
+}
+
+// DefaultPolicy 返回默认重试策略
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:  DefaultMaxAttempts,
+		InitialDelay: DefaultInitialDelay,
+		MaxDelay:     DefaultMaxDelay,
+	}
+}
+
+// Delay 返回第 attempt 次重试（从 1 开始）前的等待时间（指数退避 + 随机抖动）
+func (p Policy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultMultiplier
+	}
+	jitter := p.Jitter
+	if jitter <= 0 {
+		jitter = defaultJitter
+	}
+
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	// 在 [delay*(1-jitter), delay*(1+jitter)] 范围内随机
+	delay *= 1 + jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// Do 按重试策略执行 fn，遇到可重试的错误时等待后重试
+// name 用于日志输出，上下文取消时立即返回
+func Do(ctx context.Context, p Policy, name string, fn func(ctx context.Context) error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || attempt >= p.MaxAttempts || !retryable(err) {
+			break
+		}
+
+		delay := p.Delay(attempt)
+		args := []any{"attempt", attempt, "max_attempts", p.MaxAttempts, "delay", delay.Round(time.Millisecond), "error", err}
+		if p.Quiet {
+			logger.Debug(name+"失败，等待后重试", args...)
+		} else {
+			logger.Warn(name+"失败，等待后重试", args...)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+
+	var perm *permanentError
+	if errors.As(err, &perm) {
+		return perm.err
+	}
+	return err
+}
+
+// permanentError 不可重试的错误
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent 将错误标记为不可重试，Do 返回时会去掉该标记
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// retryableMessages 错误信息中表示临时故障的关键字（用于无法通过错误类型判断的情况，如 SDK 错误被格式化为字符串）
+var retryableMessages = []string{
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"connection lost", // SFTP
+	"unexpected eof",
+	"timeout",
+	"deadline exceeded",
+	"temporary failure",
+	"tls handshake",
+	"no such host",
+	"server misbehaving",
+	"statuscode=5", // OSS: StatusCode=503
+	"statuscode=429",
+	"status code: 5", // AWS: status code: 503
+	"status code: 429",
+	"requesttimeout",
+	"slowdown",
+	"serviceunavailable",
+	"internalerror",
+	"toomanyrequests",
+	"leader changed", // etcd
+	"no leader",
+	"rpc error: code = unavailable",
+}
+
+// IsRetryable 判断错误是否为可重试的临时故障
+// 网络错误、连接中断、服务端 5xx/429 错误可以重试，上下文取消和 Permanent 标记的错误不重试
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var perm *permanentError
+	if errors.As(err, &perm) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var statusErr interface{ StatusCode() int }
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode()
+		return code >= 500 || code == 429 || code == 408
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, keyword := range retryableMessages {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryAll 将除 Permanent 标记和上下文取消之外的所有错误视为可重试（用于 Policy.Retryable）
+func RetryAll(err error) bool {
+	var perm *permanentError
+	return err != nil && !errors.As(err, &perm) && !errors.Is(err, context.Canceled)
+}
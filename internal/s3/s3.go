@@ -0,0 +1,227 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/storage"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// etagPattern 匹配内容 MD5 形式的 ETag
+var etagPattern = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// defaultRegion 未指定区域时使用的默认区域（MinIO、Ceph RGW 通常接受任意区域）
+const defaultRegion = "us-east-1"
+
+// minPartSize S3 分片上传的最小分片大小
+const minPartSize = 5 * 1024 * 1024
+
+// Config S3 兼容存储配置
+type Config struct {
+	Endpoint     string // 自定义端点，如 http://minio.local:9000（为空时使用 AWS 官方端点）
+	Region       string // 区域，默认为 us-east-1
+	AccessKey    string // AccessKey（为空时使用 AWS 默认凭证链）
+	SecretKey    string // SecretKey
+	PathStyle    bool   // 是否使用 path-style 地址（MinIO、Ceph RGW 通常需要开启）
+	Bucket       string
+	ObjectPrefix string
+	PartSize     int64 // 分片大小（字节），最小 5MB
+	Concurrency  int   // 并发上传分片数
+}
+
+// Storage S3 兼容存储后端（AWS S3、MinIO、Ceph RGW），实现 storage.Storage 接口
+type Storage struct {
+	config   Config
+	client   *s3.Client
+	uploader *transfermanager.Client
+}
+
+// New 创建 S3 存储后端
+// 请求统一使用 SigV4 签名；只在操作要求时计算请求校验和，兼容不支持 CRC32 校验和的 MinIO、Ceph RGW 版本
+func New(config Config) (*Storage, error) {
+	region := config.Region
+	if region == "" {
+		region = defaultRegion
+	}
+
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithRegion(region),
+		awsconfig.WithRequestChecksumCalculation(aws.RequestChecksumCalculationWhenRequired),
+		awsconfig.WithResponseChecksumValidation(aws.ResponseChecksumValidationWhenRequired),
+	}
+	if config.AccessKey != "" || config.SecretKey != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(config.AccessKey, config.SecretKey, "")))
+	}
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("加载S3配置失败: %v", err)
+	}
+
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(config.Endpoint)
+		}
+		o.UsePathStyle = config.PathStyle
+	})
+	// transfermanager 仍为 v0.x 版本（原有的 feature/s3/manager 已弃用），go.mod 中固定版本，升级时需重新运行本包的测试确认上传行为
+	uploader := transfermanager.New(client, func(o *transfermanager.Options) {
+		if config.PartSize > 0 {
+			// 分片上传阈值默认为 16MB，与分片大小保持一致
+			o.PartSizeBytes = max(config.PartSize, minPartSize)
+			o.MultipartUploadThreshold = o.PartSizeBytes
+		}
+		if config.Concurrency > 0 {
+			o.Concurrency = config.Concurrency
+		}
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+	})
+
+	return &Storage{
+		config:   config,
+		client:   client,
+		uploader: uploader,
+	}, nil
+}
+
+// String 返回目标地址
+func (s *Storage) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.config.Bucket, strings.TrimPrefix(s.config.ObjectPrefix, "/"))
+}
+
+// objectName 根据对象前缀确定完整的对象名称
+func (s *Storage) objectName(key string) string {
+	return storage.JoinKey(s.config.ObjectPrefix, key)
+}
+
+// Put 从 reader 上传对象
+// 使用 transfermanager 上传，超过分片大小（未配置时为 16MB）的数据会自动使用分片上传
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	objectName := s.objectName(key)
+	logger.Info("S3上传路径", "bucket", s.config.Bucket, "object", objectName, "path", fmt.Sprintf("s3://%s/%s", s.config.Bucket, objectName))
+
+	input := &transfermanager.UploadObjectInput{
+		Bucket:   aws.String(s.config.Bucket),
+		Key:      aws.String(objectName),
+		Body:     r,
+		Metadata: opts.Metadata,
+	}
+	if len(opts.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range opts.Tags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+
+	if _, err := s.uploader.UploadObject(ctx, input); err != nil {
+		return fmt.Errorf("上传文件失败: %v", err)
+	}
+	return nil
+}
+
+// Get 下载对象
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.objectName(key)),
+	})
+	if err != nil {
+		return nil, wrapError(key, err)
+	}
+	return output.Body, nil
+}
+
+// List 列出指定前缀下的所有对象
+func (s *Storage) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	rootPrefix := s.objectName("")
+
+	var objects []storage.ObjectInfo
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.config.Bucket),
+		Prefix: aws.String(s.objectName(prefix)),
+	}
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("列出对象失败: %v", err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, storage.ObjectInfo{
+				Key:          strings.TrimPrefix(strings.TrimPrefix(aws.ToString(obj.Key), rootPrefix), "/"),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+				ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+// Delete 删除对象
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.objectName(key)),
+	})
+	if err != nil {
+		return fmt.Errorf("删除对象失败: %v", err)
+	}
+	return nil
+}
+
+// Stat 获取对象信息
+func (s *Storage) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.config.Bucket),
+		Key:    aws.String(s.objectName(key)),
+	})
+	if err != nil {
+		return nil, wrapError(key, err)
+	}
+
+	info := &storage.ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(output.ContentLength),
+		LastModified: aws.ToTime(output.LastModified),
+		ETag:         strings.Trim(aws.ToString(output.ETag), `"`),
+		Metadata:     make(map[string]string),
+	}
+	for k, v := range output.Metadata {
+		info.Metadata[strings.ToLower(k)] = v
+	}
+	// 单次上传且未使用 KMS 加密的对象，ETag 即为内容的 MD5（分片上传的 ETag 形如 xxx-N）
+	if etagPattern.MatchString(info.ETag) && output.ServerSideEncryption != types.ServerSideEncryptionAwsKms && output.ServerSideEncryption != types.ServerSideEncryptionAwsKmsDsse {
+		info.MD5 = strings.ToLower(info.ETag)
+	}
+
+	return info, nil
+}
+
+// wrapError 将 S3 404 错误转换为 storage.ErrNotExist
+func wrapError(key string, err error) error {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", storage.ErrNotExist, key)
+	}
+	return fmt.Errorf("访问对象失败: %s, %v", key, err)
+}
+
+// 确保 Storage 实现了 storage.Storage 接口
+var _ storage.Storage = (*Storage)(nil)
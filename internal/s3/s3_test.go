@@ -0,0 +1,181 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"backup-to-oss/internal/storage"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+const testBucket = "backups"
+
+// testServer 基于 gofakes3 内存后端的 S3 测试服务器
+type testServer struct {
+	parts atomic.Int32 // 收到的 UploadPart 请求数
+}
+
+// newTestStorage 启动 S3 测试服务器，返回 path-style 访问的存储后端
+func newTestStorage(t *testing.T, config Config) (*Storage, *testServer) {
+	t.Helper()
+	backend := s3mem.New()
+	if err := backend.CreateBucket(testBucket); err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{}
+	handler := gofakes3.New(backend, gofakes3.WithLogger(gofakes3.DiscardLog())).Server()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Query().Has("partNumber") {
+			ts.parts.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	config.Endpoint = server.URL
+	config.AccessKey = "test"
+	config.SecretKey = "test"
+	config.PathStyle = true
+	config.Bucket = testBucket
+	s, err := New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s, ts
+}
+
+// onlyReader 隐藏 Seek 等方法，模拟流式上传时无法回退的数据源
+type onlyReader struct {
+	io.Reader
+}
+
+func TestPutGetStatDelete(t *testing.T) {
+	s, _ := newTestStorage(t, Config{ObjectPrefix: "prefix"})
+	ctx := context.Background()
+	key := "1.2.3.4/20250101/20250101-020000_etc.tar.zst"
+
+	err := s.Put(ctx, key, onlyReader{strings.NewReader("hello")}, storage.PutOptions{
+		Metadata: map[string]string{"sha256": "abc"},
+		Tags:     map[string]string{"type": "dir"},
+	})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Get = %q, %v; want %q", data, err, "hello")
+	}
+
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	sum := md5.Sum([]byte("hello"))
+	if info.Key != key || info.Size != 5 || info.Metadata["sha256"] != "abc" || info.MD5 != hex.EncodeToString(sum[:]) {
+		t.Fatalf("Stat = %+v", info)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Get after Delete = %v, want ErrNotExist", err)
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Stat after Delete = %v, want ErrNotExist", err)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	s, ts := newTestStorage(t, Config{PartSize: minPartSize, Concurrency: 2})
+	ctx := context.Background()
+	key := "20250101/etcd-snapshot-20250101-020000.db.zst"
+
+	// 超过分片大小的流式数据使用分片上传
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*minPartSize+1024)/16)
+	if err := s.Put(ctx, key, onlyReader{bytes.NewReader(data)}, storage.PutOptions{Metadata: map[string]string{"sha256": "abc"}}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size != int64(len(data)) || info.Metadata["sha256"] != "abc" {
+		t.Fatalf("Stat = %+v", info)
+	}
+	if parts := ts.parts.Load(); parts != 3 {
+		t.Errorf("uploaded %d parts, want 3", parts)
+	}
+
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get: %d bytes, %v; want %d bytes", len(got), err, len(data))
+	}
+}
+
+func TestList(t *testing.T) {
+	s, _ := newTestStorage(t, Config{ObjectPrefix: "prefix/"})
+	ctx := context.Background()
+	keys := []string{
+		"1.2.3.4/20250101/a.tar.zst",
+		"1.2.3.4/20250102/b.tar.zst",
+		"5.6.7.8/20250101/c.tar.zst",
+	}
+	for _, key := range keys {
+		if err := s.Put(ctx, key, strings.NewReader(key), storage.PutOptions{}); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", keys},
+		{"1.2.3.4/", keys[:2]},
+		{"1.2.3.4/2025010", keys[:2]},
+		{"1.2.3.4/20250102/", keys[1:2]},
+		{"9.9.9.9/", nil},
+	}
+	for _, tt := range tests {
+		objects, err := s.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", tt.prefix, err)
+		}
+		var got []string
+		for _, obj := range objects {
+			got = append(got, obj.Key)
+			if obj.Size != int64(len(obj.Key)) {
+				t.Errorf("List(%q): %s size = %d", tt.prefix, obj.Key, obj.Size)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
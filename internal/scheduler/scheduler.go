@@ -0,0 +1,361 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/progress"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maxHistory 保留的执行记录数量
+const maxHistory = 100
+
+// 执行记录的触发方式
+const (
+	TriggerSchedule = "schedule" // 定时执行
+	TriggerManual   = "manual"   // 通过 API 手动触发
+)
+
+// 执行记录的状态
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrJobNotFound 任务不存在
+	ErrJobNotFound = errors.New("任务不存在")
+	// ErrRunNotFound 执行记录不存在
+	ErrRunNotFound = errors.New("执行记录不存在")
+	// ErrJobRunning 任务上一次执行尚未完成
+	ErrJobRunning = errors.New("任务上一次执行尚未完成")
+	// ErrStopped 调度器已停止
+	ErrStopped = errors.New("调度器已停止")
+)
+
+// Job 定时执行的任务
+type Job struct {
+	Name     string
+	Spec     string                          // cron 表达式（为空时只能手动触发）
+	Schedule cron.Schedule                   // 执行时间（为 nil 时只能手动触发）
+	Jitter   time.Duration                   // 最大随机延迟，避免多台主机同时执行
+	Run      func(ctx context.Context) error // 执行任务
+}
+
+// JobInfo 任务的调度状态
+type JobInfo struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule,omitempty"`
+	NextRun  time.Time `json:"next_run,omitzero"` // 下次执行时间（包含随机延迟）
+	Running  bool      `json:"running"`
+	LastRun  *RunInfo  `json:"last_run,omitempty"` // 最近一次执行记录
+}
+
+// RunInfo 一次执行记录
+type RunInfo struct {
+	ID         int64          `json:"id"`
+	Job        string         `json:"job"`
+	Trigger    string         `json:"trigger"` // schedule/manual
+	Status     string         `json:"status"`  // running/succeeded/failed
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at,omitzero"`
+	Duration   float64        `json:"duration_seconds"` // 执行时间（秒），执行中时为已执行的时间
+	Error      string         `json:"error,omitempty"`
+	Progress   *progress.Info `json:"progress,omitempty"` // 执行进度（只在执行中时返回）
+}
+
+// run 一次执行
+type run struct {
+	info    RunInfo
+	tracker *progress.Tracker
+	done    chan struct{} // 执行结束时关闭
+}
+
+// Parse 解析 cron 表达式
+// 支持标准的 5 段表达式（分 时 日 月 周）、@daily 等描述符、@every 1h 以及 CRON_TZ=Asia/Shanghai 前缀
+func Parse(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// Scheduler 按 cron 表达式定时执行任务，同一个任务上一次执行尚未完成时跳过本次执行
+type Scheduler struct {
+	mu      sync.Mutex
+	jobs    map[string]Job       // 当前的任务列表
+	order   []string             // 任务名称（按配置文件中的顺序）
+	next    map[string]time.Time // 任务的下次执行时间
+	running map[string]*run      // 正在执行的任务
+	history []*run               // 执行记录（从旧到新）
+	lastID  int64
+	stopped bool
+	stop    context.CancelFunc // 停止当前的调度
+	loops   sync.WaitGroup     // 调度循环
+	runs    sync.WaitGroup     // 正在执行的任务
+
+	ctx    context.Context // 任务使用的 context，强制退出时取消
+	cancel context.CancelFunc
+}
+
+// New 创建调度器
+func New() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		jobs:    make(map[string]Job),
+		next:    make(map[string]time.Time),
+		running: make(map[string]*run),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start 按新的任务列表开始调度，替换之前的任务列表
+// 正在执行的任务不受影响，重新加载后同名任务仍然不会重叠执行
+func (s *Scheduler) Start(jobs []Job) {
+	s.stopLoops()
+
+	ctx, stop := context.WithCancel(s.ctx)
+	s.mu.Lock()
+	s.stop = stop
+	s.jobs = make(map[string]Job, len(jobs))
+	s.order = s.order[:0]
+	s.next = make(map[string]time.Time)
+	for _, job := range jobs {
+		s.jobs[job.Name] = job
+		s.order = append(s.order, job.Name)
+	}
+	s.mu.Unlock()
+
+	for _, job := range jobs {
+		if job.Schedule == nil {
+			continue
+		}
+		s.loops.Add(1)
+		go s.loop(ctx, job)
+	}
+}
+
+// Stop 停止调度并等待正在执行的任务完成
+// ctx 结束时取消正在执行的任务，并等待任务退出
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.stopLoops()
+
+	done := make(chan struct{})
+	go func() {
+		s.runs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Trigger 立即执行任务（手动触发），任务正在执行时返回 ErrJobRunning 和正在执行的记录
+func (s *Scheduler) Trigger(name string) (RunInfo, error) {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return RunInfo{}, ErrJobNotFound
+	}
+	return s.start(job, TriggerManual)
+}
+
+// Wait 等待执行结束，返回执行记录；ctx 结束时返回当前的执行记录和 ctx 的错误
+func (s *Scheduler) Wait(ctx context.Context, id int64) (RunInfo, error) {
+	s.mu.Lock()
+	r := s.find(id)
+	s.mu.Unlock()
+	if r == nil {
+		return RunInfo{}, ErrRunNotFound
+	}
+
+	var err error
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return r.snapshot(), err
+}
+
+// Jobs 返回所有任务的调度状态
+func (s *Scheduler) Jobs() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := make([]JobInfo, 0, len(s.order))
+	for _, name := range s.order {
+		job := s.jobs[name]
+		info := JobInfo{Name: name, Schedule: job.Spec, NextRun: s.next[name], Running: s.running[name] != nil}
+		for i := len(s.history) - 1; i >= 0; i-- {
+			if s.history[i].info.Job == name {
+				last := s.history[i].snapshot()
+				info.LastRun = &last
+				break
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Runs 返回最近的执行记录（从新到旧），job 不为空时只返回该任务的记录
+func (s *Scheduler) Runs(job string, limit int) []RunInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	infos := []RunInfo{}
+	for i := len(s.history) - 1; i >= 0 && (limit <= 0 || len(infos) < limit); i-- {
+		if job == "" || s.history[i].info.Job == job {
+			infos = append(infos, s.history[i].snapshot())
+		}
+	}
+	return infos
+}
+
+// Run 返回执行记录
+func (s *Scheduler) Run(id int64) (RunInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r := s.find(id); r != nil {
+		return r.snapshot(), true
+	}
+	return RunInfo{}, false
+}
+
+// find 查找执行记录（调用时需要持有锁）
+func (s *Scheduler) find(id int64) *run {
+	for _, r := range s.history {
+		if r.info.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// snapshot 返回执行记录的副本（调用时需要持有调度器的锁）
+func (r *run) snapshot() RunInfo {
+	info := r.info
+	if info.Status == StatusRunning {
+		info.Duration = time.Since(info.StartedAt).Seconds()
+		p := r.tracker.Info()
+		info.Progress = &p
+	}
+	return info
+}
+
+// stopLoops 停止所有调度循环
+func (s *Scheduler) stopLoops() {
+	s.mu.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+	s.loops.Wait()
+}
+
+// loop 按任务的执行时间循环触发任务
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	defer s.loops.Done()
+	for {
+		next := job.Schedule.Next(time.Now())
+		if job.Jitter > 0 {
+			next = next.Add(rand.N(job.Jitter))
+		}
+		s.mu.Lock()
+		s.next[job.Name] = next
+		s.mu.Unlock()
+		logger.Info("任务下次执行时间", "job", job.Name, "next", next.Format(time.DateTime))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := s.start(job, TriggerSchedule); errors.Is(err, ErrJobRunning) {
+			logger.Warn("任务上一次执行尚未完成，跳过本次执行", "job", job.Name)
+		}
+	}
+}
+
+// start 在后台执行任务，任务正在执行时返回 ErrJobRunning
+func (s *Scheduler) start(job Job, trigger string) (RunInfo, error) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return RunInfo{}, ErrStopped
+	}
+	if r := s.running[job.Name]; r != nil {
+		info := r.snapshot()
+		s.mu.Unlock()
+		return info, ErrJobRunning
+	}
+	s.lastID++
+	r := &run{
+		info: RunInfo{
+			ID:        s.lastID,
+			Job:       job.Name,
+			Trigger:   trigger,
+			Status:    StatusRunning,
+			StartedAt: time.Now(),
+		},
+		tracker: &progress.Tracker{},
+		done:    make(chan struct{}),
+	}
+	s.running[job.Name] = r
+	s.history = append(s.history, r)
+	if len(s.history) > maxHistory {
+		s.history = s.history[len(s.history)-maxHistory:]
+	}
+	info := r.snapshot()
+	s.runs.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.runs.Done()
+		logger.Info("开始执行任务", "job", job.Name, "trigger", trigger, "run_id", r.info.ID)
+		err := job.Run(progress.WithTracker(s.ctx, r.tracker))
+
+		s.mu.Lock()
+		r.info.FinishedAt = time.Now()
+		r.info.Duration = r.info.FinishedAt.Sub(r.info.StartedAt).Seconds()
+		if err != nil {
+			r.info.Status = StatusFailed
+			r.info.Error = err.Error()
+		} else {
+			r.info.Status = StatusSucceeded
+		}
+		delete(s.running, job.Name)
+		s.mu.Unlock()
+		close(r.done)
+
+		duration := r.info.FinishedAt.Sub(r.info.StartedAt).Round(time.Second)
+		if err != nil {
+			logger.Error("任务执行失败", "job", job.Name, "run_id", r.info.ID, "duration", duration, "error", err)
+			return
+		}
+		logger.Info("任务执行完成", "job", job.Name, "run_id", r.info.ID, "duration", duration)
+	}()
+	return info, nil
+}
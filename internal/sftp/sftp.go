@@ -0,0 +1,445 @@
+package sftp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"backup-to-oss/internal/logger"
+	"backup-to-oss/internal/storage"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultPort    = "22"
+	defaultTimeout = 30 * time.Second
+)
+
+// Config SFTP 存储配置
+type Config struct {
+	Host           string // 主机地址，如 backup.example.com:22（未指定端口时默认为 22）
+	User           string // SSH 用户名
+	KeyFile        string // SSH 私钥文件路径
+	KeyPassphrase  string // SSH 私钥密码（可选）
+	KnownHostsFile string // known_hosts 文件路径，默认为 ~/.ssh/known_hosts
+	RemoteDir      string // 远程备份目录（为空时使用登录用户的主目录）
+	Timeout        time.Duration
+}
+
+// Storage SFTP 存储后端，实现 storage.Storage 接口
+// 连接在首次使用时建立，操作因连接断开失败后关闭连接，下次操作时重新建立；文件写入使用临时文件 + 重命名的方式保证原子性
+type Storage struct {
+	config Config
+
+	mu        sync.Mutex
+	sshClient *ssh.Client
+	client    *sftp.Client
+}
+
+// New 创建 SFTP 存储后端
+func New(config Config) (*Storage, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("SFTP 主机地址未设置")
+	}
+	if config.User == "" {
+		return nil, fmt.Errorf("SFTP 用户名未设置")
+	}
+	if config.KeyFile == "" {
+		return nil, fmt.Errorf("SFTP 私钥文件未设置")
+	}
+	if _, _, err := net.SplitHostPort(config.Host); err != nil {
+		config.Host = net.JoinHostPort(config.Host, defaultPort)
+	}
+	if config.KnownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("获取用户主目录失败: %v", err)
+		}
+		config.KnownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultTimeout
+	}
+	return &Storage{config: config}, nil
+}
+
+// String 返回目标地址
+func (s *Storage) String() string {
+	return fmt.Sprintf("sftp://%s@%s/%s", s.config.User, s.config.Host, strings.TrimPrefix(s.config.RemoteDir, "/"))
+}
+
+// connect 建立（或复用）SFTP 连接
+func (s *Storage) connect() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	// 加载私钥
+	keyData, err := os.ReadFile(s.config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取 SSH 私钥失败: %v", err)
+	}
+	var signer ssh.Signer
+	if s.config.KeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(s.config.KeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析 SSH 私钥失败: %v", err)
+	}
+
+	// 通过 known_hosts 校验服务器公钥
+	hostKeyCallback, err := knownhosts.New(s.config.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("加载 known_hosts 失败: %v", err)
+	}
+
+	logger.Info("正在连接 SFTP 服务器", "host", s.config.Host, "user", s.config.User)
+	sshClient, err := ssh.Dial("tcp", s.config.Host, &ssh.ClientConfig{
+		User:            s.config.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         s.config.Timeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接 SSH 服务器失败: %v", err)
+	}
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("创建 SFTP 客户端失败: %v", err)
+	}
+
+	s.sshClient = sshClient
+	s.client = client
+	return client, nil
+}
+
+// Close 关闭 SFTP 连接
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeLocked()
+}
+
+// closeLocked 关闭 SFTP 连接，调用时需持有 s.mu
+func (s *Storage) closeLocked() error {
+	if s.client == nil {
+		return nil
+	}
+	s.client.Close()
+	err := s.sshClient.Close()
+	s.client = nil
+	s.sshClient = nil
+	return err
+}
+
+// checkConn 操作因连接错误失败时关闭 client 对应的连接，下次操作（如重试）重新建立连接
+func (s *Storage) checkConn(client *sftp.Client, err error) {
+	if !isConnError(err) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 其他操作已经关闭并重新建立了连接
+	if s.client != client {
+		return
+	}
+	logger.Warn("SFTP 连接异常，下次操作时重新连接", "host", s.config.Host, "error", err)
+	s.closeLocked()
+}
+
+// isConnError 判断错误是否可能由连接断开引起
+// 服务器返回的状态错误（文件不存在、权限不足等）说明连接正常，其他错误（连接中断、EOF、网络错误）都视为连接错误
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		return true
+	}
+	var status *sftp.StatusError
+	return !errors.As(err, &status) && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission)
+}
+
+// remotePath 将对象键转换为远程文件路径，拒绝越出远程目录的键
+func (s *Storage) remotePath(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" {
+		return "", fmt.Errorf("无效的对象键: %q", key)
+	}
+	return s.rootDir() + cleaned, nil
+}
+
+// rootDir 返回远程备份目录（不以 / 结尾）
+func (s *Storage) rootDir() string {
+	dir := strings.TrimSuffix(s.config.RemoteDir, "/")
+	if dir == "" {
+		return "."
+	}
+	return dir
+}
+
+// metaPath 返回对象元数据文件路径（与对象同目录的隐藏文件）
+func metaPath(remotePath string) string {
+	return path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".meta")
+}
+
+// Put 从 reader 上传对象
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, opts storage.PutOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	remotePath, err := s.remotePath(key)
+	if err != nil {
+		return err
+	}
+	logger.Info("SFTP上传路径", "host", s.config.Host, "path", remotePath)
+
+	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+		s.checkConn(client, err)
+		return fmt.Errorf("创建远程目录失败: %v", err)
+	}
+
+	// 先写入元数据，确保对象可见时元数据已经存在；没有元数据时删除覆盖前对象的元数据
+	if len(opts.Metadata) > 0 {
+		data, err := json.Marshal(opts.Metadata)
+		if err != nil {
+			return fmt.Errorf("序列化元数据失败: %v", err)
+		}
+		if err := writeAtomic(client, metaPath(remotePath), bytes.NewReader(data)); err != nil {
+			s.checkConn(client, err)
+			return fmt.Errorf("写入元数据失败: %v", err)
+		}
+	} else if err := client.Remove(metaPath(remotePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.checkConn(client, err)
+		return fmt.Errorf("删除元数据失败: %v", err)
+	}
+
+	if err := writeAtomic(client, remotePath, r); err != nil {
+		s.checkConn(client, err)
+		return fmt.Errorf("上传文件失败: %v", err)
+	}
+	return nil
+}
+
+// writeAtomic 先写入临时文件，完成后重命名为目标文件
+func writeAtomic(client *sftp.Client, remotePath string, r io.Reader) error {
+	tmpPath := path.Join(path.Dir(remotePath), fmt.Sprintf(".%s.tmp%d", path.Base(remotePath), time.Now().UnixNano()))
+
+	// 错误使用 %w 包装，调用方据此判断连接是否断开
+	f, err := client.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	if _, err := f.ReadFrom(r); err != nil {
+		f.Close()
+		client.Remove(tmpPath)
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		client.Remove(tmpPath)
+		return fmt.Errorf("关闭临时文件失败: %w", err)
+	}
+
+	// 优先使用 posix-rename 扩展（支持覆盖已存在的文件），不支持时先删除再重命名
+	if err := client.PosixRename(tmpPath, remotePath); err != nil {
+		client.Remove(remotePath)
+		if err := client.Rename(tmpPath, remotePath); err != nil {
+			client.Remove(tmpPath)
+			return fmt.Errorf("重命名文件失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// Get 下载对象
+func (s *Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	remotePath, err := s.remotePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := client.Open(remotePath)
+	if err != nil {
+		s.checkConn(client, err)
+		return nil, wrapError(key, err)
+	}
+	return &file{File: f, s: s, client: client}, nil
+}
+
+// file 下载的远程文件，读取时连接断开则关闭连接
+type file struct {
+	*sftp.File
+	s      *Storage
+	client *sftp.Client
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if err != nil && err != io.EOF {
+		f.s.checkConn(f.client, err)
+	}
+	return n, err
+}
+
+// WriteTo 下载时 io.Copy 使用 sftp.File 的并发读取
+func (f *file) WriteTo(w io.Writer) (int64, error) {
+	n, err := f.File.WriteTo(w)
+	f.s.checkConn(f.client, err)
+	return n, err
+}
+
+// List 列出指定前缀下的所有对象
+func (s *Storage) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	// 前缀可能只是文件名的一部分，从前缀所在的目录开始遍历
+	root := s.rootDir()
+	prefix = strings.TrimPrefix(prefix, "/")
+	walkDir := root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		walkDir = root + path.Clean("/"+prefix[:i])
+	}
+
+	var objects []storage.ObjectInfo
+	walker := client.Walk(walkDir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			s.checkConn(client, err)
+			return nil, fmt.Errorf("列出对象失败: %v", err)
+		}
+
+		info := walker.Stat()
+		if info.IsDir() {
+			continue
+		}
+
+		// 跳过元数据文件和未完成写入的临时文件
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		objects = append(objects, storage.ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+	}
+
+	return objects, nil
+}
+
+// Delete 删除对象及其元数据
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+
+	remotePath, err := s.remotePath(key)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Remove(remotePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.checkConn(client, err)
+		return fmt.Errorf("删除对象失败: %v", err)
+	}
+	if err := client.Remove(metaPath(remotePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.checkConn(client, err)
+		return fmt.Errorf("删除元数据失败: %v", err)
+	}
+	return nil
+}
+
+// Stat 获取对象信息
+func (s *Storage) Stat(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	remotePath, err := s.remotePath(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := client.Stat(remotePath)
+	if err != nil {
+		s.checkConn(client, err)
+		return nil, wrapError(key, err)
+	}
+
+	info := &storage.ObjectInfo{
+		Key:          key,
+		Size:         fileInfo.Size(),
+		LastModified: fileInfo.ModTime(),
+		Metadata:     make(map[string]string),
+	}
+	f, err := client.Open(metaPath(remotePath))
+	if err == nil {
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(&info.Metadata); err != nil {
+			s.checkConn(client, err)
+			return nil, fmt.Errorf("解析元数据失败: %v", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		s.checkConn(client, err)
+		return nil, fmt.Errorf("读取元数据失败: %v", err)
+	}
+
+	return info, nil
+}
+
+// wrapError 将文件不存在错误转换为 storage.ErrNotExist
+func wrapError(key string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %s", storage.ErrNotExist, key)
+	}
+	return fmt.Errorf("访问对象失败: %s, %v", key, err)
+}
+
+// 确保 Storage 实现了 storage.Storage 接口
+var _ storage.Storage = (*Storage)(nil)
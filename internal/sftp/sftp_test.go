@@ -0,0 +1,367 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"backup-to-oss/internal/retry"
+	"backup-to-oss/internal/storage"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer 基于 pkg/sftp 内存文件系统的 SFTP 测试服务器
+type testServer struct {
+	addr           string
+	keyFile        string // 客户端私钥
+	knownHostsFile string // 包含服务器公钥的 known_hosts
+
+	mu    sync.Mutex
+	conns []net.Conn // 已接受的连接
+}
+
+// newTestServer 启动监听本地随机端口的 SSH 服务器，只接受生成的客户端私钥
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "backup" && string(key.Marshal()) == string(clientSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	addr := listener.Addr().String()
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostSigner.PublicKey())
+	if err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{addr: addr, keyFile: keyFile, knownHostsFile: knownHostsFile}
+
+	// 所有连接共享同一个内存文件系统
+	handlers := sftp.InMemHandler()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			ts.mu.Lock()
+			ts.conns = append(ts.conns, conn)
+			ts.mu.Unlock()
+			go serveConn(conn, config, handlers)
+		}
+	}()
+	return ts
+}
+
+// dropConnections 断开所有已建立的连接（模拟网络中断）
+func (ts *testServer) dropConnections() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, conn := range ts.conns {
+		conn.Close()
+	}
+	ts.conns = nil
+}
+
+// serveConn 处理一个 SSH 连接，只支持 sftp 子系统
+func serveConn(conn net.Conn, config *ssh.ServerConfig, handlers sftp.Handlers) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				// subsystem 请求的负载为长度前缀的子系统名称
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+			}
+		}()
+		go func() {
+			defer channel.Close()
+			server := sftp.NewRequestServer(channel, handlers)
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+// newStorage 创建连接测试服务器的存储后端
+func (ts *testServer) newStorage(t *testing.T, remoteDir string) *Storage {
+	t.Helper()
+	s, err := New(Config{
+		Host:           ts.addr,
+		User:           "backup",
+		KeyFile:        ts.keyFile,
+		KnownHostsFile: ts.knownHostsFile,
+		RemoteDir:      remoteDir,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	tests := []Config{
+		{User: "backup", KeyFile: "key"},
+		{Host: "example.com", KeyFile: "key"},
+		{Host: "example.com", User: "backup"},
+	}
+	for _, config := range tests {
+		if _, err := New(config); err == nil {
+			t.Errorf("New(%+v): expected error", config)
+		}
+	}
+
+	s, err := New(Config{Host: "example.com", User: "backup", KeyFile: "key", KnownHostsFile: "known_hosts", RemoteDir: "/data/backups"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.config.Host != "example.com:22" {
+		t.Errorf("Host = %s, want default port", s.config.Host)
+	}
+	if got := s.String(); got != "sftp://backup@example.com:22/data/backups" {
+		t.Errorf("String = %s", got)
+	}
+}
+
+func TestPutGetStatDelete(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.newStorage(t, "/data/backups")
+	ctx := context.Background()
+	key := "1.2.3.4/20250101/20250101-020000_etc.tar.zst"
+
+	if err := s.Put(ctx, key, strings.NewReader("hello"), storage.PutOptions{Metadata: map[string]string{"sha256": "abc"}}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Get = %q, %v; want %q", data, err, "hello")
+	}
+
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != key || info.Size != 5 || info.Metadata["sha256"] != "abc" {
+		t.Fatalf("Stat = %+v", info)
+	}
+
+	// 覆盖已存在的对象
+	if err := s.Put(ctx, key, strings.NewReader("hello world"), storage.PutOptions{}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// 没有元数据的覆盖写入不保留之前的元数据
+	if info, err := s.Stat(ctx, key); err != nil || info.Size != 11 || len(info.Metadata) != 0 {
+		t.Fatalf("Stat after overwrite = %+v, %v", info, err)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Get after Delete = %v, want ErrNotExist", err)
+	}
+	if _, err := s.Stat(ctx, key); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("Stat after Delete = %v, want ErrNotExist", err)
+	}
+	// 删除不存在的对象不报错
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete missing: %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.newStorage(t, "/data/backups")
+	ctx := context.Background()
+	keys := []string{
+		"1.2.3.4/20250101/a.tar.zst",
+		"1.2.3.4/20250102/b.tar.zst",
+		"5.6.7.8/20250101/c.tar.zst",
+	}
+	for _, key := range keys {
+		if err := s.Put(ctx, key, strings.NewReader(key), storage.PutOptions{Metadata: map[string]string{"k": "v"}}); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", keys},
+		{"1.2.3.4/", keys[:2]},
+		{"1.2.3.4/2025010", keys[:2]},
+		{"1.2.3.4/20250102/", keys[1:2]},
+		{"9.9.9.9/", nil},
+	}
+	for _, tt := range tests {
+		objects, err := s.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", tt.prefix, err)
+		}
+		var got []string
+		for _, obj := range objects {
+			got = append(got, obj.Key)
+			if obj.Size != int64(len(obj.Key)) {
+				t.Errorf("List(%q): %s size = %d", tt.prefix, obj.Key, obj.Size)
+			}
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestKeyCannotEscapeRemoteDir(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.newStorage(t, "/data/backups")
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "../../escape", strings.NewReader("x"), storage.PutOptions{}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	// 键被限制在远程目录内
+	outside := ts.newStorage(t, "/")
+	if _, err := outside.Stat(ctx, "data/backups/escape"); err != nil {
+		t.Fatalf("object should be written inside remote dir: %v", err)
+	}
+	if _, err := outside.Stat(ctx, "escape"); !errors.Is(err, storage.ErrNotExist) {
+		t.Fatalf("object escaped remote dir: %v", err)
+	}
+	if err := s.Put(ctx, "/", strings.NewReader("x"), storage.PutOptions{}); err == nil {
+		t.Fatal("expected error for empty key")
+	}
+}
+
+func TestUnknownHostKeyRejected(t *testing.T) {
+	ts := newTestServer(t)
+	other := newTestServer(t)
+
+	// 使用另一台服务器的 known_hosts，服务器公钥校验失败
+	s, err := New(Config{
+		Host:           ts.addr,
+		User:           "backup",
+		KeyFile:        ts.keyFile,
+		KnownHostsFile: other.knownHostsFile,
+		RemoteDir:      "/data/backups",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Put(context.Background(), "a", strings.NewReader("x"), storage.PutOptions{}); err == nil {
+		t.Fatal("expected host key verification error")
+	}
+}
+
+func TestReconnectAfterConnectionLost(t *testing.T) {
+	ts := newTestServer(t)
+	s := ts.newStorage(t, "/data/backups")
+	ctx := context.Background()
+	key := "1.2.3.4/20250101/20250101-020000_etc.tar.zst"
+
+	if err := s.Put(ctx, key, strings.NewReader("hello"), storage.PutOptions{}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	ts.dropConnections()
+
+	// 连接断开后的第一次操作可能失败（错误可以重试），之后的操作重新建立连接
+	if _, err := s.Stat(ctx, key); err != nil && !retry.IsRetryable(err) {
+		t.Fatalf("Stat after connection lost = %v, want retryable error", err)
+	}
+	if _, err := s.Stat(ctx, key); err != nil {
+		t.Fatalf("Stat after reconnect: %v", err)
+	}
+
+	// 读取过程中连接断开
+	body, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	ts.dropConnections()
+	io.ReadAll(body)
+	body.Close()
+	if err := s.Put(ctx, key, strings.NewReader("hello"), storage.PutOptions{}); err != nil && !retry.IsRetryable(err) {
+		t.Fatalf("Put after connection lost = %v, want retryable error", err)
+	}
+	if err := s.Put(ctx, key, strings.NewReader("hello world"), storage.PutOptions{}); err != nil {
+		t.Fatalf("Put after reconnect: %v", err)
+	}
+	if info, err := s.Stat(ctx, key); err != nil || info.Size != 11 {
+		t.Fatalf("Stat = %+v, %v", info, err)
+	}
+}
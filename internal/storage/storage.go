@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrNotExist 对象不存在
+var ErrNotExist = errors.New("对象不存在")
+
+// ObjectInfo 存储对象信息
+type ObjectInfo struct {
+	Key          string            // 对象键（相对于存储目标的根路径）
+	Size         int64             // 对象大小（字节）
+	LastModified time.Time         // 最后修改时间
+	ETag         string            // ETag（部分后端可能为空）
+	CRC64        string            // CRC64-ECMA 十进制字符串（仅 Stat 返回，后端不支持时为空）
+	MD5          string            // 对象内容的 MD5 十六进制字符串（仅 Stat 返回，后端无法保证时为空）
+	Metadata     map[string]string // 自定义元数据（仅 Stat 返回）
+}
+
+// PutOptions 上传选项
+type PutOptions struct {
+	Metadata map[string]string // 自定义元数据
+	Tags     map[string]string // 对象标签（用于生命周期规则等，不支持标签的后端忽略）
+}
+
+// Storage 备份存储后端
+// 所有 key 均相对于目标地址中的根路径（如 oss://bucket/prefix 中的 prefix）
+type Storage interface {
+	// Put 从 reader 上传对象
+	Put(ctx context.Context, key string, r io.Reader, opts PutOptions) error
+	// Get 下载对象，调用方负责关闭返回的 reader
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// List 列出指定前缀下的所有对象
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete 删除对象
+	Delete(ctx context.Context, key string) error
+	// Stat 获取对象信息，对象不存在时返回 ErrNotExist
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// String 返回目标地址（用于日志输出）
+	String() string
+}
+
+// FileUploader 可选接口，后端实现该接口时可以直接从本地文件上传（如使用 SDK 的文件上传能力）
+type FileUploader interface {
+	PutFile(ctx context.Context, key, filePath string, opts PutOptions) error
+}
+
+// PutFile 上传本地文件到存储后端
+// 如果后端实现了 FileUploader 则直接使用，否则打开文件后调用 Put
+func PutFile(ctx context.Context, s Storage, key, filePath string, opts PutOptions) error {
+	if u, ok := s.(FileUploader); ok {
+		return u.PutFile(ctx, key, filePath, opts)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("打开文件失败: %v", err)
+	}
+	defer f.Close()
+
+	return s.Put(ctx, key, f, opts)
+}
+
+// Location 解析后的备份目标地址
+// 例如: oss://bucket/prefix、s3://bucket/prefix、file:///mnt/backup、sftp://user@host:22/backup
+type Location struct {
+	Raw    string // 原始地址
+	Scheme string // 协议（oss/s3/file/...）
+	User   string // 用户名（可选）
+	Host   string // 主机部分（对象存储为 bucket 名称）
+	Path   string // 路径部分（对象存储为对象前缀，不以 / 开头）
+}
+
+// ParseLocation 解析 URL 形式的备份目标地址
+func ParseLocation(raw string) (*Location, error) {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("解析目标地址失败: %s, %v", raw, err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("目标地址缺少协议: %s（示例: oss://bucket/prefix、s3://bucket/prefix、file:///mnt/backup）", raw)
+	}
+
+	loc := &Location{
+		Raw:    raw,
+		Scheme: strings.ToLower(u.Scheme),
+		Host:   u.Host,
+		Path:   u.Path,
+	}
+	if u.User != nil {
+		loc.User = u.User.Username()
+	}
+
+	// 对象存储的前缀不以 / 开头，文件系统类目标保留原始路径
+	switch loc.Scheme {
+	case "file", "sftp":
+	default:
+		loc.Path = strings.TrimPrefix(loc.Path, "/")
+	}
+
+	return loc, nil
+}
+
+// JoinKey 拼接对象前缀和对象键
+// 结果不以 / 开头，不包含连续的 //，保留末尾的 /（用于表示目录前缀）
+func JoinKey(prefix, key string) string {
+	joined := prefix + "/" + key
+	for strings.Contains(joined, "//") {
+		joined = strings.ReplaceAll(joined, "//", "/")
+	}
+	return strings.TrimPrefix(joined, "/")
+}